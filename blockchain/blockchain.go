@@ -1182,6 +1182,16 @@ func (chain *Blockchain) BlockCheckSum(cbl *block.Complete_Block) []byte {
 // verifying everything  means everything possible
 // this only change mempool, no DB changes
 func (chain *Blockchain) Add_TX_To_Pool(tx *transaction.Transaction) error {
+	return chain.add_tx_to_pool(tx, false)
+}
+
+// same as Add_TX_To_Pool, but tx enters mempool in dandelion stem phase
+// registrations live in regpool, which has no stem phase
+func (chain *Blockchain) Add_TX_To_Pool_Stem(tx *transaction.Transaction) error {
+	return chain.add_tx_to_pool(tx, true)
+}
+
+func (chain *Blockchain) add_tx_to_pool(tx *transaction.Transaction, stem bool) error {
 	var err error
 
 	if tx.IsPremine() {
@@ -1294,7 +1304,13 @@ func (chain *Blockchain) Add_TX_To_Pool(tx *transaction.Transaction) error {
 		return fmt.Errorf("Incoming TX %s could not be verified, err %s", txhash, err)
 	}
 
-	if chain.Mempool.Mempool_Add_TX(tx, 0) { // new tx come with 0 marker
+	var added bool
+	if stem {
+		added = chain.Mempool.Mempool_Add_TX_Stem(tx, 0)
+	} else {
+		added = chain.Mempool.Mempool_Add_TX(tx, 0) // new tx come with 0 marker
+	}
+	if added {
 		//rlog.Tracef(2, "Successfully added tx %s to pool", txhash)
 		return nil
 	} else {
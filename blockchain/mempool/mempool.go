@@ -48,6 +48,7 @@ type TX_Sorting_struct struct {
 type Mempool struct {
 	txs           sync.Map            //map[crypto.Hash]*mempool_object
 	nonces        sync.Map            //map[crypto.Hash]bool // contains key images of all txs
+	stem          sync.Map            //map[crypto.Hash]bool // txs in dandelion stem phase, hidden from listings
 	sorted_by_fee []crypto.Hash       // contains txids sorted by fees
 	sorted        []TX_Sorting_struct // contains TX sorting information, so as new block can be forged easily
 	modified      bool                // used to monitor whethel mem pool contents have changed,
@@ -146,6 +147,15 @@ func (pool *Mempool) HasChanged() (result bool) {
 
 // a tx should only be added to pool after verification is complete
 func (pool *Mempool) Mempool_Add_TX(tx *transaction.Transaction, Height uint64) (result bool) {
+	return pool.add_tx(tx, Height, false)
+}
+
+// add a tx in stem phase, it will not be listed or mined till it is fluffed
+func (pool *Mempool) Mempool_Add_TX_Stem(tx *transaction.Transaction, Height uint64) (result bool) {
+	return pool.add_tx(tx, Height, true)
+}
+
+func (pool *Mempool) add_tx(tx *transaction.Transaction, Height uint64, stem bool) (result bool) {
 	result = false
 	pool.Lock()
 	defer pool.Unlock()
@@ -183,6 +193,9 @@ func (pool *Mempool) Mempool_Add_TX(tx *transaction.Transaction, Height uint64)
 	object.Size = uint64(len(tx.Serialize()))
 	object.FEEperBYTE = tx.Fees() / object.Size
 
+	if stem { // tx must never be visible outside stem phase
+		pool.stem.Store(tx_hash, true)
+	}
 	pool.txs.Store(tx_hash, &object)
 	pool.modified = true // pool has been modified
 
@@ -232,6 +245,7 @@ func (pool *Mempool) Mempool_Delete_TX(txid crypto.Hash) (tx *transaction.Transa
 	object := objecti.(*mempool_object)
 	tx = object.Tx
 	pool.txs.Delete(txid)
+	pool.stem.Delete(txid)
 
 	// remove all the key images
 	//TODO
@@ -247,6 +261,23 @@ func (pool *Mempool) Mempool_Delete_TX(txid crypto.Hash) (tx *transaction.Transa
 	return object.Tx     // return the tx
 }
 
+// tx enters fluff phase, returns true if tx was in stem phase
+func (pool *Mempool) Mempool_Fluff(txid crypto.Hash) bool {
+	if _, ok := pool.stem.LoadAndDelete(txid); !ok {
+		return false
+	}
+	pool.Lock()
+	pool.modified = true
+	pool.Unlock()
+	return true
+}
+
+// check whether a tx is in stem phase
+func (pool *Mempool) Mempool_Is_Stem(txid crypto.Hash) bool {
+	_, ok := pool.stem.Load(txid)
+	return ok
+}
+
 // get specific tx from mem pool without removing it
 func (pool *Mempool) Mempool_Get_TX(txid crypto.Hash) (tx *transaction.Transaction) {
 	//	pool.Lock()
@@ -267,7 +298,7 @@ func (pool *Mempool) Mempool_Get_TX(txid crypto.Hash) (tx *transaction.Transacti
 	return object.Tx
 }
 
-// return list of all txs in pool
+// return list of all txs in pool, txs in stem phase are not listed
 func (pool *Mempool) Mempool_List_TX() []crypto.Hash {
 	//	pool.Lock()
 	//	defer pool.Unlock()
@@ -276,6 +307,9 @@ func (pool *Mempool) Mempool_List_TX() []crypto.Hash {
 
 	pool.txs.Range(func(k, value interface{}) bool {
 		txhash := k.(crypto.Hash)
+		if pool.Mempool_Is_Stem(txhash) {
+			return true
+		}
 		//v := value.(*mempool_object)
 		//objects = append(objects, *v)
 		list = append(list, txhash)
@@ -367,7 +401,7 @@ func (pool *Mempool) sort_list() ([]crypto.Hash, []TX_Sorting_struct) {
 	pool.txs.Range(func(k, value interface{}) bool {
 		txhash := k.(crypto.Hash)
 		v := value.(*mempool_object)
-		if v.Height <= pool.height {
+		if v.Height <= pool.height && !pool.Mempool_Is_Stem(txhash) { // stem txs are not mined, as that would reveal them
 			data = append(data, TX_Sorting_struct{Hash: txhash, FeesPerByte: v.FEEperBYTE, Size: v.Size})
 		}
 		return true
@@ -114,6 +114,29 @@ func Test_mempool(t *testing.T) {
 		t.Errorf("Pool must have necessary key image")
 	}
 
+	// stem txs must be hidden from listing and mining
+	if pool.Mempool_Add_TX_Stem(&tx, 0) || pool.Mempool_Is_Stem(tx.GetHash()) {
+		t.Errorf("TX already in pool cannot enter stem phase")
+	}
+	pool.Mempool_Delete_TX(tx.GetHash())
+	if !pool.Mempool_Add_TX_Stem(&tx, 0) || !pool.Mempool_Is_Stem(tx.GetHash()) {
+		t.Errorf("TX should be in stem phase")
+	}
+	if len(pool.Mempool_List_TX()) != 0 || len(pool.Mempool_List_TX_SortedInfo()) != 0 {
+		t.Errorf("stem TX must not be listed")
+	}
+	if !pool.Mempool_TX_Exist(tx.GetHash()) || pool.Mempool_Get_TX(tx.GetHash()) == nil {
+		t.Errorf("stem TX must still be in pool")
+	}
+	if !pool.Mempool_Fluff(tx.GetHash()) || pool.Mempool_Fluff(tx.GetHash()) {
+		t.Errorf("TX should fluff exactly once")
+	}
+	if len(pool.Mempool_List_TX()) != 1 || len(pool.Mempool_List_TX_SortedInfo()) != 1 {
+		t.Errorf("fluffed TX must be listed")
+	}
+	pool.Mempool_Delete_TX(tx.GetHash())
+	pool.Mempool_Add_TX_Stem(&tx, 0)
+
 	// lets delete
 	if pool.Mempool_Delete_TX(tx.GetHash()) == nil {
 		t.Errorf("existing TX cannot be deleted\n")
@@ -132,4 +155,7 @@ func Test_mempool(t *testing.T) {
 		t.Errorf("Pool should  have 0 tx")
 	}
 
+	if pool.Mempool_Is_Stem(tx.GetHash()) {
+		t.Errorf("deleted TX must leave stem phase")
+	}
 }
@@ -0,0 +1,640 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package blockchain
+
+// this file implements offline state snapshots, so new nodes can be provisioned from a file instead of syncing
+// an archive contains the complete state at a base topoheight and the recent blocks till a stable topoheight,
+// every recent block carries the state changes it did, exactly like fast sync does
+// the archive is MAGIC, followed by length prefixed cbor records, followed by sha256 of everything before it
+// blocks do not commit to state, so import checks the top block against a block id supplied by the operator
+// and the state hash of the imported trees against the state hash recorded for that block
+
+import "io"
+import "os"
+import "fmt"
+import "bytes"
+import "bufio"
+import "math/big"
+import "crypto/sha256"
+import "path/filepath"
+import "encoding/binary"
+
+import "github.com/fxamacker/cbor/v2"
+
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/block"
+import "github.com/deroproject/derohe/dvm"
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/transaction"
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/graviton"
+
+const SNAPSHOT_MAGIC = "DEROSNAP"
+const SNAPSHOT_VERSION = 1
+
+const snapshot_recent_blocks = 50    // blocks exported with their state changes
+const snapshot_chunk_size = 10000    // keys per tree record
+const snapshot_max_record = 64 << 20 // records larger than this are considered corruption
+
+type Snapshot_Header struct {
+	Version        int         `cbor:"VERSION"`
+	Network_ID     [16]byte    `cbor:"NID"`
+	Base_Topo      int64       `cbor:"BTOPO"`  // complete state is exported at this topoheight
+	Base_StateHash crypto.Hash `cbor:"BSTATE"` // state hash at base topoheight
+	Topo           int64       `cbor:"TOPO"`   // topoheight of top block
+	BLID           crypto.Hash `cbor:"BLID"`   // top block
+	StateHash      crypto.Hash `cbor:"STATE"`  // state hash at top block
+	Block_Count    int64       `cbor:"BLOCKS"`
+}
+
+type snapshot_tree_changes struct {
+	TreeName []byte   `cbor:"TREENAME"`
+	Keys     [][]byte `cbor:"KEYS,omitempty"`
+	Values   [][]byte `cbor:"VALUES,omitempty"`
+	Deleted  [][]byte `cbor:"DELETED,omitempty"`
+}
+
+// a record is either a chunk of a base state tree or a recent block
+type snapshot_record struct {
+	Tree       *snapshot_tree_changes  `cbor:"TREE,omitempty"`
+	Topo       int64                   `cbor:"TOPO,omitempty"`
+	Block      []byte                  `cbor:"BLOCK,omitempty"`
+	Txs        [][]byte                `cbor:"TXS,omitempty"`
+	Difficulty string                  `cbor:"DIFF,omitempty"`
+	Changes    []snapshot_tree_changes `cbor:"CHANGES,omitempty"` // changes done by block to state
+}
+
+type snapshot_writer struct {
+	w   *bufio.Writer
+	sum io.Writer
+}
+
+func (s *snapshot_writer) write(v interface{}) error {
+	data, err := cbor.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	if _, err = s.w.Write(length[:]); err == nil {
+		_, err = s.w.Write(data)
+	}
+	return err
+}
+
+type snapshot_reader struct {
+	r *bufio.Reader
+}
+
+func (s *snapshot_reader) read(v interface{}) error {
+	var length [4]byte
+	if _, err := io.ReadFull(s.r, length[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > snapshot_max_record {
+		return fmt.Errorf("snapshot record too large %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(s.r, data); err != nil {
+		return err
+	}
+	return cbor.Unmarshal(data, v)
+}
+
+// state hash of a version, it is same as Load_Merkle_Hash but works on any store
+func snapshot_state_hash(store *graviton.Store, version uint64) (hash crypto.Hash, err error) {
+	var ss *graviton.Snapshot
+	var balance_tree, meta_tree *graviton.Tree
+	var balance_hash, meta_hash [32]byte
+	if ss, err = store.LoadSnapshot(version); err != nil {
+		return
+	}
+	if balance_tree, err = ss.GetTree(config.BALANCE_TREE); err != nil {
+		return
+	}
+	if meta_tree, err = ss.GetTree(config.SC_META); err != nil {
+		return
+	}
+	if balance_hash, err = balance_tree.Hash(); err != nil {
+		return
+	}
+	if meta_hash, err = meta_tree.Hash(); err != nil {
+		return
+	}
+	for i := range hash {
+		hash[i] = balance_hash[i] ^ meta_hash[i]
+	}
+	return
+}
+
+// export state snapshot at a stable topoheight, if topo is 0, most recent stable topoheight is used
+func (chain *Blockchain) Export_Snapshot(path string, topo int64) (header Snapshot_Header, err error) {
+	if topo <= 0 {
+		stable_height := chain.Get_Stable_Height()
+		for topo = chain.Load_TOPO_HEIGHT(); topo > 0; topo-- {
+			if toporecord, err := chain.Store.Topo_store.Read(topo); err == nil && toporecord.Height <= stable_height {
+				break
+			}
+		}
+	}
+
+	header = Snapshot_Header{Version: SNAPSHOT_VERSION, Network_ID: globals.Config.Network_ID, Topo: topo, Base_Topo: topo - (snapshot_recent_blocks - 1), Block_Count: snapshot_recent_blocks}
+	if header.Base_Topo < 2 || header.Base_Topo <= chain.Pruned {
+		return header, fmt.Errorf("topoheight %d cannot be exported, chain is pruned till %d", topo, chain.Pruned)
+	}
+	if topo > chain.Load_TOPO_HEIGHT() {
+		return header, fmt.Errorf("topoheight %d is above chain topoheight %d", topo, chain.Load_TOPO_HEIGHT())
+	}
+	base_record, err := chain.Store.Topo_store.Read(header.Base_Topo)
+	if err != nil {
+		return
+	}
+	top_record, err := chain.Store.Topo_store.Read(topo)
+	if err != nil {
+		return
+	}
+	header.BLID = top_record.BLOCK_ID
+	if top_record.Height > chain.Get_Stable_Height() {
+		globals.Logger.Info("Exporting topoheight which is not yet stable", "topoheight", topo)
+	}
+	if header.Base_StateHash, err = chain.Load_Merkle_Hash(base_record.State_Version); err != nil {
+		return
+	}
+	if header.StateHash, err = chain.Load_Merkle_Hash(top_record.State_Version); err != nil {
+		return
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp) // only on failure, rename moves it
+	defer file.Close()
+
+	checksum := sha256.New()
+	s := snapshot_writer{w: bufio.NewWriterSize(io.MultiWriter(file, checksum), 1<<20)}
+	if _, err = s.w.WriteString(SNAPSHOT_MAGIC); err != nil {
+		return
+	}
+	if err = s.write(header); err != nil {
+		return
+	}
+
+	// base state, in chunks, SC data trees follow meta tree
+	keys := int64(0)
+	ss, err := chain.Store.Balance_store.LoadSnapshot(base_record.State_Version)
+	if err != nil {
+		return
+	}
+	treenames := []string{config.BALANCE_TREE, config.SC_META}
+	for i := 0; i < len(treenames); i++ {
+		var tree *graviton.Tree
+		if tree, err = ss.GetTree(treenames[i]); err != nil {
+			return
+		}
+		chunk := snapshot_tree_changes{TreeName: []byte(treenames[i])}
+		c := tree.Cursor()
+		for k, v, err := c.First(); err == nil; k, v, err = c.Next() {
+			chunk.Keys = append(chunk.Keys, k)
+			chunk.Values = append(chunk.Values, v)
+			if treenames[i] == config.SC_META {
+				treenames = append(treenames, string(k))
+			}
+			if len(chunk.Keys) >= snapshot_chunk_size {
+				keys += int64(len(chunk.Keys))
+				if err = s.write(snapshot_record{Tree: &chunk}); err != nil {
+					return header, err
+				}
+				chunk = snapshot_tree_changes{TreeName: []byte(treenames[i])}
+			}
+		}
+		keys += int64(len(chunk.Keys))
+		if err = s.write(snapshot_record{Tree: &chunk}); err != nil { // every tree has atleast 1 record, even if empty
+			return
+		}
+	}
+	globals.Logger.Info("Snapshot state exported", "topoheight", header.Base_Topo, "keys", keys, "trees", len(treenames))
+
+	// recent blocks with their changes
+	for t := header.Base_Topo; t <= topo; t++ {
+		var record snapshot_record
+		if record, err = chain.snapshot_block(t, t == header.Base_Topo); err != nil {
+			return
+		}
+		if err = s.write(record); err != nil {
+			return
+		}
+	}
+
+	if err = s.w.Flush(); err != nil {
+		return
+	}
+	if _, err = file.Write(checksum.Sum(nil)); err != nil {
+		return
+	}
+	if err = file.Sync(); err != nil {
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	err = os.Rename(tmp, path)
+	return
+}
+
+// loads a block, its txs and changes it did to state, first block of archive does not carry changes
+func (chain *Blockchain) snapshot_block(topo int64, base bool) (record snapshot_record, err error) {
+	current_record, err := chain.Store.Topo_store.Read(topo)
+	if err != nil {
+		return
+	}
+	bl, err := chain.Load_BL_FROM_ID(current_record.BLOCK_ID)
+	if err != nil {
+		return
+	}
+	record.Topo = topo
+	record.Block = bl.Serialize()
+	for _, txid := range bl.Tx_hashes {
+		var tx_bytes []byte
+		if tx_bytes, err = chain.Store.Block_tx_store.ReadTX(txid); err != nil {
+			return
+		}
+		record.Txs = append(record.Txs, tx_bytes)
+	}
+	record.Difficulty = chain.Load_Block_Difficulty(current_record.BLOCK_ID).String()
+	if base {
+		return
+	}
+
+	previous_record, err := chain.Store.Topo_store.Read(topo - 1)
+	if err != nil {
+		return
+	}
+	var previous_ss, current_ss *graviton.Snapshot
+	if previous_ss, err = chain.Store.Balance_store.LoadSnapshot(previous_record.State_Version); err != nil {
+		return
+	}
+	if current_ss, err = chain.Store.Balance_store.LoadSnapshot(current_record.State_Version); err != nil {
+		return
+	}
+
+	treenames := []string{config.BALANCE_TREE, config.SC_META}
+	for i := 0; i < len(treenames); i++ {
+		var changes snapshot_tree_changes
+		if changes, err = snapshot_changes(previous_ss, current_ss, treenames[i]); err != nil {
+			return
+		}
+		if treenames[i] == config.SC_META { // every modified SC has its meta modified
+			for _, k := range changes.Keys {
+				treenames = append(treenames, string(k))
+			}
+		}
+		record.Changes = append(record.Changes, changes)
+	}
+	return
+}
+
+func snapshot_changes(previous_ss, current_ss *graviton.Snapshot, treename string) (changes snapshot_tree_changes, err error) {
+	var previous_tree, current_tree *graviton.Tree
+	changes.TreeName = []byte(treename)
+	if previous_tree, err = previous_ss.GetTree(treename); err != nil {
+		return
+	}
+	if current_tree, err = current_ss.GetTree(treename); err != nil {
+		return
+	}
+	change_handler := func(k, v []byte) {
+		changes.Keys = append(changes.Keys, k)
+		changes.Values = append(changes.Values, v)
+	}
+	modify_handler := func(k, v []byte) { // modification receives old value
+		new_value, _ := current_tree.Get(k)
+		change_handler(k, new_value)
+	}
+	delete_handler := func(k, v []byte) {
+		changes.Deleted = append(changes.Deleted, k)
+	}
+	err = graviton.Diff(previous_tree, current_tree, delete_handler, modify_handler, change_handler)
+	return
+}
+
+// verifies checksum of the archive and returns its header together with hash of its top block
+func Verify_Snapshot(path string) (header Snapshot_Header, top_blid crypto.Hash, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	fstat, err := file.Stat()
+	if err != nil {
+		return
+	}
+	if fstat.Size() < int64(len(SNAPSHOT_MAGIC)+sha256.Size) {
+		return header, top_blid, fmt.Errorf("snapshot archive is too small")
+	}
+
+	checksum := sha256.New()
+	if _, err = io.Copy(checksum, io.LimitReader(file, fstat.Size()-sha256.Size)); err != nil {
+		return
+	}
+	expected := make([]byte, sha256.Size)
+	if _, err = io.ReadFull(file, expected); err != nil {
+		return
+	}
+	if !bytes.Equal(checksum.Sum(nil), expected) {
+		return header, top_blid, fmt.Errorf("snapshot archive checksum mismatch")
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	s, err := open_snapshot(io.LimitReader(file, fstat.Size()-sha256.Size), &header)
+	if err != nil {
+		return
+	}
+
+	blocks := int64(0)
+	for {
+		var record snapshot_record
+		if err = s.read(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return
+		}
+		if record.Block == nil {
+			continue
+		}
+		var bl block.Block
+		if err = bl.Deserialize(record.Block); err != nil {
+			return
+		}
+		if record.Topo != header.Base_Topo+blocks {
+			return header, top_blid, fmt.Errorf("snapshot block at topoheight %d is out of order", record.Topo)
+		}
+		top_blid = bl.GetHash()
+		blocks++
+	}
+	err = nil
+	if blocks != header.Block_Count || header.Base_Topo+blocks-1 != header.Topo {
+		return header, top_blid, fmt.Errorf("snapshot archive contains %d blocks, expected %d", blocks, header.Block_Count)
+	}
+	if top_blid != header.BLID {
+		return header, top_blid, fmt.Errorf("snapshot top block %s does not match header %s", top_blid, header.BLID)
+	}
+	return
+}
+
+func open_snapshot(r io.Reader, header *Snapshot_Header) (s snapshot_reader, err error) {
+	s.r = bufio.NewReaderSize(r, 1<<20)
+	magic := make([]byte, len(SNAPSHOT_MAGIC))
+	if _, err = io.ReadFull(s.r, magic); err != nil {
+		return
+	}
+	if string(magic) != SNAPSHOT_MAGIC {
+		return s, fmt.Errorf("not a snapshot archive")
+	}
+	if err = s.read(header); err != nil {
+		return
+	}
+	if header.Version != SNAPSHOT_VERSION {
+		return s, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+	if header.Network_ID != globals.Config.Network_ID {
+		return s, fmt.Errorf("snapshot belongs to different network")
+	}
+	return
+}
+
+// imports an archive into an empty data directory, before the chain is started
+// the archive is validated against operator supplied top block id and state hash before anything is written
+// the archive's own header cannot be trusted, so a state hash must always be supplied
+func Import_Snapshot(path string, blid crypto.Hash, state_hash crypto.Hash) (err error) {
+	if state_hash.IsZero() {
+		return fmt.Errorf("snapshot import requires a trusted state hash")
+	}
+	header, top_blid, err := Verify_Snapshot(path)
+	if err != nil {
+		return
+	}
+	if top_blid != blid {
+		return fmt.Errorf("snapshot top block %s does not match supplied block %s", top_blid, blid)
+	}
+	if header.StateHash != state_hash {
+		return fmt.Errorf("snapshot state hash %s does not match supplied state hash %s", header.StateHash, state_hash)
+	}
+	globals.Logger.Info("Snapshot archive verified", "topoheight", header.Topo, "blid", header.BLID, "statehash", header.StateHash)
+
+	var store storage
+	current_path := filepath.Join(globals.GetDataDirectory())
+	if store.Balance_store, err = graviton.NewDiskStore(filepath.Join(current_path, "balances")); err != nil {
+		return
+	}
+	defer store.Balance_store.Close()
+	if err = store.Topo_store.Open(current_path); err != nil {
+		return
+	}
+	defer store.Topo_store.topomapping.Close()
+	store.Block_tx_store.basedir = current_path
+
+	if store.Topo_store.Count() != 0 || store.IsBalancesIntialized() {
+		return fmt.Errorf("data directory %s already contains a chain, import requires an empty data directory", current_path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fstat, err := file.Stat()
+	if err != nil {
+		return
+	}
+	s, err := open_snapshot(io.LimitReader(file, fstat.Size()-sha256.Size), &header)
+	if err != nil {
+		return
+	}
+
+	var commit_version uint64
+	var keys int64
+	state_verified := false
+	for {
+		var record snapshot_record
+		if err = s.read(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return
+		}
+
+		if record.Tree != nil { // base state
+			var ss *graviton.Snapshot
+			var tree *graviton.Tree
+			if ss, err = store.Balance_store.LoadSnapshot(0); err != nil {
+				return
+			}
+			if tree, err = ss.GetTree(string(record.Tree.TreeName)); err != nil {
+				return
+			}
+			if len(record.Tree.Keys) != len(record.Tree.Values) {
+				return fmt.Errorf("snapshot tree %x key count %d value count %d", record.Tree.TreeName, len(record.Tree.Keys), len(record.Tree.Values))
+			}
+			for i := range record.Tree.Keys {
+				if err = tree.Put(record.Tree.Keys[i], record.Tree.Values[i]); err != nil {
+					return
+				}
+			}
+			if commit_version, err = graviton.Commit(tree); err != nil {
+				return
+			}
+			keys += int64(len(record.Tree.Keys))
+			continue
+		}
+
+		if !state_verified { // all trees have been written, whatever we have written must match state hash
+			var hash crypto.Hash
+			if hash, err = snapshot_state_hash(store.Balance_store, commit_version); err != nil {
+				return
+			}
+			if hash != header.Base_StateHash {
+				return fmt.Errorf("imported state hash %s does not match archive %s, delete data directory before retrying", hash, header.Base_StateHash)
+			}
+			if err = snapshot_verify_sc_trees(store.Balance_store, commit_version); err != nil {
+				return
+			}
+			for i := int64(0); i < header.Base_Topo; i++ {
+				store.Topo_store.Write(i, crypto.Hash{}, commit_version, 0) // commit everything
+			}
+			store.Topo_store.Sync()
+			globals.Logger.Info("Snapshot state imported", "topoheight", header.Base_Topo, "keys", keys)
+			state_verified = true
+		}
+
+		if commit_version, err = snapshot_import_block(&store, record, record.Topo != header.Base_Topo); err != nil {
+			return
+		}
+	}
+
+	var hash crypto.Hash
+	if hash, err = snapshot_state_hash(store.Balance_store, commit_version); err != nil {
+		return
+	}
+	if hash != header.StateHash {
+		return fmt.Errorf("imported state hash %s does not match archive %s, delete data directory before retrying", hash, header.StateHash)
+	}
+	store.Topo_store.Sync()
+	globals.Logger.Info("Snapshot imported successfully", "topoheight", header.Topo, "blid", header.BLID, "statehash", hash)
+	return nil
+}
+
+// every SC data tree must match hash recorded in its meta, old format metas do not record it
+func snapshot_verify_sc_trees(store *graviton.Store, version uint64) (err error) {
+	var ss *graviton.Snapshot
+	var meta_tree *graviton.Tree
+	if ss, err = store.LoadSnapshot(version); err != nil {
+		return
+	}
+	if meta_tree, err = ss.GetTree(config.SC_META); err != nil {
+		return
+	}
+	c := meta_tree.Cursor()
+	for k, v, err := c.First(); err == nil; k, v, err = c.Next() {
+		var meta dvm.SC_META_DATA
+		if meta.UnmarshalBinaryGood(v) != nil || meta.DataHash.IsZero() {
+			continue
+		}
+		var data_tree *graviton.Tree
+		var hash [32]byte
+		if data_tree, err = ss.GetTree(string(k)); err != nil {
+			return err
+		}
+		if hash, err = data_tree.Hash(); err != nil {
+			return err
+		}
+		if hash != meta.DataHash {
+			return fmt.Errorf("SC %x data tree %x does not match meta %s", k, hash, meta.DataHash)
+		}
+	}
+	return nil
+}
+
+// writes recent block, applying its changes to the state
+func snapshot_import_block(store *storage, record snapshot_record, apply_changes bool) (commit_version uint64, err error) {
+	var bl block.Block
+	if err = bl.Deserialize(record.Block); err != nil {
+		return
+	}
+	if len(record.Txs) != len(bl.Tx_hashes) {
+		return 0, fmt.Errorf("block %s tx count %d expected %d", bl.GetHash(), len(record.Txs), len(bl.Tx_hashes))
+	}
+	for i := range record.Txs {
+		var tx transaction.Transaction
+		if err = tx.Deserialize(record.Txs[i]); err != nil {
+			return
+		}
+		if tx.GetHash() != bl.Tx_hashes[i] {
+			return 0, fmt.Errorf("block %s tx %s mismatch", bl.GetHash(), tx.GetHash())
+		}
+		if err = store.Block_tx_store.WriteTX(bl.Tx_hashes[i], record.Txs[i]); err != nil {
+			return
+		}
+	}
+
+	diff := new(big.Int)
+	if _, ok := diff.SetString(record.Difficulty, 10); !ok {
+		return 0, fmt.Errorf("block %s difficulty %q cannot be parsed", bl.GetHash(), record.Difficulty)
+	}
+
+	var ss *graviton.Snapshot
+	if ss, err = store.Balance_store.LoadSnapshot(0); err != nil {
+		return
+	}
+	commit_version = ss.GetVersion()
+	if apply_changes {
+		var changed_trees []*graviton.Tree
+		for _, change := range record.Changes {
+			var tree *graviton.Tree
+			if tree, err = ss.GetTree(string(change.TreeName)); err != nil {
+				return
+			}
+			if len(change.Keys) != len(change.Values) {
+				return 0, fmt.Errorf("block %s changes to tree %x are malformed", bl.GetHash(), change.TreeName)
+			}
+			for j := range change.Keys {
+				if err = tree.Put(change.Keys[j], change.Values[j]); err != nil {
+					return
+				}
+			}
+			for j := range change.Deleted {
+				if err = tree.Delete(change.Deleted[j]); err != nil {
+					return
+				}
+			}
+			changed_trees = append(changed_trees, tree)
+		}
+		if commit_version, err = graviton.Commit(changed_trees...); err != nil {
+			return
+		}
+	}
+
+	if err = store.Block_tx_store.WriteBlock(bl.GetHash(), bl.Serialize(), diff, commit_version, bl.Height); err != nil {
+		return
+	}
+	err = store.Topo_store.Write(record.Topo, bl.GetHash(), commit_version, int64(bl.Height))
+	return
+}
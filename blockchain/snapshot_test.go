@@ -0,0 +1,78 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package blockchain
+
+import "io"
+import "bytes"
+import "bufio"
+import "testing"
+import "strings"
+
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/globals"
+
+func Test_Snapshot_Records(t *testing.T) {
+	var buf bytes.Buffer
+	s := snapshot_writer{w: bufio.NewWriter(&buf)}
+	s.w.WriteString(SNAPSHOT_MAGIC)
+
+	header := Snapshot_Header{Version: SNAPSHOT_VERSION, Network_ID: globals.Config.Network_ID, Base_Topo: 2, Topo: 51, Block_Count: snapshot_recent_blocks}
+	tree := snapshot_tree_changes{TreeName: []byte("B"), Keys: [][]byte{[]byte("k")}, Values: [][]byte{[]byte("v")}}
+	if err := s.write(header); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write(snapshot_record{Tree: &tree}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.write(snapshot_record{Topo: 2, Block: []byte{1, 2, 3}, Difficulty: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	s.w.Flush()
+
+	var decoded Snapshot_Header
+	r, err := open_snapshot(bytes.NewReader(buf.Bytes()), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != header {
+		t.Fatalf("header mismatch %+v %+v", decoded, header)
+	}
+	var record snapshot_record
+	if err = r.read(&record); err != nil || record.Tree == nil || string(record.Tree.Keys[0]) != "k" || string(record.Tree.Values[0]) != "v" {
+		t.Fatalf("tree record mismatch %+v err %v", record, err)
+	}
+	record = snapshot_record{}
+	if err = r.read(&record); err != nil || record.Tree != nil || record.Topo != 2 || !bytes.Equal(record.Block, []byte{1, 2, 3}) {
+		t.Fatalf("block record mismatch %+v err %v", record, err)
+	}
+	if err = r.read(&record); err != io.EOF {
+		t.Fatalf("expected EOF err %v", err)
+	}
+
+	other := buf.Bytes()
+	other[0] ^= 1
+	if _, err = open_snapshot(bytes.NewReader(other), &decoded); err == nil {
+		t.Fatalf("archive without magic must be rejected")
+	}
+}
+
+// archive header cannot be trusted, import must be anchored to an operator supplied state hash
+func Test_Snapshot_Import_Requires_Root(t *testing.T) {
+	if err := Import_Snapshot("/nonexistent/snapshot", crypto.Hash{1}, crypto.Hash{}); err == nil || !strings.Contains(err.Error(), "state hash") {
+		t.Fatalf("import without state hash must be rejected, err %v", err)
+	}
+}
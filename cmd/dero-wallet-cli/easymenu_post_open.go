@@ -96,7 +96,7 @@ func handle_easymenu_post_open_command(l *readline.Instance, line string) (proce
 	case "1":
 		fmt.Fprintf(l.Stderr(), "Wallet address : "+color_green+"%s"+color_white+"\n", wallet.GetAddress())
 
-		if !wallet.IsRegistered() {
+		if !wallet.IsRegistered() && !wallet.IsViewOnly() {
 			reg_tx := wallet.GetRegistrationTX()
 			fmt.Fprintf(l.Stderr(), "Registration TX : "+color_green+"%x"+color_white+"\n", reg_tx.Serialize())
 		}
@@ -124,7 +124,9 @@ func handle_easymenu_post_open_command(l *readline.Instance, line string) (proce
 
 	case "4": // Registration
 
-		if !wallet.IsRegistered() {
+		if wallet.IsViewOnly() {
+			logger.Error(fmt.Errorf("view only wallet cannot register"), "")
+		} else if !wallet.IsRegistered() {
 
 			fmt.Fprintf(l.Stderr(), "Wallet address : "+color_green+"%s"+color_white+" is going to be registered.This is a pre-condition for using the online chain.It will take few seconds to register.\n", wallet.GetAddress())
 
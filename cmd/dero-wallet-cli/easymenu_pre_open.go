@@ -17,6 +17,7 @@
 package main
 
 import "io"
+import "os"
 import "fmt"
 import "time"
 import "strconv"
@@ -30,6 +31,7 @@ import "github.com/deroproject/derohe/config"
 import "github.com/deroproject/derohe/globals"
 import "github.com/deroproject/derohe/walletapi"
 import "github.com/deroproject/derohe/walletapi/rpcserver"
+import "github.com/deroproject/derohe/walletapi/mnemonics"
 
 // display menu before a wallet is opened
 func display_easymenu_pre_open_command(l *readline.Instance) {
@@ -39,6 +41,9 @@ func display_easymenu_pre_open_command(l *readline.Instance) {
 	io.WriteString(w, "\t\033[1m2\033[0m\tCreate New Wallet\n")
 	io.WriteString(w, "\t\033[1m3\033[0m\tRecover Wallet using recovery seed (25 words)\n")
 	io.WriteString(w, "\t\033[1m4\033[0m\tRecover Wallet using recovery key (64 char private spend key hex)\n")
+	io.WriteString(w, "\t\033[1m5\033[0m\tCreate view only Wallet using view credential file\n")
+	io.WriteString(w, "\t\033[1m6\033[0m\tRestore Wallet from encrypted backup file\n")
+	io.WriteString(w, "\t\033[1m7\033[0m\tRecover Wallet using seed shares ("+strconv.Itoa(mnemonics.SHARE_LENGTH)+" words each)\n")
 	io.WriteString(w, "\n\t\033[1m9\033[0m\tExit menu and start prompt\n")
 	io.WriteString(w, "\t\033[1m0\033[0m\tExit Wallet\n")
 }
@@ -157,45 +162,77 @@ func handle_easymenu_pre_open_command(l *readline.Instance, line string) {
 
 		display_seed(l, wallet)
 		common_processing(wallet)
-		/*
-		   	case "5": // create new view only wallet // TODO user providing wrong key is not being validated, do it ASAP
-
-		   		filename := choose_file_name(l)
-		   		view_key_string := read_line_with_prompt(l, "Please enter your View Only Key ( hex 128 chars): ")
-
-		   		password := ReadConfirmedPassword(l, "Enter password", "Confirm password")
-		   		wallet, err = walletapi.Create_Encrypted_Wallet_ViewOnly(filename, password, view_key_string)
-
-		   		if err != nil {
-		   			globals.Logger.Warnf("Error while reconstructing view only wallet using view key err %s\n", err)
-		   			break
-		   		}
-
-		   		if globals.Arguments["--offline"].(bool) == true {
-		   			//offline_mode = true
-		   		} else {
-		   			wallet.SetOnlineMode()
-		   		}
-		           case "6": // create non deterministic wallet // TODO user providing wrong key is not being validated, do it ASAP
-
-		   		filename := choose_file_name(l)
-		   		spend_key_string := read_line_with_prompt(l, "Please enter your Secret spend key ( hex 64 chars): ")
-		                   view_key_string := read_line_with_prompt(l, "Please enter your Secret view key ( hex 64 chars): ")
-
-		   		password := ReadConfirmedPassword(l, "Enter password", "Confirm password")
-		   		wallet, err = walletapi.Create_Encrypted_Wallet_NonDeterministic(filename, password, spend_key_string,view_key_string)
-
-		   		if err != nil {
-		   			globals.Logger.Warnf("Error while reconstructing view only wallet using view key err %s\n", err)
-		   			break
-		   		}
-
-		   		if globals.Arguments["--offline"].(bool) == true {
-		   			//offline_mode = true
-		   		} else {
-		   			wallet.SetOnlineMode()
-		   		}
-		*/
+
+	case "5": // create view only wallet from view credential exported by the owner
+
+		filename := choose_file_name(l)
+		credential_file := read_line_with_prompt(l, "Please enter view credential file : ")
+
+		credential, err := os.ReadFile(strings.TrimSpace(credential_file))
+		if err != nil {
+			logger.Error(err, "Cannot read view credential file")
+			break
+		}
+
+		password := ReadConfirmedPassword(l, "Enter password", "Confirm password")
+		wallett, err = walletapi.Create_Encrypted_Wallet_ViewOnly(filename, password, string(credential))
+		if err != nil {
+			logger.Error(err, "Error while creating view only wallet using view credential")
+			break
+		}
+		logger.Info("Successfully created view only wallet, it shows state at credential topoheight only, import newer credentials to update", "topoheight", wallett.Get_TopoHeight())
+		logger.Info("Disclosed history will be shown once verified against blockchain")
+		wallet = wallett
+		wallett = nil
+		common_processing(wallet)
+	case "6": // restore wallet along with history and settings from encrypted backup
+
+		filename := choose_file_name(l)
+		backup_file := read_line_with_prompt(l, "Please enter backup file : ")
+
+		backup, err := os.ReadFile(strings.TrimSpace(backup_file))
+		if err != nil {
+			logger.Error(err, "Cannot read backup file")
+			break
+		}
+
+		backup_password := ReadPassword(l, strings.TrimSpace(backup_file))
+		password := ReadConfirmedPassword(l, "Enter password", "Confirm password")
+		wallett, err = walletapi.Restore_Encrypted_Wallet(filename, password, backup_password, backup)
+		if err != nil {
+			logger.Error(err, "Error while restoring wallet using backup")
+			break
+		}
+		logger.Info("Successfully restored wallet from backup, history will be verified against blockchain once online", "topoheight", wallett.Get_TopoHeight())
+		wallet = wallett
+		wallett = nil
+		common_processing(wallet)
+	case "7": // create wallet from seed shares
+
+		filename := choose_file_name(l)
+		password := ReadConfirmedPassword(l, "Enter password", "Confirm password")
+
+		var shares []string
+		share := read_line_with_prompt(l, fmt.Sprintf("Enter share 1 (%d words) : ", mnemonics.SHARE_LENGTH))
+		_, first, err := mnemonics.Words_To_Share(share)
+		if err != nil {
+			logger.Error(err, "Invalid share")
+			break
+		}
+		shares = append(shares, share)
+		for i := 2; i <= int(first.Threshold); i++ {
+			shares = append(shares, read_line_with_prompt(l, fmt.Sprintf("Enter share %d of %d (%d words) : ", i, first.Threshold, mnemonics.SHARE_LENGTH)))
+		}
+
+		wallett, err = walletapi.Create_Encrypted_Wallet_From_Shares(filename, password, shares)
+		if err != nil {
+			logger.Error(err, "Error while recovering wallet using seed shares.")
+			break
+		}
+		wallet = wallett
+		wallett = nil
+		logger.Info("Successfully recovered wallet from seed shares")
+		common_processing(wallet)
 	case "9":
 		menu_mode = false
 		logger.Info("Prompt mode enabled")
@@ -247,6 +284,38 @@ func common_processing(wallet *walletapi.Wallet_Disk) {
 		}
 	}
 
+	if globals.Arguments["--sync-workers"] != nil && globals.Arguments["--sync-workers"].(string) != "" {
+		s, err := strconv.Atoi(globals.Arguments["--sync-workers"].(string))
+		if err != nil {
+			logger.Error(err, "Error parsing sync workers(in numeric form)")
+		} else {
+			wallet.SetSyncWorkers(s)
+			logger.Info("Wallet will sync using", "workers", wallet.SetSyncWorkers(-1))
+		}
+	}
+
+	if globals.Arguments["--webhook-url"] != nil && globals.Arguments["--webhook-url"].(string) != "" {
+		hook := walletapi.Webhook{URL: globals.Arguments["--webhook-url"].(string)}
+		if globals.Arguments["--webhook-secret"] != nil {
+			hook.Secret = globals.Arguments["--webhook-secret"].(string)
+		}
+		if globals.Arguments["--webhook-confirmations"] != nil && globals.Arguments["--webhook-confirmations"].(string) != "" {
+			for _, c := range strings.Split(globals.Arguments["--webhook-confirmations"].(string), ",") {
+				confirmations, err := strconv.ParseInt(strings.TrimSpace(c), 10, 64)
+				if err != nil {
+					logger.Error(err, "Error parsing webhook confirmations(in numeric form)")
+					continue
+				}
+				hook.Confirmations = append(hook.Confirmations, confirmations)
+			}
+		}
+		if err := wallet.AddWebhook(hook); err != nil {
+			logger.Error(err, "Error adding webhook")
+		} else {
+			logger.Info("Webhook enabled", "url", hook.URL, "confirmations", hook.Confirmations, "pending", wallet.GetWebhookQueueLength())
+		}
+	}
+
 	wallet.SetNetwork(!globals.Arguments["--testnet"].(bool))
 
 	// start rpc server if requested
@@ -83,6 +83,11 @@ Usage:
   --allow-rpc-password-change   RPC server will change password if you send "Pass" header with new password
   --scan-top-n-blocks=<100000>  Only scan top N blocks
   --save-every-x-seconds=<300>  Save wallet every x seconds
+  --lookup-table=<file>  Load balance lookup table from this file, generate and save it if missing, file can be shared by wallets
+  --sync-workers=<8>  Number of concurrent daemon requests while syncing history
+  --webhook-url=<url>  Post incoming transfer events to this http(s) url
+  --webhook-secret=<secret>  Secret used to sign webhook events using HMAC-SHA256, required with --webhook-url
+  --webhook-confirmations=<10,100>  Also notify webhook when incoming transfers reach these confirmations
   `
 var menu_mode bool = true // default display menu mode
 // var account_valid bool = false                        // if an account has been opened, do not allow to create new account in this session
@@ -122,12 +127,22 @@ func main() {
 	}
 
 	// init the lookup table one, anyone importing walletapi should init this first, this will take around 1 sec on any recent system
+	table_size := 1 << 21
 	if os.Getenv("USE_BIG_TABLE") != "" {
+		table_size = 1 << 24 // use 8 times more more ram, around 256 MB RAM
+	}
+	if globals.Arguments["--lookup-table"] != nil {
+		fmt.Printf("Please wait, loading precompute table....")
+		if _, err := walletapi.Initialize_LookupTable_File(1, table_size, globals.Arguments["--lookup-table"].(string)); err != nil {
+			fmt.Printf("%s ", err)
+		}
+		fmt.Printf("done\n")
+	} else if table_size > 1<<21 {
 		fmt.Printf("Please wait, generating precompute table....")
-		walletapi.Initialize_LookupTable(1, 1<<24) // use 8 times more more ram, around 256 MB RAM
+		walletapi.Initialize_LookupTable(1, table_size)
 		fmt.Printf("done\n")
 	} else {
-		walletapi.Initialize_LookupTable(1, 1<<21)
+		walletapi.Initialize_LookupTable(1, table_size)
 	}
 
 	// We need to initialize readline first, so it changes stderr to ansi processor on windows
@@ -68,11 +68,15 @@ func handle_prompt_command(l *readline.Instance, line string) {
 
 	// handled closed wallet commands
 	switch command {
-	case "address", "rescan_bc", "seed", "set", "password", "get_tx_key", "i8", "payment_id":
+	case "address", "rescan_bc", "seed", "seed_shares", "set", "password", "get_tx_key", "i8", "payment_id":
 		fallthrough
 	case "spendkey", "transfer", "close":
 		fallthrough
-	case "transfer_all", "sweep_all", "show_transfers", "balance", "status":
+	case "contacts", "contact_add", "contact_del", "contact_update":
+		fallthrough
+	case "transfer_all", "sweep_all", "show_transfers", "pending", "balance", "status":
+		fallthrough
+	case "view_export", "view_import", "view_verify", "prove_receiver", "verify_proof", "backup_export", "backup_import":
 		if wallet == nil {
 			logger.Error(err, "No wallet available")
 			return
@@ -133,6 +137,14 @@ func handle_prompt_command(l *readline.Instance, line string) {
 		}
 		display_seed(l, wallet) // seed should be given only to authenticated users
 
+	case "seed_shares": // split seed in shares, any threshold shares can recover the wallet
+		if !ValidateCurrentPassword(l, wallet) {
+			logger.Error(err, "Invalid password")
+			PressAnyKey(l, wallet)
+			break
+		}
+		display_seed_shares(l, wallet)
+
 	case "spendkey": // give user his spend key
 		display_spend_key(l, wallet)
 
@@ -220,6 +232,118 @@ func handle_prompt_command(l *readline.Instance, line string) {
 			logger.Info("Signature verified successfully.", "file", filename)
 		}
 
+	case "view_export": // export view credential for auditors
+		if !ValidateCurrentPassword(l, wallet) {
+			logger.Error(err, "Invalid password")
+			PressAnyKey(l, wallet)
+			break
+		}
+
+		filename, err := ReadString(l, "Enter file to save view credential", "")
+		if err != nil {
+			logger.Error(err, "Cannot read output file name")
+			break
+		}
+
+		if credential, err := wallet.ExportViewCredential(); err != nil {
+			logger.Error(err, "Cannot export view credential")
+		} else if err := os.WriteFile(filename, []byte(credential), 0600); err != nil {
+			logger.Error(err, "Cannot write output file", "file", filename)
+		} else {
+			logger.Info("successfully exported view credential, it discloses balances and history till current height only, export again to disclose later changes", "file", filename)
+		}
+
+	case "backup_export": // export encrypted backup with history and settings
+		if !ValidateCurrentPassword(l, wallet) {
+			logger.Error(err, "Invalid password")
+			PressAnyKey(l, wallet)
+			break
+		}
+
+		filename, err := ReadString(l, "Enter file to save backup", "")
+		if err != nil {
+			logger.Error(err, "Cannot read output file name")
+			break
+		}
+		backup_password := ReadConfirmedPassword(l, "Enter backup password", "Confirm backup password")
+
+		if backup, err := wallet.ExportBackup(backup_password); err != nil {
+			logger.Error(err, "Cannot export backup")
+		} else if err := os.WriteFile(filename, backup, 0600); err != nil {
+			logger.Error(err, "Cannot write output file", "file", filename)
+		} else {
+			logger.Info("successfully exported encrypted backup", "file", filename)
+		}
+
+	case "backup_import": // restore history and settings of this wallet from an encrypted backup
+		filename, err := ReadString(l, "Enter backup file", "")
+		if err != nil {
+			logger.Error(err, "Cannot read input file name")
+			break
+		}
+
+		backup, err := os.ReadFile(filename)
+		if err != nil {
+			logger.Error(err, "Cannot read input file")
+			break
+		}
+		backup_password := ReadPassword(l, filename)
+		if err := wallet.ImportBackup(backup_password, backup); err != nil {
+			logger.Error(err, "Cannot import backup", "file", filename)
+		} else {
+			logger.Info("successfully imported backup, history will be verified against blockchain once online")
+		}
+
+	case "view_import": // update view only wallet with a newer view credential
+		filename, err := ReadString(l, "Enter view credential file", "")
+		if err != nil {
+			logger.Error(err, "Cannot read input file name")
+			break
+		}
+
+		if credential, err := os.ReadFile(filename); err != nil {
+			logger.Error(err, "Cannot read input file")
+		} else if err := wallet.ImportViewCredential(string(credential)); err != nil {
+			logger.Error(err, "Cannot import view credential", "file", filename)
+		} else {
+			logger.Info("successfully imported view credential, history will be shown once verified against blockchain", "topoheight", wallet.Get_TopoHeight())
+		}
+
+	case "view_verify": // verify view credential against chain
+		if changed, err := wallet.VerifyViewCredential(); err != nil {
+			logger.Error(err, "View credential verification failed")
+		} else if len(changed) > 0 {
+			logger.Info("View credential is valid, but balances have changed since, please import a newer view credential", "scids", changed)
+		} else {
+			logger.Info("View credential is valid and balances are current")
+		}
+
+	case "prove_receiver": // prove_receiver <txid>
+		if len(line_parts) != 2 {
+			logger.Error(fmt.Errorf("prove_receiver needs txid as argument"), "")
+			break
+		}
+		if proofs, err := wallet.GenerateReceiverProof(line_parts[1]); err != nil {
+			logger.Error(err, "Cannot generate receiver proof", "txid", line_parts[1])
+		} else {
+			for _, p := range proofs {
+				fmt.Fprintf(l.Stderr(), "Receiver proof : "+color_green+"%s"+color_white+"\n", p)
+			}
+		}
+
+	case "verify_proof": // verify_proof <txid> <proof>
+		if len(line_parts) != 3 {
+			logger.Error(fmt.Errorf("verify_proof needs txid and proof as arguments"), "")
+			break
+		}
+		if receivers, amounts, payloads, err := wallet.VerifyProof(line_parts[2], line_parts[1]); err != nil {
+			logger.Error(err, "Proof verification failed", "txid", line_parts[1])
+		} else {
+			for i := range receivers {
+				fmt.Fprintf(l.Stderr(), "Receiver %s received "+color_green+"%s"+color_white+" payload %s\n", receivers[i], globals.FormatMoney(amounts[i]), payloads[i])
+			}
+		}
+
 	case "password": // change wallet password
 		if ConfirmYesNoDefaultNo(l, "Change wallet password (y/N)") &&
 			ValidateCurrentPassword(l, wallet) {
@@ -273,6 +397,9 @@ func handle_prompt_command(l *readline.Instance, line string) {
 			break
 		}
 
+	case "pending": // outgoing txs which are not yet stable
+		show_pending_transactions(l, wallet)
+
 	case "set": // set/display different settings
 		handle_set_command(l, line)
 	case "close": // close the account
@@ -326,7 +453,53 @@ func handle_prompt_command(l *readline.Instance, line string) {
 			//fmt.Printf("queued tx err %s\n", err)
 			//build_relay_transaction(l, uid, err, offline_tx, amount_list)
 		}
-	case "transfer":
+	case "transfer": // transfer <address|contact|name> <amount> [<address|contact|name> <amount>]...
+		if !valid_registration_or_display_error(l, wallet) {
+			break
+		}
+		line_parts := line_parts[1:] // remove first part
+		if len(line_parts) < 2 || len(line_parts)%2 != 0 {
+			logger.Error(nil, "transfer needs destination and amount pairs as input parameter")
+			break
+		}
+
+		var transfers []rpc.Transfer
+		for ; len(line_parts) >= 2; line_parts = line_parts[2:] {
+			amount, err := globals.ParseAmount(line_parts[1])
+			if err != nil {
+				logger.Error(err, "Error Parsing amount", "raw", line_parts[1])
+				return
+			}
+			transfers = append(transfers, rpc.Transfer{Destination: line_parts[0], Amount: amount})
+		}
+
+		warnings, err := wallet.ResolveContacts(transfers, true)
+		if err != nil {
+			logger.Error(err, "Error resolving contacts")
+			break
+		}
+		for _, warning := range warnings {
+			logger.Error(nil, warning)
+		}
+
+		for _, t := range transfers {
+			logger.Info("Transfer", "destination", t.Destination, "amount", globals.FormatMoney(t.Amount), "payload", t.Payload_RPC)
+		}
+
+		if ConfirmYesNoDefaultNo(l, "Confirm Transaction (y/N)") && ValidateCurrentPassword(l, wallet) {
+			tx, err := wallet.TransferPayload0(transfers, 0, false, rpc.Arguments{}, 0, false) // empty SCDATA
+			if err != nil {
+				logger.Error(err, "Error while building Transaction")
+				break
+			}
+
+			if err = wallet.SendTransaction(tx); err != nil {
+				logger.Error(err, "Error while dispatching Transaction")
+				break
+			}
+			logger.Info("Dispatched tx", "txid", tx.GetHash().String())
+		}
+
 		// parse the address, amount pair
 		/*
 			line_parts := line_parts[1:] // remove first part
@@ -395,6 +568,67 @@ func handle_prompt_command(l *readline.Instance, line string) {
 			build_relay_transaction(l, tx, inputs, input_sum, change, err, offline, amount_list)
 		*/
 
+	case "contacts": // list address book
+		contacts := wallet.ListContacts()
+		if len(contacts) == 0 {
+			logger.Info("Address book is empty")
+			break
+		}
+		for _, c := range contacts {
+			if c.Name != "" {
+				fmt.Fprintf(l.Stderr(), color_extra_white+"%-16s"+color_normal+" name %s owner %s", c.Label, c.Name, c.Address)
+			} else {
+				fmt.Fprintf(l.Stderr(), color_extra_white+"%-16s"+color_normal+" %s", c.Label, c.Address)
+			}
+			if c.DestinationPort != 0 {
+				fmt.Fprintf(l.Stderr(), " dst port %d", c.DestinationPort)
+			}
+			if len(c.Payload_RPC) >= 1 {
+				fmt.Fprintf(l.Stderr(), " payload %s", c.Payload_RPC)
+			}
+			fmt.Fprintf(l.Stderr(), "\n")
+		}
+
+	case "contact_add": // contact_add <label> <address|integrated address|name> [dstport]
+		if len(line_parts) != 3 && len(line_parts) != 4 {
+			logger.Error(nil, "contact_add needs label, address or name and optional destination port as input parameters")
+			break
+		}
+		dstport := uint64(0)
+		if len(line_parts) == 4 {
+			if dstport, err = strconv.ParseUint(line_parts[3], 0, 64); err != nil {
+				logger.Error(err, "Error parsing destination port")
+				break
+			}
+		}
+		if c, err := wallet.AddContact(line_parts[1], line_parts[2], dstport, nil); err != nil {
+			logger.Error(err, "Contact could not be added")
+		} else {
+			logger.Info("Contact saved", "label", c.Label, "address", c.Address, "name", c.Name, "dstport", c.DestinationPort)
+		}
+
+	case "contact_del":
+		if len(line_parts) != 2 {
+			logger.Error(nil, "contact_del needs label as input parameter")
+			break
+		}
+		if err := wallet.DeleteContact(line_parts[1]); err != nil {
+			logger.Error(err, "Contact could not be deleted")
+		} else {
+			logger.Info("Contact deleted", "label", line_parts[1])
+		}
+
+	case "contact_update": // accept new owner of a name
+		if len(line_parts) != 2 {
+			logger.Error(nil, "contact_update needs label as input parameter")
+			break
+		}
+		if c, err := wallet.UpdateContactOwner(line_parts[1]); err != nil {
+			logger.Error(err, "Contact could not be updated")
+		} else {
+			logger.Info("Contact updated", "label", c.Label, "name", c.Name, "address", c.Address)
+		}
+
 	case "q", "bye", "exit", "quit":
 		globals.Exit_In_Progress = true
 		if wallet != nil {
@@ -496,7 +730,7 @@ func ReadAddress(l *readline.Instance, wallet *walletapi.Wallet_Disk) (a *rpc.Ad
 
 		if len(line) >= 1 {
 			_, err := globals.ParseValidateAddress(string(line))
-			if err != nil {
+			if _, ok := wallet.GetContact(string(line)); err != nil && !ok {
 				if linestr, err = wallet.NameToAddress(string(strings.TrimSpace(string(line)))); err != nil {
 					error_message = " " //err.Error()
 				} else {
@@ -520,7 +754,12 @@ func ReadAddress(l *readline.Instance, wallet *walletapi.Wallet_Disk) (a *rpc.Ad
 	if err != nil {
 		return
 	}
-	if linestr == "" {
+	if _, ok := wallet.GetContact(string(line)); ok { // contacts carry their payload as integrated arguments
+		var owner_changed bool
+		if a, owner_changed, err = wallet.ContactToAddress(string(line)); err == nil && owner_changed {
+			logger.Error(nil, "WARNING: name owner of this contact has changed since it was saved. use contact_update to accept", "contact", string(line), "address", a.BaseAddress().String())
+		}
+	} else if linestr == "" {
 		a, err = globals.ParseValidateAddress(string(line))
 	} else {
 		a, err = globals.ParseValidateAddress(string(linestr))
@@ -902,6 +1141,10 @@ var completer = readline.NewPrefixCompleter(
 	readline.PcItem("help"),
 	readline.PcItem("address"),
 	readline.PcItem("balance"),
+	readline.PcItem("contacts"),
+	readline.PcItem("contact_add"),
+	readline.PcItem("contact_del"),
+	readline.PcItem("contact_update"),
 	readline.PcItem("integrated_address"),
 	readline.PcItem("get_tx_key"),
 	readline.PcItem("filesign"),
@@ -912,6 +1155,7 @@ var completer = readline.NewPrefixCompleter(
 	readline.PcItem("rescan_bc"),
 	readline.PcItem("payment_id"),
 	readline.PcItem("print_height"),
+	readline.PcItem("prove_receiver"),
 	readline.PcItem("seed"),
 
 	readline.PcItem("set",
@@ -919,12 +1163,20 @@ var completer = readline.NewPrefixCompleter(
 		readline.PcItem("seed"),
 		readline.PcItem("priority"),
 	),
+	readline.PcItem("pending"),
 	readline.PcItem("show_transfers"),
 	readline.PcItem("spendkey"),
 	readline.PcItem("status"),
 	readline.PcItem("version"),
 	readline.PcItem("transfer"),
 	readline.PcItem("transfer_all"),
+	readline.PcItem("verify_proof"),
+	readline.PcItem("seed_shares"),
+	readline.PcItem("backup_export"),
+	readline.PcItem("backup_import"),
+	readline.PcItem("view_export"),
+	readline.PcItem("view_import"),
+	readline.PcItem("view_verify"),
 	readline.PcItem("bye"),
 	readline.PcItem("exit"),
 	readline.PcItem("quit"),
@@ -936,20 +1188,36 @@ func usage(w io.Writer) {
 	io.WriteString(w, "\t\033[1mhelp\033[0m\t\tthis help\n")
 	io.WriteString(w, "\t\033[1maddress\033[0m\t\tDisplay user address\n")
 	io.WriteString(w, "\t\033[1mbalance\033[0m\t\tDisplay user balance\n")
+	io.WriteString(w, "\t\033[1mcontacts\033[0m\tDisplay address book\n")
+	io.WriteString(w, "\t\033[1mcontact_add\033[0m\tAdd contact to address book\n")
+	io.WriteString(w, "\t\t\tEg. contact_add <label> <address|integrated address|name> [dstport]\n")
+	io.WriteString(w, "\t\033[1mcontact_del\033[0m\tDelete contact from address book\n")
+	io.WriteString(w, "\t\033[1mcontact_update\033[0m\tAccept current owner of a name contact\n")
 	io.WriteString(w, "\t\033[1mintegrated_address\033[0m\tDisplay random integrated address (with encrypted payment ID)\n")
 	io.WriteString(w, "\t\033[1mmenu\033[0m\t\tEnable menu mode\n")
 	io.WriteString(w, "\t\033[1mrescan_bc\033[0m\tRescan blockchain to re-obtain transaction history \n")
 	io.WriteString(w, "\t\033[1mpassword\033[0m\tChange wallet password\n")
 	io.WriteString(w, "\t\033[1mpayment_id\033[0m\tPrint random Payment ID (for encrypted version see integrated_address)\n")
 	io.WriteString(w, "\t\033[1mseed\033[0m\t\tDisplay seed\n")
+	io.WriteString(w, "\t\033[1mseed_shares\033[0m\tSplit seed in shares, any k of n shares recover wallet\n")
 	io.WriteString(w, "\t\033[1mshow_transfers\033[0m\tShow all transactions to/from current wallet\n")
+	io.WriteString(w, "\t\033[1mpending\033[0m\t\tShow outgoing transactions which are not yet confirmed\n")
 	io.WriteString(w, "\t\033[1mset\033[0m\t\tSet/get various settings\n")
 	io.WriteString(w, "\t\033[1mstatus\033[0m\t\tShow general information and balance\n")
 	io.WriteString(w, "\t\033[1mspendkey\033[0m\tView secret key\n")
 	io.WriteString(w, "\t\033[1mtransfer\033[0m\tTransfer/Send DERO to another address\n")
-	io.WriteString(w, "\t\t\tEg. transfer <address> <amount>\n")
+	io.WriteString(w, "\t\t\tEg. transfer <address|contact|name> <amount>\n")
 	io.WriteString(w, "\t\033[1mtransfer_all\033[0m\tTransfer everything to another address\n")
 	io.WriteString(w, "\t\033[1mversion\033[0m\t\tShow version\n")
+	io.WriteString(w, "\t\033[1mprove_receiver\033[0m\tProve amount received in a tx without revealing keys\n")
+	io.WriteString(w, "\t\t\tEg. prove_receiver <txid>\n")
+	io.WriteString(w, "\t\033[1mverify_proof\033[0m\tVerify sender or receiver proof of a tx\n")
+	io.WriteString(w, "\t\t\tEg. verify_proof <txid> <proof>\n")
+	io.WriteString(w, "\t\033[1mbackup_export\033[0m\tExport encrypted backup including history and settings\n")
+	io.WriteString(w, "\t\033[1mbackup_import\033[0m\tRestore history and settings from encrypted backup\n")
+	io.WriteString(w, "\t\033[1mview_export\033[0m\tExport view credential for auditors, a snapshot of balances and provable history at current height\n")
+	io.WriteString(w, "\t\033[1mview_import\033[0m\tImport newer view credential in view only wallet, view only wallets never sync by themselves\n")
+	io.WriteString(w, "\t\033[1mview_verify\033[0m\tVerify view credential balances and history against blockchain\n")
 	io.WriteString(w, "\t\033[1mbye\033[0m\t\tQuit wallet\n")
 	io.WriteString(w, "\t\033[1mexit\033[0m\t\tQuit wallet\n")
 	io.WriteString(w, "\t\033[1mquit\033[0m\t\tQuit wallet\n")
@@ -958,16 +1226,52 @@ func usage(w io.Writer) {
 
 // display seed to the user in his preferred language
 func display_seed(l *readline.Instance, wallet *walletapi.Wallet_Disk) {
+	if wallet.IsViewOnly() {
+		fmt.Fprintf(l.Stderr(), color_red+"View only wallet does not have a seed"+color_white+"\n")
+		return
+	}
 	seed := wallet.GetSeed()
 	fmt.Fprintf(l.Stderr(), color_green+"PLEASE NOTE: the following 25 words can be used to recover access to your wallet. Please write them down and store them somewhere safe and secure. Please do not store them in your email or on file storage services outside of your immediate control."+color_white+"\n")
 	fmt.Fprintf(os.Stderr, color_red+"%s"+color_white+"\n", seed)
 
 }
 
+// display seed split in shares
+func display_seed_shares(l *readline.Instance, wallet *walletapi.Wallet_Disk) {
+	if wallet.IsViewOnly() {
+		fmt.Fprintf(l.Stderr(), color_red+"View only wallet does not have a seed"+color_white+"\n")
+		return
+	}
+	count, err := ReadInt64(l, "Enter number of shares to create", 3)
+	if err != nil {
+		logger.Error(err, "Cannot read number of shares")
+		return
+	}
+	threshold, err := ReadInt64(l, "Enter number of shares required to recover wallet", 2)
+	if err != nil {
+		logger.Error(err, "Cannot read threshold")
+		return
+	}
+
+	shares, err := wallet.GetSeedShares(int(threshold), int(count))
+	if err != nil {
+		logger.Error(err, "Cannot create seed shares")
+		return
+	}
+	fmt.Fprintf(l.Stderr(), color_green+"PLEASE NOTE: any %d of the following %d shares can be used to recover access to your wallet. Give each share to a different person and store them separately. Fewer than %d shares reveal nothing about your seed."+color_white+"\n", threshold, count, threshold)
+	for i, share := range shares {
+		fmt.Fprintf(os.Stderr, "Share %d of %d: "+color_red+"%s"+color_white+"\n", i+1, count, share)
+	}
+}
+
 // display spend key
 // viewable wallet do not have spend secret key
 // TODO wee need to give user a warning if we are printing secret
 func display_spend_key(l *readline.Instance, wallet *walletapi.Wallet_Disk) {
+	if wallet.IsViewOnly() {
+		fmt.Fprintf(l.Stderr(), color_red+"View only wallet does not have a secret key"+color_white+"\n")
+		return
+	}
 
 	keys := wallet.Get_Keys()
 	h := "0000000000000000000000000000000000000000000000" + keys.Secret.Text(16)
@@ -992,6 +1296,33 @@ func valid_registration_or_display_error(l *readline.Instance, wallet *walletapi
 	return true
 }
 
+// show outgoing txs tracked by wallet
+func show_pending_transactions(l *readline.Instance, wallet *walletapi.Wallet_Disk) {
+	pending := wallet.GetPendingTransactions()
+	if len(pending) == 0 {
+		fmt.Fprintf(l.Stderr(), "No pending transactions\n")
+		return
+	}
+	for _, p := range pending {
+		color := color_yellow
+		switch p.State {
+		case rpc.PendingTXMined:
+			color = color_green
+		case rpc.PendingTXFailed:
+			color = color_red
+		}
+		fmt.Fprintf(l.Stderr(), "%s %s%-9s%s fees %s broadcasts %d", p.Created.Local().Format(time.RFC822), color, p.State, color_white, walletapi.FormatMoney(p.Fees), p.Broadcasts)
+		if p.BlockHeight > 0 {
+			fmt.Fprintf(l.Stderr(), " height %d", p.BlockHeight)
+		}
+		fmt.Fprintf(l.Stderr(), " txid %s", p.TXID)
+		if p.Error != "" {
+			fmt.Fprintf(l.Stderr(), " %s(%s)%s", color_red, p.Error, color_white)
+		}
+		fmt.Fprintf(l.Stderr(), "\n")
+	}
+}
+
 // show the transfers to the user originating from this account
 func show_transfers(l *readline.Instance, wallet *walletapi.Wallet_Disk, scid crypto.Hash, limit uint64) {
 
@@ -59,7 +59,7 @@ var command_line string = `derod
 DERO : A secure, private blockchain with smart-contracts
 
 Usage:
-  derod [--help] [--version] [--testnet] [--debug]  [--sync-node] [--timeisinsync] [--fastsync] [--socks-proxy=<socks_ip:port>] [--data-dir=<directory>] [--p2p-bind=<0.0.0.0:18089>] [--add-exclusive-node=<ip:port>]... [--add-priority-node=<ip:port>]... [--min-peers=<11>] [--max-peers=<100>] [--rpc-bind=<127.0.0.1:9999>] [--getwork-bind=<0.0.0.0:18089>] [--node-tag=<unique name>] [--prune-history=<50>] [--integrator-address=<address>] [--clog-level=1] [--flog-level=1]
+  derod [--help] [--version] [--testnet] [--debug]  [--sync-node] [--timeisinsync] [--fastsync] [--fastsync-single-peer] [--socks-proxy=<socks_ip:port>] [--data-dir=<directory>] [--p2p-bind=<0.0.0.0:18089>] [--add-exclusive-node=<ip:port>]... [--add-priority-node=<ip:port>]... [--p2p-identity=<file>] [--p2p-allow-key=<key>]... [--min-peers=<11>] [--max-peers=<100>] [--ban-score=<-100>] [--deprioritize-score=<-30>] [--rpc-bind=<127.0.0.1:9999>] [--getwork-bind=<0.0.0.0:18089>] [--node-tag=<unique name>] [--prune-history=<50>] [--snapshot-import=<file>] [--snapshot-blid=<block id>] [--snapshot-root=<state hash>] [--p2p-capture=<directory>] [--p2p-upload-limit=<0>] [--p2p-download-limit=<0>] [--p2p-peer-upload-limit=<0>] [--p2p-peer-download-limit=<0>] [--integrator-address=<address>] [--clog-level=1] [--flog-level=1]
   derod -h | --help
   derod --version
 
@@ -71,20 +71,33 @@ Options:
   --clog-level=1	Set console log level (0 to 127) 
   --flog-level=1	Set file log level (0 to 127)
   --fastsync      Fast sync mode (this option has effect only while bootstrapping)
+  --fastsync-single-peer  Accept state root confirmed by a single peer during fast sync, otherwise atleast 2 peers must agree
   --timeisinsync  Confirms to daemon that time is in sync, so daemon doesn't try to sync
   --socks-proxy=<socks_ip:port>  Use a proxy to connect to network.
   --data-dir=<directory>    Store blockchain data at this location
   --rpc-bind=<127.0.0.1:9999>    RPC listens on this ip:port
   --p2p-bind=<0.0.0.0:18089>    p2p server listens on this ip:port, specify port 0 to disable listening server
   --getwork-bind=<0.0.0.0:10100>    getwork server listens on this ip:port, specify port 0 to disable listening server
-  --add-exclusive-node=<ip:port>	Connect to specific peer only 
-  --add-priority-node=<ip:port>	Maintain persistant connection to specified peer
+  --add-exclusive-node=<ip:port>	Connect to specific peer only, use key@ip:port to require the peer to prove this identity
+  --add-priority-node=<ip:port>	Maintain persistant connection to specified peer, use key@ip:port to require the peer to prove this identity
+  --p2p-identity=<file>	persistent p2p identity key, default is p2p_identity.pem within data directory
+  --p2p-allow-key=<key>	accept only peers with these identities, used to form private groups of nodes
   --sync-node       Sync node automatically with the seeds nodes. This option is for rare use.
   --node-tag=<unique name>	Unique name of node, visible to everyone
   --integrator-address	if this node mines a block,Integrator rewards will be given to address.default is dev's address.
   --min-peers=<31>	  Node will try to maintain atleast this many connections to peers
   --max-peers=<101>	  Node will maintain maximim this many connections to peers and will stop accepting connections
+  --ban-score=<-100>	  Misbehaving peers reaching this score are disconnected and banned for an hour
+  --deprioritize-score=<-30>	  Peers below this score are used only if no better peers are available
   --prune-history=<50>	prunes blockchain history until the specific topo_height
+  --snapshot-import=<file>	provision empty data directory from a snapshot archive, see snapshot_export command
+  --snapshot-blid=<block id>	top block id of the snapshot archive, obtain it from a trusted source
+  --snapshot-root=<state hash>	state hash at top block of the snapshot archive, obtain it from a trusted source
+  --p2p-capture=<directory>	capture traffic of every p2p connection to this directory, replay it using p2p-replay
+  --p2p-upload-limit=<0>	limit p2p upload to this many KiB/s, 0 is unlimited
+  --p2p-download-limit=<0>	limit p2p download to this many KiB/s, 0 is unlimited
+  --p2p-peer-upload-limit=<0>	limit p2p upload to every peer to this many KiB/s, 0 is unlimited
+  --p2p-peer-download-limit=<0>	limit p2p download from every peer to this many KiB/s, 0 is unlimited
 
   `
 
@@ -189,6 +202,35 @@ func main() {
 		}
 	}
 
+	// check whether we are provisioning from a snapshot, if requested do so
+	if globals.Arguments["--snapshot-import"] != nil {
+		var blid, state_hash crypto.Hash
+		if globals.Arguments["--snapshot-blid"] == nil {
+			logger.Error(fmt.Errorf("--snapshot-import requires --snapshot-blid"), "invalid argument")
+			return
+		}
+		if err := blid.UnmarshalText([]byte(globals.Arguments["--snapshot-blid"].(string))); err != nil {
+			logger.Error(err, "error Parsing --snapshot-blid")
+			return
+		}
+		if globals.Arguments["--snapshot-root"] == nil {
+			logger.Error(fmt.Errorf("--snapshot-import requires --snapshot-root"), "invalid argument")
+			return
+		}
+		if err := state_hash.UnmarshalText([]byte(globals.Arguments["--snapshot-root"].(string))); err != nil {
+			logger.Error(err, "error Parsing --snapshot-root")
+			return
+		}
+		logger.Info("will import snapshot", "file", globals.Arguments["--snapshot-import"], "blid", blid)
+
+		if err := blockchain.Import_Snapshot(globals.Arguments["--snapshot-import"].(string), blid, state_hash); err != nil {
+			logger.Error(err, "Error importing snapshot")
+			return
+		} else {
+			logger.Info("snapshot import successful")
+		}
+	}
+
 	if _, ok := globals.Arguments["--timeisinsync"]; ok {
 		globals.TimeIsInSync = globals.Arguments["--timeisinsync"].(bool)
 	}
@@ -1001,6 +1043,24 @@ restart_loop:
 			}
 			dump(line_parts[1])
 
+		case command == "snapshot_export":
+			if len(line_parts) < 2 || len(line_parts) > 3 {
+				logger.Error(fmt.Errorf("snapshot_export needs a file name and optionally a topoheight as argument"), "")
+				continue
+			}
+			topo := int64(0) // most recent stable topoheight
+			if len(line_parts) == 3 {
+				if topo, err = strconv.ParseInt(line_parts[2], 10, 64); err != nil {
+					logger.Error(err, "err parsing topoheight")
+					continue
+				}
+			}
+			if header, err := chain.Export_Snapshot(line_parts[1], topo); err != nil {
+				logger.Error(err, "err exporting snapshot")
+			} else {
+				logger.Info("successfully exported snapshot", "file", line_parts[1], "topoheight", header.Topo, "blid", header.BLID, "statehash", header.StateHash)
+			}
+
 		case command == "ban":
 
 			if len(line_parts) >= 4 || len(line_parts) == 1 {
@@ -1167,6 +1227,7 @@ func usage(w io.Writer) {
 	io.WriteString(w, "\t\033[1mpeer_list\033[0m\tPrint peer list\n")
 	io.WriteString(w, "\t\033[1msyncinfo\033[0m\tPrint information about connected peers and their state\n")
 	io.WriteString(w, "\t\033[1mbye\033[0m\t\tQuit the daemon\n")
+	io.WriteString(w, "\t\033[1msnapshot_export\033[0m\tExport state snapshot for provisioning new nodes, snapshot_export <file> [topoheight]\n")
 	io.WriteString(w, "\t\033[1mban\033[0m\t\tBan specific ip from making any connections\n")
 	io.WriteString(w, "\t\033[1munban\033[0m\t\tRevoke restrictions on previously banned ips\n")
 	io.WriteString(w, "\t\033[1mbans\033[0m\t\tPrint current ban list\n")
@@ -1200,6 +1261,7 @@ var completer = readline.NewPrefixCompleter(
 	readline.PcItem("print_block"),
 	readline.PcItem("block_export"),
 	readline.PcItem("block_import"),
+	readline.PcItem("snapshot_export"),
 	//	readline.PcItem("print_tx"),
 	readline.PcItem("setintegratoraddress"),
 	readline.PcItem("status"),
@@ -38,6 +38,7 @@ func GetInfo(ctx context.Context) (result rpc.GetInfo_Result, err error) {
 	//result.Difficulty = chain.Get_Difficulty_At_Block(top_id)
 	result.Height = chain.Get_Height()
 	result.StableHeight = chain.Get_Stable_Height()
+	result.PrunedTopoHeight = chain.LocatePruneTopo()
 	result.TopoHeight = chain.Load_TOPO_HEIGHT()
 
 	{
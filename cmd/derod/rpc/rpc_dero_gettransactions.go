@@ -52,7 +52,7 @@ func GetTransaction(ctx context.Context, p rpc.GetTransaction_Params) (result rp
 				// check whether we can get the tx from the pool
 				{
 					tx := chain.Mempool.Mempool_Get_TX(hash)
-					if tx != nil { // found the tx in the mempool
+					if tx != nil && !chain.Mempool.Mempool_Is_Stem(hash) { // found the tx in the mempool, stem txs stay hidden till fluffed
 						var related rpc.Tx_Related_Info
 
 						related.Block_Height = -1 // not mined
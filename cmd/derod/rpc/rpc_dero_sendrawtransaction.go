@@ -58,8 +58,7 @@ func SendRawTransaction(ctx context.Context, p rpc.SendRawTransaction_Params) (r
 
 	// lets try to add it to pool
 
-	if err = chain.Add_TX_To_Pool(&tx); err == nil {
-		p2p.Broadcast_Tx(&tx, 0) // broadcast tx
+	if err = p2p.Relay_Local_Tx(&tx); err == nil { // broadcast tx, possibly through dandelion stem
 		result.Status = "OK"
 		result.TXID = fmt.Sprintf("%s", tx.GetHash())
 	} else {
@@ -314,7 +314,8 @@ var historical_apis = handler.Map{"getinfo": handler.New(GetInfo),
 	"getencryptedbalance":        handler.New(GetEncryptedBalance),
 	"getsc":                      handler.New(GetSC),
 	"getgasestimate":             handler.New(GetGasEstimate),
-	"nametoaddress":              handler.New(NameToAddress)}
+	"nametoaddress":              handler.New(NameToAddress),
+	"getpeers":                   handler.New(GetPeers)}
 
 var servicemux = handler.ServiceMap{
 	"DERO": handler.Map{
@@ -337,6 +338,7 @@ var servicemux = handler.ServiceMap{
 		"GetSC":                      handler.New(GetSC),
 		"GetGasEstimate":             handler.New(GetGasEstimate),
 		"NameToAddress":              handler.New(NameToAddress),
+		"GetPeers":                   handler.New(GetPeers),
 	},
 	"DAEMON": handler.Map{
 		"Echo": handler.New(DAEMON_Echo),
@@ -0,0 +1,12 @@
+// Copyright 2017-2018 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+
+package main
+
+import "testing"
+
+func Test_Part1(t *testing.T) {
+
+}
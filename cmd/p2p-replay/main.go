@@ -0,0 +1,124 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+// this tool replays p2p captures made by derod --p2p-capture against a local chain
+// it is used to reproduce p2p bugs, the chain within data directory is modified, so work on a copy
+
+import "os"
+import "fmt"
+import "sort"
+import "strconv"
+import "runtime"
+
+import "github.com/docopt/docopt-go"
+import "github.com/go-logr/logr"
+
+import "github.com/deroproject/derohe/p2p"
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/blockchain"
+
+var command_line string = `p2p-replay
+Replays p2p captures made using derod --p2p-capture against a local chain.
+The chain within data directory will be modified, so replay against a copy.
+
+Usage:
+  p2p-replay [--help] [--version] [--testnet] [--debug] [--data-dir=<directory>] [--speed=<0>] [--clog-level=1] <capture>...
+  p2p-replay -h | --help
+  p2p-replay --version
+
+Options:
+  -h --help     Show this screen.
+  --version     Show version.
+  --testnet  	Replay against testnet chain.
+  --debug       Debug mode enabled, print more log messages
+  --clog-level=1	Set console log level (0 to 127)
+  --data-dir=<directory>    Blockchain data is at this location
+  --speed=<0>	Scale time between requests, 1 replays in real time, 0 replays as fast as possible`
+
+var logger logr.Logger
+
+func main() {
+	var err error
+	globals.Arguments, err = docopt.Parse(command_line, nil, true, config.Version.String(), false)
+	if err != nil {
+		fmt.Printf("Error while parsing options err: %s\n", err)
+		return
+	}
+
+	exename, _ := os.Executable()
+	f, err := os.Create(exename + ".log")
+	if err != nil {
+		fmt.Printf("Error while opening log file err: %s filename %s\n", err, exename+".log")
+		return
+	}
+	globals.InitializeLog(os.Stdout, f)
+	logger = globals.Logger.WithName("replay")
+
+	logger.Info("", "OS", runtime.GOOS, "ARCH", runtime.GOARCH, "GOMAXPROCS", runtime.GOMAXPROCS(0))
+	logger.V(1).Info("", "Arguments", globals.Arguments)
+
+	speed := float64(0)
+	if globals.Arguments["--speed"] != nil {
+		if speed, err = strconv.ParseFloat(globals.Arguments["--speed"].(string), 64); err != nil || speed < 0 {
+			logger.Error(err, "error Parsing --speed")
+			return
+		}
+	}
+
+	globals.Initialize() // setup network and proxy
+	logger.Info("", "MODE", globals.Config.Name, "data directory", globals.GetDataDirectory())
+
+	chain, err := blockchain.Blockchain_Start(map[string]interface{}{})
+	if err != nil {
+		logger.Error(err, "Error starting blockchain")
+		return
+	}
+	defer chain.Shutdown()
+
+	for _, name := range globals.Arguments["<capture>"].([]string) {
+		replay(chain, name, speed)
+	}
+}
+
+func replay(chain *blockchain.Blockchain, name string, speed float64) {
+	file, err := os.Open(name)
+	if err != nil {
+		logger.Error(err, "capture cannot be opened", "file", name)
+		return
+	}
+	defer file.Close()
+
+	stats, err := p2p.Replay_Capture(chain, file, speed)
+	if err != nil {
+		logger.Error(err, "replay stopped", "file", name)
+	}
+
+	var methods []string
+	for method := range stats {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	fmt.Printf("%s\n", name)
+	fmt.Printf("%-24s %9s %7s %9s %8s %7s %11s\n", "Method", "Requests", "Errors", "Recorded", "Changed", "Served", "Unanswered")
+	for _, method := range methods {
+		s := stats[method]
+		fmt.Printf("%-24s %9d %7d %9d %8d %7d %11d\n", method, s.Requests, s.Errors, s.Recorded_Errors, s.Changed, s.Served, s.Unanswered)
+	}
+}
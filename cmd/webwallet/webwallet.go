@@ -23,6 +23,7 @@ import (
 import "github.com/go-logr/logr"
 
 import "github.com/deroproject/derohe/walletapi"
+import "github.com/deroproject/derohe/walletapi/wasmsdk"
 import "github.com/deroproject/derohe/globals"
 import "github.com/deroproject/derohe/config"
 import "github.com/deroproject/derohe/rpc"
@@ -702,6 +703,8 @@ func main() {
 	register_wallet_callbacks()
 	fmt.Printf("registered callbacks")
 
+	wasmsdk.Register("DERO") // promise based api, DERO_JS_* callbacks above are kept for existing pages
+
     go walletapi.Keep_Connectivity() // maintain connectivity
     // init the lookup table one, anyone importing walletapi should init this first
 	//go walletapi.Initialize_LookupTable(1, 1<<18)
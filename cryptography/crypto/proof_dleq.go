@@ -0,0 +1,52 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package crypto
+
+import "fmt"
+import "math/big"
+import "github.com/deroproject/derohe/cryptography/bn256"
+
+// this file implements proof of equality of discrete logarithms ( Chaum-Pedersen), made non-interactive
+// it proves that S = x*D for the same x as public key Y = x*G, without revealing x
+// this allows disclosing decryption of a single balance or transfer
+
+// ProveDLEQ proves that log_G(Y) == log_D(S) == x
+func ProveDLEQ(x *big.Int, Y, D, S *bn256.G1) (c, s *big.Int) {
+	k := RandomScalar()
+	A1 := new(bn256.G1).ScalarMult(G, k)
+	A2 := new(bn256.G1).ScalarMult(D, k)
+
+	c = dleq_challenge(Y, D, S, A1, A2)
+	s = new(big.Int).Mul(c, x) // basicaly scalar mul add
+	s = s.Mod(s, bn256.Order)
+	s = s.Add(s, k)
+	s = s.Mod(s, bn256.Order)
+	return
+}
+
+// VerifyDLEQ verifies proof generated by ProveDLEQ
+func VerifyDLEQ(Y, D, S *bn256.G1, c, s *big.Int) bool {
+	neg_c := new(big.Int).Neg(c)
+	A1 := new(bn256.G1).Add(new(bn256.G1).ScalarMult(G, s), new(bn256.G1).ScalarMult(Y, neg_c))
+	A2 := new(bn256.G1).Add(new(bn256.G1).ScalarMult(D, s), new(bn256.G1).ScalarMult(S, neg_c))
+
+	return c.Cmp(dleq_challenge(Y, D, S, A1, A2)) == 0
+}
+
+func dleq_challenge(Y, D, S, A1, A2 *bn256.G1) *big.Int {
+	return ReducedHash([]byte(fmt.Sprintf("%s%s%s%s%s", Y.String(), D.String(), S.String(), A1.String(), A2.String())))
+}
@@ -36,3 +36,6 @@ var ErrInvalidSize = fmt.Errorf("Invalid Size")
 var ErrInvalidTX = fmt.Errorf("Invalid TX")
 
 var ErrAccountUnregistered = fmt.Errorf("Account Unregistered")
+
+// wallet errors
+var ErrInsufficientFunds = fmt.Errorf("Insufficient funds")
@@ -24,6 +24,7 @@ import "math"
 import "net/url"
 import "strings"
 import "strconv"
+import "sync/atomic"
 import "math/big"
 import "path/filepath"
 import "runtime/debug"
@@ -88,7 +89,7 @@ func GetOffsetNTP() time.Duration {
 	return ClockOffsetNTP
 }
 func GetOffsetP2P() time.Duration {
-	return ClockOffsetP2P
+	return time.Duration(atomic.LoadInt64((*int64)(&ClockOffsetP2P)))
 }
 
 var Cron = cron.New(cron.WithChain(
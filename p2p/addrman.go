@@ -0,0 +1,279 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file implements the address manager, which decides which peer addresses are kept and which are tried
+ * addresses heard from others are kept in new buckets, addresses we have connected to move to tried buckets
+ * bucket placement depends on network group of the address, network group of the peer who told us and a secret key
+ * so a single group can only fill a handful of buckets and cannot flush out everyone else, this makes eclipse attacks costly
+ */
+import "os"
+import "fmt"
+import "net"
+import "time"
+import "errors"
+import "strconv"
+import "math/rand"
+import "path/filepath"
+import "encoding/hex"
+import "encoding/json"
+import "encoding/binary"
+
+import "crypto/sha256"
+import crand "crypto/rand"
+
+import "golang.org/x/time/rate"
+
+const ADDR_NEW_BUCKETS = 256
+const ADDR_TRIED_BUCKETS = 64
+const ADDR_BUCKET_SIZE = 64
+const ADDR_NEW_BUCKETS_PER_SOURCE = 32 // addresses told by a single source group land in atmost these many new buckets
+const ADDR_TRIED_BUCKETS_PER_GROUP = 8 // addresses of a single group land in atmost these many tried buckets
+
+const ADDR_HORIZON = 24 * 3600           // new addresses not seen within this many seconds are dropped and never relayed
+const ADDR_TRIED_HORIZON = 7 * 24 * 3600 // tried addresses are kept longer
+const ADDR_UNKNOWN_AGE = 2 * 3600        // older peers do not send last seen, their addresses are considered this old
+const ADDR_RELAY_RATE rate.Limit = 0.5   // addresses per second accepted from a single peer
+const ADDR_RELAY_BURST = 64              // a handshake and a few peer lists fit within burst
+
+type address_manager struct {
+	key           [32]byte         // secret, so others cannot predict bucket placement
+	peers         map[string]*Peer // all known peers keyed by ip, same peer is also placed in exactly one bucket
+	new_buckets   [ADDR_NEW_BUCKETS]map[string]*Peer
+	tried_buckets [ADDR_TRIED_BUCKETS]map[string]*Peer
+}
+
+// this is what is saved to disk, buckets are recomputed while loading
+type address_manager_state struct {
+	Key   string  `json:"key"`
+	Peers []*Peer `json:"peers"`
+}
+
+func new_address_manager() *address_manager {
+	a := &address_manager{peers: map[string]*Peer{}}
+	if _, err := crand.Read(a.key[:]); err != nil {
+		panic(err)
+	}
+	for i := range a.new_buckets {
+		a.new_buckets[i] = map[string]*Peer{}
+	}
+	for i := range a.tried_buckets {
+		a.tried_buckets[i] = map[string]*Peer{}
+	}
+	return a
+}
+
+func new_addr_limiter() *rate.Limiter {
+	return rate.NewLimiter(ADDR_RELAY_RATE, ADDR_RELAY_BURST)
+}
+
+// network group of an address, /16 for ipv4, /32 for ipv6
+// addresses within a group are usually controlled by a single entity
+func netgroup(address string) string {
+	host := ParseIPNoError(address)
+	if host == "" {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return host // dns names are a group of their own
+	case ip.IsLoopback():
+		return "local"
+	case ip.To4() != nil:
+		ip4 := ip.To4()
+		return fmt.Sprintf("%d.%d", ip4[0], ip4[1])
+	default:
+		return fmt.Sprintf("%x", []byte(ip.To16()[:4]))
+	}
+}
+
+func (a *address_manager) hash(data ...string) uint64 {
+	h := sha256.New()
+	h.Write(a.key[:])
+	for _, d := range data {
+		h.Write([]byte(d))
+		h.Write([]byte{0})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
+
+func (a *address_manager) new_bucket(p *Peer) map[string]*Peer {
+	slot := a.hash(netgroup(p.Address), p.Source) % ADDR_NEW_BUCKETS_PER_SOURCE
+	return a.new_buckets[a.hash(p.Source, strconv.FormatUint(slot, 10))%ADDR_NEW_BUCKETS]
+}
+
+func (a *address_manager) tried_bucket(p *Peer) map[string]*Peer {
+	slot := a.hash(ParseIPNoError(p.Address)) % ADDR_TRIED_BUCKETS_PER_GROUP
+	return a.tried_buckets[a.hash(netgroup(p.Address), strconv.FormatUint(slot, 10))%ADDR_TRIED_BUCKETS]
+}
+
+func (a *address_manager) bucket(p *Peer) map[string]*Peer {
+	if p.Whitelist {
+		return a.tried_bucket(p)
+	}
+	return a.new_bucket(p)
+}
+
+// places the peer in its bucket, if the bucket is full the entry seen longest ago makes place
+// evicted tried entries are moved back to new buckets
+func (a *address_manager) insert(p *Peer) {
+	key := ParseIPNoError(p.Address)
+	bucket := a.bucket(p)
+	if len(bucket) >= ADDR_BUCKET_SIZE {
+		var oldest *Peer
+		for _, v := range bucket {
+			if oldest == nil || v.LastSeen < oldest.LastSeen {
+				oldest = v
+			}
+		}
+		if oldest.LastSeen > p.LastSeen && !p.Whitelist { // existing entries are fresher, discard this one
+			return
+		}
+		a.remove(oldest.Address)
+		if oldest.Whitelist {
+			oldest.Whitelist = false
+			a.insert(oldest)
+		}
+	}
+	bucket[key] = p
+	a.peers[key] = p
+}
+
+func (a *address_manager) remove(address string) {
+	key := ParseIPNoError(address)
+	if p, ok := a.peers[key]; ok {
+		delete(a.bucket(p), key)
+		delete(a.peers, key)
+	}
+}
+
+// peer was connected successfully, move it to tried buckets
+func (a *address_manager) mark_tried(p *Peer) {
+	if p.Whitelist {
+		return
+	}
+	a.remove(p.Address)
+	p.Whitelist = true
+	a.insert(p)
+}
+
+// picks a random bucket amongst those having a suitable peer and a random suitable peer within it
+// tried and new buckets are given equal chance, so fresh addresses keep getting tested
+func (a *address_manager) pick(suitable func(*Peer) bool) *Peer {
+	tried, fresh := suitable_buckets(a.tried_buckets[:], suitable), suitable_buckets(a.new_buckets[:], suitable)
+
+	buckets := tried
+	if len(tried) == 0 || (len(fresh) > 0 && rand.Intn(2) == 0) {
+		buckets = fresh
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	candidates := buckets[rand.Intn(len(buckets))]
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// suitable peers of every bucket, buckets without any are skipped
+func suitable_buckets(buckets []map[string]*Peer, suitable func(*Peer) bool) (result [][]*Peer) {
+	for _, bucket := range buckets {
+		var candidates []*Peer
+		for _, v := range bucket {
+			if suitable(v) {
+				candidates = append(candidates, v)
+			}
+		}
+		if len(candidates) > 0 {
+			result = append(result, candidates)
+		}
+	}
+	return
+}
+
+func (a *address_manager) tried_count() (count int) {
+	for _, bucket := range a.tried_buckets {
+		count += len(bucket)
+	}
+	return
+}
+
+// loads state from file, peers are placed in buckets again since bucket sizes may have changed
+func (a *address_manager) load(file string) error {
+	var state address_manager_state
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	key, err := hex.DecodeString(state.Key)
+	if err != nil || len(key) != len(a.key) {
+		return fmt.Errorf("invalid address manager key")
+	}
+	copy(a.key[:], key)
+	for _, p := range state.Peers {
+		if p.Source == "" {
+			p.Source = netgroup(p.Address)
+		}
+		a.insert(p)
+	}
+	return nil
+}
+
+func (a *address_manager) save(file string) error {
+	state := address_manager_state{Key: hex.EncodeToString(a.key[:])}
+	for _, p := range a.peers {
+		state.Peers = append(state.Peers, p)
+	}
+	data, err := json.MarshalIndent(&state, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0600)
+}
+
+// peers.json from earlier versions is imported once, its peers are considered fresh
+func (a *address_manager) import_legacy(file string) error {
+	legacy := map[string]*Peer{}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	now := uint64(time.Now().UTC().Unix())
+	for _, p := range legacy {
+		p.LastSeen, p.Source = now, netgroup(p.Address)
+		a.insert(p)
+	}
+	return nil
+}
+
+func (n *node) addrman_file() string {
+	return filepath.Join(n.data_dir, "addrman.json")
+}
+
+func (n *node) legacy_peer_file() string {
+	return filepath.Join(n.data_dir, "peers.json")
+}
+
+func file_exists(file string) bool {
+	_, err := os.Stat(file)
+	return !errors.Is(err, os.ErrNotExist)
+}
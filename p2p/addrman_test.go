@@ -0,0 +1,129 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+import "os"
+import "fmt"
+import "net"
+import "time"
+import "testing"
+import "path/filepath"
+
+import "github.com/go-logr/logr"
+
+func Test_Netgroup(t *testing.T) {
+	for _, c := range []struct{ address, group string }{
+		{"203.0.113.7:18089", "203.0"},
+		{"203.0.200.1", "203.0"},
+		{"198.51.100.1:18089", "198.51"},
+		{"127.0.0.1:18089", "local"},
+		{"[2001:db8::1]:18089", "20010db8"},
+		{"[2001:db8:ffff::1]:18089", "20010db8"},
+	} {
+		if actual := netgroup(c.address); actual != c.group {
+			t.Fatalf("netgroup of %s expected %s actual %s", c.address, c.group, actual)
+		}
+	}
+}
+
+// a single source cannot fill the address manager, others still find place
+func Test_Addrman_Source_Limit(t *testing.T) {
+	n := new_node()
+	now := uint64(time.Now().UTC().Unix())
+	for i := 0; i < 5000; i++ {
+		n.Peer_Add(&Peer{Address: fmt.Sprintf("%d.%d.0.1:18089", 1+i%200, i/200), LastSeen: now, Source: "203.0"})
+	}
+	if count := n.Peer_Counts(); count > ADDR_NEW_BUCKETS_PER_SOURCE*ADDR_BUCKET_SIZE {
+		t.Fatalf("single source occupies %d entries", count)
+	}
+
+	n.Peer_Add(&Peer{Address: "198.51.100.1:18089", LastSeen: now, Source: "198.51"})
+	if !n.IsPeerInList("198.51.100.1") {
+		t.Fatalf("address from another source must be accepted")
+	}
+
+	n.Peer_Add(&Peer{Address: "198.51.100.2:18089", LastSeen: now - ADDR_HORIZON - 1, Source: "198.51"})
+	if n.IsPeerInList("198.51.100.2") {
+		t.Fatalf("stale address must not be accepted")
+	}
+}
+
+func Test_Addrman_Tried(t *testing.T) {
+	n := new_node()
+	n.Peer_Add(&Peer{Address: "203.0.113.7:18089", LastSeen: uint64(time.Now().UTC().Unix())})
+	if len(n.get_peer_list()) != 0 {
+		t.Fatalf("untried addresses must not be gossiped")
+	}
+
+	n.Peer_SetSuccess("203.0.113.7:18089")
+	if n.addrman.tried_count() != 1 || !n.GetPeerInList("203.0.113.7").Whitelist {
+		t.Fatalf("connected peer must move to tried buckets")
+	}
+	if list := n.get_peer_list(); len(list) != 1 || list[0].LastSeen == 0 {
+		t.Fatalf("tried peer must be gossiped with last seen %+v", list)
+	}
+
+	if p := n.find_peer_to_connect(1); p == nil || p.Address != "203.0.113.7:18089" {
+		t.Fatalf("tried peer must be picked for connection")
+	}
+}
+
+func Test_Addrman_Persistence(t *testing.T) {
+	n := new_node()
+	n.data_dir = t.TempDir()
+	now := uint64(time.Now().UTC().Unix())
+	n.Peer_Add(&Peer{Address: "203.0.113.7:18089", LastSeen: now})
+	n.Peer_Add(&Peer{Address: "198.51.100.1:18089", LastSeen: now})
+	n.Peer_SetSuccess("198.51.100.1:18089")
+	n.save_peer_list()
+
+	loaded := new_node()
+	loaded.data_dir = n.data_dir
+	loaded.load_peer_list()
+	if loaded.Peer_Counts() != 2 || loaded.addrman.tried_count() != 1 || loaded.addrman.key != n.addrman.key {
+		t.Fatalf("state not restored, peers %d tried %d", loaded.Peer_Counts(), loaded.addrman.tried_count())
+	}
+
+	// list of earlier versions is imported and then replaced
+	legacy := new_node()
+	legacy.data_dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(legacy.data_dir, "peers.json"), []byte(`{"203.0.113.7":{"address":"203.0.113.7:18089","whitelist":true}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	legacy.load_peer_list()
+	if !legacy.IsPeerInList("203.0.113.7") || legacy.addrman.tried_count() != 1 {
+		t.Fatalf("legacy peer list not imported")
+	}
+	legacy.save_peer_list()
+	if file_exists(filepath.Join(legacy.data_dir, "peers.json")) || !file_exists(filepath.Join(legacy.data_dir, "addrman.json")) {
+		t.Fatalf("legacy peer list must be replaced")
+	}
+}
+
+func Test_Addr_Relay_Rate(t *testing.T) {
+	n := new_node()
+	c := &Connection{node: n, Addr: &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 18089}, logger: logr.Discard(), addr_limiter: new_addr_limiter()}
+
+	var list []Peer_Info
+	for i := 0; i < 2*ADDR_RELAY_BURST; i++ {
+		list = append(list, Peer_Info{Addr: fmt.Sprintf("10.%d.0.1:18089", i), LastSeen: uint64(time.Now().UTC().Unix())})
+	}
+	c.add_peer_list(list, len(list))
+	if count := n.Peer_Counts(); count != ADDR_RELAY_BURST {
+		t.Fatalf("expected %d addresses accepted, actual %d", ADDR_RELAY_BURST, count)
+	}
+}
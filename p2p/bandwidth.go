@@ -0,0 +1,262 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file implements bandwidth limits and accounting of p2p traffic
+ * limits are configured in KiB/s, globally and per peer, for upload and download, 0 means unlimited
+ * frames are classified by method, historical chain data used for syncing waits while priority traffic,
+ * such as handshakes, announcements, miniblocks and blocks at the tip, is waiting for bandwidth
+ * every frame is counted per method and per peer within the metrics package
+ */
+import "fmt"
+import "sync"
+import "time"
+import "context"
+import "strconv"
+import "sync/atomic"
+
+import "golang.org/x/time/rate"
+
+import "github.com/deroproject/derohe/metrics"
+
+const BANDWIDTH_CHUNK = 64 * 1024 // frames take bandwidth in pieces, so a large frame cannot hold priority traffic for long
+const HISTORICAL_DEPTH = 10       // blocks deeper than this are served at sync priority
+
+type traffic_class int
+
+const (
+	class_priority traffic_class = iota // handshakes, pings, announcements, miniblocks, blocks and txs at the tip
+	class_sync                          // historical chain data, used by syncing peers
+)
+
+var traffic_class_names = [...]string{"priority", "sync"}
+
+// sync methods always carry historical data
+var sync_methods = map[string]bool{"Peer.Chain": true, "Peer.ChangeSet": true, "Peer.TreeSection": true}
+
+// limits traffic in a single direction, sync traffic waits while priority traffic is waiting
+type bandwidth_limiter struct {
+	limiter          *rate.Limiter
+	priority_waiting int32
+}
+
+// returns nil if unlimited
+func new_bandwidth_limiter(kib_per_sec int64) *bandwidth_limiter {
+	if kib_per_sec <= 0 {
+		return nil
+	}
+	return &bandwidth_limiter{limiter: rate.NewLimiter(rate.Limit(kib_per_sec*1024), BANDWIDTH_CHUNK)}
+}
+
+// waits till size bytes can be transferred
+func (b *bandwidth_limiter) wait(size int, class traffic_class) {
+	if b == nil {
+		return
+	}
+	if class == class_priority {
+		atomic.AddInt32(&b.priority_waiting, 1)
+		defer atomic.AddInt32(&b.priority_waiting, -1)
+	}
+	for size > 0 {
+		for class == class_sync && atomic.LoadInt32(&b.priority_waiting) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		chunk := size
+		if chunk > BANDWIDTH_CHUNK {
+			chunk = BANDWIDTH_CHUNK
+		}
+		b.limiter.WaitN(context.Background(), chunk)
+		size -= chunk
+	}
+}
+
+// parse limit in KiB/s from command line
+func bandwidth_argument(arguments map[string]interface{}, name string) (int64, error) {
+	if arguments[name] == nil {
+		return 0, nil
+	}
+	limit, err := strconv.ParseInt(arguments[name].(string), 10, 64)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("%s must be KiB/s, 0 for unlimited", name)
+	}
+	return limit, nil
+}
+
+// request whose response is yet to be read or written
+type pending_request struct {
+	method string
+	class  traffic_class
+}
+
+// bandwidth state of a connection, used by codec
+type connection_bandwidth struct {
+	node     *node
+	up, down *bandwidth_limiter // per peer limits
+
+	in_method string // method of frame being read, body frames follow header frames
+	in_class  traffic_class
+
+	sync.Mutex
+	connection *Connection                // not available while connection is being setup
+	ours       map[uint64]pending_request // our requests by seq, responses are counted under method of request
+	theirs     map[uint64]pending_request // peer requests by seq
+
+	peer_in, peer_out string // per peer metric names
+}
+
+func (n *node) new_connection_bandwidth(peer string) *connection_bandwidth {
+	return &connection_bandwidth{
+		node:     n,
+		up:       new_bandwidth_limiter(n.peer_upload_limit),
+		down:     new_bandwidth_limiter(n.peer_download_limit),
+		ours:     map[uint64]pending_request{},
+		theirs:   map[uint64]pending_request{},
+		peer_in:  `p2p_peer_bytes_total{direction="in",peer="` + peer + `"}`,
+		peer_out: `p2p_peer_bytes_total{direction="out",peer="` + peer + `"}`,
+	}
+}
+
+func (bw *connection_bandwidth) set_connection(c *Connection) {
+	if bw == nil {
+		return
+	}
+	bw.Lock()
+	bw.connection = c
+	bw.Unlock()
+}
+
+// syncing connections download historical objects
+func (bw *connection_bandwidth) request_class(method string) traffic_class {
+	if sync_methods[method] {
+		return class_sync
+	}
+	bw.Lock()
+	c := bw.connection
+	bw.Unlock()
+	if method == "Peer.GetObject" && c != nil && atomic.LoadInt32(&c.Syncing) >= 1 {
+		return class_sync
+	}
+	return class_priority
+}
+
+// we are sending a request
+func (bw *connection_bandwidth) request_sent(seq uint64, method string, size int) {
+	if bw == nil {
+		return
+	}
+	class := bw.request_class(method)
+	bw.Lock()
+	bw.ours[seq] = pending_request{method: method, class: class}
+	bw.Unlock()
+	bw.sent(method, class, size)
+}
+
+// we are responding to a request, historical objects are sent at sync priority
+func (bw *connection_bandwidth) response_sent(seq uint64, obj interface{}, size int) {
+	if bw == nil {
+		return
+	}
+	bw.Lock()
+	request := bw.theirs[seq]
+	delete(bw.theirs, seq)
+	bw.Unlock()
+	if objects, ok := obj.(*Objects); ok && objects.historical {
+		request.class = class_sync
+	}
+	bw.sent(request.method, request.class, size)
+}
+
+// a header frame has been read, body frame if any is counted the same way
+func (bw *connection_bandwidth) header_received(header *RequestResponse, size int) {
+	if bw == nil {
+		return
+	}
+	if header.Method != "" {
+		bw.in_method, bw.in_class = header.Method, bw.request_class(header.Method)
+		bw.Lock()
+		bw.theirs[header.Seq] = pending_request{method: bw.in_method, class: bw.in_class}
+		bw.Unlock()
+	} else {
+		bw.Lock()
+		request := bw.ours[header.Seq]
+		delete(bw.ours, header.Seq)
+		bw.Unlock()
+		bw.in_method, bw.in_class = request.method, request.class
+	}
+	bw.received(size)
+}
+
+// a body frame has been read
+func (bw *connection_bandwidth) body_received(size int) {
+	if bw == nil {
+		return
+	}
+	bw.received(size)
+}
+
+func (bw *connection_bandwidth) received(size int) {
+	bw.count("in", bw.in_method, size)
+	bw.throttle("in", bw.in_class, size, bw.down, bw.node.download)
+}
+
+func (bw *connection_bandwidth) sent(method string, class traffic_class, size int) {
+	bw.count("out", method, size)
+	bw.throttle("out", class, size, bw.up, bw.node.upload)
+}
+
+func (bw *connection_bandwidth) count(direction string, method string, size int) {
+	if method == "" {
+		method = "unknown"
+	}
+	metrics.Set.GetOrCreateCounter(`p2p_bytes_total{direction="` + direction + `",method="` + method + `"}`).Add(size)
+	if direction == "in" {
+		metrics.Set.GetOrCreateCounter(bw.peer_in).Add(size)
+	} else {
+		metrics.Set.GetOrCreateCounter(bw.peer_out).Add(size)
+	}
+
+	bw.Lock()
+	c := bw.connection
+	bw.Unlock()
+	if c != nil && direction == "in" {
+		atomic.AddUint64(&c.BytesIn, uint64(size))
+	} else if c != nil {
+		atomic.AddUint64(&c.BytesOut, uint64(size))
+	}
+}
+
+// waits for peer limit, then global limit
+func (bw *connection_bandwidth) throttle(direction string, class traffic_class, size int, peer, global *bandwidth_limiter) {
+	if peer == nil && global == nil {
+		return
+	}
+	start := time.Now()
+	peer.wait(size, class)
+	global.wait(size, class)
+	if waited := time.Since(start); waited > time.Millisecond {
+		metrics.Set.GetOrCreateFloatCounter(`p2p_bandwidth_wait_seconds_total{direction="` + direction + `",class="` + traffic_class_names[class] + `"}`).Add(waited.Seconds())
+	}
+}
+
+// per peer counters are removed once connection closes
+func (bw *connection_bandwidth) close() {
+	if bw == nil {
+		return
+	}
+	metrics.Set.UnregisterMetric(bw.peer_in)
+	metrics.Set.UnregisterMetric(bw.peer_out)
+}
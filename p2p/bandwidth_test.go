@@ -0,0 +1,109 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+import "sync"
+import "time"
+import "testing"
+
+import "github.com/deroproject/derohe/metrics"
+
+func Test_Bandwidth_Limit(t *testing.T) {
+	if new_bandwidth_limiter(0) != nil {
+		t.Fatalf("0 must be unlimited")
+	}
+
+	b := new_bandwidth_limiter(1024) // 64 KiB pieces take 62.5 ms each
+	start := time.Now()
+	b.wait(4*BANDWIDTH_CHUNK, class_sync) // first piece is within burst
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("limit not enforced, 256 KiB took %s", elapsed)
+	}
+}
+
+// sync traffic waits while priority traffic is waiting
+func Test_Bandwidth_Priority(t *testing.T) {
+	b := new_bandwidth_limiter(1024)
+	b.wait(BANDWIDTH_CHUNK, class_priority) // empty the burst
+
+	var wg sync.WaitGroup
+	var sync_done, priority_done time.Time
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		b.wait(4*BANDWIDTH_CHUNK, class_sync)
+		sync_done = time.Now()
+	}()
+	go func() {
+		defer wg.Done()
+		time.Sleep(10 * time.Millisecond)
+		b.wait(BANDWIDTH_CHUNK, class_priority)
+		priority_done = time.Now()
+	}()
+	wg.Wait()
+
+	if !priority_done.Before(sync_done) {
+		t.Fatalf("priority traffic must not wait for sync traffic")
+	}
+}
+
+// frames are counted per method and per peer, responses under method of their request
+func Test_Bandwidth_Accounting(t *testing.T) {
+	n := new_node()
+	bw := n.new_connection_bandwidth("192.0.2.1:18089")
+	c := &Connection{}
+	bw.set_connection(c)
+
+	counter := func(name string) uint64 { return metrics.Set.GetOrCreateCounter(name).Get() }
+	chain_out, chain_in := counter(`p2p_bytes_total{direction="out",method="Peer.Chain"}`), counter(`p2p_bytes_total{direction="in",method="Peer.Chain"}`)
+
+	bw.request_sent(7, "Peer.Chain", 100)
+	bw.header_received(&RequestResponse{Seq: 7}, 10)
+	if bw.in_method != "Peer.Chain" || bw.in_class != class_sync {
+		t.Fatalf("response must be accounted under its request %s %d", bw.in_method, bw.in_class)
+	}
+	bw.body_received(1000)
+
+	if counter(`p2p_bytes_total{direction="out",method="Peer.Chain"}`)-chain_out != 100 || counter(`p2p_bytes_total{direction="in",method="Peer.Chain"}`)-chain_in != 1010 {
+		t.Fatalf("method counters mismatch")
+	}
+	if counter(bw.peer_out) != 100 || counter(bw.peer_in) != 1010 || c.BytesOut != 100 || c.BytesIn != 1010 {
+		t.Fatalf("peer counters mismatch")
+	}
+
+	bw.header_received(&RequestResponse{Method: "Peer.GetObject", Seq: 3}, 10)
+	if bw.in_class != class_priority {
+		t.Fatalf("objects requested by peers at the tip are priority traffic")
+	}
+	getobject_out := counter(`p2p_bytes_total{direction="out",method="Peer.GetObject"}`)
+	bw.response_sent(3, &Objects{historical: true}, 50)
+	if counter(`p2p_bytes_total{direction="out",method="Peer.GetObject"}`)-getobject_out != 50 {
+		t.Fatalf("response must be accounted under its request")
+	}
+
+	c.Syncing = 1
+	if bw.request_class("Peer.GetObject") != class_sync || bw.request_class("Peer.NotifyMiniBlock") != class_priority {
+		t.Fatalf("objects requested while syncing are sync traffic")
+	}
+
+	bw.close()
+	for _, name := range metrics.Set.ListMetricNames() {
+		if name == bw.peer_in || name == bw.peer_out {
+			t.Fatalf("peer counters must be removed once connection closes")
+		}
+	}
+}
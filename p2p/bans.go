@@ -22,7 +22,6 @@ package p2p
 import "os"
 import "fmt"
 import "net"
-import "sync"
 import "time"
 import "errors"
 
@@ -35,8 +34,6 @@ import "encoding/json"
 
 //import log "github.com/sirupsen/logrus"
 
-import "github.com/deroproject/derohe/globals"
-
 //import "github.com/deroproject/derosuite/crypto"
 
 // This structure is used to do book keeping for the peer list and keeps other DATA related to peer
@@ -50,71 +47,68 @@ import "github.com/deroproject/derohe/globals"
 // enableban address  // by default all addresses are bannable
 // disableban address  // this address will never be banned
 
-var ban_map = map[string]uint64{} // keeps ban maps
-var ban_mutex sync.Mutex
-
 // loads peers list from disk
-func load_ban_list() {
-	go ban_clean_up_goroutine() // start routine to clean up ban list
-	defer ban_clean_up()        // cleanup list after loading it
-	ban_mutex.Lock()
-	defer ban_mutex.Unlock()
+func (n *node) load_ban_list() {
+	go n.ban_clean_up_goroutine() // start routine to clean up ban list
+	defer n.ban_clean_up()        // cleanup list after loading it
+	n.ban_mutex.Lock()
+	defer n.ban_mutex.Unlock()
 
-	ban_file := filepath.Join(globals.GetDataDirectory(), "ban_list.json")
+	ban_file := filepath.Join(n.data_dir, "ban_list.json")
 
 	if _, err := os.Stat(ban_file); errors.Is(err, os.ErrNotExist) {
 		return // since file doesn't exist , we cannot load it
 	}
 	file, err := os.Open(ban_file)
 	if err != nil {
-		logger.Error(err, "opening ban data file")
+		n.logger.Error(err, "opening ban data file")
 	} else {
 		defer file.Close()
 		decoder := json.NewDecoder(file)
-		err = decoder.Decode(&ban_map)
+		err = decoder.Decode(&n.ban_map)
 		if err != nil {
-			logger.Error(err, "Error unmarshalling ban data")
+			n.logger.Error(err, "Error unmarshalling ban data")
 		} else { // successfully unmarshalled data
-			logger.V(1).Info("Successfully loaded bans from file", "ban_count", (len(ban_map)))
+			n.logger.V(1).Info("Successfully loaded bans from file", "ban_count", (len(n.ban_map)))
 		}
 	}
 }
 
 // save ban list to disk
-func save_ban_list() {
+func (n *node) save_ban_list() {
 
-	ban_clean_up() // cleanup before saving
-	ban_mutex.Lock()
-	defer ban_mutex.Unlock()
+	n.ban_clean_up() // cleanup before saving
+	n.ban_mutex.Lock()
+	defer n.ban_mutex.Unlock()
 
-	ban_file := filepath.Join(globals.GetDataDirectory(), "ban_list.json")
+	ban_file := filepath.Join(n.data_dir, "ban_list.json")
 	file, err := os.Create(ban_file)
 	if err != nil {
-		logger.Error(err, "creating ban data file")
+		n.logger.Error(err, "creating ban data file")
 	} else {
 		defer file.Close()
 		encoder := json.NewEncoder(file)
 		encoder.SetIndent("", "\t")
-		err = encoder.Encode(&ban_map)
+		err = encoder.Encode(&n.ban_map)
 		if err != nil {
-			logger.Error(err, "Error unmarshalling ban data")
+			n.logger.Error(err, "Error unmarshalling ban data")
 		} else { // successfully unmarshalled data
-			logger.V(1).Info("Successfully saved bans to file", "count", (len(ban_map)))
+			n.logger.V(1).Info("Successfully saved bans to file", "count", (len(n.ban_map)))
 		}
 	}
 }
 
 // clean up ban list every 20 seconds
-func ban_clean_up_goroutine() {
+func (n *node) ban_clean_up_goroutine() {
 
 	delay := time.NewTicker(20 * time.Second)
 	for {
 		select {
-		case <-Exit_Event:
+		case <-n.exit_event:
 			return
 		case <-delay.C:
 		}
-		ban_clean_up()
+		n.ban_clean_up()
 	}
 
 }
@@ -129,14 +123,14 @@ func init() {
 */
 
 // clean up by discarding entries which are  in the past
-func ban_clean_up() {
-	ban_mutex.Lock()
-	defer ban_mutex.Unlock()
+func (n *node) ban_clean_up() {
+	n.ban_mutex.Lock()
+	defer n.ban_mutex.Unlock()
 
 	current_time := uint64(time.Now().UTC().Unix())
-	for k, v := range ban_map {
+	for k, v := range n.ban_map {
 		if v < current_time {
-			delete(ban_map, k)
+			delete(n.ban_map, k)
 		}
 	}
 }
@@ -167,21 +161,21 @@ func ParseAddress(address string) (ipnet *net.IPNet, result string, err error) {
 //	we should loop and search in subnets also
 //
 // TODO make it fast, however we are not expecting millions of bans, so this may be okay for time being
-func IsAddressInBanList(address string) bool {
-	ban_mutex.Lock()
-	defer ban_mutex.Unlock()
+func (n *node) IsAddressInBanList(address string) bool {
+	n.ban_mutex.Lock()
+	defer n.ban_mutex.Unlock()
 
 	// any i which cannot be banned should never be banned
 	// this list contains any seed nodes/exclusive nodes/proirity nodes
 	// these are never banned
-	for i := range nonbanlist {
-		if address == nonbanlist[i] {
+	for i := range n.nonbanlist {
+		if address == n.nonbanlist[i] {
 			return true
 		}
 	}
 
 	// if it's a subnet or direct ip, do instant check
-	if _, ok := ban_map[address]; ok {
+	if _, ok := n.ban_map[address]; ok {
 		return true
 	}
 
@@ -189,7 +183,7 @@ func IsAddressInBanList(address string) bool {
 	if ip != nil {
 
 		// parse and check the subnets
-		for k, _ := range ban_map {
+		for k, _ := range n.ban_map {
 			ipnet, _, err := ParseAddress(k)
 
 			//  fmt.Printf("parsing address %s err %s  checking ip %s",k,err,address)
@@ -207,10 +201,13 @@ func IsAddressInBanList(address string) bool {
 // ban a peer for specific time
 // manual bans are always placed
 // address can be an address or a subnet
-
 func Ban_Address(address string, ban_seconds uint64) (err error) {
-	ban_mutex.Lock()
-	defer ban_mutex.Unlock()
+	return default_node.Ban_Address(address, ban_seconds)
+}
+
+func (n *node) Ban_Address(address string, ban_seconds uint64) (err error) {
+	n.ban_mutex.Lock()
+	defer n.ban_mutex.Unlock()
 
 	// make sure we are not banning seed nodes/exclusive node/priority nodes on command line
 	_, address, err = ParseAddress(address)
@@ -219,7 +216,7 @@ func Ban_Address(address string, ban_seconds uint64) (err error) {
 	}
 
 	//logger.Warnf("%s banned for %d secs", address, ban_seconds)
-	ban_map[address] = uint64(time.Now().UTC().Unix()) + ban_seconds
+	n.ban_map[address] = uint64(time.Now().UTC().Unix()) + ban_seconds
 	return
 }
 
@@ -240,13 +237,17 @@ func Peer_Ban_Internal(address string, ban_seconds uint64) (err error){
 
 // unban a peer for specific time
 func UnBan_Address(address string) (err error) {
-	if !IsAddressInBanList(address) {
+	return default_node.UnBan_Address(address)
+}
+
+func (n *node) UnBan_Address(address string) (err error) {
+	if !n.IsAddressInBanList(address) {
 		return fmt.Errorf("Address \"%s\" not found in ban list", address)
 	}
-	ban_mutex.Lock()
-	defer ban_mutex.Unlock()
-	logger.Info("unbanned", "address", address)
-	delete(ban_map, address)
+	n.ban_mutex.Lock()
+	defer n.ban_mutex.Unlock()
+	n.logger.Info("unbanned", "address", address)
+	delete(n.ban_map, address)
 	return
 }
 
@@ -274,21 +275,25 @@ func Peer_EnableBan(address string) (err error){
 
 // prints all the connection info to screen
 func BanList_Print() {
-	ban_clean_up() // clean up before printing
-	ban_mutex.Lock()
-	defer ban_mutex.Unlock()
-	logger.Info(fmt.Sprintf("Ban List contains %d \n", len(ban_map)))
-	logger.Info(fmt.Sprintf("%-22s %-6s\n", "Addr", "Seconds to unban"))
-
-	for k, v := range ban_map {
-		logger.Info(fmt.Sprintf("%-22s %6d\n", k, v-uint64(time.Now().UTC().Unix())))
+	default_node.BanList_Print()
+}
+
+func (n *node) BanList_Print() {
+	n.ban_clean_up() // clean up before printing
+	n.ban_mutex.Lock()
+	defer n.ban_mutex.Unlock()
+	n.logger.Info(fmt.Sprintf("Ban List contains %d \n", len(n.ban_map)))
+	n.logger.Info(fmt.Sprintf("%-22s %-6s\n", "Addr", "Seconds to unban"))
+
+	for k, v := range n.ban_map {
+		n.logger.Info(fmt.Sprintf("%-22s %6d\n", k, v-uint64(time.Now().UTC().Unix())))
 	}
 
 }
 
 // this function return peer count which have successful handshake
-func Ban_Count() (Count uint64) {
-	ban_mutex.Lock()
-	defer ban_mutex.Unlock()
-	return uint64(len(ban_map))
+func (n *node) Ban_Count() (Count uint64) {
+	n.ban_mutex.Lock()
+	defer n.ban_mutex.Unlock()
+	return uint64(len(n.ban_map))
 }
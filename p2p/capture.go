@@ -0,0 +1,162 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file implements capture of p2p traffic, enabled using --p2p-capture=<directory>
+ * every connection is captured to its own file, which contains every frame sent or received by the codec
+ * frames are recorded after tls, so captures are plain cbor and can be replayed using cmd/p2p-replay
+ * captures contain everything exchanged with the peers, including our own txs, so handle them with care
+ */
+import "os"
+import "io"
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "strings"
+import "path/filepath"
+import "encoding/binary"
+
+import "github.com/fxamacker/cbor/v2"
+
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/globals"
+
+const CAPTURE_EXTENSION = ".p2pcap"
+
+// first record of every capture
+type Capture_Info struct {
+	Remote   string `cbor:"REMOTE"`
+	Local    string `cbor:"LOCAL"`
+	Incoming bool   `cbor:"IN"`    // whether connection was initiated by peer
+	Start    int64  `cbor:"START"` // unixmicro
+	Peer_ID  uint64 `cbor:"PID"`   // our peer id
+	Version  string `cbor:"DVERSION"`
+}
+
+// a single frame, header frames are followed by body frames the same way as on the wire
+type Capture_Record struct {
+	Time     int64  `cbor:"T"` // unixmicro
+	Incoming bool   `cbor:"I"` // frame was received from peer
+	Frame    []byte `cbor:"F"`
+}
+
+type capture_file struct {
+	sync.Mutex
+	file *os.File
+	name string
+}
+
+// create capture file for a connection, returns nil if capture is disabled or file cannot be created
+func (n *node) new_capture(conn net.Conn, remote net.Addr, incoming bool) *capture_file {
+	if n.capture_dir == "" {
+		return nil
+	}
+	direction := "out"
+	if incoming {
+		direction = "in"
+	}
+	escaped := strings.NewReplacer(":", "_", "[", "", "]", "").Replace(remote.String())
+	name := filepath.Join(n.capture_dir, fmt.Sprintf("%d_%s_%s%s", time.Now().UnixMilli(), direction, escaped, CAPTURE_EXTENSION))
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		n.logger.Error(err, "capture file cannot be created", "file", name)
+		return nil
+	}
+	c := &capture_file{file: file, name: name}
+	info := Capture_Info{Remote: remote.String(), Local: conn.LocalAddr().String(), Incoming: incoming, Start: globals.Time().UTC().UnixMicro(), Peer_ID: n.GetPeerID(), Version: config.Version.String()}
+	if err = c.write(info); err != nil {
+		n.logger.Error(err, "capture file cannot be written", "file", name)
+		c.close()
+		return nil
+	}
+	return c
+}
+
+// records a frame, capture is stopped on first error
+func (c *capture_file) record(incoming bool, frame []byte) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.file == nil {
+		return
+	}
+	if err := c.write(Capture_Record{Time: globals.Time().UTC().UnixMicro(), Incoming: incoming, Frame: frame}); err != nil {
+		logger.Error(err, "capture stopped", "file", c.name)
+		c.file.Close()
+		c.file = nil
+	}
+}
+
+// length prefixed cbor, same as frames on the wire
+func (c *capture_file) write(obj interface{}) error {
+	data, err := cbor.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	var frame_length_buf [4]byte
+	binary.LittleEndian.PutUint32(frame_length_buf[:], uint32(len(data)))
+	_, err = c.file.Write(append(frame_length_buf[:], data...))
+	return err
+}
+
+func (c *capture_file) close() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+}
+
+// reads a capture file, a capture truncated by a crash returns all complete records
+func Read_Capture(r io.Reader) (info Capture_Info, records []Capture_Record, err error) {
+	read := func(obj interface{}) error {
+		var frame_length_buf [4]byte
+		if _, err := io.ReadFull(r, frame_length_buf[:]); err != nil {
+			return err
+		}
+		frame_length := binary.LittleEndian.Uint32(frame_length_buf[:])
+		if uint64(frame_length) > (6 * config.STARGATE_HE_MAX_BLOCK_SIZE) { // records are slightly larger than frames
+			return fmt.Errorf("record length is too big %d", frame_length)
+		}
+		data := make([]byte, frame_length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		return cbor.Unmarshal(data, obj)
+	}
+
+	if err = read(&info); err != nil {
+		return info, nil, fmt.Errorf("invalid capture header, err %s", err)
+	}
+	for {
+		var record Capture_Record
+		if err = read(&record); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return info, records, nil
+		} else if err != nil {
+			return
+		}
+		records = append(records, record)
+	}
+}
@@ -17,15 +17,15 @@
 package p2p
 
 import "fmt"
+import "errors"
 
 //import "net"
 import "time"
 import "math/big"
-import "math/bits"
 import "sync/atomic"
-import "encoding/binary"
 
 import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/dvm"
 import "github.com/deroproject/derohe/block"
 
 //import "github.com/deroproject/derohe/errormsg"
@@ -40,13 +40,14 @@ import "github.com/deroproject/derohe/cryptography/crypto"
 // we are expecting other side to have a heavier PoW chain
 // this is for the case when the chain only moves in pruned state
 // if after bootstraping the chain can continousky sync for few minutes, this means we have got the job done
-// TODO if during bootstrap error occurs, then we must discard data and restart from scratch
-// resume may be implemented in future
+// state is fetched from all peers agreeing on the state root and verified before being committed, see fastsync.go
+// if bootstrap fails, it is resumed during next sync
 func (connection *Connection) bootstrap_chain() {
 	defer handle_connection_panic(connection)
+	chain := connection.node.chain
 	var request ChangeList
 	var response Changes
-	// var err error
+	var err error
 	var zerohash crypto.Hash
 
 	// peer's chain is only 110 height, so do not bootstrap
@@ -54,228 +55,159 @@ func (connection *Connection) bootstrap_chain() {
 		connection.logger.Info("fastsync cannot be done as peer's chain has low height")
 		connection.logger.Info("will do normal sync")
 		connection.sync_chain()
+		chain.Sync = true
 		return
 	}
 
 	// we will request top 60 blocks
 	ctopo := connection.TopoHeight - 50 // last 50 blocks have to be synced, this syncing will help us detect error
-	var topos []int64
-	for i := ctopo - (max_request_topoheights - 1); i < ctopo; i++ {
-		topos = append(topos, i)
+	fresh_topo := ctopo - (max_request_topoheights - 1)
+	topo := fresh_topo
+	if saved := connection.node.load_fastsync_state(); saved >= 2 && saved < topo {
+		topo = saved // resume interrupted bootstrap, if peers still serve its state
 	}
 
 	connection.logger.Info("Bootstrap Initiated")
 
-	for i := range topos {
-		request.TopoHeights = append(request.TopoHeights, topos[i])
+	var f *fastsync
+	var balance *tree_sync
+	var balance_keys int64
+	for {
+		if f, err = connection.node.new_fastsync(topo, connection.logger); err != nil {
+			connection.logger.Error(err, "fastsync data cannot be stored")
+			return
+		}
+		f.find_peers(connection)
+		if balance, balance_keys, err = f.agree_root([]byte(config.BALANCE_TREE), zerohash, f.peers); err == nil || topo == fresh_topo {
+			break
+		}
+		connection.logger.Info("Interrupted bootstrap cannot be resumed, starting over", "topoheight", topo, "err", err)
+		topo = fresh_topo
+	}
+	if err != nil { // nothing has been written yet
+		connection.logger.Error(err, "Verified fastsync is not possible with current peers, will do normal sync", "peers", len(f.peers))
+		chain.Sync = true
+		return
+	}
+	connection.logger.Info("State root agreed", "topoheight", topo, "peers", len(balance.peers), "keycount", balance_keys)
+
+	for i := topo; i < topo+max_request_topoheights; i++ {
+		request.TopoHeights = append(request.TopoHeights, i)
 	}
 
-	fill_common(&request.Common) // fill common info
+	connection.node.fill_common(&request.Common) // fill common info
 	if err := connection.Client.Call("Peer.ChangeSet", request, &response); err != nil {
 		connection.logger.V(1).Error(err, "Call failed ChangeSet")
 		return
 	}
+	if len(response.CBlocks) != len(request.TopoHeights) {
+		connection.logger.Error(nil, "changeset does not contain requested blocks", "requested", len(request.TopoHeights), "received", len(response.CBlocks))
+		connection.score(score_empty_response)
+		return
+	}
 	// we have a response, see if its valid and try to add to get the blocks
 	connection.logger.V(1).Info("changeset received", "keycount", response.KeyCount, "sccount", response.SCKeyCount)
 
 	commit_version := uint64(0)
 
 	{ // fetch and commit balance tree
+		if err = f.fetch_tree(balance, balance_keys, "step1"); err != nil {
+			connection.logger.Error(err, "Bootstrap failed, will resume later")
+			return
+		}
 
-		chunksize := int64(640)
-		chunks_estm := response.KeyCount / chunksize
-		chunks := int64(1) // chunks need to be in power of 2
-		path_length := 0
-		for chunks < chunks_estm {
-			chunks = chunks * 2
-			path_length++
+		var balance_tree *graviton.Tree
+		if ss, err := chain.Store.Balance_store.LoadSnapshot(0); err != nil {
+			panic(err)
+		} else if balance_tree, err = ss.GetTree(config.BALANCE_TREE); err != nil {
+			panic(err)
 		}
+		if commit_version, err = balance.commit(balance_tree); err != nil {
+			panic(err)
+		}
+	}
 
-		if chunks < 2 {
-			chunks = 2
-			path_length = 1
+	var meta *tree_sync
+	{ // fetch and commit SC tree, every SC data tree is verified against the hash stored in its meta
+		var meta_keys int64
+		if meta, meta_keys, err = f.agree_root([]byte(config.SC_META), zerohash, balance.peers); err != nil {
+			connection.logger.Error(err, "Bootstrap failed, will resume later")
+			return
+		}
+		if err = f.fetch_tree(meta, meta_keys, "step 2"); err != nil {
+			connection.logger.Error(err, "Bootstrap failed, will resume later")
+			return
 		}
 
-		var section [8]byte
+		err = meta.each(func(section *tree_section) error {
+			for j := range section.Keys {
+				var sc_meta dvm.SC_META_DATA
+				var expected crypto.Hash
+				if sc_meta.UnmarshalBinaryGood(section.Values[j]) == nil {
+					expected = sc_meta.DataHash // zero for SCs stored in old format, their root is agreed by peers
+				}
 
-		total_keys := 0
+				sc, sc_keys, err := f.agree_root(section.Keys[j], expected, meta.peers)
+				if err != nil {
+					return err
+				}
+				if err = f.fetch_tree(sc, sc_keys, "step 2"); err != nil {
+					return err
+				}
 
-		for i := int64(0); i < chunks; i++ {
-			binary.BigEndian.PutUint64(section[:], bits.Reverse64(uint64(i))) // place reverse path
-			ts_request := Request_Tree_Section_Struct{Topo: request.TopoHeights[0], TreeName: []byte(config.BALANCE_TREE), Section: section[:], SectionLength: uint64(path_length)}
-			var ts_response Response_Tree_Section_Struct
-			fill_common(&ts_request.Common)
-			if err := connection.Client.Call("Peer.TreeSection", ts_request, &ts_response); err != nil {
-				connection.logger.V(1).Error(err, "Call failed TreeSection")
-				return
-			} else {
-				// now we must write all the state changes to gravition
-				var balance_tree *graviton.Tree
+				var sc_data_tree *graviton.Tree
 				if ss, err := chain.Store.Balance_store.LoadSnapshot(0); err != nil {
 					panic(err)
-				} else if balance_tree, err = ss.GetTree(config.BALANCE_TREE); err != nil {
+				} else if sc_data_tree, err = ss.GetTree(string(section.Keys[j])); err != nil {
 					panic(err)
 				}
-
-				if len(ts_response.Keys) != len(ts_response.Values) {
-					//rlog.Warnf("Incoming Key count %d value count %d \"%s\" ", len(ts_response.Keys), len(ts_response.Values), globals.CTXString(connection.logger))
-					connection.exit()
-					return
-				}
-				//rlog.Debugf("chunk %d Will write %d keys\n", i, len(ts_response.Keys))
-
-				for j := range ts_response.Keys {
-					balance_tree.Put(ts_response.Keys[j], ts_response.Values[j])
-				}
-				total_keys += len(ts_response.Keys)
-
-				commit_version, err = graviton.Commit(balance_tree)
-				if err != nil {
+				if _, err = sc.commit(sc_data_tree); err != nil {
 					panic(err)
 				}
-
-				h, err := balance_tree.Hash()
-				_ = h
-				_ = err
-				//rlog.Debugf("total keys %d hash %x err %s\n", total_keys, h, err)
-
 			}
-			connection.logger.Info("Bootstrap in progress(step1)", "percent", float32(i*100)/float32(chunks))
+			return nil
+		})
+		if err != nil {
+			connection.logger.Error(err, "Bootstrap failed, will resume later")
+			return
 		}
-	}
 
-	{ // fetch and commit SC tree
-
-		chunksize := int64(640)
-		chunks_estm := response.SCKeyCount / chunksize
-		chunks := int64(1) // chunks need to be in power of 2
-		path_length := 0
-		for chunks < chunks_estm {
-			chunks = chunks * 2
-			path_length++
+		var sc_tree *graviton.Tree
+		if ss, err := chain.Store.Balance_store.LoadSnapshot(0); err != nil {
+			panic(err)
+		} else if sc_tree, err = ss.GetTree(config.SC_META); err != nil {
+			panic(err)
 		}
-
-		if chunks < 2 {
-			chunks = 2
-			path_length = 1
+		if commit_version, err = meta.commit(sc_tree); err != nil {
+			panic(err)
 		}
+	}
 
-		var section [8]byte
-
-		total_keys := 0
-
-		for i := int64(0); i < chunks; i++ {
-			binary.BigEndian.PutUint64(section[:], bits.Reverse64(uint64(i))) // place reverse path
-			ts_request := Request_Tree_Section_Struct{Topo: request.TopoHeights[0], TreeName: []byte(config.SC_META), Section: section[:], SectionLength: uint64(path_length)}
-			var ts_response Response_Tree_Section_Struct
-			fill_common(&ts_request.Common)
-			if err := connection.Client.Call("Peer.TreeSection", ts_request, &ts_response); err != nil {
-				connection.logger.V(1).Error(err, "Call failed TreeSection")
-				return
-			} else {
-				// now we must write all the state changes to gravition
-				var changed_trees []*graviton.Tree
-				var sc_tree *graviton.Tree
-				//var changed_trees []*graviton.Tree
-				ss, err := chain.Store.Balance_store.LoadSnapshot(0)
-				if err != nil {
-					panic(err)
-				} else if sc_tree, err = ss.GetTree(config.SC_META); err != nil {
-					panic(err)
-				}
-
-				if len(ts_response.Keys) != len(ts_response.Values) {
-					//rlog.Warnf("Incoming Key count %d value count %d \"%s\" ", len(ts_response.Keys), len(ts_response.Values), globals.CTXString(connection.logger))
-					connection.exit()
-					return
-				}
-				//rlog.Debugf("SC chunk %d Will write %d keys\n", i, len(ts_response.Keys))
-
-				for j := range ts_response.Keys {
-					sc_tree.Put(ts_response.Keys[j], ts_response.Values[j])
-
-					// we must fetch each individual SC tree
-
-					sc_request := Request_Tree_Section_Struct{Topo: request.TopoHeights[0], TreeName: ts_response.Keys[j], Section: section[:], SectionLength: uint64(0)}
-					var sc_response Response_Tree_Section_Struct
-					fill_common(&sc_request.Common)
-					if err := connection.Client.Call("Peer.TreeSection", sc_request, &sc_response); err != nil {
-						connection.logger.V(1).Error(err, "Call failed TreeSection")
-						return
-					} else {
-
-						var sc_data_tree *graviton.Tree
-						if sc_data_tree, err = ss.GetTree(string(ts_response.Keys[j])); err != nil {
-							panic(err)
-						} else if sc_response.KeyCount < 4096 {
-							for k := range sc_response.Keys {
-								sc_data_tree.Put(sc_response.Keys[k], sc_response.Values[k])
-							}
-						} else { // tree is a huge tree, get it in chunks
-							sc_chunks_estm := sc_response.KeyCount / chunksize
-							sc_chunks := int64(1) // chunks need to be in power of 2
-							sc_path_length := 0
-							for sc_chunks < sc_chunks_estm {
-								sc_chunks = sc_chunks * 2
-								sc_path_length++
-							}
-
-							if sc_chunks < 2 {
-								sc_chunks = 2
-								sc_path_length = 1
-							}
-
-							var sc_section [8]byte
-							for k := int64(0); k < sc_chunks; k++ {
-								binary.BigEndian.PutUint64(sc_section[:], bits.Reverse64(uint64(k))) // place reverse path
-								sc_ts_request := Request_Tree_Section_Struct{Topo: request.TopoHeights[0], TreeName: ts_response.Keys[j], Section: sc_section[:], SectionLength: uint64(sc_path_length)}
-								var sc_ts_response Response_Tree_Section_Struct
-								fill_common(&sc_ts_request.Common)
-								if err := connection.Client.Call("Peer.TreeSection", sc_ts_request, &sc_ts_response); err != nil {
-									connection.logger.V(1).Error(err, "Call failed TreeSection")
-									return
-								} else { // request was successfull
-
-									if len(sc_ts_response.Keys) != len(sc_ts_response.Values) {
-										connection.logger.V(1).Error(nil, "Wrong key/values", "Keycount", len(sc_ts_response.Keys), "valuecount", len(sc_ts_response.Values))
-										connection.exit()
-										return
-									}
-									//fmt.Printf("writing SC chunk %d/%d (%d)  writing %d keys %x\n", k, sc_chunks, sc_response.KeyCount, len(sc_ts_response.Keys), ts_response.Keys[j])
-									for l := range sc_ts_response.Keys {
-										sc_data_tree.Put(sc_ts_response.Keys[l], sc_ts_response.Values[l])
-									}
-								}
-
-							}
-						}
-						changed_trees = append(changed_trees, sc_data_tree)
-
-					}
-
-				}
-				total_keys += len(ts_response.Keys)
-				changed_trees = append(changed_trees, sc_tree)
-				commit_version, err = graviton.Commit(changed_trees...)
-				if err != nil {
-					panic(err)
-				}
-
-				h, err := sc_tree.Hash()
-				_ = h
-				_ = err
-				//rlog.Debugf("total SC keys %d hash %x err %s\n", total_keys, h, err)
+	var expected_state crypto.Hash // state hash is xor of balance and meta tree roots
+	for i := range expected_state {
+		expected_state[i] = balance.root[i] ^ meta.root[i]
+	}
 
-			}
-			connection.logger.Info("Bootstrap in progress(step 2)", "percent", float32(i*100)/float32(chunks))
-		}
+	// whatever datastore we have written, its state hash must match before anything refers to it
+	if state, err := chain.Load_Merkle_Hash(commit_version); err != nil || state != expected_state {
+		connection.logger.Error(err, "Bootstrapped state does not match state root", "state", state, "expected", expected_state)
+		f.discard()
+		return
 	}
+	verified_version := commit_version
 
 	for i := int64(0); i <= request.TopoHeights[0]; i++ {
 		chain.Store.Topo_store.Write(i, zerohash, commit_version, 0) // commit everything
 	}
 	chain.Store.Topo_store.Sync()
 
+	verified := false // unless final state is verified, changesets are rolled back and bootstrap is resumed later
+	defer func() {
+		if !verified {
+			connection.rollback_changes(verified_version, request.TopoHeights, response.CBlocks)
+		}
+	}()
+
 	for i := range response.CBlocks { // we must store the blocks
 
 		var cbl block.Complete_Block // parse incoming block and deserialize it
@@ -292,11 +224,6 @@ func (connection *Connection) bootstrap_chain() {
 		// give the chain some more time to respond
 		atomic.StoreInt64(&connection.LastObjectRequestTime, time.Now().Unix())
 
-		if i == 0 { // whatever datastore we have written, its state hash must match
-			// ToDo
-
-		}
-
 		// complete the txs
 		for j := range response.CBlocks[i].Txs {
 			var tx transaction.Transaction
@@ -350,6 +277,11 @@ func (connection *Connection) bootstrap_chain() {
 			var changed_trees []*graviton.Tree
 
 			for _, change := range response.CBlocks[i].Changes {
+				if len(change.Keys) != len(change.Values) {
+					connection.logger.Error(nil, "Incoming changeset is malformed", "keys", len(change.Keys), "values", len(change.Values))
+					connection.score(score_protocol_violation)
+					return
+				}
 				var tree *graviton.Tree
 				if tree, err = ss.GetTree(string(change.TreeName)); err != nil {
 					panic(err)
@@ -376,9 +308,90 @@ func (connection *Connection) bootstrap_chain() {
 		chain.Store.Topo_store.Write(request.TopoHeights[i], bl.GetHash(), commit_version, int64(bl.Height)) // commit everything
 	}
 
+	// changesets above the bootstrapped state come from a single peer, so the resulting state must match the one agreed upon by peers
+	top := request.TopoHeights[len(request.TopoHeights)-1]
+	expected_state, err = f.at(top).agree_state(balance.peers)
+	if err != nil {
+		connection.logger.Error(err, "Bootstrapped chain cannot be verified, will resume later", "topoheight", top)
+		return
+	}
+	if record, err := chain.Store.Topo_store.Read(top); err != nil {
+		connection.logger.Error(err, "Bootstrapped chain cannot be verified, will resume later", "topoheight", top)
+		return
+	} else if state, err := chain.Load_Merkle_Hash(record.State_Version); err != nil || state != expected_state {
+		connection.logger.Error(err, "Changesets do not match state root, will resume later", "topoheight", top, "state", state, "expected", expected_state)
+		connection.score(score_bad_chunk)
+		return
+	}
+	verified = true
+
+	f.discard() // progress is no longer required
 	connection.logger.Info("Bootstrap completed successfully.")
 	// load the chain from the disk
 	chain.Initialise_Chain_From_DB()
 	chain.Sync = true
 	return
 }
+
+// restores state to the verified version and forgets blocks above it, so bootstrap can be resumed
+func (connection *Connection) rollback_changes(verified_version uint64, topoheights []int64, cblocks []Complete_Block) {
+	chain := connection.node.chain
+	for _, topo := range topoheights[1:] {
+		chain.Store.Topo_store.Clean(topo)
+	}
+	chain.Store.Topo_store.Sync()
+
+	if err := revert_changes(chain.Store.Balance_store, verified_version, cblocks[1:]); err != nil {
+		connection.logger.Error(err, "Changesets could not be rolled back")
+	}
+}
+
+// every key touched by the changesets gets its value at the verified version back, keys which did not exist are deleted
+func revert_changes(store *graviton.Store, verified_version uint64, cblocks []Complete_Block) error {
+	verified_ss, err := store.LoadSnapshot(verified_version)
+	if err != nil {
+		return err
+	}
+	ss, err := store.LoadSnapshot(0)
+	if err != nil {
+		return err
+	}
+
+	trees := map[string][2]*graviton.Tree{} // verified and latest tree
+	var changed_trees []*graviton.Tree
+	for _, cbl := range cblocks {
+		for _, change := range cbl.Changes {
+			name := string(change.TreeName)
+			pair, ok := trees[name]
+			if !ok {
+				if pair[0], err = verified_ss.GetTree(name); err != nil {
+					return err
+				}
+				if pair[1], err = ss.GetTree(name); err != nil {
+					return err
+				}
+				trees[name] = pair
+				changed_trees = append(changed_trees, pair[1])
+			}
+
+			for _, key := range change.Keys {
+				value, err := pair[0].Get(key)
+				if err == nil {
+					err = pair[1].Put(key, value)
+				} else if errors.Is(err, graviton.ErrNotFound) {
+					if err = pair[1].Delete(key); errors.Is(err, graviton.ErrNotFound) {
+						err = nil // changes were not applied
+					}
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(changed_trees) == 0 {
+		return nil
+	}
+	_, err = graviton.Commit(changed_trees...)
+	return err
+}
@@ -109,8 +109,8 @@ func ConvertCBlock_To_CompleteBlock(cblock Complete_Block) (cbl block.Complete_B
 
 // we are expecting other side to have a heavier PoW chain, try to sync now
 func (connection *Connection) sync_chain() {
-
 	defer handle_connection_panic(connection)
+	chain := connection.node.chain
 	atomic.AddInt32(&connection.Syncing, 1)
 	defer atomic.AddInt32(&connection.Syncing, -1)
 
@@ -152,7 +152,7 @@ try_again:
 	// add genesis block at the end
 	request.Block_list = append(request.Block_list, globals.Config.Genesis_Block_Hash)
 	request.TopoHeights = append(request.TopoHeights, 0)
-	fill_common(&request.Common) // fill common info
+	connection.node.fill_common(&request.Common) // fill common info
 
 	if err := connection.Client.Call("Peer.Chain", request, &response); err != nil {
 		connection.logger.V(2).Error(err, "Call failed Chain")
@@ -220,10 +220,12 @@ try_again:
 
 				//fmt.Printf("inserting blocks %d %x\n", (int64(i) + response.Start_topoheight), response.Block_list[i][:])
 				orequest.Block_list = append(orequest.Block_list, response.Block_list[i])
-				fill_common(&orequest.Common)
-				if err := connection.Client.Call("Peer.GetObject", orequest, &oresponse); err != nil {
+				connection.node.fill_common(&orequest.Common)
+				if err := connection.request_objects(orequest, &oresponse); err != nil {
 					connection.logger.V(2).Error(err, "Call failed GetObject")
 					return
+				} else if len(oresponse.CBlocks) < 1 {
+					return
 				} else { // process the response
 					cbl, _ := ConvertCBlock_To_CompleteBlock(oresponse.CBlocks[0])
 					ramstore.insert_block(&cbl) // insert block with checking verification
@@ -268,8 +270,8 @@ try_again:
 				var oresponse Objects
 
 				orequest.Block_list = append(orequest.Block_list, response.Block_list[i])
-				fill_common(&orequest.Common)
-				if err := connection.Client.Call("Peer.GetObject", orequest, &oresponse); err != nil {
+				connection.node.fill_common(&orequest.Common)
+				if err := connection.request_objects(orequest, &oresponse); err != nil {
 					connection.logger.V(2).Error(err, "Call failed GetObject")
 					return
 				} else { // process the response
@@ -301,6 +303,7 @@ try_again:
 
 func (connection *Connection) process_object_response(response Objects, sent int64, syncing bool) error {
 	var err error
+	chain := connection.node.chain
 	defer globals.Recover(2)
 
 	for i := 0; i < len(response.CBlocks); i++ { // process incoming full blocks
@@ -315,6 +318,7 @@ func (connection *Connection) process_object_response(response Objects, sent int
 		err := bl.Deserialize(response.CBlocks[i].Block)
 		if err != nil { // we have a block which could not be deserialized ban peer
 			connection.logger.V(2).Error(err, "Incoming block could not be deserilised")
+			connection.score(score_invalid_block)
 			connection.exit()
 			if syncing {
 				return nil
@@ -338,6 +342,7 @@ func (connection *Connection) process_object_response(response Objects, sent int
 			err = tx.Deserialize(response.CBlocks[i].Txs[j])
 			if err != nil { // we have a tx which could not be deserialized ban peer
 				connection.logger.V(2).Error(err, "Incoming TX could not be deserilised")
+				connection.score(score_invalid_tx)
 				connection.exit()
 
 				if syncing {
@@ -351,8 +356,12 @@ func (connection *Connection) process_object_response(response Objects, sent int
 
 		// check if we can add ourselves to chain
 		err, ok := chain.Add_Complete_Block(&cbl)
+		if ok {
+			connection.score(score_useful_relay)
+		}
 		if !ok && err == errormsg.ErrInvalidPoW {
 			connection.logger.V(2).Error(err, "This peer should be banned")
+			connection.score(score_invalid_block)
 			connection.exit()
 			if syncing {
 				return nil
@@ -384,20 +393,28 @@ func (connection *Connection) process_object_response(response Objects, sent int
 		err = tx.Deserialize(response.Txs[i])
 		if err != nil { // we have a tx which could not be deserialized ban peer
 			connection.logger.V(2).Error(err, "Incoming TX could not be deserilised")
+			connection.score(score_invalid_tx)
 			connection.exit()
 
 			return nil
 		}
 
-		if !chain.Mempool.Mempool_TX_Exist(tx.GetHash()) { // we still donot have it, so try to process it
+		if !(chain.Mempool.Mempool_TX_Exist(tx.GetHash()) || chain.Regpool.Regpool_TX_Exist(tx.GetHash())) { // we still donot have it, so try to process it
 			if chain.Add_TX_To_Pool(&tx) == nil { // currently we are ignoring error
-				broadcast_Tx(&tx, 0, sent)
+				connection.score(score_useful_relay)
+				connection.node.broadcast_Tx(&tx, 0, sent)
+			} else {
+				connection.score(score_rejected_tx)
 			}
 		}
 	}
 
 	for i := range response.Chunks { // process incoming chunks
-		connection.feed_chunk(&response.Chunks[i], sent)
+		if err := connection.feed_chunk(&response.Chunks[i], sent); err == errormsg.ErrInvalidPoW {
+			connection.score(score_invalid_block)
+		} else if err != nil {
+			connection.score(score_bad_chunk)
+		}
 	}
 
 	return nil
@@ -13,16 +13,10 @@ import "github.com/klauspost/reedsolomon"
 import "github.com/deroproject/derohe/block"
 import "github.com/deroproject/derohe/transaction"
 import "github.com/deroproject/derohe/globals"
-import "github.com/deroproject/derohe/errormsg"
 import "github.com/deroproject/derohe/config"
 import "github.com/deroproject/derohe/metrics"
 import "github.com/deroproject/derohe/cryptography/crypto"
 
-var chunk_map sync.Map // key is blid, value is pointer to  Chunks_Per_Block_Data
-
-var chunk_lock sync.Mutex
-var single_construction sync.Mutex // used to single threaded processing while reconstructing blocks
-
 const MAX_CHUNKS uint8 = 255
 
 type Chunks_Per_Block_Data struct {
@@ -36,19 +30,19 @@ type Chunks_Per_Block_Data struct {
 }
 
 // cleans up chunks every minute
-func chunks_clean_up() {
-	chunk_map.Range(func(key, value interface{}) bool {
+func (n *node) chunks_clean_up() {
+	n.chunk_map.Range(func(key, value interface{}) bool {
 		chunks_per_block := value.(*Chunks_Per_Block_Data)
 		if time.Now().Sub(chunks_per_block.Created) > time.Second*180 {
-			chunk_map.Delete(key)
+			n.chunk_map.Delete(key)
 		}
 		return true
 	})
 }
 
 // return whether chunk exist
-func is_chunk_exist(hhash [32]byte, cid uint8) *Block_Chunk {
-	chunksi, ok := chunk_map.Load(fmt.Sprintf("%x", hhash))
+func (n *node) is_chunk_exist(hhash [32]byte, cid uint8) *Block_Chunk {
+	chunksi, ok := n.chunk_map.Load(fmt.Sprintf("%x", hhash))
 	if !ok {
 		//debug.PrintStack()
 		return nil
@@ -59,9 +53,8 @@ func is_chunk_exist(hhash [32]byte, cid uint8) *Block_Chunk {
 
 // feed a chunk until we are able to fully decode a chunk
 func (connection *Connection) feed_chunk(chunk *Block_Chunk, sent int64) error {
-
-	chunk_lock.Lock()
-	defer chunk_lock.Unlock()
+	connection.node.chunk_lock.Lock()
+	defer connection.node.chunk_lock.Unlock()
 
 	if chunk.HHash != chunk.HeaderHash() {
 		connection.logger.V(2).Info("This peer should be banned, since he supplied wrong chunk")
@@ -96,12 +89,12 @@ func (connection *Connection) feed_chunk(chunk *Block_Chunk, sent int64) error {
 		return fmt.Errorf("Corrupted Chunk")
 	}
 
-	if nil != is_chunk_exist(chunk.HHash, uint8(chunk.CHUNK_ID)) { // chunk already exists return
+	if nil != connection.node.is_chunk_exist(chunk.HHash, uint8(chunk.CHUNK_ID)) { // chunk already exists return
 		return nil
 	}
 
 	chunks_per_block := new(Chunks_Per_Block_Data)
-	if chunksi, ok := chunk_map.LoadOrStore(fmt.Sprintf("%x", chunk.HHash), chunks_per_block); ok {
+	if chunksi, ok := connection.node.chunk_map.LoadOrStore(fmt.Sprintf("%x", chunk.HHash), chunks_per_block); ok {
 		chunks_per_block = chunksi.(*Chunks_Per_Block_Data)
 
 		// make sure we are matching what is stored already
@@ -136,20 +129,8 @@ func (connection *Connection) feed_chunk(chunk *Block_Chunk, sent int64) error {
 		}
 
 		// we must check the Pow now
-		if int64(bl.Height) >= chain.Get_Height()-3 && int64(bl.Height) <= chain.Get_Height()+3 {
-
-		} else {
-			return nil // we need not broadcast
-		}
-
-		if len(bl.Tips) == 0 || len(bl.MiniBlocks) < 5 {
-			return nil
-		}
-
-		for _, mbl := range bl.MiniBlocks {
-			if !chain.VerifyMiniblockPoW(&bl, mbl) {
-				return errormsg.ErrInvalidPoW
-			}
+		if ok, err := connection.node.relayable_block(&bl); !ok {
+			return err
 		}
 
 		chunks_per_block.Created = time.Now()
@@ -164,7 +145,7 @@ func (connection *Connection) feed_chunk(chunk *Block_Chunk, sent int64) error {
 
 	if chunks_per_block.ChunkCollection[chunk.CHUNK_ID] == nil {
 		chunks_per_block.ChunkCollection[chunk.CHUNK_ID] = chunk
-		broadcast_Chunk(chunk, 0, sent) // broadcast chunk INV
+		connection.node.broadcast_Chunk(chunk, 0, sent) // broadcast chunk INV
 	}
 
 	chunk_count := 0
@@ -233,8 +214,8 @@ func (connection *Connection) feed_chunk(chunk *Block_Chunk, sent int64) error {
 }
 
 // cehck whether we have already chunked this
-func is_already_chunked_by_us(blid crypto.Hash, data_shard_count, parity_shard_count int) (hash [32]byte, chunk_count int) {
-	chunk_map.Range(func(key, value interface{}) bool {
+func (n *node) is_already_chunked_by_us(blid crypto.Hash, data_shard_count, parity_shard_count int) (hash [32]byte, chunk_count int) {
+	n.chunk_map.Range(func(key, value interface{}) bool {
 
 		chunks_per_block := value.(*Chunks_Per_Block_Data)
 		for _, c := range chunks_per_block.ChunkCollection {
@@ -296,7 +277,7 @@ func Convert_P2PCBL_TO_CBL(input []byte) *block.Complete_Block {
 
 // note we do not send complete block,
 // since other nodes already have most of the mempool, let the mempool be used as much as possible
-func convert_block_to_chunks(cbl *block.Complete_Block, data_shard_count, parity_shard_count int) ([32]byte, int) {
+func (n *node) convert_block_to_chunks(cbl *block.Complete_Block, data_shard_count, parity_shard_count int) ([32]byte, int) {
 	var cbor_cbl TXSET
 	bl_serialized := cbl.Bl.Serialize()
 	blid := [32]byte(cbl.Bl.GetHash())
@@ -317,14 +298,14 @@ func convert_block_to_chunks(cbl *block.Complete_Block, data_shard_count, parity
 	//	return hhash, count
 	//}
 	// loop through all the data chunks and overide from there
-	chunk_map.Range(func(key, value interface{}) bool {
+	n.chunk_map.Range(func(key, value interface{}) bool {
 		chunk := value.(*Chunks_Per_Block_Data)
 		for _, c := range chunk.ChunkCollection {
 			if c != nil && c.BLID == blid {
 				if data_shard_count != int(c.CHUNK_NEED) || parity_shard_count != int(c.CHUNK_COUNT-c.CHUNK_NEED) {
 					data_shard_count = int(c.CHUNK_NEED)
 					parity_shard_count = int(c.CHUNK_COUNT - c.CHUNK_NEED)
-					logger.V(2).Info("overiding shards count to %d, parity to %d\n", "data_count", data_shard_count, "parity_count", parity_shard_count)
+					n.logger.V(2).Info("overiding shards count to %d, parity to %d\n", "data_count", data_shard_count, "parity_count", parity_shard_count)
 				}
 				return false
 			}
@@ -387,6 +368,6 @@ func convert_block_to_chunks(cbl *block.Complete_Block, data_shard_count, parity
 	chunks.Processed = true
 	chunks.Complete = true
 
-	chunk_map.Store(fmt.Sprintf("%x", chunk[0].HHash), chunks)
+	n.chunk_map.Store(fmt.Sprintf("%x", chunk[0].HHash), chunks)
 	return chunk[0].HeaderHash(), data_shard_count + parity_shard_count
 }
@@ -7,22 +7,22 @@ import "github.com/deroproject/derohe/globals"
 import "github.com/deroproject/derohe/cryptography/crypto"
 
 // fill the common part from our chain
-func fill_common(common *Common_Struct) {
+func (n *node) fill_common(common *Common_Struct) {
 	var err error
-	common.Height = chain.Get_Height()
+	common.Height = n.chain.Get_Height()
 	//common.StableHeight = chain.Get_Stable_Height()
-	common.TopoHeight = chain.Load_TOPO_HEIGHT()
+	common.TopoHeight = n.chain.Load_TOPO_HEIGHT()
 
-	version, err := chain.ReadBlockSnapshotVersion(chain.Get_Top_ID())
+	version, err := n.chain.ReadBlockSnapshotVersion(n.chain.Get_Top_ID())
 	if err != nil {
 		panic(err)
 	}
 
-	if common.StateHash, err = chain.Load_Merkle_Hash(version); err != nil {
+	if common.StateHash, err = n.chain.Load_Merkle_Hash(version); err != nil {
 		panic(err)
 	}
 
-	common.Top_Version = uint64(chain.Get_Current_Version_at_Height(int64(common.Height))) // this must be taken from the hardfork
+	common.Top_Version = uint64(n.chain.Get_Current_Version_at_Height(int64(common.Height))) // this must be taken from the hardfork
 	common.T0 = globals.TimeSkipP2P().UTC().UnixMicro()
 }
 
@@ -39,15 +39,15 @@ func fill_common_T0T1T2(request, response *Common_Struct) {
 
 // used while sendint TX ASAP
 // this also skips statehash
-func fill_common_skip_topoheight(common *Common_Struct) {
-	fill_common(common)
+func (n *node) fill_common_skip_topoheight(common *Common_Struct) {
+	n.fill_common(common)
 	return
 
 }
 
 // update some common properties quickly
 func (connection *Connection) update(common *Common_Struct) {
-	connection.update_received = time.Now()
+	atomic.StoreInt64(&connection.update_received, time.Now().UnixNano())
 	var hash crypto.Hash
 	atomic.StoreInt64(&connection.Height, common.Height) // satify race detector GOD
 	if common.StableHeight != 0 {
@@ -57,9 +57,12 @@ func (connection *Connection) update(common *Common_Struct) {
 
 	//connection.Top_ID = common.Top_ID
 
-	if connection.Top_Version != common.Top_Version {
+	if atomic.LoadUint64(&connection.Top_Version) != common.Top_Version {
 		atomic.StoreUint64(&connection.Top_Version, common.Top_Version) // satify race detector GOD
 	}
+
+	// update is called concurrently from handlers and broadcasts
+	connection.update_mutex.Lock()
 	if common.StateHash != hash {
 		connection.StateHash = common.StateHash
 	}
@@ -76,15 +79,12 @@ func (connection *Connection) update(common *Common_Struct) {
 
 		//fmt.Printf("clock index %d\n",connection.clock_index)
 	}
+	connection.update_mutex.Unlock()
 
 	// parse delivered peer list as grey list
 	if len(common.PeerList) > 1 {
 		connection.logger.V(4).Info("Peer provides peers", "count", len(common.PeerList))
-		for i := range common.PeerList {
-			if i < 31 {
-				Peer_Add(&Peer{Address: common.PeerList[i].Addr, LastConnected: uint64(time.Now().UTC().Unix())})
-			}
-		}
+		connection.add_peer_list(common.PeerList, 31)
 	}
 }
 
@@ -97,5 +97,5 @@ func (connection *Connection) calculate_avg_offset() {
 			count++
 		}
 	}
-	connection.clock_offset = int64(total / count)
+	atomic.StoreInt64(&connection.clock_offset, int64(total/count))
 }
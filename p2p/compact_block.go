@@ -0,0 +1,274 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file implements compact block relay
+ * peers which negotiated compact blocks during handshake receive the block with its txs replaced by short ids
+ * the receiver rebuilds the block from its own mempool and regpool and requests only the txs it does not have
+ * other peers keep receiving erasure coded chunks, see chunk_server.go
+ */
+import "fmt"
+import "math"
+import "time"
+import "sync/atomic"
+import "encoding/binary"
+
+import "github.com/deroproject/derohe/block"
+import "github.com/deroproject/derohe/errormsg"
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/metrics"
+import "github.com/deroproject/derohe/transaction"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+const EXT_COMPACT_BLOCKS = "cblock" // handshake extension, peer understands Peer.NotifyCompactBlock
+
+func has_extension(list []string, extension string) bool {
+	for i := range list {
+		if list[i] == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// short ids are salted with block id, so colliding txs cannot be prepared in advance
+func compact_short_id(blid crypto.Hash, txid crypto.Hash) (id [8]byte) {
+	hash := crypto.Keccak256(blid[:], txid[:])
+	copy(id[:], hash[:])
+	return
+}
+
+// block without tx hashes, together with short ids of its txs
+func compact_block(cbl *block.Complete_Block) (compact Compact_Block) {
+	bl := *cbl.Bl
+	compact.BLID = bl.GetHash()
+	for _, txid := range bl.Tx_hashes {
+		compact.ShortIDs = append(compact.ShortIDs, compact_short_id(compact.BLID, txid))
+	}
+	bl.Tx_hashes = nil
+	compact.Block = bl.Serialize()
+	return
+}
+
+// sends compact block to every suitable peer which negotiated it
+// returns the remaining peers, they need to be served chunks
+func (n *node) broadcast_Compact_Block(cbl *block.Complete_Block, PeerID uint64, first_seen int64, connections []*Connection) (legacy []*Connection, count int) {
+	var request Compact_Block
+	our_height := n.chain.Get_Height()
+
+	for _, v := range connections {
+		if !v.compact {
+			legacy = append(legacy, v)
+			continue
+		}
+		if atomic.LoadUint32(&v.State) == HANDSHAKE_PENDING || PeerID == v.Peer_ID || v.Peer_ID == n.GetPeerID() { // skip pre-handshake connections
+			continue
+		}
+		peer_height := atomic.LoadInt64(&v.Height) // same as chunks, lagging or leading peers are skipped
+		if (our_height-peer_height) > 2 || (peer_height-our_height) > 2 {
+			continue
+		}
+
+		if count == 0 { // build the request once and dispatch it to all possible peers
+			request = compact_block(cbl)
+			request.Sent = first_seen
+			n.fill_common(&request.Common)
+		}
+		count++
+
+		go func(connection *Connection) {
+			defer globals.Recover(3)
+			connection.logger.V(3).Info("Sending compact block to peer ", "blid", request.BLID, "tx_count", len(request.ShortIDs))
+			var dummy Dummy
+			if err := connection.Client.Call("Peer.NotifyCompactBlock", request, &dummy); err != nil {
+				return
+			}
+			connection.update(&dummy.Common) // update common information
+		}(v)
+	}
+	return
+}
+
+// peer announces a block in compact form
+func (c *Connection) NotifyCompactBlock(request Compact_Block, response *Dummy) (err error) {
+	defer handle_connection_panic(c)
+	chain := c.node.chain
+
+	c.update(&request.Common)            // update common information
+	c.node.fill_common(&response.Common) // fill common info
+
+	var bl block.Block
+	if err = bl.Deserialize(request.Block); err != nil || len(bl.Tx_hashes) != 0 || len(request.ShortIDs) > math.MaxUint16 {
+		c.logger.V(3).Error(err, "Compact block cannot be deserialized.Should be banned")
+		c.score(score_invalid_block)
+		c.exit()
+		return fmt.Errorf("invalid compact block")
+	}
+
+	blid := crypto.Hash(request.BLID)
+	if chain.Is_Block_Topological_order(blid) || chain.Block_Exists(blid) { // we already have it
+		return nil
+	}
+	if ok, err := c.node.relayable_block(&bl); !ok { // only verified blocks cause tx requests
+		if err != nil {
+			c.score(score_invalid_block)
+		}
+		return err
+	}
+
+	cbl, err := c.rebuild_compact_block(blid, &bl, request.ShortIDs)
+	if err != nil {
+		c.logger.V(2).Error(err, "compact block could not be rebuilt", "blid", blid)
+		return err
+	}
+
+	if request.Sent != 0 && request.Sent < globals.Time().UTC().UnixMicro() {
+		time_to_receive := float64(globals.Time().UTC().UnixMicro()-request.Sent) / 1000000
+		metrics.Set.GetOrCreateHistogram("block_propagation_duration_histogram_seconds").Update(time_to_receive)
+	}
+
+	// make sure connection does not timeout and be killed while processing huge blocks
+	atomic.StoreInt64(&c.LastObjectRequestTime, time.Now().Unix())
+	if err, ok := chain.Add_Complete_Block(cbl); ok { // if block addition was successfil
+		c.score(score_useful_relay)
+		c.node.Broadcast_Block(cbl, c.Peer_ID) // do not send back to the original peer
+	} else if err == errormsg.ErrInvalidPoW {
+		c.logger.Error(err, "This peer should be banned and terminated")
+		c.score(score_invalid_block)
+		c.exit()
+		return err
+	}
+	return nil
+}
+
+// finds txs of a compact block within our pools, txs we do not have are requested from the peer
+// if a short id matched a wrong tx, block id will not match and all txs are requested
+func (c *Connection) rebuild_compact_block(blid crypto.Hash, bl *block.Block, short_ids [][8]byte) (*block.Complete_Block, error) {
+	chain := c.node.chain
+
+	known := map[[8]byte]*transaction.Transaction{}
+	collision := map[[8]byte]bool{}
+	add := func(txid crypto.Hash, tx *transaction.Transaction) {
+		if tx == nil {
+			return
+		}
+		id := compact_short_id(blid, txid)
+		if _, ok := known[id]; ok {
+			collision[id] = true
+		}
+		known[id] = tx
+	}
+	for _, txid := range chain.Mempool.Mempool_List_TX() {
+		add(txid, chain.Mempool.Mempool_Get_TX(txid))
+	}
+	for _, txid := range chain.Regpool.Regpool_List_TX() {
+		add(txid, chain.Regpool.Regpool_Get_TX(txid))
+	}
+
+	txs := make([]*transaction.Transaction, len(short_ids))
+	var missing []int
+	for i, id := range short_ids {
+		if tx, ok := known[id]; ok && !collision[id] {
+			txs[i] = tx
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	metrics.Set.GetOrCreateCounter("compact_block_tx_found_total").Add(len(short_ids) - len(missing))
+	metrics.Set.GetOrCreateCounter("compact_block_tx_missing_total").Add(len(missing))
+
+	if err := c.request_block_txs(blid, txs, missing); err != nil {
+		return nil, err
+	}
+
+	if compact_block_hash(bl, txs) != blid {
+		all := make([]int, len(txs))
+		for i := range all {
+			all[i] = i
+		}
+		if err := c.request_block_txs(blid, txs, all); err != nil {
+			return nil, err
+		}
+		if compact_block_hash(bl, txs) != blid {
+			c.score(score_invalid_block)
+			return nil, fmt.Errorf("compact block does not match its id")
+		}
+	}
+
+	return &block.Complete_Block{Bl: bl, Txs: txs}, nil
+}
+
+// restores tx hashes and returns resulting block id
+func compact_block_hash(bl *block.Block, txs []*transaction.Transaction) crypto.Hash {
+	bl.Tx_hashes = bl.Tx_hashes[:0]
+	for _, tx := range txs {
+		bl.Tx_hashes = append(bl.Tx_hashes, tx.GetHash())
+	}
+	return bl.GetHash()
+}
+
+// request txs of a block by their position within the block
+func (c *Connection) request_block_txs(blid crypto.Hash, txs []*transaction.Transaction, indexes []int) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	var request ObjectList
+	var response Objects
+	for _, i := range indexes {
+		var id [32 + 2]byte
+		copy(id[:], blid[:])
+		binary.BigEndian.PutUint16(id[32:], uint16(i))
+		request.Block_Tx_list = append(request.Block_Tx_list, id)
+	}
+	c.node.fill_common(&request.Common) // fill common info
+	if err := c.request_objects(request, &response); err != nil {
+		return err
+	}
+	if len(response.Txs) != len(indexes) {
+		return fmt.Errorf("peer returned %d txs, requested %d", len(response.Txs), len(indexes))
+	}
+	for j, i := range indexes {
+		var tx transaction.Transaction
+		if err := tx.Deserialize(response.Txs[j]); err != nil { // we have a tx which could not be deserialized ban peer
+			c.score(score_invalid_tx)
+			return err
+		}
+		txs[i] = &tx
+	}
+	c.logger.V(2).Info("Requested missing txs of compact block", "blid", blid, "count", len(indexes))
+	return nil
+}
+
+// checks done before spending any effort on a relayed block
+// blocks far from our height are ignored, blocks carrying invalid PoW are an error
+func (n *node) relayable_block(bl *block.Block) (bool, error) {
+	if int64(bl.Height) < n.chain.Get_Height()-3 || int64(bl.Height) > n.chain.Get_Height()+3 {
+		return false, nil // we need not broadcast
+	}
+
+	if len(bl.Tips) == 0 || len(bl.MiniBlocks) < 5 {
+		return false, nil
+	}
+
+	for _, mbl := range bl.MiniBlocks {
+		if !n.chain.VerifyMiniblockPoW(bl, mbl) {
+			return false, errormsg.ErrInvalidPoW
+		}
+	}
+	return true, nil
+}
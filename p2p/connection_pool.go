@@ -45,6 +45,8 @@ import "github.com/deroproject/derohe/transaction"
 
 import "github.com/cenkalti/rpc2"
 
+import "golang.org/x/time/rate"
+
 // any connection incoming/outgoing can only be in this state
 //type Conn_State uint32
 
@@ -68,11 +70,13 @@ type Connection struct {
 	Latency               int64  // time.Duration            // latency to this node when sending timed sync
 	BytesIn               uint64 // total bytes in
 	BytesOut              uint64 // total bytes out
+	Score                 int64  // behaviour score, see peer_score.go
 	Top_Version           uint64 // current hard fork version supported by peer
 	Peer_ID               uint64 // Remote peer id
 	Port                  uint32 // port advertised by other end as its server,if it's 0 server cannot accept connections
 	State                 uint32 // state of the connection
 	Syncing               int32  // denotes whether we are syncing and thus stop pinging
+	handshake_accepted    uint32 // handshake of the other end has been accepted, see handshake_pending
 
 	Client  *rpc2.Client
 	Conn    net.Conn // actual object to talk
@@ -85,18 +89,24 @@ type Connection struct {
 	Incoming        bool     // is connection incoming or outgoing
 	Addr            net.Addr // endpoint on the other end
 	SyncNode        bool     // whether the peer has been added to command line as sync node
+	compact         bool     // both ends negotiated compact blocks
+	dandelion       bool     // both ends negotiated dandelion stem relay
 	ProtocolVersion string
 	Tag             string // tag for the other end
+	Identity        string // public key proven by the other end during tls handshake, empty if none, see verify_identity
+	pinned          string // identity the other end must prove, set for pinned outgoing connections
 	DaemonVersion   string
 	Top_ID          crypto.Hash // top block id of the connection
 
 	logger logr.Logger // connection specific logger
+	node   *node       // node this connection belongs to
 
 	Requested_Objects [][32]byte // currently unused as we sync up with a single peer at a time
 
-	peer_sent_time   time.Time // contains last time when peerlist was sent
-	update_received  time.Time // last time when upated was received
-	ping_in_progress int32     // contains ping pending against this connection
+	peer_sent_time   time.Time     // contains last time when peerlist was sent
+	addr_limiter     *rate.Limiter // limits addresses accepted from this peer
+	update_received  int64         // unix nano time when last update was received
+	ping_in_progress int32         // contains ping pending against this connection
 
 	ping_count int64
 
@@ -105,10 +115,21 @@ type Connection struct {
 	delays        [MAX_CLOCK_DATA_SET]time.Duration
 	clock_offset  int64 // duration updated on every miniblock
 	onceexit      sync.Once
+	identity_once sync.Once  // Identity is recorded by whichever handshake completes first
+	update_mutex  sync.Mutex // protects StateHash and clock data set
+
+	score_mutex  sync.Mutex
+	score_events map[string]uint64 // how many times each score reason was applied
 
 	Mutex sync.Mutex // used only by connection go routine
 }
 
+// requests other than handshake are refused while handshake is pending
+// the other end may finish its handshake before ours completes, so its requests are served once its handshake is accepted
+func (c *Connection) handshake_pending() bool {
+	return atomic.LoadUint32(&c.State) == HANDSHAKE_PENDING && atomic.LoadUint32(&c.handshake_accepted) == 0
+}
+
 func Address(c *Connection) string {
 	if c.Addr == nil {
 		return ""
@@ -127,45 +148,43 @@ func (c *Connection) exit() {
 }
 
 // add connection to  map
-func Connection_Delete(c *Connection) {
-	connection_map.Range(func(k, value interface{}) bool {
+func (n *node) Connection_Delete(c *Connection) {
+	n.connection_map.Range(func(k, value interface{}) bool {
 		v := value.(*Connection)
 		if c.Addr.String() == v.Addr.String() {
-			connection_map.Delete(Address(v))
+			n.connection_map.Delete(Address(v))
 			return false
 		}
 		return true
 	})
 }
 
-func Connection_Pending_Clear() {
-	connection_map.Range(func(k, value interface{}) bool {
+func (n *node) Connection_Pending_Clear() {
+	n.connection_map.Range(func(k, value interface{}) bool {
 		v := value.(*Connection)
 		if atomic.LoadUint32(&v.State) == HANDSHAKE_PENDING && time.Now().Sub(v.Created) > 10*time.Second { //and skip ourselves
 			v.exit()
 			v.logger.V(3).Info("Cleaning pending connection")
 		}
 
-		if time.Now().Sub(v.update_received).Round(time.Second).Seconds() > 20 {
+		if time.Now().Sub(time.Unix(0, atomic.LoadInt64(&v.update_received))).Round(time.Second).Seconds() > 20 {
 			v.exit()
-			Connection_Delete(v)
+			n.Connection_Delete(v)
 			v.logger.V(1).Info("Purging connection due since idle")
 		}
 
-		if IsAddressInBanList(Address(v)) {
+		if n.IsAddressInBanList(Address(v)) {
 			v.exit()
-			Connection_Delete(v)
+			n.Connection_Delete(v)
 			v.logger.V(1).Info("Purging connection due to ban list")
 		}
 		return true
 	})
 }
 
-var connection_map sync.Map // map[string]*Connection{}
-
 // check whether an IP is in the map already
-func IsAddressConnected(address string) bool {
-	if _, ok := connection_map.Load(strings.TrimSpace(address)); ok {
+func (n *node) IsAddressConnected(address string) bool {
+	if _, ok := n.connection_map.Load(strings.TrimSpace(address)); ok {
 		return true
 	}
 	return false
@@ -175,8 +194,8 @@ func IsAddressConnected(address string) bool {
 // we also check for limits for incoming connections
 // same ip max 8 ip ( considering NAT)
 // same Peer ID   4
-func Connection_Add(c *Connection) bool {
-	if dup, ok := connection_map.LoadOrStore(Address(c), c); !ok {
+func (n *node) Connection_Add(c *Connection) bool {
+	if dup, ok := n.connection_map.LoadOrStore(Address(c), c); !ok {
 		c.Created = time.Now()
 		c.logger.V(3).Info("IP address being added", "ip", c.Addr.String())
 		return true
@@ -191,11 +210,11 @@ func Connection_Add(c *Connection) bool {
 // since 2 nodes may be connected in both directions, we need to deliver new blocks/tx to only one
 // thereby saving NW/computing costs
 // we find duplicates using peer id
-func UniqueConnections() map[uint64]*Connection {
+func (n *node) UniqueConnections() map[uint64]*Connection {
 	unique_map := map[uint64]*Connection{}
-	connection_map.Range(func(k, value interface{}) bool {
+	n.connection_map.Range(func(k, value interface{}) bool {
 		v := value.(*Connection)
-		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && GetPeerID() != v.Peer_ID { //and skip ourselves
+		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && n.GetPeerID() != v.Peer_ID { //and skip ourselves
 			unique_map[v.Peer_ID] = v // map will automatically deduplicate/overwrite previous
 		}
 		return true
@@ -204,10 +223,10 @@ func UniqueConnections() map[uint64]*Connection {
 }
 
 // this function has infinite loop to keep ping every few sec
-func ping_loop() {
-	connection_map.Range(func(k, value interface{}) bool {
+func (n *node) ping_loop() {
+	n.connection_map.Range(func(k, value interface{}) bool {
 		c := value.(*Connection)
-		if atomic.LoadUint32(&c.State) != HANDSHAKE_PENDING && GetPeerID() != c.Peer_ID /*&& atomic.LoadInt32(&c.ping_in_progress) == 0*/ {
+		if atomic.LoadUint32(&c.State) != HANDSHAKE_PENDING && n.GetPeerID() != c.Peer_ID /*&& atomic.LoadInt32(&c.ping_in_progress) == 0*/ {
 			if atomic.LoadInt32(&c.Syncing) >= 1 {
 				return true
 			}
@@ -217,11 +236,11 @@ func ping_loop() {
 				defer atomic.AddInt32(&c.ping_in_progress, -1)
 
 				var request, response Dummy
-				fill_common(&request.Common) // fill common info
+				n.fill_common(&request.Common) // fill common info
 
 				c.ping_count++
 				if c.ping_count%10 == 1 {
-					request.Common.PeerList = get_peer_list_specific(Address(c))
+					request.Common.PeerList = n.get_peer_list_specific(Address(c))
 				}
 
 				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -241,38 +260,42 @@ func ping_loop() {
 
 // prints all the connection info to screen
 func Connection_Print() {
+	default_node.Connection_Print()
+}
+
+func (n *node) Connection_Print() {
 	var clist []*Connection
 
-	connection_map.Range(func(k, value interface{}) bool {
+	n.connection_map.Range(func(k, value interface{}) bool {
 		v := value.(*Connection)
 		clist = append(clist, v)
 		return true
 	})
 
-	version, err := chain.ReadBlockSnapshotVersion(chain.Get_Top_ID())
+	version, err := n.chain.ReadBlockSnapshotVersion(n.chain.Get_Top_ID())
 	if err != nil {
 		panic(err)
 	}
 
-	StateHash, err := chain.Load_Merkle_Hash(version)
+	StateHash, err := n.chain.Load_Merkle_Hash(version)
 
 	if err != nil {
 		panic(err)
 	}
 
-	logger.Info("Connection info for peers", "count", len(clist), "our Statehash", StateHash)
+	n.logger.Info("Connection info for peers", "count", len(clist), "our Statehash", StateHash)
 
-	fmt.Printf("%-30s %-16s %-5s %-7s %-7s %-7s %23s %3s %5s %s %s %16s %16s\n", "Remote Addr", "PEER ID", "PORT", " State", "Latency", "Offset", "S/H/T", "DIR", "QUEUE", "     IN", "    OUT", "Version", "Statehash")
+	fmt.Printf("%-30s %-16s %-16s %-5s %-7s %-7s %-7s %5s %23s %3s %5s %s %s %16s %16s\n", "Remote Addr", "PEER ID", "IDENTITY", "PORT", " State", "Latency", "Offset", "Score", "S/H/T", "DIR", "QUEUE", "     IN", "    OUT", "Version", "Statehash")
 
 	// sort the list
 	sort.Slice(clist, func(i, j int) bool { return clist[i].Addr.String() < clist[j].Addr.String() })
 
-	our_topo_height := chain.Load_TOPO_HEIGHT()
+	our_topo_height := n.chain.Load_TOPO_HEIGHT()
 
 	for i := range clist {
 
 		// skip pending  handshakes and skip ourselves
-		if atomic.LoadUint32(&clist[i].State) == HANDSHAKE_PENDING || GetPeerID() == clist[i].Peer_ID {
+		if atomic.LoadUint32(&clist[i].State) == HANDSHAKE_PENDING || n.GetPeerID() == clist[i].Peer_ID {
 			//	continue
 		}
 
@@ -299,32 +322,49 @@ func Connection_Print() {
 		}
 
 		var color_yellow = "\033[33m"
+		var color_red = "\033[31m"
 		var color_normal = "\033[0m"
 
 		//if our_height is more than
 		if our_topo_height > clist[i].TopoHeight {
 			fmt.Print(color_yellow)
 		}
+		if clist[i].deprioritized() {
+			fmt.Print(color_red)
+		}
 
 		ctime := time.Now().Sub(clist[i].Created).Round(time.Second)
 
+		identity := clist[i].Identity
+		if len(identity) > 16 {
+			identity = identity[:16]
+		}
+
+		clist[i].update_mutex.Lock()
+		state_hash := clist[i].StateHash
+		clist[i].update_mutex.Unlock()
+
 		hstring := fmt.Sprintf("%d/%d/%d", clist[i].StableHeight, clist[i].Height, clist[i].TopoHeight)
-		fmt.Printf("%-30s %16x %5d %7s %7s %7s %23s %s %5d %7s %7s     %16s %s %x\n", Address(clist[i])+" ("+ctime.String()+")", clist[i].Peer_ID, clist[i].Port, state, time.Duration(atomic.LoadInt64(&clist[i].Latency)).Round(time.Millisecond).String(), time.Duration(atomic.LoadInt64(&clist[i].clock_offset)).Round(time.Millisecond).String(), hstring, dir, 0, humanize.Bytes(atomic.LoadUint64(&clist[i].BytesIn)), humanize.Bytes(atomic.LoadUint64(&clist[i].BytesOut)), version, tag, clist[i].StateHash[:])
+		fmt.Printf("%-30s %16x %-16s %5d %7s %7s %7s %5d %23s %s %5d %7s %7s     %16s %s %x\n", Address(clist[i])+" ("+ctime.String()+")", clist[i].Peer_ID, identity, clist[i].Port, state, time.Duration(atomic.LoadInt64(&clist[i].Latency)).Round(time.Millisecond).String(), time.Duration(atomic.LoadInt64(&clist[i].clock_offset)).Round(time.Millisecond).String(), atomic.LoadInt64(&clist[i].Score), hstring, dir, 0, humanize.Bytes(atomic.LoadUint64(&clist[i].BytesIn)), humanize.Bytes(atomic.LoadUint64(&clist[i].BytesOut)), version, tag, state_hash[:])
 
 		fmt.Print(color_normal)
 	}
-	logger.Info("Connection info for peers", "count", len(clist), "our Statehash", StateHash)
+	n.logger.Info("Connection info for peers", "count", len(clist), "our Statehash", StateHash)
 
 }
 
 // for continuos update on command line, get the maximum height of all peers
 // show the average network status
 func Best_Peer_Height() (best_height, best_topo_height int64) {
+	return default_node.Best_Peer_Height()
+}
+
+func (n *node) Best_Peer_Height() (best_height, best_topo_height int64) {
 
 	var heights []uint64
 	var topoheights []uint64
 
-	connection_map.Range(func(k, value interface{}) bool {
+	n.connection_map.Range(func(k, value interface{}) bool {
 		v := value.(*Connection)
 		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING {
 			height := atomic.LoadInt64(&v.Height)
@@ -342,9 +382,13 @@ func Best_Peer_Height() (best_height, best_topo_height int64) {
 
 // this function return peer count which have successful handshake
 func Peer_Count() (Count uint64) {
-	connection_map.Range(func(k, value interface{}) bool {
+	return default_node.Peer_Count()
+}
+
+func (n *node) Peer_Count() (Count uint64) {
+	n.connection_map.Range(func(k, value interface{}) bool {
 		v := value.(*Connection)
-		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && GetPeerID() != v.Peer_ID {
+		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && n.GetPeerID() != v.Peer_ID {
 			Count++
 		}
 		return true
@@ -354,9 +398,13 @@ func Peer_Count() (Count uint64) {
 
 // this returns count of peers in both directions
 func Peer_Direction_Count() (Incoming uint64, Outgoing uint64) {
-	connection_map.Range(func(k, value interface{}) bool {
+	return default_node.Peer_Direction_Count()
+}
+
+func (n *node) Peer_Direction_Count() (Incoming uint64, Outgoing uint64) {
+	n.connection_map.Range(func(k, value interface{}) bool {
 		v := value.(*Connection)
-		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && GetPeerID() != v.Peer_ID {
+		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && n.GetPeerID() != v.Peer_ID {
 			if v.Incoming {
 				Incoming++
 			} else {
@@ -369,18 +417,22 @@ func Peer_Direction_Count() (Incoming uint64, Outgoing uint64) {
 }
 
 func Broadcast_Block(cbl *block.Complete_Block, PeerID uint64) {
-	Broadcast_Block_Coded(cbl, PeerID)
+	default_node.Broadcast_Block(cbl, PeerID)
+}
+
+func (n *node) Broadcast_Block(cbl *block.Complete_Block, PeerID uint64) {
+	n.Broadcast_Block_Coded(cbl, PeerID)
 }
 
 // broad cast a block to all connected peers in cut up in chunks with erasure coding
 // we can only broadcast a block which is in our db
 // this function is trigger from 2 points, one when we receive a unknown block which can be successfully added to chain
 // second from the blockchain which has to relay locally  mined blocks as soon as possible
-func Broadcast_Block_Coded(cbl *block.Complete_Block, PeerID uint64) { // if peerid is provided it is skipped
-	broadcast_Block_Coded(cbl, PeerID, globals.Time().UTC().UnixMicro())
+func (n *node) Broadcast_Block_Coded(cbl *block.Complete_Block, PeerID uint64) { // if peerid is provided it is skipped
+	n.broadcast_Block_Coded(cbl, PeerID, globals.Time().UTC().UnixMicro())
 }
 
-func broadcast_Block_Coded(cbl *block.Complete_Block, PeerID uint64, first_seen int64) {
+func (n *node) broadcast_Block_Coded(cbl *block.Complete_Block, PeerID uint64, first_seen int64) {
 
 	defer globals.Recover(3)
 
@@ -390,15 +442,15 @@ func broadcast_Block_Coded(cbl *block.Complete_Block, PeerID uint64, first_seen
 
 	blid := cbl.Bl.GetHash()
 
-	logger.V(1).Info("Will broadcast block", "blid", blid, "tx_count", len(cbl.Bl.Tx_hashes), "txs", len(cbl.Txs))
+	n.logger.V(1).Info("Will broadcast block", "blid", blid, "tx_count", len(cbl.Bl.Tx_hashes), "txs", len(cbl.Txs))
 
-	hhash, chunk_count := convert_block_to_chunks(cbl, 16, 32)
+	hhash, chunk_count := n.convert_block_to_chunks(cbl, 16, 32)
 
-	our_height := chain.Get_Height()
+	our_height := n.chain.Get_Height()
 	// build the request once and dispatch it to all possible peers
 	tries := 0
 	count := 0
-	unique_map := UniqueConnections()
+	unique_map := n.UniqueConnections()
 
 	var connections []*Connection
 	for _, v := range unique_map {
@@ -406,6 +458,9 @@ func broadcast_Block_Coded(cbl *block.Complete_Block, PeerID uint64, first_seen
 	}
 
 	sort.SliceStable(connections, func(i, j int) bool {
+		if connections[i].deprioritized() != connections[j].deprioritized() {
+			return !connections[i].deprioritized()
+		}
 		return connections[i].Latency < connections[j].Latency
 	})
 
@@ -414,15 +469,20 @@ func broadcast_Block_Coded(cbl *block.Complete_Block, PeerID uint64, first_seen
 		bw_factor = 1
 	}
 
+	// peers supporting compact blocks get the block right away, others are served chunks
+	connections, compact_count := n.broadcast_Compact_Block(cbl, PeerID, first_seen, connections)
+
 	for { // we must send all blocks atleast once, once we are done, break ut
 
 		if len(connections) < 1 {
-			globals.Logger.Error(nil, "we want to broadcast block, but donot have peers, most possibly block will go stale")
+			if compact_count == 0 {
+				globals.Logger.Error(nil, "we want to broadcast block, but donot have peers, most possibly block will go stale")
+			}
 			return
 		}
 		for _, v := range connections {
 			select {
-			case <-Exit_Event:
+			case <-n.exit_event:
 				return
 			default:
 			}
@@ -431,7 +491,7 @@ func broadcast_Block_Coded(cbl *block.Complete_Block, PeerID uint64, first_seen
 				return
 			}
 			tries++
-			if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && PeerID != v.Peer_ID && v.Peer_ID != GetPeerID() { // skip pre-handshake connections
+			if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && PeerID != v.Peer_ID && v.Peer_ID != n.GetPeerID() { // skip pre-handshake connections
 
 				// if the other end is > 2 blocks behind, do not broadcast block to him
 				// this is an optimisation, since if the other end is syncing
@@ -459,7 +519,7 @@ func broadcast_Block_Coded(cbl *block.Complete_Block, PeerID uint64, first_seen
 					peer_specific_list.Chunk_list = append(peer_specific_list.Chunk_list, chunkid)
 					connection.logger.V(3).Info("Sending erasure coded chunk to peer ", "cid", cid)
 					var dummy Dummy
-					fill_common(&peer_specific_list.Common) // fill common info
+					n.fill_common(&peer_specific_list.Common) // fill common info
 					if err := connection.Client.Call("Peer.NotifyINV", peer_specific_list, &dummy); err != nil {
 						return
 					}
@@ -481,23 +541,23 @@ done:
 // we can only broadcast a block which is in our db
 // this function is triggerred from 2 points, one when we receive a unknown block which can be successfully added to chain
 // second from the blockchain which has to relay locally  mined blocks as soon as possible
-func broadcast_Chunk(chunk *Block_Chunk, PeerID uint64, first_seen int64) { // if peerid is provided it is skipped
+func (n *node) broadcast_Chunk(chunk *Block_Chunk, PeerID uint64, first_seen int64) { // if peerid is provided it is skipped
 	defer globals.Recover(3)
 
-	our_height := chain.Get_Height()
+	our_height := n.chain.Get_Height()
 
 	count := 0
-	unique_map := UniqueConnections()
+	unique_map := n.UniqueConnections()
 
 	hhash := chunk.HHash
 
 	for _, v := range unique_map {
 		select {
-		case <-Exit_Event:
+		case <-n.exit_event:
 			return
 		default:
 		}
-		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && PeerID != v.Peer_ID && v.Peer_ID != GetPeerID() { // skip pre-handshake connections
+		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && PeerID != v.Peer_ID && v.Peer_ID != n.GetPeerID() { // skip pre-handshake connections
 
 			// if the other end is > 50 blocks behind, do not broadcast block to hime
 			// this is an optimisation, since if the other end is syncing
@@ -521,9 +581,9 @@ func broadcast_Chunk(chunk *Block_Chunk, PeerID uint64, first_seen int64) { // i
 				peer_specific_list.Sent = first_seen
 
 				peer_specific_list.Chunk_list = append(peer_specific_list.Chunk_list, chunkid)
-				connection.logger.V(3).Info("Sending erasure coded chunk INV to peer ", "raw", fmt.Sprintf("%x", chunkid), "blid", fmt.Sprintf("%x", chunk.BLID), "cid", chunk.CHUNK_ID, "hhash", fmt.Sprintf("%x", hhash), "exists", nil != is_chunk_exist(hhash, uint8(chunk.CHUNK_ID)))
+				connection.logger.V(3).Info("Sending erasure coded chunk INV to peer ", "raw", fmt.Sprintf("%x", chunkid), "blid", fmt.Sprintf("%x", chunk.BLID), "cid", chunk.CHUNK_ID, "hhash", fmt.Sprintf("%x", hhash), "exists", nil != n.is_chunk_exist(hhash, uint8(chunk.CHUNK_ID)))
 				var dummy Dummy
-				fill_common(&peer_specific_list.Common) // fill common info
+				n.fill_common(&peer_specific_list.Common) // fill common info
 				if err := connection.Client.Call("Peer.NotifyINV", peer_specific_list, &dummy); err != nil {
 					return
 				}
@@ -539,31 +599,35 @@ func broadcast_Chunk(chunk *Block_Chunk, PeerID uint64, first_seen int64) { // i
 // this function is trigger from 2 points, one when we receive a unknown block which can be successfully added to chain
 // second from the blockchain which has to relay locally  mined blocks as soon as possible
 func Broadcast_MiniBlock(mbl block.MiniBlock, PeerID uint64) { // if peerid is provided it is skipped
-	broadcast_MiniBlock(mbl, PeerID, globals.Time().UTC().UnixMicro())
+	default_node.Broadcast_MiniBlock(mbl, PeerID)
 }
-func broadcast_MiniBlock(mbl block.MiniBlock, PeerID uint64, first_seen int64) { // if peerid is provided it is skipped
+
+func (n *node) Broadcast_MiniBlock(mbl block.MiniBlock, PeerID uint64) { // if peerid is provided it is skipped
+	n.broadcast_MiniBlock(mbl, PeerID, globals.Time().UTC().UnixMicro())
+}
+func (n *node) broadcast_MiniBlock(mbl block.MiniBlock, PeerID uint64, first_seen int64) { // if peerid is provided it is skipped
 
 	defer globals.Recover(3)
 
 	var peer_specific_block Objects
 	peer_specific_block.MiniBlocks = append(peer_specific_block.MiniBlocks, mbl.Serialize())
-	fill_common(&peer_specific_block.Common) // fill common info
+	n.fill_common(&peer_specific_block.Common) // fill common info
 	peer_specific_block.Sent = first_seen
 
-	our_height := chain.Get_Height()
+	our_height := n.chain.Get_Height()
 	// build the request once and dispatch it to all possible peers
 	count := 0
-	unique_map := UniqueConnections()
+	unique_map := n.UniqueConnections()
 
 	//connection.logger.V(4).Info("Sending mini block to peer ")
 
 	for _, v := range unique_map {
 		select {
-		case <-Exit_Event:
+		case <-n.exit_event:
 			return
 		default:
 		}
-		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && PeerID != v.Peer_ID && v.Peer_ID != GetPeerID() { // skip pre-handshake connections
+		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && PeerID != v.Peer_ID && v.Peer_ID != n.GetPeerID() { // skip pre-handshake connections
 
 			// if the other end is > 50 blocks behind, do not broadcast block to hime
 			// this is an optimisation, since if the other end is syncing
@@ -595,30 +659,36 @@ func broadcast_MiniBlock(mbl block.MiniBlock, PeerID uint64, first_seen int64) {
 // second from the mempool which may want to relay local ot soon going to expire transactions
 
 func Broadcast_Tx(tx *transaction.Transaction, PeerID uint64) (relayed_count int32) {
-	return broadcast_Tx(tx, PeerID, globals.Time().UTC().UnixMicro())
+	return default_node.Broadcast_Tx(tx, PeerID)
+}
+
+func (n *node) Broadcast_Tx(tx *transaction.Transaction, PeerID uint64) (relayed_count int32) {
+	return n.broadcast_Tx(tx, PeerID, globals.Time().UTC().UnixMicro())
 
 }
-func broadcast_Tx(tx *transaction.Transaction, PeerID uint64, sent int64) (relayed_count int32) {
+func (n *node) broadcast_Tx(tx *transaction.Transaction, PeerID uint64, sent int64) (relayed_count int32) {
 	defer globals.Recover(3)
 
 	var request ObjectList
-	fill_common_skip_topoheight(&request.Common) // fill common info, but skip topo height
+	n.fill_common_skip_topoheight(&request.Common) // fill common info, but skip topo height
 	txhash := tx.GetHash()
 
 	request.Tx_list = append(request.Tx_list, txhash)
 	request.Sent = sent
 
-	our_height := chain.Get_Height()
+	our_height := n.chain.Get_Height()
+
+	unique_map := n.UniqueConnections()
 
-	unique_map := UniqueConnections()
+	var relayed int32 // senders keep counting after we return, so result is a snapshot
 
 	for _, v := range unique_map {
 		select {
-		case <-Exit_Event:
-			return
+		case <-n.exit_event:
+			return atomic.LoadInt32(&relayed)
 		default:
 		}
-		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && PeerID != v.Peer_ID && v.Peer_ID != GetPeerID() { // skip pre-handshake connections
+		if atomic.LoadUint32(&v.State) != HANDSHAKE_PENDING && PeerID != v.Peer_ID && v.Peer_ID != n.GetPeerID() { // skip pre-handshake connections
 
 			// if the other end is > 50 blocks behind, do not broadcast block to hime
 			// this is an optimisation, since if the other end is syncing
@@ -638,17 +708,18 @@ func broadcast_Tx(tx *transaction.Transaction, PeerID uint64, sent int64) (relay
 				}()
 
 				var dummy Dummy
-				fill_common(&dummy.Common) // fill common info
+				n.fill_common(&dummy.Common) // fill common info
 				if err := connection.Client.Call("Peer.NotifyINV", request, &dummy); err != nil {
 					return
 				}
 				connection.update(&dummy.Common) // update common information
-				atomic.AddInt32(&relayed_count, 1)
+				atomic.AddInt32(&relayed, 1)
 
 			}(v)
 		}
 
 	}
+	relayed_count = atomic.LoadInt32(&relayed)
 	if relayed_count > 0 {
 		//rlog.Debugf("Broadcasted tx %s to %d peers", txhash, relayed_count)
 	}
@@ -656,10 +727,10 @@ func broadcast_Tx(tx *transaction.Transaction, PeerID uint64, sent int64) (relay
 }
 
 // trigger a sync with a random peer
-func trigger_sync() {
+func (n *node) trigger_sync() {
 	defer globals.Recover(3)
 
-	unique_map := UniqueConnections()
+	unique_map := n.UniqueConnections()
 
 	var clist []*Connection
 
@@ -673,9 +744,12 @@ func trigger_sync() {
 		clist[i], clist[j] = clist[j], clist[i]
 	})
 
+	// peers with low score are only used for syncing if nobody else can be used
+	sort.SliceStable(clist, func(i, j int) bool { return !clist[i].deprioritized() && clist[j].deprioritized() })
+
 	for _, connection := range clist {
 
-		height := chain.Get_Height()
+		height := n.chain.Get_Height()
 
 		//connection.Lock()   recursive mutex are not suported
 		// only choose highest available peers for syncing
@@ -689,13 +763,13 @@ func trigger_sync() {
 			// islagging := true
 			//connection.Unlock()
 			if islagging {
-				if connection.Pruned > chain.Load_Block_Topological_order(chain.Get_Top_ID()) && chain.Get_Height() != 0 {
+				if connection.Pruned > n.chain.Load_Block_Topological_order(n.chain.Get_Top_ID()) && n.chain.Get_Height() != 0 {
 					connection.logger.V(1).Info("We cannot resync with the peer, since peer chain is pruned", "height", connection.Height, "pruned", connection.Pruned)
 					continue
 				}
 
 				time.Sleep(time.Second)
-				height := chain.Get_Height()
+				height := n.chain.Get_Height()
 				islagging = (height < atomic.LoadInt64(&connection.Height) || (connection.SyncNode && height > (atomic.LoadInt64(&connection.Height)+2)))
 
 				if islagging {
@@ -708,14 +782,13 @@ func trigger_sync() {
 
 					metrics.Set.GetOrCreateCounter("blockchain_sync_total").Inc() // tracks number of syncs
 
-					if chain.Sync {
+					if n.chain.Sync {
 						//fmt.Printf("chain send chain request disabled\n")
 						connection.sync_chain()
 						connection.logger.V(1).Info("sync done")
 
 					} else { // we need a state only sync, bootstrap without history but verified chain
-						connection.bootstrap_chain()
-						chain.Sync = true
+						connection.bootstrap_chain() // on failure, bootstrap is resumed during next sync
 					}
 					break
 				}
@@ -737,23 +810,21 @@ func Abs(n int64) int64 {
 // detect whether we are behind any of the connected peers and trigger sync ASAP
 // randomly with one of the peers
 
-var single_sync int32
-
-func syncroniser() {
+func (n *node) syncroniser() {
 
-	defer atomic.AddInt32(&single_sync, -1)
+	defer atomic.AddInt32(&n.single_sync, -1)
 
-	if atomic.AddInt32(&single_sync, 1) != 1 {
+	if atomic.AddInt32(&n.single_sync, 1) != 1 {
 		return
 	}
-	calculate_network_time() // calculate time every sec
-	trigger_sync()           // check whether we are out of sync
+	n.calculate_network_time() // calculate time every sec
+	n.trigger_sync()           // check whether we are out of sync
 }
 
 // update P2P time
-func calculate_network_time() {
+func (n *node) calculate_network_time() {
 	var total, count, mean int64
-	unique_map := UniqueConnections()
+	unique_map := n.UniqueConnections()
 
 	for _, v := range unique_map {
 		if Abs(atomic.LoadInt64(&v.clock_offset)) < 100*1000000000 { //  this is 100 sec
@@ -797,5 +868,5 @@ func calculate_network_time() {
 		return
 	}
 
-	globals.ClockOffsetP2P = time.Duration(total / count)
+	atomic.StoreInt64((*int64)(&globals.ClockOffsetP2P), total/count)
 }
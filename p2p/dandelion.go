@@ -0,0 +1,251 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file implements Dandelion++ relay of txs, see https://arxiv.org/abs/1805.11060
+ * a tx starts in stem phase, where it is passed to a single peer along a route which changes every epoch
+ * at every hop it may enter fluff phase, where it is announced to everyone using the usual INV
+ * stem txs are hidden within the mempool, they are neither listed, served to peers nor mined
+ * if a stem tx is not seen in fluff phase before its embargo expires, it is fluffed by the node holding it
+ */
+import "fmt"
+import "sync"
+import "time"
+import "sync/atomic"
+
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/metrics"
+import "github.com/deroproject/derohe/transaction"
+
+const FLAG_DANDELION = "dandelion" // handshake flag, peer understands Peer.NotifyStemTx
+
+const DANDELION_EPOCH = 10 * time.Minute // routes and fluff mode are reselected every epoch
+const DANDELION_FLUFF_PERCENT = 10       // chance that a node fluffs every stem tx it receives during an epoch
+const DANDELION_STEM_PEERS = 2           // outgoing peers which receive stem txs during an epoch
+
+var dandelion_embargo = 30 * time.Second // stem txs are fluffed after embargo plus random jitter of upto embargo
+
+type dandelion_state struct {
+	sync.Mutex
+	epoch      time.Time
+	fluff      bool                   // every received stem tx is fluffed during this epoch
+	successors []*Connection          // stem peers for this epoch
+	routes     map[uint64]*Connection // incoming peer id to stem peer, so every peer is routed the same way
+	local      *Connection            // stem peer for our own txs
+}
+
+func (n *node) dandelion_enabled() bool {
+	return has_extension(n.flags, FLAG_DANDELION)
+}
+
+// whether connection is still part of the pool
+func (n *node) connected(c *Connection) bool {
+	v, ok := n.connection_map.Load(Address(c))
+	return ok && v.(*Connection) == c && atomic.LoadUint32(&c.State) == ACTIVE
+}
+
+// select stem peers and fluff mode for a new epoch, lock must be held
+func (n *node) dandelion_new_epoch() {
+	d := &n.dandelion
+	d.epoch = time.Now()
+	d.fluff = globals.Global_Random.Intn(100) < DANDELION_FLUFF_PERCENT
+	d.routes = map[uint64]*Connection{}
+	d.local = nil
+	d.successors = d.successors[:0]
+
+	var candidates []*Connection
+	n.connection_map.Range(func(k, value interface{}) bool {
+		if c := value.(*Connection); c.dandelion && !c.Incoming && c.Peer_ID != n.GetPeerID() && atomic.LoadUint32(&c.State) == ACTIVE {
+			candidates = append(candidates, c)
+		}
+		return true
+	})
+	globals.Global_Random.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > DANDELION_STEM_PEERS {
+		candidates = candidates[:DANDELION_STEM_PEERS]
+	}
+	d.successors = append(d.successors, candidates...)
+	n.logger.V(2).Info("New dandelion epoch", "fluff", d.fluff, "stem_peers", len(d.successors))
+}
+
+// find the stem peer for a tx received from a peer, or our own tx if from is nil
+// returns nil if the tx must be fluffed
+func (n *node) dandelion_route(from *Connection) *Connection {
+	d := &n.dandelion
+	d.Lock()
+	defer d.Unlock()
+
+	renew := time.Since(d.epoch) > DANDELION_EPOCH || len(d.successors) == 0
+	for _, c := range d.successors {
+		renew = renew || !n.connected(c)
+	}
+	if renew {
+		n.dandelion_new_epoch()
+	}
+
+	pick := func(exclude uint64) *Connection {
+		var list []*Connection
+		for _, c := range d.successors {
+			if c.Peer_ID != exclude {
+				list = append(list, c)
+			}
+		}
+		if len(list) == 0 {
+			return nil
+		}
+		return list[globals.Global_Random.Intn(len(list))]
+	}
+
+	if from == nil { // our own txs are always stemmed
+		if d.local == nil {
+			d.local = pick(0)
+		}
+		return d.local
+	}
+	if d.fluff {
+		return nil
+	}
+	if _, ok := d.routes[from.Peer_ID]; !ok {
+		d.routes[from.Peer_ID] = pick(from.Peer_ID)
+	}
+	return d.routes[from.Peer_ID]
+}
+
+// adds a tx created locally, such as by rpc, to mempool and relays it, txs enter stem phase if possible
+func Relay_Local_Tx(tx *transaction.Transaction) error {
+	return default_node.relay_local_tx(tx)
+}
+
+func (n *node) relay_local_tx(tx *transaction.Transaction) error {
+	stem := n.stem_phase(tx)
+	if err := n.add_tx_to_pool(tx, stem); err != nil {
+		return err
+	}
+	if stem {
+		n.stem_tx(tx, nil)
+	} else {
+		n.Broadcast_Tx(tx, 0)
+	}
+	return nil
+}
+
+// registrations live in regpool, which has no stem phase
+func (n *node) stem_phase(tx *transaction.Transaction) bool {
+	return !tx.IsRegistration() && n.dandelion_enabled()
+}
+
+// stem txs are added to mempool already in stem phase, so they are never listed, served or mined before fluff
+func (n *node) add_tx_to_pool(tx *transaction.Transaction, stem bool) error {
+	if stem {
+		return n.chain.Add_TX_To_Pool_Stem(tx)
+	}
+	return n.chain.Add_TX_To_Pool(tx)
+}
+
+// pass a stem tx to the next hop, tx must already be marked as stem in mempool
+func (n *node) stem_tx(tx *transaction.Transaction, from *Connection) {
+	successor := n.dandelion_route(from)
+	if successor == nil {
+		n.fluff_tx(tx, 0)
+		return
+	}
+
+	n.embargo_tx(tx)
+	metrics.Set.GetOrCreateCounter("dandelion_stem_relayed_total").Inc()
+
+	var request Objects
+	n.fill_common(&request.Common)
+	request.Txs = append(request.Txs, tx.Serialize())
+	go func() {
+		defer globals.Recover(3)
+		var dummy Dummy
+		if err := successor.Client.Call("Peer.NotifyStemTx", request, &dummy); err != nil {
+			successor.logger.V(2).Error(err, "stem tx could not be relayed, fluffing", "txid", tx.GetHash())
+			n.fluff_tx(tx, 0)
+			return
+		}
+		successor.update(&dummy.Common) // update common information
+	}()
+}
+
+// tx enters fluff phase and is announced to everyone, nothing is done if tx is not in stem phase
+func (n *node) fluff_tx(tx *transaction.Transaction, PeerID uint64) {
+	if n.chain.Mempool.Mempool_Fluff(tx.GetHash()) {
+		metrics.Set.GetOrCreateCounter("dandelion_fluffed_total").Inc()
+		n.broadcast_Tx(tx, PeerID, globals.Time().UTC().UnixMicro())
+	}
+}
+
+// fluff the tx ourselves if it is still in stem phase after embargo, this protects against black holes
+func (n *node) embargo_tx(tx *transaction.Transaction) {
+	embargo := dandelion_embargo + time.Duration(globals.Global_Random.Int63n(int64(dandelion_embargo)))
+	time.AfterFunc(embargo, func() {
+		select {
+		case <-n.exit_event:
+			return
+		default:
+		}
+		if n.chain.Mempool.Mempool_Is_Stem(tx.GetHash()) {
+			metrics.Set.GetOrCreateCounter("dandelion_embargo_expired_total").Inc()
+			n.fluff_tx(tx, 0)
+		}
+	})
+}
+
+// peer passes us a tx in stem phase
+func (c *Connection) NotifyStemTx(request Objects, response *Dummy) (err error) {
+	defer handle_connection_panic(c)
+	chain := c.node.chain
+
+	c.update(&request.Common)            // update common information
+	c.node.fill_common(&response.Common) // fill common info
+
+	if len(request.Txs) != 1 || len(request.CBlocks) != 0 || len(request.MiniBlocks) != 0 || len(request.Chunks) != 0 {
+		err = fmt.Errorf("Notify Stem TX must carry a single tx")
+		c.logger.V(3).Error(err, "Should be banned")
+		c.score(score_protocol_violation)
+		c.exit()
+		return err
+	}
+
+	var tx transaction.Transaction
+	if err = tx.Deserialize(request.Txs[0]); err != nil {
+		c.logger.V(2).Error(err, "Incoming stem TX could not be deserilised")
+		c.score(score_invalid_tx)
+		c.exit()
+		return err
+	}
+
+	if chain.Mempool.Mempool_TX_Exist(tx.GetHash()) || chain.Regpool.Regpool_TX_Exist(tx.GetHash()) { // stem looped back, embargo is already running
+		return nil
+	}
+	stem := c.node.stem_phase(&tx)
+	if c.node.add_tx_to_pool(&tx, stem) != nil { // currently we are ignoring error
+		c.score(score_rejected_tx)
+		return nil
+	}
+	c.score(score_useful_relay)
+
+	if !stem {
+		c.node.broadcast_Tx(&tx, 0, globals.Time().UTC().UnixMicro())
+		return nil
+	}
+	c.node.stem_tx(&tx, c)
+	return nil
+}
@@ -0,0 +1,607 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file implements verified and resumable fast sync of state trees
+ * blocks do not commit to state, so the root of every tree is established by a quorum of peers at the bootstrap topoheight
+ * trees are split into sections (prefixes of the key hash), which are fetched from all agreeing peers in parallel
+ * the graviton subtree hash of every section is recomputed locally and the sections are folded back into the tree root
+ * a tree is committed only if the folded root matches, otherwise sections are refetched from other peers and the culprits scored
+ * every received section is persisted in the data directory, so an interrupted bootstrap resumes instead of starting over
+ */
+import "os"
+import "fmt"
+import "sync"
+import "time"
+import "bytes"
+import "errors"
+import "math/bits"
+import "sync/atomic"
+import "path/filepath"
+import "encoding/json"
+import "encoding/binary"
+
+import "github.com/go-logr/logr"
+import "github.com/fxamacker/cbor/v2"
+
+import "github.com/deroproject/graviton"
+
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+const fastsync_chunk_size = 640     // expected keys per section
+const fastsync_max_section = 10000  // peers truncate sections larger than this
+const fastsync_max_peers = 8        // at most this many peers are used in parallel
+const fastsync_root_retries = 3     // rounds of refetching, if the folded root does not match
+const fastsync_probe_length = 255   // section length used to query tree roots, such deep sections are practically empty
+const fastsync_max_path_length = 20 // at most 2^20 sections are used, whatever key count peers report
+
+// graviton node tags, see graviton/node.go
+const graviton_inner_node = 1
+const graviton_leaf_node = 2
+
+// hash of empty subtrees in graviton
+var graviton_zero_hash = graviton.Sum(append([]byte{graviton_leaf_node}, make([]byte, graviton.HASHSIZE)...))
+
+// digest of a subtree, count saturates at 2 since only empty and single leaf subtrees are treated specially
+type tree_digest struct {
+	count int
+	hash  [32]byte
+}
+
+var empty_digest = tree_digest{hash: graviton_zero_hash}
+
+func leaf_digest(key, value []byte) tree_digest {
+	keyhash, valuehash := graviton.Sum(key), graviton.Sum(value)
+	var buf bytes.Buffer
+	buf.WriteByte(graviton_leaf_node)
+	buf.Write(keyhash[:])
+	buf.Write(valuehash[:])
+	return tree_digest{count: 1, hash: graviton.Sum(buf.Bytes())}
+}
+
+func inner_hash(left, right [32]byte) [32]byte {
+	var buf [1 + 2*32]byte
+	buf[0] = graviton_inner_node
+	copy(buf[1:], left[:])
+	copy(buf[1+32:], right[:])
+	return graviton.Sum(buf[:])
+}
+
+// combines 2 sibling subtrees below the root, graviton hoists single leafs as high as possible but never inner nodes
+func combine_digest(left, right tree_digest) tree_digest {
+	switch {
+	case left.count == 0 && right.count <= 1:
+		return right
+	case right.count == 0 && left.count <= 1:
+		return left
+	}
+	return tree_digest{count: 2, hash: inner_hash(left.hash, right.hash)}
+}
+
+func is_bit_set(keyhash []byte, index uint) bool {
+	return keyhash[index/8]&(1<<(7-index%8)) != 0
+}
+
+// calculates digest of the subtree at depth, all keyhashes must share the first depth bits
+func subtree_digest(keyhashes [][32]byte, leafs []tree_digest, depth uint) tree_digest {
+	switch len(leafs) {
+	case 0:
+		return empty_digest
+	case 1:
+		return leafs[0]
+	}
+	var lkeys, rkeys [][32]byte
+	var lleafs, rleafs []tree_digest
+	for i := range keyhashes {
+		if is_bit_set(keyhashes[i][:], depth) {
+			rkeys, rleafs = append(rkeys, keyhashes[i]), append(rleafs, leafs[i])
+		} else {
+			lkeys, lleafs = append(lkeys, keyhashes[i]), append(lleafs, leafs[i])
+		}
+	}
+	return combine_digest(subtree_digest(lkeys, lleafs, depth+1), subtree_digest(rkeys, rleafs, depth+1))
+}
+
+// folds section digests back into tree root, section index i covers the path whose bit d is bit d of i
+func fold_sections(digests []tree_digest, path_length uint) [32]byte {
+	var fold func(prefix uint64, depth uint) tree_digest
+	fold = func(prefix uint64, depth uint) tree_digest {
+		if depth == path_length {
+			return digests[prefix]
+		}
+		return combine_digest(fold(prefix, depth+1), fold(prefix|1<<depth, depth+1))
+	}
+	if path_length == 0 {
+		return digests[0].hash
+	}
+	left, right := fold(0, 1), fold(1, 1)
+	return inner_hash(left.hash, right.hash) // root is always an inner node
+}
+
+// section path as requested on the wire
+func section_path(index uint64) []byte {
+	var section [8]byte
+	binary.BigEndian.PutUint64(section[:], bits.Reverse64(index)) // place reverse path
+	return section[:]
+}
+
+// number of sections for the estimated key count, always a power of 2
+func section_layout(keycount int64) (chunks uint64, path_length uint) {
+	chunks = 1
+	for int64(chunks) < keycount/fastsync_chunk_size && path_length < fastsync_max_path_length {
+		chunks *= 2
+		path_length++
+	}
+	if chunks < 2 {
+		chunks, path_length = 2, 1
+	}
+	return
+}
+
+// a section as received and persisted
+type tree_section struct {
+	Keys   [][]byte `cbor:"KEYS,omitempty"`
+	Values [][]byte `cbor:"VALUES,omitempty"`
+}
+
+// checks that the section only contains keys of its path and calculates its digest
+func (s *tree_section) verify(index uint64, path_length uint) (tree_digest, error) {
+	if len(s.Keys) != len(s.Values) {
+		return empty_digest, fmt.Errorf("key count %d value count %d", len(s.Keys), len(s.Values))
+	}
+	if len(s.Keys) > fastsync_max_section {
+		return empty_digest, fmt.Errorf("section truncated at %d keys", len(s.Keys))
+	}
+	keyhashes := make([][32]byte, len(s.Keys))
+	leafs := make([]tree_digest, len(s.Keys))
+	seen := map[[32]byte]bool{}
+	for i := range s.Keys {
+		keyhashes[i] = graviton.Sum(s.Keys[i])
+		if seen[keyhashes[i]] {
+			return empty_digest, fmt.Errorf("duplicate key %x", s.Keys[i])
+		}
+		seen[keyhashes[i]] = true
+		for d := uint(0); d < path_length; d++ {
+			if is_bit_set(keyhashes[i][:], d) != (index>>d&1 == 1) {
+				return empty_digest, fmt.Errorf("key %x outside section %d", s.Keys[i], index)
+			}
+		}
+		leafs[i] = leaf_digest(s.Keys[i], s.Values[i])
+	}
+	return subtree_digest(keyhashes, leafs, path_length), nil
+}
+
+var errFastsyncRoot = errors.New("state root could not be agreed upon")
+
+// a tree being synced
+type tree_sync struct {
+	name        []byte
+	root        [32]byte
+	path_length uint
+	dir         string        // persisted sections
+	peers       []*Connection // peers which agree on the root
+	digests     []tree_digest
+	have        []bool
+	source      []*Connection // peer which served the section, nil if loaded from disk
+}
+
+type fastsync_state struct {
+	Topo int64 `json:"topo"` // topoheight whose state is being fetched
+}
+
+// fastsync carries state of a single bootstrap attempt
+type fastsync struct {
+	node   *node
+	topo   int64
+	dir    string
+	peers  []*Connection
+	logger logr.Logger
+}
+
+func (n *node) fastsync_dir() string {
+	return filepath.Join(n.data_dir, "fastsync")
+}
+
+// loads topoheight of an interrupted bootstrap, 0 if there is none
+func (n *node) load_fastsync_state() int64 {
+	var state fastsync_state
+	data, err := os.ReadFile(filepath.Join(n.fastsync_dir(), "fastsync.json"))
+	if err != nil {
+		return 0
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		n.logger.Error(err, "Error unmarshalling fastsync state")
+		return 0
+	}
+	return state.Topo
+}
+
+// starts fresh bootstrap at topo, discarding any previous progress
+func (n *node) new_fastsync(topo int64, l logr.Logger) (*fastsync, error) {
+	f := &fastsync{node: n, topo: topo, dir: n.fastsync_dir(), logger: l}
+	if n.load_fastsync_state() == topo {
+		f.logger.Info("Resuming interrupted bootstrap", "topoheight", topo)
+		return f, nil
+	}
+	f.discard()
+	if err := os.MkdirAll(f.dir, 0750); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(fastsync_state{Topo: topo})
+	if err != nil {
+		return nil, err
+	}
+	return f, os.WriteFile(filepath.Join(f.dir, "fastsync.json"), data, 0640)
+}
+
+// same bootstrap attempt at another topoheight, it shares peers and persisted progress
+func (f *fastsync) at(topo int64) *fastsync {
+	return &fastsync{node: f.node, topo: topo, dir: f.dir, peers: f.peers, logger: f.logger}
+}
+
+// removes all persisted progress
+func (f *fastsync) discard() {
+	if err := os.RemoveAll(f.dir); err != nil {
+		f.logger.Error(err, "Error removing fastsync data", "dir", f.dir)
+	}
+}
+
+// all active peers which can serve state at our topoheight, well behaved peers first
+func (f *fastsync) find_peers(primary *Connection) {
+	f.peers = append(f.peers[:0], primary)
+	var deprioritized []*Connection
+	for _, c := range f.node.UniqueConnections() {
+		if c == primary || atomic.LoadUint32(&c.State) != ACTIVE || atomic.LoadInt64(&c.TopoHeight) <= f.topo+max_request_topoheights {
+			continue
+		}
+		if atomic.LoadInt64(&c.Pruned) >= f.topo {
+			continue
+		}
+		if c.deprioritized() {
+			deprioritized = append(deprioritized, c)
+		} else {
+			f.peers = append(f.peers, c)
+		}
+	}
+	f.peers = append(f.peers, deprioritized...)
+	if len(f.peers) > fastsync_max_peers {
+		f.peers = f.peers[:fastsync_max_peers]
+	}
+}
+
+type root_vote struct {
+	c        *Connection
+	root     [32]byte
+	keycount int64
+}
+
+// every peer reports root of the tree in the response to a section request
+func (f *fastsync) query_roots(treename []byte, peers []*Connection) (votes []root_vote) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for _, c := range peers {
+		wg.Add(1)
+		go func(c *Connection) {
+			defer wg.Done()
+			request := Request_Tree_Section_Struct{Topo: f.topo, TreeName: treename, Section: make([]byte, 32), SectionLength: fastsync_probe_length}
+			var response Response_Tree_Section_Struct
+			f.node.fill_common(&request.Common)
+			if err := c.Client.Call("Peer.TreeSection", request, &response); err != nil {
+				f.logger.V(1).Error(err, "Call failed TreeSection", "peer", Address(c))
+				return
+			}
+			if response.StateHash == [32]byte{} { // peer does not support verified fast sync
+				return
+			}
+			mutex.Lock()
+			votes = append(votes, root_vote{c: c, root: response.StateHash, keycount: response.KeyCount})
+			mutex.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	return
+}
+
+// establishes tree root, if expected is provided, it is used as is, otherwise majority of responding peers must agree
+// atleast 2 peers must agree, unless --fastsync-single-peer is used
+func (f *fastsync) agree_root(treename []byte, expected [32]byte, peers []*Connection) (t *tree_sync, keycount int64, err error) {
+	votes := f.query_roots(treename, peers)
+
+	root, count := expected, 0
+	if root == ([32]byte{}) {
+		tally := map[[32]byte]int{}
+		for _, v := range votes {
+			tally[v.root]++
+			if tally[v.root] > count {
+				root, count = v.root, tally[v.root]
+			}
+		}
+		if count == 0 || count*2 <= len(votes) {
+			return nil, 0, fmt.Errorf("%w: tree %x votes %d agreeing %d", errFastsyncRoot, treename, len(votes), count)
+		}
+		if single_peer, _ := f.node.arguments["--fastsync-single-peer"].(bool); count < 2 && !single_peer {
+			return nil, 0, fmt.Errorf("%w: tree %x root is confirmed by a single peer only, use --fastsync-single-peer to trust it", errFastsyncRoot, treename)
+		}
+	}
+
+	t = &tree_sync{name: treename, root: root}
+	for _, v := range votes {
+		if v.root == root {
+			t.peers = append(t.peers, v.c)
+		}
+	}
+	if len(t.peers) == 0 {
+		return nil, 0, fmt.Errorf("%w: no peer serves tree %x with root %x", errFastsyncRoot, treename, root)
+	}
+	if len(t.peers) == 1 {
+		f.logger.Info("State root confirmed by a single peer only", "tree", fmt.Sprintf("%x", treename), "root", fmt.Sprintf("%x", root))
+	}
+	return t, agreed_keycount(votes, root), nil
+}
+
+// state hash at our topoheight agreed by peers, it is xor of balance and meta tree roots
+func (f *fastsync) agree_state(peers []*Connection) (state crypto.Hash, err error) {
+	var balance, meta *tree_sync
+	if balance, _, err = f.agree_root([]byte(config.BALANCE_TREE), [32]byte{}, peers); err != nil {
+		return
+	}
+	if meta, _, err = f.agree_root([]byte(config.SC_META), [32]byte{}, balance.peers); err != nil {
+		return
+	}
+	for i := range state {
+		state[i] = balance.root[i] ^ meta.root[i]
+	}
+	return
+}
+
+// median of key counts reported by peers agreeing on the root, so a single peer cannot inflate it
+func agreed_keycount(votes []root_vote, root [32]byte) int64 {
+	var counts []uint64
+	for _, v := range votes {
+		if v.root != root {
+			continue
+		}
+		switch {
+		case v.keycount < 0:
+			counts = append(counts, 0)
+		case v.keycount > fastsync_chunk_size<<fastsync_max_path_length: // more sections are never used
+			counts = append(counts, fastsync_chunk_size<<fastsync_max_path_length)
+		default:
+			counts = append(counts, uint64(v.keycount))
+		}
+	}
+	return int64(Median(counts))
+}
+
+// fetches all sections of the tree and verifies them against the root
+func (f *fastsync) fetch_tree(t *tree_sync, keycount int64, progress string) (err error) {
+	chunks, path_length := section_layout(keycount)
+	t.path_length = path_length
+	t.digests = make([]tree_digest, chunks)
+	t.have = make([]bool, chunks)
+	t.source = make([]*Connection, chunks)
+	t.dir = filepath.Join(f.dir, fmt.Sprintf("%x_%d_%x", t.name, path_length, t.root[:8]))
+	if err = os.MkdirAll(t.dir, 0750); err != nil {
+		return
+	}
+
+	var pending []uint64
+	for i := uint64(0); i < chunks; i++ {
+		if section, err := t.load_section(i); err == nil {
+			if t.digests[i], err = section.verify(i, path_length); err == nil {
+				t.have[i] = true
+				continue
+			}
+		}
+		pending = append(pending, i)
+	}
+	if resumed := int(chunks) - len(pending); resumed > 0 {
+		f.logger.V(1).Info("Resumed tree sections from disk", "tree", fmt.Sprintf("%x", t.name), "sections", resumed, "total", chunks)
+	}
+
+	if err = f.fetch_sections(t, pending, nil, progress); err != nil {
+		return
+	}
+
+	for round := 0; ; round++ {
+		if fold_sections(t.digests, path_length) == t.root {
+			return nil
+		}
+		if round >= fastsync_root_retries {
+			return fmt.Errorf("tree %x does not match root %x after %d retries", t.name, t.root, round)
+		}
+		f.logger.Info("Tree does not match state root, refetching sections from other peers", "tree", fmt.Sprintf("%x", t.name), "round", round+1)
+
+		// refetch everything from different peers and find the sections which differ
+		old_digests, old_source := append([]tree_digest{}, t.digests...), append([]*Connection{}, t.source...)
+		avoid := map[uint64]*Connection{}
+		var all []uint64
+		for i := uint64(0); i < chunks; i++ {
+			all = append(all, i)
+			avoid[i] = t.source[i]
+		}
+		if err = f.fetch_sections(t, all, avoid, progress); err != nil {
+			return
+		}
+		if fold_sections(t.digests, path_length) == t.root {
+			for i := range t.digests {
+				if t.digests[i] != old_digests[i] && old_source[i] != nil {
+					old_source[i].logger.V(1).Info("Peer served tree section not matching state root", "tree", fmt.Sprintf("%x", t.name), "section", i)
+					old_source[i].score(score_bad_chunk)
+				}
+			}
+		}
+	}
+}
+
+type section_result struct {
+	index   uint64
+	c       *Connection
+	section tree_section
+	digest  tree_digest
+	err     error
+	invalid bool // whether the peer served invalid data
+}
+
+// fetches sections in parallel, each peer serves a single section at a time
+// failed sections are retried at other peers, avoid contains peers which must not serve the section
+func (f *fastsync) fetch_sections(t *tree_sync, pending []uint64, avoid map[uint64]*Connection, progress string) error {
+	failed := map[uint64]map[*Connection]bool{}
+	idle := append([]*Connection{}, t.peers...)
+	results := make(chan section_result)
+	inflight, done, total := 0, 0, len(pending)
+
+	for len(pending) > 0 || inflight > 0 {
+		for i := 0; i < len(pending) && len(idle) > 0; {
+			index, j := pending[i], -1
+			for k := range idle {
+				if !failed[index][idle[k]] && (avoid == nil || avoid[index] != idle[k]) {
+					j = k
+					break
+				}
+			}
+			if j < 0 {
+				i++
+				continue
+			}
+			c := idle[j]
+			idle = append(idle[:j], idle[j+1:]...)
+			pending = append(pending[:i], pending[i+1:]...)
+			inflight++
+			go func() { results <- f.fetch_section(t, c, index) }()
+		}
+		if inflight == 0 {
+			return fmt.Errorf("no peer left to serve section %d of tree %x", pending[0], t.name)
+		}
+
+		r := <-results
+		inflight--
+		if r.err != nil {
+			r.c.logger.V(1).Error(r.err, "Tree section failed", "tree", fmt.Sprintf("%x", t.name), "section", r.index)
+			if failed[r.index] == nil {
+				failed[r.index] = map[*Connection]bool{}
+			}
+			failed[r.index][r.c] = true
+			pending = append(pending, r.index)
+			if r.invalid {
+				r.c.score(score_bad_chunk)
+				idle = append(idle, r.c)
+			} else {
+				r.c.score(score_empty_response) // peer is dropped from this tree
+			}
+			continue
+		}
+		idle = append(idle, r.c)
+
+		if err := t.save_section(r.index, &r.section); err != nil {
+			return err
+		}
+		t.digests[r.index], t.have[r.index], t.source[r.index] = r.digest, true, r.c
+		done++
+		if done%64 == 0 || done == total {
+			f.logger.Info("Bootstrap in progress("+progress+")", "percent", float32(done*100)/float32(total), "peers", len(t.peers))
+		}
+	}
+	return nil
+}
+
+func (f *fastsync) fetch_section(t *tree_sync, c *Connection, index uint64) (r section_result) {
+	r.index, r.c = index, c
+	atomic.AddInt32(&c.Syncing, 1)
+	defer atomic.AddInt32(&c.Syncing, -1)
+
+	request := Request_Tree_Section_Struct{Topo: f.topo, TreeName: t.name, Section: section_path(index), SectionLength: uint64(t.path_length)}
+	var response Response_Tree_Section_Struct
+	f.node.fill_common(&request.Common)
+	start := time.Now()
+	if r.err = c.Client.Call("Peer.TreeSection", request, &response); r.err != nil {
+		return
+	}
+	if time.Now().Sub(start) > slow_response_time {
+		c.score(score_slow_response)
+	}
+	if response.StateHash != t.root {
+		r.err, r.invalid = fmt.Errorf("peer changed root to %x", response.StateHash), true
+		return
+	}
+	r.section = tree_section{Keys: response.Keys, Values: response.Values}
+	if r.digest, r.err = r.section.verify(index, t.path_length); r.err != nil {
+		r.invalid = true
+	}
+	return
+}
+
+func (t *tree_sync) section_file(index uint64) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%d.cbor", index))
+}
+
+func (t *tree_sync) save_section(index uint64, section *tree_section) error {
+	data, err := cbor.Marshal(section)
+	if err != nil {
+		return err
+	}
+	tmp := t.section_file(index) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.section_file(index)) // sections are either complete or absent
+}
+
+func (t *tree_sync) load_section(index uint64) (section tree_section, err error) {
+	var data []byte
+	if data, err = os.ReadFile(t.section_file(index)); err == nil {
+		err = cbor.Unmarshal(data, &section)
+	}
+	return
+}
+
+// calls fn for every persisted section of the tree
+func (t *tree_sync) each(fn func(section *tree_section) error) error {
+	for i := range t.have {
+		section, err := t.load_section(uint64(i))
+		if err != nil {
+			return err
+		}
+		if err = fn(&section); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writes a verified tree to graviton section by section and checks the resulting root
+func (t *tree_sync) commit(tree *graviton.Tree) (commit_version uint64, err error) {
+	err = t.each(func(section *tree_section) (err error) {
+		for j := range section.Keys {
+			if err = tree.Put(section.Keys[j], section.Values[j]); err != nil {
+				return
+			}
+		}
+		commit_version, err = graviton.Commit(tree)
+		return
+	})
+	if err != nil {
+		return
+	}
+	if h, err := tree.Hash(); err != nil {
+		return commit_version, err
+	} else if h != t.root {
+		return commit_version, fmt.Errorf("written tree %x has root %x expected %x", t.name, h, t.root)
+	}
+	return
+}
@@ -0,0 +1,179 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+import "fmt"
+import "testing"
+
+import "github.com/deroproject/graviton"
+
+func fastsync_test_tree(t *testing.T, count int, deleted int) *graviton.Tree {
+	store, err := graviton.NewMemStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := store.LoadSnapshot(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ss.GetTree("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	for i := 0; i < deleted; i++ {
+		tree.Delete([]byte(fmt.Sprintf("key%d", i*3)))
+	}
+	if _, err = graviton.Commit(tree); err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// sections listed by tree_section_list must fold back into the graviton root
+func Test_Fastsync_Root(t *testing.T) {
+	for _, c := range []struct{ count, deleted int }{{0, 0}, {1, 0}, {2, 0}, {3, 1}, {50, 0}, {700, 0}, {3000, 500}} {
+		tree := fastsync_test_tree(t, c.count, c.deleted)
+		root, err := tree.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path_length := range []uint{1, 2, 5, 9} {
+			chunks := uint64(1) << path_length
+			digests := make([]tree_digest, chunks)
+			total := 0
+			for i := uint64(0); i < chunks; i++ {
+				var section tree_section
+				section.Keys, section.Values = tree_section_list(tree, section_path(i), path_length)
+				if digests[i], err = section.verify(i, path_length); err != nil {
+					t.Fatalf("count %d section %d/%d err %s", c.count, i, chunks, err)
+				}
+				total += len(section.Keys)
+			}
+			if total != c.count-c.deleted {
+				t.Fatalf("count %d path length %d sections contain %d keys", c.count, path_length, total)
+			}
+			if folded := fold_sections(digests, path_length); folded != root {
+				t.Fatalf("count %d path length %d folded root %x expected %x", c.count, path_length, folded, root)
+			}
+		}
+	}
+}
+
+func Test_Fastsync_Section_Verify(t *testing.T) {
+	tree := fastsync_test_tree(t, 100, 0)
+	var section tree_section
+	section.Keys, section.Values = tree_section_list(tree, section_path(0), 2)
+	if len(section.Keys) < 2 {
+		t.Fatalf("section too small for test")
+	}
+	digest, err := section.verify(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = section.verify(1, 2); err == nil {
+		t.Fatalf("keys outside section must be rejected")
+	}
+
+	tampered := tree_section{Keys: section.Keys, Values: append([][]byte{[]byte("tampered")}, section.Values[1:]...)}
+	if tampered_digest, err := tampered.verify(0, 2); err != nil || tampered_digest == digest {
+		t.Fatalf("tampered value must change digest err %v", err)
+	}
+
+	duplicate := tree_section{Keys: append(section.Keys, section.Keys[0]), Values: append(section.Values, section.Values[0])}
+	if _, err = duplicate.verify(0, 2); err == nil {
+		t.Fatalf("duplicate keys must be rejected")
+	}
+}
+
+// a peer reporting a huge key count must not make us allocate sections for it
+func Test_Fastsync_Keycount(t *testing.T) {
+	var root, other [32]byte
+	other[0] = 1
+	votes := []root_vote{{root: root, keycount: 1 << 62}, {root: root, keycount: 64000}, {root: root, keycount: 64100}, {root: other, keycount: 1 << 40}}
+	if keycount := agreed_keycount(votes, root); keycount != 64100 {
+		t.Fatalf("key count must be median of agreeing peers, actual %d", keycount)
+	}
+	if keycount := agreed_keycount(votes[:1], root); keycount != fastsync_chunk_size<<fastsync_max_path_length {
+		t.Fatalf("key count must be capped, actual %d", keycount)
+	}
+	if keycount := agreed_keycount([]root_vote{{root: root, keycount: -5}}, root); keycount != 0 {
+		t.Fatalf("negative key count must be ignored, actual %d", keycount)
+	}
+
+	if chunks, path_length := section_layout(1 << 62); path_length != fastsync_max_path_length || chunks != 1<<fastsync_max_path_length {
+		t.Fatalf("sections must be capped, chunks %d path length %d", chunks, path_length)
+	}
+	if chunks, path_length := section_layout(64100); path_length != 7 || chunks != 128 {
+		t.Fatalf("unexpected layout, chunks %d path length %d", chunks, path_length)
+	}
+}
+
+// changesets which fail verification are rolled back to the verified state
+func Test_Fastsync_Revert_Changes(t *testing.T) {
+	store, err := graviton.NewMemStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, _ := store.LoadSnapshot(0)
+	tree, _ := ss.GetTree("test")
+	other, _ := ss.GetTree("other")
+	for i := 0; i < 10; i++ {
+		tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	verified_version, err := graviton.Commit(tree, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := tree.Hash()
+	other_root, _ := other.Hash()
+
+	changes := []Tree_Changes{
+		{TreeName: []byte("test"), Keys: [][]byte{[]byte("key1"), []byte("key20"), []byte("key30")}, Values: [][]byte{[]byte("changed"), []byte("added"), []byte("never applied")}},
+		{TreeName: []byte("other"), Keys: [][]byte{[]byte("key1")}, Values: [][]byte{[]byte("added")}},
+	}
+	for _, change := range changes { // bootstrap failed before applying the last key
+		ss, _ = store.LoadSnapshot(0)
+		tree, _ = ss.GetTree(string(change.TreeName))
+		for j := range change.Keys {
+			if string(change.Keys[j]) != "key30" {
+				tree.Put(change.Keys[j], change.Values[j])
+			}
+		}
+		if _, err = graviton.Commit(tree); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = revert_changes(store, verified_version, []Complete_Block{{Changes: changes}}); err != nil {
+		t.Fatal(err)
+	}
+	ss, _ = store.LoadSnapshot(0)
+	tree, _ = ss.GetTree("test")
+	other, _ = ss.GetTree("other")
+	if h, _ := tree.Hash(); h != root {
+		t.Fatalf("tree must be reverted to verified root")
+	}
+	if h, _ := other.Hash(); h != other_root {
+		t.Fatalf("other tree must be reverted to verified root")
+	}
+	if value, err := tree.Get([]byte("key1")); err != nil || string(value) != "value1" {
+		t.Fatalf("changed key must get verified value back, err %s", err)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file implements node identities, every node has a persistent ed25519 key stored in its data directory
+ * the key signs the tls certificate offered by both ends, so the tls handshake proves possession of the key
+ * peers may be pinned using --add-exclusive-node=<key@ip:port> or --add-priority-node=<key@ip:port>
+ * private groups may be formed using --p2p-allow-key=<key>, peers without an allowed key fail the tls handshake
+ */
+import "os"
+import "fmt"
+import "time"
+import "errors"
+import "strings"
+import "math/big"
+import "crypto/tls"
+import "crypto/rand"
+import "crypto/x509"
+import "crypto/ed25519"
+import "encoding/hex"
+import "encoding/pem"
+import "path/filepath"
+
+const IDENTITY_FILE = "p2p_identity.pem"
+
+// load identity key of this node, a new one is generated if the file does not exist
+func (n *node) load_identity() (err error) {
+	file := filepath.Join(n.data_dir, IDENTITY_FILE)
+	if n.arguments["--p2p-identity"] != nil {
+		file = n.arguments["--p2p-identity"].(string)
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		b, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}), 0600); err != nil {
+			return err
+		}
+		n.identity = key
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("identity file %s is not pem encoded", file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("identity file %s err %s", file, err)
+	}
+	var ok bool
+	if n.identity, ok = key.(ed25519.PrivateKey); !ok {
+		return fmt.Errorf("identity file %s does not contain an ed25519 key", file)
+	}
+	return nil
+}
+
+// public key of this node in hex, empty if identity is not loaded
+func (n *node) Identity() string {
+	if n.identity == nil {
+		return ""
+	}
+	return hex.EncodeToString(n.identity.Public().(ed25519.PublicKey))
+}
+
+// return identity of the node started by P2P_Init
+func Identity() string {
+	return default_node.Identity()
+}
+
+// parse --p2p-allow-key, keys are normalized to lower case hex
+func (n *node) load_allowed_identities() error {
+	n.allowed = map[string]bool{}
+	if n.arguments["--p2p-allow-key"] == nil {
+		return nil
+	}
+	for _, key := range n.arguments["--p2p-allow-key"].([]string) {
+		key = strings.ToLower(key)
+		if err := verify_identity_format(key); err != nil {
+			return fmt.Errorf("--p2p-allow-key %s", err)
+		}
+		n.allowed[key] = true
+	}
+	return nil
+}
+
+func verify_identity_format(key string) error {
+	if b, err := hex.DecodeString(key); err != nil || len(b) != ed25519.PublicKeySize {
+		return fmt.Errorf("identity %q must be %d bytes in hex", key, ed25519.PublicKeySize)
+	}
+	return nil
+}
+
+// endpoints may carry expected identity as key@ip:port
+func split_endpoint(endpoint string) (identity, address string) {
+	if i := strings.LastIndex(endpoint, "@"); i >= 0 {
+		return strings.ToLower(endpoint[:i]), endpoint[i+1:]
+	}
+	return "", endpoint
+}
+
+// tls certificate signed by identity key, it is offered by both servers and clients
+// NOTE: the key is persistent, so peers can recognise this node even if it changes ip
+func (n *node) identity_tls_cert() tls.Certificate {
+	tml := x509.Certificate{
+		SerialNumber: big.NewInt(int64(n.GetPeerID()) ^ int64(time.Now().UnixNano())),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &tml, &tml, n.identity.Public(), n.identity)
+	if err != nil {
+		n.logger.Error(err, "Certificate cannot be created.")
+		panic(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{cert}, PrivateKey: n.identity}
+}
+
+// identity proven by peer during tls handshake, empty if peer did not offer an ed25519 certificate
+func (c *Connection) peer_identity() string {
+	tlsconn, ok := c.ConnTls.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	state := tlsconn.ConnectionState()
+	if !state.HandshakeComplete || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	if key, ok := state.PeerCertificates[0].PublicKey.(ed25519.PublicKey); ok {
+		return hex.EncodeToString(key)
+	}
+	return ""
+}
+
+// identity of the key which signed the first certificate, empty if there is none or it is not ed25519
+func certificate_identity(raw [][]byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(raw[0])
+	if err != nil {
+		return ""
+	}
+	if key, ok := cert.PublicKey.(ed25519.PublicKey); ok {
+		return hex.EncodeToString(key)
+	}
+	return ""
+}
+
+// checks identity against pinned identity and allow list
+func (n *node) check_identity(pinned, identity string) error {
+	if pinned != "" && pinned != identity {
+		return fmt.Errorf("peer identity %q does not match %s", identity, pinned)
+	}
+	if len(n.allowed) > 0 && !n.allowed[identity] {
+		return fmt.Errorf("peer identity %q is not allowed", identity)
+	}
+	return nil
+}
+
+// used as tls VerifyPeerCertificate, so peers without allowed or pinned identity fail the tls handshake and never reach rpc handlers
+func (n *node) verify_peer_certificate(pinned string) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, _ [][]*x509.Certificate) error {
+		return n.check_identity(pinned, certificate_identity(raw))
+	}
+}
+
+// records identity of peer and checks it against pinned identity and allow list
+// both ends handshake, so this is called twice, identity is recorded only once
+func (c *Connection) verify_identity() bool {
+	c.identity_once.Do(func() { c.Identity = c.peer_identity() })
+	if err := c.node.check_identity(c.pinned, c.Identity); err != nil {
+		c.logger.V(1).Error(err, "peer identity rejected")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+import "os"
+import "testing"
+import "path/filepath"
+
+func Test_Identity_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	load := func() (*node, error) {
+		n := new_node()
+		n.data_dir = dir
+		return n, n.load_identity()
+	}
+
+	n, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verify_identity_format(n.Identity()); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := load(); err != nil || again.Identity() != n.Identity() {
+		t.Fatalf("identity must survive restarts err %v", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, IDENTITY_FILE), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = load(); err == nil {
+		t.Fatalf("corrupted identity must not be replaced silently")
+	}
+}
+
+func Test_Split_Endpoint(t *testing.T) {
+	key := "7f2b4a3c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708"
+	if identity, address := split_endpoint("7F2B4A3C5D6E7F8091A2B3C4D5E6F708192A3B4C5D6E7F8091A2B3C4D5E6F708@1.2.3.4:18089"); identity != key || address != "1.2.3.4:18089" {
+		t.Fatalf("invalid split %s %s", identity, address)
+	}
+	if identity, address := split_endpoint("1.2.3.4:18089"); identity != "" || address != "1.2.3.4:18089" {
+		t.Fatalf("invalid split %s %s", identity, address)
+	}
+	if verify_identity_format(key) != nil || verify_identity_format(key[2:]) == nil || verify_identity_format("xyz") == nil {
+		t.Fatalf("identity format is not verified")
+	}
+}
@@ -0,0 +1,532 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+// these tests run several nodes, each with its own simulator chain, connected through an in-memory network
+import "io"
+import "os"
+import "fmt"
+import "time"
+import "sync"
+import "context"
+import "testing"
+import "crypto/tls"
+import "path/filepath"
+
+import "github.com/go-logr/logr"
+import "github.com/cenkalti/rpc2"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/block"
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/metrics"
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/blockchain"
+import "github.com/deroproject/derohe/walletapi"
+import "github.com/deroproject/derohe/transaction"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+const test_p2p_port = 18095
+
+var test_miner rpc.Address // registered in genesis, so it can mine on every test chain
+var test_network_once sync.Once
+
+// all test chains share a genesis block whose miner is test_miner
+// globals are set only once, goroutines of nodes stopped by earlier tests may still be reading them
+func test_network_init(t *testing.T) {
+	test_network_once.Do(func() {
+		logger = logr.Discard()
+		globals.Logger = logr.Discard()
+
+		globals.Arguments = map[string]interface{}{"--testnet": true, "--simulator": true}
+		globals.Initialize()
+
+		test_miner = *rpc.NewAddressFromKeys(crypto.GPoint.ScalarMult(crypto.RandomScalarBNRed()))
+		test_miner.Mainnet = false
+
+		genesis_tx := transaction.Transaction{Transaction_Prefix: transaction.Transaction_Prefix{Version: 1, Value: 2012345}}
+		copy(genesis_tx.MinerAddress[:], test_miner.PublicKey.EncodeCompressed())
+		config.Testnet.Genesis_Tx = fmt.Sprintf("%x", genesis_tx.Serialize())
+		genesis_block := blockchain.Generate_Genesis_Block()
+		config.Testnet.Genesis_Block_Hash = genesis_block.GetHash()
+		globals.InitNetwork()
+		globals.Cron.Start()
+	})
+}
+
+// starts a node with a fresh chain, it listens on ip and keeps connections to the priority endpoints
+func test_node_start(t *testing.T, network *Memory_Network, ip string, priority ...string) *node {
+	return test_node_start_arguments(t, network, ip, map[string]interface{}{"--add-priority-node": priority})
+}
+
+// starts a node with additional command line options
+func test_node_start_arguments(t *testing.T, network *Memory_Network, ip string, arguments map[string]interface{}) *node {
+	dir := filepath.Join(t.TempDir(), ip)
+	globals.Arguments["--data-dir"] = dir // chain storage is placed within data directory
+	chain, err := blockchain.Blockchain_Start(map[string]interface{}{"--simulator": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	n := new_node()
+	arguments["--p2p-bind"] = fmt.Sprintf("%s:%d", ip, test_p2p_port)
+	if err = n.start(map[string]interface{}{"chain": chain, "transport": network.Transport(ip), "arguments": arguments, "data_dir": dir, "logger": logr.Discard()}); err != nil {
+		t.Fatal(err)
+	}
+	chain.P2P_Block_Relayer = n.Broadcast_Block
+	chain.P2P_MiniBlock_Relayer = n.Broadcast_MiniBlock
+
+	t.Cleanup(func() {
+		n.stop()
+		chain.Shutdown()
+	})
+	return n
+}
+
+func test_endpoint(ip string) string {
+	return fmt.Sprintf("%s:%d", ip, test_p2p_port)
+}
+
+// mines a block at the tip and announces it to peers the way the miner does
+func test_mine_block(t *testing.T, n *node) *block.Complete_Block {
+	cbl, _, err := n.chain.Create_new_miner_block(test_miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for { // a full set of miniblocks, peers ignore chunks of blocks which carry fewer
+		mbl := blockchain.ConvertBlockToMiniblock(*cbl.Bl, test_miner)
+		for !n.chain.VerifyMiniblockPoW(cbl.Bl, mbl) { // simulator difficulty is low, but peers verify PoW of relayed chunks
+			mbl.Nonce[0]++
+		}
+		cbl.Bl.MiniBlocks = append(cbl.Bl.MiniBlocks, mbl)
+		if mbl.Final {
+			break
+		}
+	}
+	if err, ok := n.chain.Add_Complete_Block(cbl); !ok {
+		t.Fatalf("error adding block %s", err)
+	}
+	go n.chain.P2P_Block_Relayer(cbl, 0)
+	return cbl
+}
+
+// waits until condition is satisfied
+func test_wait(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	for deadline := time.Now().Add(timeout); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func test_wait_peers(t *testing.T, n *node, count uint64) {
+	test_wait(t, 30*time.Second, "peers to connect", func() bool { return n.Peer_Count() >= count })
+}
+
+func test_wait_top(t *testing.T, n *node, top crypto.Hash) {
+	test_wait(t, 60*time.Second, fmt.Sprintf("block %s", top), func() bool { return n.chain.Get_Top_ID() == top })
+}
+
+func Test_Memory_Transport(t *testing.T) {
+	network := New_Memory_Network()
+	server, client := network.Transport("10.0.0.1"), network.Transport("10.0.0.2")
+
+	if _, err := client.Dial(test_endpoint("10.0.0.1")); err == nil {
+		t.Fatalf("dialing an address nobody listens on must fail")
+	}
+
+	l, err := server.Listen("0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.Listen(l.Addr().String()); err == nil {
+		t.Fatalf("address must not be reused while listening")
+	}
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if ParseIPNoError(conn.RemoteAddr().String()) != "10.0.0.2" {
+			conn.Write([]byte("bad address"))
+			return
+		}
+		var buf [4]byte
+		conn.Read(buf[:])
+		conn.Write(buf[:])
+	}()
+
+	conn, err := client.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != l.Addr().String() {
+		t.Fatalf("remote address %s expected %s", conn.RemoteAddr(), l.Addr())
+	}
+	conn.Write([]byte("ping"))
+	var buf [4]byte
+	if _, err = conn.Read(buf[:]); err != nil || string(buf[:]) != "ping" {
+		t.Fatalf("echo failed %q err %v", buf, err)
+	}
+
+	l.Close()
+	if _, err = client.Dial(l.Addr().String()); err == nil {
+		t.Fatalf("dialing a closed listener must fail")
+	}
+}
+
+// a block mined on one node reaches its peer
+func Test_Multinode_Block_Relay(t *testing.T) {
+	test_network_init(t)
+	network := New_Memory_Network()
+
+	a := test_node_start(t, network, "10.0.1.1")
+	b := test_node_start(t, network, "10.0.1.2", test_endpoint("10.0.1.1"))
+	test_wait_peers(t, a, 1)
+	test_wait_peers(t, b, 1)
+
+	for i := 0; i < 3; i++ {
+		cbl := test_mine_block(t, a)
+		test_wait_top(t, b, cbl.Bl.GetHash())
+	}
+	if b.chain.Get_Height() != a.chain.Get_Height() {
+		t.Fatalf("height mismatch %d %d", a.chain.Get_Height(), b.chain.Get_Height())
+	}
+}
+
+// nodes in a line, a block crosses the middle node as erasure coded chunks, which it reconstructs and relays
+func Test_Multinode_Chunk_Propagation(t *testing.T) {
+	test_network_init(t)
+	t.Setenv("COMPACT_BLOCKS", "0") // otherwise blocks travel in compact form
+	network := New_Memory_Network()
+
+	a := test_node_start(t, network, "10.0.2.1")
+	b := test_node_start(t, network, "10.0.2.2", test_endpoint("10.0.2.1"))
+	c := test_node_start(t, network, "10.0.2.3", test_endpoint("10.0.2.2"))
+	test_wait_peers(t, a, 1)
+	test_wait_peers(t, b, 2)
+	test_wait_peers(t, c, 1)
+
+	if a.IsAddressConnected("10.0.2.3") || c.IsAddressConnected("10.0.2.1") {
+		t.Fatalf("edge nodes must not be connected directly")
+	}
+
+	cbl := test_mine_block(t, a)
+	test_wait_top(t, c, cbl.Bl.GetHash())
+
+	hhash, chunk_count := b.is_already_chunked_by_us(cbl.Bl.GetHash(), 16, 32)
+	if chunk_count == 0 {
+		t.Fatalf("middle node must have received the block as chunks")
+	}
+	if b.is_chunk_exist(hhash, 0) == nil && b.is_chunk_exist(hhash, uint8(chunk_count-1)) == nil {
+		t.Fatalf("middle node must keep received chunks")
+	}
+}
+
+// a block is rebuilt from txs the receiver has, the tx it lacks is requested
+func Test_Multinode_Compact_Block(t *testing.T) {
+	test_network_init(t)
+	network := New_Memory_Network()
+
+	a := test_node_start(t, network, "10.0.4.1")
+	b := test_node_start(t, network, "10.0.4.2", test_endpoint("10.0.4.1"))
+	test_wait_peers(t, a, 1)
+	test_wait_peers(t, b, 1)
+	for _, c := range b.UniqueConnections() {
+		if !c.compact {
+			t.Fatalf("compact blocks must have been negotiated")
+		}
+	}
+
+	var txs []*transaction.Transaction
+	for i := 0; i < 2; i++ {
+		w, err := walletapi.Create_Encrypted_Wallet_Random_Memory("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, w.GetRegistrationTX())
+	}
+	for _, tx := range txs {
+		if err := a.chain.Add_TX_To_Pool(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.chain.Add_TX_To_Pool(txs[0]); err != nil { // b only knows the first one
+		t.Fatal(err)
+	}
+
+	found, missing := metrics.Set.GetOrCreateCounter("compact_block_tx_found_total").Get(), metrics.Set.GetOrCreateCounter("compact_block_tx_missing_total").Get()
+	cbl := test_mine_block(t, a)
+	if len(cbl.Txs) != 2 {
+		t.Fatalf("block must carry both txs, has %d", len(cbl.Txs))
+	}
+	test_wait_top(t, b, cbl.Bl.GetHash())
+
+	if actual := metrics.Set.GetOrCreateCounter("compact_block_tx_found_total").Get() - found; actual != 1 {
+		t.Fatalf("expected 1 tx found in pool, actual %d", actual)
+	}
+	if actual := metrics.Set.GetOrCreateCounter("compact_block_tx_missing_total").Get() - missing; actual != 1 {
+		t.Fatalf("expected 1 tx requested, actual %d", actual)
+	}
+	if b.Peer_Count() != 1 {
+		t.Fatalf("peer must not have been disconnected")
+	}
+}
+
+// a stem tx is neither listed nor served to peers, till its embargo expires and it is fluffed
+func Test_Multinode_Dandelion(t *testing.T) {
+	test_network_init(t)
+	network := New_Memory_Network()
+
+	a := test_node_start(t, network, "10.0.5.1")
+	b := test_node_start(t, network, "10.0.5.2", test_endpoint("10.0.5.1"))
+	test_wait_peers(t, a, 1)
+	test_wait_peers(t, b, 1)
+	test_wait(t, 10*time.Second, "stem route", func() bool { return b.dandelion_route(nil) != nil })
+	if a.dandelion_route(nil) != nil {
+		t.Fatalf("stem routes must only use outgoing peers")
+	}
+
+	var conn *Connection
+	for _, c := range b.UniqueConnections() {
+		conn = c
+	}
+	if !conn.dandelion || b.dandelion_route(nil) != conn {
+		t.Fatalf("dandelion must have been negotiated")
+	}
+
+	w, err := walletapi.Create_Encrypted_Wallet_Random_Memory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := w.GetRegistrationTX() // registrations skip stem phase, so it is placed directly within mempool
+	if !a.chain.Mempool.Mempool_Add_TX_Stem(tx, 0) || !a.chain.Mempool.Mempool_Is_Stem(tx.GetHash()) {
+		t.Fatalf("tx must enter stem phase")
+	}
+
+	request := ObjectList{Tx_list: [][32]byte{tx.GetHash()}}
+	var response Objects
+	if conn.request_objects(request, &response); len(response.Txs) != 0 || len(a.chain.Mempool.Mempool_List_TX()) != 0 {
+		t.Fatalf("stem tx must not be revealed")
+	}
+
+	defer func(embargo time.Duration) { dandelion_embargo = embargo }(dandelion_embargo)
+	dandelion_embargo = 50 * time.Millisecond
+	expired := metrics.Set.GetOrCreateCounter("dandelion_embargo_expired_total").Get()
+	a.embargo_tx(tx)
+	test_wait(t, 10*time.Second, "embargo", func() bool { return !a.chain.Mempool.Mempool_Is_Stem(tx.GetHash()) })
+	if metrics.Set.GetOrCreateCounter("dandelion_embargo_expired_total").Get() != expired+1 {
+		t.Fatalf("embargo must have fluffed the tx")
+	}
+
+	response = Objects{}
+	if conn.request_objects(request, &response); len(response.Txs) != 1 || len(a.chain.Mempool.Mempool_List_TX()) != 1 {
+		t.Fatalf("fluffed tx must be served")
+	}
+}
+
+// traffic served by a node is captured and replays against the same chain with same results
+func Test_Multinode_Capture_Replay(t *testing.T) {
+	test_network_init(t)
+	network := New_Memory_Network()
+
+	a := test_node_start(t, network, "10.0.6.1")
+	a.capture_dir = t.TempDir()
+	var top crypto.Hash
+	for i := 0; i < 3; i++ {
+		top = test_mine_block(t, a).Bl.GetHash()
+	}
+	b := test_node_start(t, network, "10.0.6.2", test_endpoint("10.0.6.1"))
+	test_wait_top(t, b, top)
+
+	captures, err := filepath.Glob(filepath.Join(a.capture_dir, "*"+CAPTURE_EXTENSION))
+	if err != nil || len(captures) != 1 {
+		t.Fatalf("expected a single capture, actual %d err %v", len(captures), err)
+	}
+	file, err := os.Open(captures[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, records, err := Read_Capture(file)
+	if err != nil || !info.Incoming || info.Peer_ID != a.GetPeerID() || len(records) == 0 {
+		t.Fatalf("invalid capture %+v records %d err %v", info, len(records), err)
+	}
+
+	file.Seek(0, io.SeekStart)
+	stats, err := Replay_Capture(a.chain, file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["Peer.Handshake"] == nil || stats["Peer.Handshake"].Requests != 1 || stats["Peer.Chain"] == nil {
+		t.Fatalf("handshake and chain requests must have been replayed %+v", stats)
+	}
+	for method, s := range stats {
+		if s.Changed != 0 {
+			t.Fatalf("%s replayed with different results %+v", method, *s)
+		}
+	}
+}
+
+// identity to be used by a test node, the key file is returned as well
+func test_identity(t *testing.T) (string, string) {
+	k := new_node()
+	k.data_dir = t.TempDir()
+	if err := k.load_identity(); err != nil {
+		t.Fatal(err)
+	}
+	return k.Identity(), filepath.Join(k.data_dir, IDENTITY_FILE)
+}
+
+func Test_Multinode_Identity(t *testing.T) {
+	test_network_init(t)
+	network := New_Memory_Network()
+
+	b_key, b_file := test_identity(t)
+	c_key, c_file := test_identity(t)
+
+	a := test_node_start_arguments(t, network, "10.0.7.1", map[string]interface{}{"--p2p-allow-key": []string{b_key}})
+	b := test_node_start_arguments(t, network, "10.0.7.2", map[string]interface{}{"--p2p-identity": b_file, "--add-priority-node": []string{a.Identity() + "@" + test_endpoint("10.0.7.1")}})
+	c := test_node_start_arguments(t, network, "10.0.7.3", map[string]interface{}{"--p2p-identity": c_file, "--add-priority-node": []string{test_endpoint("10.0.7.1")}})
+	d := test_node_start_arguments(t, network, "10.0.7.4", map[string]interface{}{"--add-priority-node": []string{c_key + "@" + test_endpoint("10.0.7.1")}})
+	if b.Identity() != b_key || c.Identity() != c_key {
+		t.Fatalf("identity must be loaded from the provided file")
+	}
+
+	test_wait_peers(t, a, 1)
+	test_wait_peers(t, b, 1)
+	time.Sleep(3 * time.Second) // rejected peers have handshaked by now
+
+	if a.Peer_Count() != 1 || c.Peer_Count() != 0 || d.Peer_Count() != 0 {
+		t.Fatalf("only allowed and correctly pinned peers may connect %d %d %d", a.Peer_Count(), c.Peer_Count(), d.Peer_Count())
+	}
+	if peers := a.Peer_Status_List(); len(peers) != 1 || peers[0].Identity != b_key {
+		t.Fatalf("peer list must show identity %+v", peers)
+	}
+	if peers := b.Peer_Status_List(); len(peers) != 1 || peers[0].Identity != a.Identity() {
+		t.Fatalf("peer list must show identity %+v", peers)
+	}
+	for _, x := range []struct {
+		n        *node
+		endpoint string
+		identity string
+	}{{a, test_endpoint("10.0.7.2"), b_key}, {b, test_endpoint("10.0.7.1"), a.Identity()}} { // proven identities are remembered in the peer list
+		p := x.n.GetPeerInList(ParseIPNoError(x.endpoint))
+		if p == nil {
+			t.Fatalf("peer %s must be in peer list", x.endpoint)
+		}
+		x.n.peer_mutex.Lock()
+		identity := p.Identity
+		x.n.peer_mutex.Unlock()
+		if identity != x.identity {
+			t.Fatalf("peer list must remember identity of %s, got %q", x.endpoint, identity)
+		}
+	}
+}
+
+// raw connection with identity from file, it skips p2p handshake, so it can call any handler
+func test_raw_client(t *testing.T, network *Memory_Network, ip, endpoint, identity_file string) *rpc2.Client {
+	k := new_node()
+	k.data_dir = t.TempDir()
+	k.arguments = map[string]interface{}{"--p2p-identity": identity_file}
+	if err := k.load_identity(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := network.Transport(ip).Dial(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsconn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{k.identity_tls_cert()}})
+	client := rpc2.NewClientWithCodec(NewCBORCodec(tlsconn))
+	go client.Run()
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// peers which are not allowed or have not passed handshake are not served
+func Test_Multinode_Identity_Requests(t *testing.T) {
+	test_network_init(t)
+	network := New_Memory_Network()
+
+	b_key, b_file := test_identity(t)
+	_, c_file := test_identity(t)
+	a := test_node_start_arguments(t, network, "10.0.8.1", map[string]interface{}{"--p2p-allow-key": []string{b_key}})
+
+	request := ObjectList{Block_list: [][32]byte{a.chain.Get_Top_ID()}}
+	for _, x := range []struct {
+		name string
+		file string
+	}{{"not allowed", c_file}, {"handshake pending", b_file}} {
+		client := test_raw_client(t, network, "10.0.8.2", test_endpoint("10.0.8.1"), x.file)
+		time.Sleep(500 * time.Millisecond) // connection is set up asynchronously, while handshake is dispatched after 2 secs
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var response Objects
+		err := client.CallWithContext(ctx, "Peer.GetObject", request, &response)
+		cancel()
+		if err == nil || len(response.CBlocks) != 0 {
+			t.Fatalf("%s peer must not get objects, err %v blocks %d", x.name, err, len(response.CBlocks))
+		}
+		if pending := err.Error() == errHandshakePending.Error(); pending != (x.file == b_file) { // peers which are not allowed fail tls handshake
+			t.Fatalf("%s peer refused with unexpected err %s", x.name, err)
+		}
+	}
+}
+
+// two nodes mine separately, once connected the node with the lighter chain reorganises to the heavier one
+func Test_Multinode_Reorg(t *testing.T) {
+	test_network_init(t)
+	network := New_Memory_Network()
+
+	a := test_node_start(t, network, "10.0.3.1")
+	b := test_node_start(t, network, "10.0.3.2")
+
+	var a_blocks []crypto.Hash
+	for i := 0; i < 3; i++ {
+		a_blocks = append(a_blocks, test_mine_block(t, a).Bl.GetHash())
+	}
+	var top crypto.Hash
+	for i := 0; i < 6; i++ {
+		top = test_mine_block(t, b).Bl.GetHash()
+	}
+	if a.chain.Get_Top_ID() == top {
+		t.Fatalf("chains must have diverged")
+	}
+
+	go a.maintain_outgoing_priority_connection(test_endpoint("10.0.3.2"), false)
+	test_wait_peers(t, a, 1)
+	test_wait_top(t, a, top)
+
+	if a.chain.Get_Height() != b.chain.Get_Height() {
+		t.Fatalf("height mismatch %d %d", a.chain.Get_Height(), b.chain.Get_Height())
+	}
+	for _, blid := range a_blocks {
+		if a.chain.Is_Block_Topological_order(blid) {
+			t.Fatalf("block %s of the lighter chain must have been orphaned", blid)
+		}
+	}
+
+	// the reorganised node keeps following the network
+	cbl := test_mine_block(t, b)
+	test_wait_top(t, a, cbl.Bl.GetHash())
+}
@@ -22,20 +22,15 @@ package p2p
 import "os"
 import "fmt"
 
-import "errors"
 import "sync"
 import "time"
 import "sort"
-import "path/filepath"
-import "encoding/json"
 
 //import "encoding/binary"
 //import "container/list"
 
 //import log "github.com/sirupsen/logrus"
 
-import "github.com/deroproject/derohe/globals"
-
 //import "github.com/deroproject/derosuite/crypto"
 
 // This structure is used to do book keeping for the peer list and keeps other DATA related to peer
@@ -53,163 +48,210 @@ type Peer struct {
 	ID      uint64 `json:"peerid"`  // peer id
 	Miner   bool   `json:"miner"`   // miner
 	//NeverBlacklist    bool    // this address will never be blacklisted
-	LastConnected   uint64 `json:"lastconnected"`   // epoch time when it was connected , 0 if never connected
-	FailCount       uint64 `json:"failcount"`       // how many times have we failed  (tcp errors)
-	ConnectAfter    uint64 `json:"connectafter"`    // we should connect when the following timestamp passes
-	BlacklistBefore uint64 `json:"blacklistbefore"` // peer blacklisted till epoch , priority nodes are never blacklisted, 0 if not blacklist
-	GoodCount       uint64 `json:"goodcount"`       // how many times peer has been shared with us
-	Version         int    `json:"version"`         // version 1 is original C daemon peer, version 2 is golang p2p version
-	Whitelist       bool   `json:"whitelist"`
+	LastConnected   uint64 `json:"lastconnected"`      // epoch time when it was connected , 0 if never connected
+	FailCount       uint64 `json:"failcount"`          // how many times have we failed  (tcp errors)
+	ConnectAfter    uint64 `json:"connectafter"`       // we should connect when the following timestamp passes
+	BlacklistBefore uint64 `json:"blacklistbefore"`    // peer blacklisted till epoch , priority nodes are never blacklisted, 0 if not blacklist
+	GoodCount       uint64 `json:"goodcount"`          // how many times peer has been shared with us
+	Version         int    `json:"version"`            // version 1 is original C daemon peer, version 2 is golang p2p version
+	Whitelist       bool   `json:"whitelist"`          // whitelisted peers have been connected and live in tried buckets
+	Score           int64  `json:"score,omitempty"`    // last score of a connection to this peer, see peer_score.go
+	LastSeen        uint64 `json:"lastseen"`           // epoch time when peer was last known to be up, travels with gossip
+	Source          string `json:"source,omitempty"`   // network group of the peer which told us this address
+	Identity        string `json:"identity,omitempty"` // public key proven by peer during tls handshake, empty if never proven
 	sync.Mutex
 }
 
-var peer_map = map[string]*Peer{}
-var peer_mutex sync.Mutex
-
-// loads peers list from disk
-func load_peer_list() {
-	defer clean_up()
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
-
-	peer_file := filepath.Join(globals.GetDataDirectory(), "peers.json")
-	if _, err := os.Stat(peer_file); errors.Is(err, os.ErrNotExist) {
+// loads address manager state from disk, peers.json of earlier versions is imported if no state exists
+func (n *node) load_peer_list() {
+	defer n.clean_up()
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
+
+	var err error
+	switch {
+	case file_exists(n.addrman_file()):
+		err = n.addrman.load(n.addrman_file())
+	case file_exists(n.legacy_peer_file()):
+		err = n.addrman.import_legacy(n.legacy_peer_file())
+	default:
 		return // since file doesn't exist , we cannot load it
 	}
-	file, err := os.Open(peer_file)
 	if err != nil {
-		logger.Error(err, "opening peer file")
-	} else {
-		defer file.Close()
-		decoder := json.NewDecoder(file)
-		err = decoder.Decode(&peer_map)
-		if err != nil {
-			logger.Error(err, "Error unmarshalling peer data")
-		} else { // successfully unmarshalled data
-			logger.V(1).Info("Successfully loaded peers from file", "peer_count", (len(peer_map)))
-		}
+		n.logger.Error(err, "Error loading peer data")
+		n.addrman = new_address_manager()
+	} else { // successfully unmarshalled data
+		n.logger.V(1).Info("Successfully loaded peers from file", "peer_count", len(n.addrman.peers), "tried", n.addrman.tried_count())
 	}
-
 }
 
-// save peer list to disk
-func save_peer_list() {
+// save address manager state to disk
+func (n *node) save_peer_list() {
 
-	clean_up()
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
+	n.clean_up()
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
 
-	peer_file := filepath.Join(globals.GetDataDirectory(), "peers.json")
-	file, err := os.Create(peer_file)
-	if err != nil {
-		logger.Error(err, "saving peer file")
+	if err := n.addrman.save(n.addrman_file()); err != nil {
+		n.logger.Error(err, "Error saving peer data")
 	} else {
-		defer file.Close()
-		encoder := json.NewEncoder(file)
-		encoder.SetIndent("", "\t")
-		err = encoder.Encode(&peer_map)
-		if err != nil {
-			logger.Error(err, "Error marshalling peer data")
-		} else { // successfully unmarshalled data
-			logger.V(1).Info("Successfully saved peers to file", "peer_count", (len(peer_map)))
-		}
+		os.Remove(n.legacy_peer_file()) // state has been carried over
+		n.logger.V(1).Info("Successfully saved peers to file", "peer_count", len(n.addrman.peers))
 	}
 }
 
-// clean up by discarding entries which are too much into future
-func clean_up() {
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
-	for k, v := range peer_map {
-		if IsAddressConnected(ParseIPNoError(v.Address)) {
+// clean up by discarding entries which failed too many times or have not been seen for long
+// connected peers are seen right now
+func (n *node) clean_up() {
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
+	now := uint64(time.Now().UTC().Unix())
+	for _, v := range n.addrman.peers {
+		if n.IsAddressConnected(ParseIPNoError(v.Address)) {
+			v.LastSeen = now
 			continue
 		}
 		if v.FailCount >= 8 { // roughly 8 tries before we discard the peer
-			delete(peer_map, k)
-		}
-		if v.LastConnected == 0 { // if never connected, purge the peer
-			delete(peer_map, k)
-		}
-
-		if uint64(time.Now().UTC().Unix()) > (v.LastConnected + 3600) { // purge all peers which were not connected in
-			delete(peer_map, k)
+			n.addrman.remove(v.Address)
+		} else if !v.Whitelist && now > v.LastSeen+ADDR_HORIZON {
+			n.addrman.remove(v.Address)
+		} else if v.Whitelist && now > v.LastSeen+ADDR_TRIED_HORIZON {
+			n.addrman.remove(v.Address)
 		}
 	}
 }
 
 // check whether an IP is in the map already
-func IsPeerInList(address string) bool {
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
+func (n *node) IsPeerInList(address string) bool {
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
 
-	if _, ok := peer_map[ParseIPNoError(address)]; ok {
+	if _, ok := n.addrman.peers[ParseIPNoError(address)]; ok {
 		return true
 	}
 	return false
 }
-func GetPeerInList(address string) *Peer {
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
+func (n *node) GetPeerInList(address string) *Peer {
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
 
-	if v, ok := peer_map[ParseIPNoError(address)]; ok {
+	if v, ok := n.addrman.peers[ParseIPNoError(address)]; ok {
 		return v
 	}
 	return nil
 }
 
-// add connection to  map
-func Peer_Add(p *Peer) {
-	clean_up()
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
+// add peer to new buckets, if it is already known only its freshness is updated
+func (n *node) Peer_Add(p *Peer) {
+	n.clean_up()
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
 
-	if p.ID == GetPeerID() { // if peer is self do not connect
+	if p.ID == n.GetPeerID() { // if peer is self do not connect
 		// logger.Infof("Peer is ourselves, discard")
 		return
 
 	}
 
-	if v, ok := peer_map[ParseIPNoError(p.Address)]; ok {
+	now := uint64(time.Now().UTC().Unix())
+	if p.LastSeen > now { // nobody can see peers in future
+		p.LastSeen = now
+	}
+	if p.Source == "" {
+		p.Source = netgroup(p.Address)
+	}
+
+	if v, ok := n.addrman.peers[ParseIPNoError(p.Address)]; ok {
 		v.Lock()
 		// logger.Infof("Peer already in list adding good count")
 		v.GoodCount++
+		if p.LastSeen > v.LastSeen {
+			v.LastSeen = p.LastSeen
+		}
+		if p.Identity != "" { // only set from handshakes, gossip never carries identities
+			v.Identity = p.Identity
+		}
 		v.Unlock()
-	} else {
+	} else if now <= p.LastSeen+ADDR_HORIZON { // stale addresses are not worth keeping
 		// logger.Infof("Peer adding to list")
-		peer_map[ParseIPNoError(p.Address)] = p
+		p.Whitelist = false
+		n.addrman.insert(p)
+	}
+}
+
+// adds addresses gossiped by a peer, every peer can only feed us ADDR_RELAY_RATE addresses per second
+func (c *Connection) add_peer_list(list []Peer_Info, max int) {
+	source := netgroup(Address(c))
+	now := uint64(time.Now().UTC().Unix())
+	for i := range list {
+		if i >= max {
+			break
+		}
+		if !c.addr_limiter.Allow() {
+			c.logger.V(2).Info("Peer is sending addresses too fast, ignoring rest", "ignored", len(list)-i)
+			break
+		}
+		last_seen := list[i].LastSeen
+		if last_seen == 0 { // peer does not track freshness
+			last_seen = now - ADDR_UNKNOWN_AGE
+		}
+		c.node.Peer_Add(&Peer{Address: list[i].Addr, ID: list[i].ID, Miner: list[i].Miner, LastSeen: last_seen, LastConnected: now, Source: source})
 	}
 }
 
 // a peer marked as fail, will only be connected  based on exponential back-off based on powers of 2
-func Peer_SetFail(address string) {
-	p := GetPeerInList(ParseIPNoError(address))
+func (n *node) Peer_SetFail(address string) {
+	p := n.GetPeerInList(ParseIPNoError(address))
 	if p == nil {
 		return
 	}
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
 	p.FailCount++ //  increase fail count, and mark for delayed connect
 	p.ConnectAfter = uint64(time.Now().UTC().Unix()) + 1<<(p.FailCount-1)
 }
 
 // set peer as successfully connected
 // we will only distribute peers which have been successfully connected by us
-func Peer_SetSuccess(address string) {
+func (n *node) Peer_SetSuccess(address string) {
 	//logger.Infof("Setting peer as success")
-	p := GetPeerInList(ParseIPNoError(address))
+	p := n.GetPeerInList(ParseIPNoError(address))
 	if p == nil {
 		return
 	}
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
 	p.FailCount = 0 //  fail count is zero again
 	p.ConnectAfter = 0
-	p.Whitelist = true
 	p.LastConnected = uint64(time.Now().UTC().Unix()) // set time when last connected
+	p.LastSeen = p.LastConnected
+	n.addrman.mark_tried(p)
 
 	// logger.Infof("Setting peer as white listed")
 }
 
+// remember the score of the connection, so reconnecting does not reset it
+func (n *node) Peer_SetScore(address string, score int64) {
+	p := n.GetPeerInList(ParseIPNoError(address))
+	if p == nil {
+		return
+	}
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
+	p.Score = score
+}
+
+// score a new connection to this address starts with
+func (n *node) peer_score(address string) int64 {
+	p := n.GetPeerInList(ParseIPNoError(address))
+	if p == nil {
+		return 0
+	}
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
+	return p.Score
+}
+
 /*
  //TODO do we need a functionality so some peers are never banned
 func Peer_DisableBan(address string) (err error){
@@ -233,23 +275,27 @@ func Peer_EnableBan(address string) (err error){
 }
 */
 
-// add connection to  map
-func Peer_Delete(p *Peer) {
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
-	delete(peer_map, ParseIPNoError(p.Address))
+// remove peer from address manager
+func (n *node) Peer_Delete(p *Peer) {
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
+	n.addrman.remove(p.Address)
 }
 
 // prints all the connection info to screen
 func PeerList_Print() {
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
+	default_node.PeerList_Print()
+}
+
+func (n *node) PeerList_Print() {
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
 	fmt.Printf("Peer List\n")
-	fmt.Printf("%-22s %-6s %-4s   %-5s %-7s %9s %3s\n", "Remote Addr", "Active", "Good", "Fail", " State", "Height", "DIR")
+	fmt.Printf("%-22s %-16s %-6s %-4s   %-5s %-7s %9s %3s\n", "Remote Addr", "Identity", "Active", "Good", "Fail", " State", "Height", "DIR")
 
 	var list []*Peer
 	greycount := 0
-	for _, v := range peer_map {
+	for _, v := range n.addrman.peers {
 		if v.Whitelist { // only display white listed peer
 			list = append(list, v)
 		} else {
@@ -262,47 +308,47 @@ func PeerList_Print() {
 
 	for i := range list {
 		connected := ""
-		if IsAddressConnected(ParseIPNoError(list[i].Address)) {
+		if n.IsAddressConnected(ParseIPNoError(list[i].Address)) {
 			connected = "ACTIVE"
 		}
-		fmt.Printf("%-22s %-6s %4d %5d \n", list[i].Address, connected, list[i].GoodCount, list[i].FailCount)
+		identity := list[i].Identity
+		if len(identity) > 16 {
+			identity = identity[:16]
+		}
+		fmt.Printf("%-22s %-16s %-6s %4d %5d \n", list[i].Address, identity, connected, list[i].GoodCount, list[i].FailCount)
 	}
 
-	fmt.Printf("\nWhitelist size %d\n", len(peer_map)-greycount)
-	fmt.Printf("Greylist size %d\n", greycount)
+	fmt.Printf("\nWhitelist(tried) size %d\n", len(n.addrman.peers)-greycount)
+	fmt.Printf("Greylist(new) size %d\n", greycount)
 
 }
 
 // this function return peer count which are in our list
-func Peer_Counts() (Count uint64) {
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
-	return uint64(len(peer_map))
+func (n *node) Peer_Counts() (Count uint64) {
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
+	return uint64(len(n.addrman.peers))
 }
 
 // this function finds a possible peer to connect to keeping blacklist and already existing connections into picture
 // it must not be already connected using outgoing connection
 // we do allow loops such as both  incoming/outgoing simultaneously
+// only a single outgoing connection is made per network group, so one entity cannot occupy all our outgoing slots
 // this will return atmost 1 address, empty address if peer list is empty
-func find_peer_to_connect(version int) *Peer {
-	defer clean_up()
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
-
-	// first search the whitelisted ones
-	for _, v := range peer_map {
-		if uint64(time.Now().Unix()) > v.BlacklistBefore && //  if ip is blacklisted skip it
-			uint64(time.Now().Unix()) > v.ConnectAfter &&
-			!IsAddressConnected(ParseIPNoError(v.Address)) && v.Whitelist && !IsAddressInBanList(ParseIPNoError(v.Address)) {
-			v.ConnectAfter = uint64(time.Now().UTC().Unix()) + 10 // minimum 10 secs gap
-			return v
-		}
-	}
-	// if we donot have any white listed, choose from the greylist
-	for _, v := range peer_map {
-		if uint64(time.Now().Unix()) > v.BlacklistBefore && //  if ip is blacklisted skip it
-			uint64(time.Now().Unix()) > v.ConnectAfter &&
-			!IsAddressConnected(ParseIPNoError(v.Address)) && !v.Whitelist && !IsAddressInBanList(ParseIPNoError(v.Address)) {
+func (n *node) find_peer_to_connect(version int) *Peer {
+	defer n.clean_up()
+	groups := n.outgoing_groups()
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
+
+	// peers with low score are only tried if nobody else is available
+	for _, deprioritized := range []bool{false, true} {
+		v := n.addrman.pick(func(v *Peer) bool {
+			return uint64(time.Now().Unix()) > v.BlacklistBefore && //  if ip is blacklisted skip it
+				uint64(time.Now().Unix()) > v.ConnectAfter && (v.Score < Deprioritize_Score) == deprioritized &&
+				!groups[netgroup(v.Address)] && !n.IsAddressConnected(ParseIPNoError(v.Address)) && !n.IsAddressInBanList(ParseIPNoError(v.Address))
+		})
+		if v != nil {
 			v.ConnectAfter = uint64(time.Now().UTC().Unix()) + 10 // minimum 10 secs gap
 			return v
 		}
@@ -311,28 +357,35 @@ func find_peer_to_connect(version int) *Peer {
 	return nil // if no peer found, return nil
 }
 
-// return white listed peer list
-// for use in handshake
-func get_peer_list() (peers []Peer_Info) {
-	peer_mutex.Lock()
-	defer peer_mutex.Unlock()
-
-	for _, v := range peer_map { // trim the white list
-		if v.Whitelist && !IsAddressConnected(ParseIPNoError(v.Address)) {
-			delete(peer_map, ParseIPNoError(v.Address))
+// network groups we already have outgoing connections to
+func (n *node) outgoing_groups() map[string]bool {
+	groups := map[string]bool{}
+	n.connection_map.Range(func(k, value interface{}) bool {
+		if c := value.(*Connection); !c.Incoming {
+			groups[netgroup(Address(c))] = true
 		}
-	}
+		return true
+	})
+	return groups
+}
 
-	for _, v := range peer_map {
-		if v.Whitelist {
-			peers = append(peers, Peer_Info{Addr: v.Address})
+// return fresh white listed peer list along with when they were last seen
+// for use in handshake
+func (n *node) get_peer_list() (peers []Peer_Info) {
+	n.peer_mutex.Lock()
+	defer n.peer_mutex.Unlock()
+
+	now := uint64(time.Now().UTC().Unix())
+	for _, v := range n.addrman.peers {
+		if v.Whitelist && now <= v.LastSeen+ADDR_HORIZON && v.Score >= Deprioritize_Score { // do not advertise misbehaving peers
+			peers = append(peers, Peer_Info{Addr: v.Address, Miner: v.Miner, ID: v.ID, LastSeen: v.LastSeen})
 		}
 	}
 	return
 }
 
-func get_peer_list_specific(addr string) (peers []Peer_Info) {
-	plist := get_peer_list()
+func (n *node) get_peer_list_specific(addr string) (peers []Peer_Info) {
+	plist := n.get_peer_list()
 	sort.SliceStable(plist, func(i, j int) bool { return plist[i].Addr < plist[j].Addr })
 
 	if len(plist) <= 31 {
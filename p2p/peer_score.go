@@ -0,0 +1,244 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file implements per connection scoring
+ * misbehaviour lowers the score, useful relays raise it
+ * connections below Deprioritize_Score are used last for syncing, relaying and reconnecting
+ * connections reaching Ban_Score are disconnected and their address is banned automatically
+ */
+import "fmt"
+import "sort"
+import "time"
+import "strconv"
+import "sync/atomic"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/metrics"
+
+type score_reason int
+
+const (
+	score_invalid_block       score_reason = iota // block or miniblock which fails PoW or cannot be deserialized
+	score_invalid_tx                              // tx which cannot be deserialized
+	score_rejected_tx                             // tx rejected by mempool, may happen to honest peers due to races
+	score_bad_chunk                               // chunk which fails verification
+	score_slow_response                           // GetObject took longer than slow_response_time
+	score_empty_response                          // GetObject failed or did not return what was requested
+	score_handshake_violation                     // handshake with wrong network, malformed or oversized fields
+	score_protocol_violation                      // malformed requests or notifications
+	score_useful_relay                            // relayed a block, miniblock or tx which we accepted
+	score_reason_count
+)
+
+var score_reasons = [score_reason_count]struct {
+	name   string
+	weight int64
+}{
+	{"invalid_block", -100},
+	{"invalid_tx", -20},
+	{"rejected_tx", -2},
+	{"bad_chunk", -25},
+	{"slow_response", -5},
+	{"empty_response", -10},
+	{"handshake_violation", -50},
+	{"protocol_violation", -25},
+	{"useful_relay", 1},
+}
+
+// rewards are capped, so good behaviour cannot be banked to cover later misbehaviour
+const SCORE_MAX = 100
+
+// GetObject responses slower than this are penalized
+const slow_response_time = 5 * time.Second
+
+var Ban_Score = int64(-100)          // connections reaching this score are disconnected and banned
+var Deprioritize_Score = int64(-30)  // connections below this score are used last
+var Ban_Score_Seconds = uint64(3600) // duration of automatic bans
+
+// parse score thresholds from command line
+func score_init() {
+	if _, ok := globals.Arguments["--ban-score"]; ok && globals.Arguments["--ban-score"] != nil {
+		i, err := strconv.ParseInt(globals.Arguments["--ban-score"].(string), 10, 64)
+		if err != nil {
+			logger.Error(err, "Error Parsing --ban-score")
+		} else if i >= 0 {
+			logger.Error(fmt.Errorf("--ban-score should be negative"), "")
+		} else {
+			Ban_Score = i
+		}
+	}
+
+	if _, ok := globals.Arguments["--deprioritize-score"]; ok && globals.Arguments["--deprioritize-score"] != nil {
+		i, err := strconv.ParseInt(globals.Arguments["--deprioritize-score"].(string), 10, 64)
+		if err != nil {
+			logger.Error(err, "Error Parsing --deprioritize-score")
+		} else if i <= Ban_Score || i > 0 {
+			logger.Error(fmt.Errorf("--deprioritize-score should be between --ban-score and 0"), "")
+		} else {
+			Deprioritize_Score = i
+		}
+	}
+	logger.V(1).Info("Peer scoring", "ban-score", Ban_Score, "deprioritize-score", Deprioritize_Score)
+}
+
+// applies reason to the connection score and acts on the thresholds
+func (c *Connection) score(reason score_reason) int64 {
+	r := score_reasons[reason]
+
+	var score int64
+	for {
+		old := atomic.LoadInt64(&c.Score)
+		if score = old + r.weight; score > SCORE_MAX {
+			score = SCORE_MAX
+		}
+		if atomic.CompareAndSwapInt64(&c.Score, old, score) {
+			break
+		}
+	}
+
+	c.score_mutex.Lock()
+	if c.score_events == nil {
+		c.score_events = map[string]uint64{}
+	}
+	c.score_events[r.name]++
+	c.score_mutex.Unlock()
+
+	metrics.Set.GetOrCreateCounter(`p2p_score_events_total{reason="` + r.name + `"}`).Inc()
+	c.node.Peer_SetScore(Address(c), score)
+
+	if r.weight < 0 {
+		c.logger.V(1).Info("Peer penalized", "reason", r.name, "score", score)
+		if score <= Ban_Score {
+			c.ban_for_score(r.name, score)
+		}
+	}
+	return score
+}
+
+// whether the connection should be used only if nothing better is available
+func (c *Connection) deprioritized() bool {
+	return atomic.LoadInt64(&c.Score) < Deprioritize_Score
+}
+
+// seed nodes, exclusive and priority nodes are never banned, they are only disconnected
+func (c *Connection) ban_for_score(reason string, score int64) {
+	address := Address(c)
+	if c.SyncNode || c.node.is_nonbannable(address) {
+		c.logger.Info("Disconnecting misbehaving peer which cannot be banned", "reason", reason, "score", score)
+	} else if err := c.node.Ban_Address(address, Ban_Score_Seconds); err != nil {
+		c.logger.Error(err, "Banning misbehaving peer failed", "reason", reason, "score", score)
+	} else {
+		c.logger.Info("Banned misbehaving peer", "reason", reason, "score", score, "seconds", Ban_Score_Seconds)
+		metrics.Set.GetOrCreateCounter("p2p_score_bans_total").Inc()
+	}
+	c.exit()
+}
+
+func (n *node) is_nonbannable(address string) bool {
+	n.ban_mutex.Lock()
+	defer n.ban_mutex.Unlock()
+	for i := range n.nonbanlist {
+		if address == n.nonbanlist[i] || address == ParseIPNoError(n.nonbanlist[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// scores drift back towards zero, so old misbehaviour and old rewards are forgotten slowly
+// remembered scores of peers decay as well, scores of connected peers are then replaced by their connection score
+func (n *node) score_decay() {
+	n.peer_mutex.Lock()
+	for _, p := range n.addrman.peers {
+		p.Score = decay_score(p.Score)
+	}
+	n.peer_mutex.Unlock()
+
+	n.connection_map.Range(func(k, value interface{}) bool {
+		c := value.(*Connection)
+		for {
+			old := atomic.LoadInt64(&c.Score)
+			score := decay_score(old)
+			if score == old || atomic.CompareAndSwapInt64(&c.Score, old, score) {
+				n.Peer_SetScore(Address(c), score)
+				break
+			}
+		}
+		return true
+	})
+}
+
+// moves score 10% towards zero, atleast by 1
+func decay_score(score int64) int64 {
+	step := score / 10
+	switch {
+	case score > 0 && step == 0:
+		step = 1
+	case score < 0 && step == 0:
+		step = -1
+	}
+	return score - step
+}
+
+// used in syncinfo and rpc
+func (c *Connection) score_status() map[string]uint64 {
+	c.score_mutex.Lock()
+	defer c.score_mutex.Unlock()
+	if len(c.score_events) == 0 {
+		return nil
+	}
+	events := map[string]uint64{}
+	for k, v := range c.score_events {
+		events[k] = v
+	}
+	return events
+}
+
+// return status of all connected peers with their scores
+func Peer_Status_List() (peers []rpc.Peer_Status) {
+	return default_node.Peer_Status_List()
+}
+
+func (n *node) Peer_Status_List() (peers []rpc.Peer_Status) {
+	n.connection_map.Range(func(k, value interface{}) bool {
+		c := value.(*Connection)
+		if atomic.LoadUint32(&c.State) == HANDSHAKE_PENDING || n.GetPeerID() == c.Peer_ID {
+			return true
+		}
+		peers = append(peers, rpc.Peer_Status{
+			Address:       Address(c),
+			Peer_ID:       c.Peer_ID,
+			Incoming:      c.Incoming,
+			Height:        atomic.LoadInt64(&c.Height),
+			TopoHeight:    atomic.LoadInt64(&c.TopoHeight),
+			Latency:       time.Duration(atomic.LoadInt64(&c.Latency)).Milliseconds(),
+			DaemonVersion: c.DaemonVersion,
+			Tag:           c.Tag,
+			Score:         atomic.LoadInt64(&c.Score),
+			Deprioritized: c.deprioritized(),
+			Score_Events:  c.score_status(),
+			BytesIn:       atomic.LoadUint64(&c.BytesIn),
+			BytesOut:      atomic.LoadUint64(&c.BytesOut),
+			Identity:      c.Identity,
+		})
+		return true
+	})
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Score > peers[j].Score })
+	return
+}
@@ -0,0 +1,98 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+import "net"
+import "time"
+import "testing"
+
+import "github.com/go-logr/logr"
+
+import "github.com/deroproject/derohe/globals"
+
+func Test_Score_Decay(t *testing.T) {
+	for _, c := range []struct{ score, expected int64 }{{0, 0}, {1, 0}, {-1, 0}, {9, 8}, {-9, -8}, {100, 90}, {-100, -90}} {
+		if actual := decay_score(c.score); actual != c.expected {
+			t.Fatalf("decay of %d expected %d actual %d", c.score, c.expected, actual)
+		}
+	}
+}
+
+// scores remembered for reconnecting must be forgotten slowly as well
+func Test_Score_Decay_Peer_List(t *testing.T) {
+	logger = logr.Discard()
+	globals.Logger = logr.Discard()
+
+	n := new_node()
+	n.Peer_Add(&Peer{Address: "203.0.113.9:18089", LastSeen: uint64(time.Now().UTC().Unix())})
+	n.Peer_SetScore("203.0.113.9:18089", -100)
+
+	n.score_decay()
+	if score := n.peer_score("203.0.113.9:18089"); score != -90 {
+		t.Fatalf("remembered score must decay, expected -90 actual %d", score)
+	}
+	for i := 0; i < 100; i++ {
+		n.score_decay()
+	}
+	if score := n.peer_score("203.0.113.9:18089"); score != 0 {
+		t.Fatalf("remembered score must be forgotten, actual %d", score)
+	}
+}
+
+func Test_Score_Thresholds(t *testing.T) {
+	logger = logr.Discard()
+	globals.Logger = logr.Discard()
+
+	n := new_node()
+	c := &Connection{node: n, Addr: &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 18089}, logger: logr.Discard()}
+
+	for i := 0; i < 2*SCORE_MAX; i++ {
+		c.score(score_useful_relay)
+	}
+	if c.Score != SCORE_MAX {
+		t.Fatalf("rewards must be capped at %d, actual %d", SCORE_MAX, c.Score)
+	}
+
+	c.Score = 0
+	c.score(score_slow_response)
+	if c.deprioritized() {
+		t.Fatalf("single slow response must not deprioritize")
+	}
+	for c.Score >= Deprioritize_Score {
+		c.score(score_empty_response)
+	}
+	if !c.deprioritized() || n.IsAddressInBanList(Address(c)) {
+		t.Fatalf("connection must be deprioritized but not banned, score %d", c.Score)
+	}
+
+	c.score(score_invalid_block)
+	if !n.IsAddressInBanList(Address(c)) {
+		t.Fatalf("connection must be banned, score %d", c.Score)
+	}
+	if events := c.score_status(); events["useful_relay"] != 2*SCORE_MAX || events["slow_response"] != 1 || events["invalid_block"] != 1 {
+		t.Fatalf("unexpected score events %+v", events)
+	}
+	n.UnBan_Address(Address(c))
+
+	// seed nodes and priority nodes are never banned
+	n.nonbanlist = append(n.nonbanlist, "203.0.113.8:18089")
+	priority := &Connection{node: n, Addr: &net.UDPAddr{IP: net.ParseIP("203.0.113.8"), Port: 18089}, logger: logr.Discard()}
+	priority.score(score_invalid_block)
+	if n.ban_map["203.0.113.8"] != 0 {
+		t.Fatalf("priority node must not be banned")
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file replays captures made using --p2p-capture against a local chain, see capture.go
+ * requests made by the captured peer are fed one by one into a fresh handler set over an in-memory pipe
+ * requests made by the handlers are answered using responses which the captured peer gave
+ * it is used to reproduce bugs seen in the wild, the local chain will be modified by the replay
+ */
+import "io"
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "bytes"
+
+import "github.com/cenkalti/rpc2"
+import "github.com/fxamacker/cbor/v2"
+
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/blockchain"
+
+// results of a replay for a single method
+type Replay_Stat struct {
+	Requests        int // requests made by captured peer
+	Errors          int // requests which failed during replay
+	Recorded_Errors int // requests which failed during capture
+	Changed         int // requests which failed during capture but not during replay, or vice versa
+	Served          int // requests made by handlers, answered from capture
+	Unanswered      int // requests made by handlers, which capture could not answer
+}
+
+// header frame together with its body frame if any
+type capture_message struct {
+	time     int64
+	incoming bool
+	header   RequestResponse
+	frame    []byte // raw header frame
+	body     []byte // raw body frame
+}
+
+// pair header frames with their body frames, the same way codec does
+func parse_capture(records []Capture_Record) (messages []capture_message, err error) {
+	var pending [2]*capture_message // indexed by direction, as frames of both directions are interleaved
+	for _, record := range records {
+		dir := 0
+		if record.Incoming {
+			dir = 1
+		}
+		if pending[dir] != nil {
+			pending[dir].body = record.Frame
+			messages = append(messages, *pending[dir])
+			pending[dir] = nil
+			continue
+		}
+
+		m := capture_message{time: record.Time, incoming: record.Incoming, frame: record.Frame}
+		if err = cbor.Unmarshal(record.Frame, &m.header); err != nil {
+			return nil, fmt.Errorf("invalid header frame in capture, err %s", err)
+		}
+		if m.header.Method != "" || m.header.Error == "" { // requests and successful responses carry a body
+			pending[dir] = &m
+		} else {
+			messages = append(messages, m)
+		}
+	}
+	return
+}
+
+// replays a capture against the chain, speed scales the time between requests, 0 replays as fast as possible
+func Replay_Capture(chain *blockchain.Blockchain, r io.Reader, speed float64) (stats map[string]*Replay_Stat, err error) {
+	info, records, err := Read_Capture(r)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := parse_capture(records)
+	if err != nil {
+		return nil, err
+	}
+
+	stats = map[string]*Replay_Stat{}
+	stat := func(method string) *Replay_Stat {
+		if stats[method] == nil {
+			stats[method] = &Replay_Stat{}
+		}
+		return stats[method]
+	}
+
+	our_methods := map[uint64]string{}        // seq of our requests to method
+	answers := map[string][]capture_message{} // responses of captured peer by method, in order
+	recorded_errors := map[uint64]string{}    // our response to requests of captured peer
+	var requests []capture_message            // requests of captured peer
+	for _, m := range messages {
+		switch {
+		case !m.incoming && m.header.Method != "":
+			our_methods[m.header.Seq] = m.header.Method
+		case !m.incoming:
+			recorded_errors[m.header.Seq] = m.header.Error
+		case m.header.Method != "":
+			requests = append(requests, m)
+		default:
+			if method, ok := our_methods[m.header.Seq]; ok {
+				answers[method] = append(answers[method], m)
+			}
+		}
+	}
+
+	if logger.GetSink() == nil { // replay tool does not call P2P_Init
+		logger = globals.Logger.WithName("P2P")
+	}
+
+	// fresh handler set, similar to process_outgoing_connection but without handshake
+	n := new_node()
+	n.chain = chain
+	n.logger = logger.WithName("replay")
+	n.arguments = map[string]interface{}{}
+	defer n.stop()
+
+	local, remote := net.Pipe()
+	client := rpc2.NewClientWithCodec(NewCBORCodec(local))
+	c := &Connection{node: n, Client: client, Conn: local, ConnTls: local, Addr: memory_addr(info.Remote), State: ACTIVE, Incoming: info.Incoming, addr_limiter: new_addr_limiter()}
+	c.logger = n.logger.WithName(info.Remote)
+	set_handlers(client)
+	client.State = rpc2.NewState()
+	client.State.Set("c", c)
+	go client.Run()
+	defer c.exit()
+
+	var lock sync.Mutex // protects stats, answers, waiting and writes to remote
+	waiting := map[uint64]chan string{}
+	closed := make(chan bool)
+
+	write := func(header RequestResponse, body []byte) error {
+		frame, err := cbor.Marshal(header)
+		if err != nil {
+			return err
+		}
+		if err = write_frame(remote, frame); err == nil && body != nil {
+			err = write_frame(remote, body)
+		}
+		return err
+	}
+
+	go func() { // plays the captured peer
+		defer close(closed)
+		buf := new(bytes.Buffer)
+		for {
+			frame, err := read_frame(remote, buf)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			} else if err != nil {
+				return
+			}
+			var header RequestResponse
+			if err = cbor.Unmarshal(frame, &header); err != nil {
+				return
+			}
+			if header.Method != "" || header.Error == "" { // body is not needed
+				if _, err = read_frame(remote, buf); err != nil {
+					return
+				}
+			}
+
+			lock.Lock()
+			if header.Method != "" { // handlers made a request
+				if queue := answers[header.Method]; len(queue) == 0 {
+					stat(header.Method).Unanswered++
+					err = write(RequestResponse{Seq: header.Seq, Error: "not found in capture"}, nil)
+				} else {
+					answers[header.Method] = queue[1:]
+					stat(header.Method).Served++
+					err = write(RequestResponse{Seq: header.Seq, Error: queue[0].header.Error}, queue[0].body)
+				}
+			} else if ch, ok := waiting[header.Seq]; ok { // handlers responded
+				delete(waiting, header.Seq)
+				ch <- header.Error
+			}
+			lock.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	for i, m := range requests {
+		if speed > 0 {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(m.time-requests[0].time)/speed) * time.Microsecond)))
+		}
+
+		ch := make(chan string, 1)
+		lock.Lock()
+		s := stat(m.header.Method)
+		s.Requests++
+		if recorded_errors[m.header.Seq] != "" {
+			s.Recorded_Errors++
+		}
+		waiting[m.header.Seq] = ch
+		err = write_frame(remote, m.frame)
+		if err == nil {
+			err = write_frame(remote, m.body)
+		}
+		lock.Unlock()
+		if err != nil {
+			return stats, fmt.Errorf("connection closed by handlers after %d requests", i)
+		}
+
+		select {
+		case e := <-ch:
+			if e != "" {
+				s.Errors++
+				n.logger.V(1).Info("replayed request failed", "method", m.header.Method, "seq", m.header.Seq, "error", e, "recorded_error", recorded_errors[m.header.Seq])
+			}
+			if (e == "") != (recorded_errors[m.header.Seq] == "") {
+				s.Changed++
+			}
+		case <-closed:
+			return stats, fmt.Errorf("connection closed by handlers after %d requests", i+1)
+		case <-time.After(READ_TIMEOUT):
+			return stats, fmt.Errorf("request %s seq %d timed out", m.header.Method, m.header.Seq)
+		}
+	}
+	return stats, nil
+}
@@ -31,67 +31,94 @@ var bufPool = &sync.Pool{
 
 // reads our data, length prefix blocks
 func Read_Data_Frame(r net.Conn, obj interface{}) error {
+	_, err := read_data_frame(r, obj, nil)
+	return err
+}
+
+// reads our data, length prefix blocks, frame is recorded if capture is not nil
+// returns bytes read from the wire
+func read_data_frame(r net.Conn, obj interface{}, capture *capture_file) (int, error) {
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+
+	data_buf, err := read_frame(r, buf)
+	if err != nil {
+		return 0, err
+	}
+	capture.record(true, data_buf)
+	if len(data_buf) == 0 {
+		return 4, nil
+	}
+	err = cbor.Unmarshal(data_buf, obj)
+
+	//fmt.Printf("Read object %+v raw %s\n",obj, data_buf)
+	return 4 + len(data_buf), err
+}
+
+// reads a raw frame into buf, returned data is only valid while buf is not reused
+func read_frame(r net.Conn, buf *bytes.Buffer) ([]byte, error) {
 	var frame_length_buf [4]byte
 
 	//connection.set_timeout()
 	r.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
 	nbyte, err := io.ReadFull(r, frame_length_buf[:])
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if nbyte != 4 {
-		return fmt.Errorf("needed 4 bytes, but got %d bytes", nbyte)
+		return nil, fmt.Errorf("needed 4 bytes, but got %d bytes", nbyte)
 	}
 
 	//  time to ban
 	frame_length := binary.LittleEndian.Uint32(frame_length_buf[:])
 	if frame_length == 0 {
-		return nil
+		return nil, nil
 	}
 	// most probably memory DDOS attack, kill the connection
 	if uint64(frame_length) > (5 * config.STARGATE_HE_MAX_BLOCK_SIZE) {
-		return fmt.Errorf("Frame length is too big Expected %d Actual %d", 5*config.STARGATE_HE_MAX_BLOCK_SIZE, frame_length)
+		return nil, fmt.Errorf("Frame length is too big Expected %d Actual %d", 5*config.STARGATE_HE_MAX_BLOCK_SIZE, frame_length)
 	}
 
-	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	buf.Grow(int(frame_length))
-	defer bufPool.Put(buf)
 
 	data_buf := buf.Bytes()
 	data_buf = data_buf[:frame_length]
 	data_size, err := io.ReadFull(r, data_buf)
 	if err != nil || data_size <= 0 || uint32(data_size) != frame_length {
-		return fmt.Errorf("Could not read data size  read %d, frame length %d err %s", data_size, frame_length, err)
+		return nil, fmt.Errorf("Could not read data size  read %d, frame length %d err %s", data_size, frame_length, err)
 	}
-	data_buf = data_buf[:frame_length]
-	err = cbor.Unmarshal(data_buf, obj)
-
-	//fmt.Printf("Read object %+v raw %s\n",obj, data_buf)
-	return err
+	return data_buf[:frame_length], nil
 }
 
-// reads our data, length prefix blocks
+// writes our data, length prefix blocks
 func Write_Data_Frame(w net.Conn, obj interface{}) error {
-	var frame_length_buf [4]byte
 	data_bytes, err := cbor.Marshal(obj)
 	if err != nil {
 		return err
 	}
+	//fmt.Printf("Wrote object %+v raw %s\n",obj, data_bytes)
+	return write_frame(w, data_bytes)
+}
+
+// writes a raw frame
+func write_frame(w net.Conn, data_bytes []byte) error {
+	var frame_length_buf [4]byte
 	binary.LittleEndian.PutUint32(frame_length_buf[:], uint32(len(data_bytes)))
 
 	w.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
-	if _, err = w.Write(frame_length_buf[:]); err != nil {
+	if _, err := w.Write(frame_length_buf[:]); err != nil {
 		return err
 	}
-	_, err = w.Write(data_bytes[:])
-	//fmt.Printf("Wrote object %+v raw %s\n",obj, data_bytes)
+	_, err := w.Write(data_bytes[:])
 	return err
 }
 
 // ClientCodec implements the rpc.ClientCodec interface for generic golang objects.
 type ClientCodec struct {
-	r net.Conn
+	r         net.Conn
+	capture   *capture_file         // if not nil, every frame is recorded, see capture.go
+	bandwidth *connection_bandwidth // if not nil, frames are counted and throttled, see bandwidth.go
 	sync.Mutex
 }
 
@@ -107,9 +134,11 @@ func NewCBORCodec(conn net.Conn) *ClientCodec {
 // in the given request.
 func (c *ClientCodec) ReadResponseHeader(resp *rpc2.Response) error {
 	var header RequestResponse
-	if err := Read_Data_Frame(c.r, &header); err != nil {
+	size, err := read_data_frame(c.r, &header, c.capture)
+	if err != nil {
 		return err
 	}
+	c.bandwidth.header_received(&header, size)
 	//if header.Method == "" {
 	//	return fmt.Errorf("header missing method: %s", "no Method")
 	//}
@@ -122,6 +151,8 @@ func (c *ClientCodec) ReadResponseHeader(resp *rpc2.Response) error {
 
 // Close closes the underlying connection.
 func (c *ClientCodec) Close() error {
+	c.capture.close()
+	c.bandwidth.close()
 	return c.r.Close()
 }
 
@@ -130,9 +161,11 @@ func (c *ClientCodec) Close() error {
 // in the given request.
 func (s *ClientCodec) ReadHeader(req *rpc2.Request, resp *rpc2.Response) error {
 	var header RequestResponse
-	if err := Read_Data_Frame(s.r, &header); err != nil {
+	size, err := read_data_frame(s.r, &header, s.capture)
+	if err != nil {
 		return err
 	}
+	s.bandwidth.header_received(&header, size)
 
 	if header.Method != "" {
 		req.Seq = header.Seq
@@ -150,7 +183,9 @@ func (s *ClientCodec) ReadRequestBody(obj interface{}) error {
 	if obj == nil {
 		return nil
 	}
-	return Read_Data_Frame(s.r, obj)
+	size, err := read_data_frame(s.r, obj, s.capture)
+	s.bandwidth.body_received(size)
+	return err
 }
 
 // ReadResponseBody reads a 4 byte length from the connection and decodes that many
@@ -160,36 +195,70 @@ func (c *ClientCodec) ReadResponseBody(obj interface{}) error {
 	if obj == nil {
 		return nil
 	}
-	return Read_Data_Frame(c.r, obj)
+	size, err := read_data_frame(c.r, obj, c.capture)
+	c.bandwidth.body_received(size)
+	return err
 }
 
 // WriteRequest writes the 4 byte length from the connection and encodes that many
 // subsequent bytes into the given object.
 func (c *ClientCodec) WriteRequest(req *rpc2.Request, obj interface{}) error {
-	c.Lock()
-	defer c.Unlock()
-
 	header := RequestResponse{Method: req.Method, Seq: req.Seq}
-	if err := Write_Data_Frame(c.r, header); err != nil {
+	frames, err := marshal_frames(header, obj)
+	if err != nil {
 		return err
 	}
-	return Write_Data_Frame(c.r, obj)
+	c.bandwidth.request_sent(req.Seq, req.Method, frames_size(frames)) // throttle before taking lock, so other frames may pass
+	return c.write_frames(frames)
 }
 
 // WriteResponse writes the appropriate header. If
 // the response was invalid, the size of the body of the resp is reported as
 // having size zero and is not sent.
 func (c *ClientCodec) WriteResponse(resp *rpc2.Response, obj interface{}) error {
-	c.Lock()
-	defer c.Unlock()
 	header := RequestResponse{Seq: resp.Seq, Error: resp.Error}
-	if err := Write_Data_Frame(c.r, header); err != nil {
+	var frames [][]byte
+	var err error
+	if resp.Error == "" { // only write response object if error is nil
+		frames, err = marshal_frames(header, obj)
+	} else {
+		frames, err = marshal_frames(header)
+	}
+	if err != nil {
 		return err
 	}
+	c.bandwidth.response_sent(resp.Seq, obj, frames_size(frames))
+	return c.write_frames(frames)
+}
 
-	if resp.Error == "" { // only write response object if error is nil
-		return Write_Data_Frame(c.r, obj)
+func marshal_frames(objs ...interface{}) (frames [][]byte, err error) {
+	for _, obj := range objs {
+		var data_bytes []byte
+		if data_bytes, err = cbor.Marshal(obj); err != nil {
+			return nil, err
+		}
+		frames = append(frames, data_bytes)
 	}
+	return
+}
 
+// bytes on the wire including length prefixes
+func frames_size(frames [][]byte) (size int) {
+	for _, frame := range frames {
+		size += 4 + len(frame)
+	}
+	return
+}
+
+// frames of a request or response are written together
+func (c *ClientCodec) write_frames(frames [][]byte) error {
+	c.Lock()
+	defer c.Unlock()
+	for _, frame := range frames {
+		c.capture.record(false, frame)
+		if err := write_frame(c.r, frame); err != nil {
+			return err
+		}
+	}
 	return nil
 }
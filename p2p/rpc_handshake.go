@@ -30,11 +30,17 @@ import "github.com/blang/semver/v4"
 
 // verify incoming handshake for number of checks such as mainnet/testnet etc etc
 func Verify_Handshake(handshake *Handshake_Struct) bool {
-	v := semver.MustParse(handshake.DaemonVersion)
+	v, err := semver.Parse(handshake.DaemonVersion)
+	if err != nil {
+		return false
+	}
 
 	if v.Major >= 3 && v.Minor >= 5 && v.Patch >= 0 {
 
 	} else {
+		if len(v.Pre) == 0 {
+			return false
+		}
 		var pre int
 		fmt.Sscanf(v.Pre[0].String(), "%d", &pre) // make sure previous releases can connect
 		if pre < 88 {
@@ -45,18 +51,19 @@ func Verify_Handshake(handshake *Handshake_Struct) bool {
 	return bytes.Equal(handshake.Network_ID[:], globals.Config.Network_ID[:])
 }
 
-func (handshake *Handshake_Struct) Fill() {
-	fill_common(&handshake.Common) // fill common info
+func (handshake *Handshake_Struct) Fill(n *node) {
+	n.fill_common(&handshake.Common) // fill common info
 
 	handshake.ProtocolVersion = "1.0.0"
 	handshake.DaemonVersion = config.Version.String()
-	handshake.Tag = node_tag
+	handshake.Tag = n.node_tag
 	handshake.UTC_Time = int64(time.Now().UTC().Unix()) // send our UTC time
-	handshake.Local_Port = uint32(P2P_Port)             // export requested or default port
-	handshake.Peer_ID = GetPeerID()                     // give our randomly generated peer id
-	handshake.Pruned = chain.LocatePruneTopo()
+	handshake.Local_Port = uint32(n.p2p_port)           // export requested or default port
+	handshake.Peer_ID = n.GetPeerID()                   // give our randomly generated peer id
+	handshake.Pruned = n.chain.LocatePruneTopo()
 
-	//	handshake.Flags = // add any flags necessary
+	handshake.Flags = n.flags
+	handshake.Extension_List = n.extensions
 
 	copy(handshake.Network_ID[:], globals.Config.Network_ID[:])
 }
@@ -66,10 +73,10 @@ func (handshake *Handshake_Struct) Fill() {
 func (connection *Connection) dispatch_test_handshake() {
 	defer handle_connection_panic(connection)
 	var request, response Handshake_Struct
-	request.Fill()
+	request.Fill(connection.node)
 
 	//scan our peer list and send peers which have been recently communicated
-	request.PeerList = get_peer_list_specific(Address(connection))
+	request.PeerList = connection.node.get_peer_list_specific(Address(connection))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
@@ -81,11 +88,19 @@ func (connection *Connection) dispatch_test_handshake() {
 
 	if !Verify_Handshake(&response) { // if not same network boot off
 		connection.logger.V(3).Info("terminating connection network id mismatch ", "networkid", response.Network_ID)
+		connection.score(score_handshake_violation)
 		connection.exit()
 		return
 	}
-	connection.update(&response.Common) // update common information
-	if !Connection_Add(connection) {    // add connection to pool
+	if !connection.verify_identity() { // pinned or private peers must prove their identity
+		connection.exit()
+		return
+	}
+	if len(response.ProtocolVersion) >= 128 || len(response.DaemonVersion) >= 128 || len(response.Tag) >= 128 || response.Local_Port > 65535 {
+		connection.score(score_handshake_violation)
+	}
+	connection.update(&response.Common)              // update common information
+	if !connection.node.Connection_Add(connection) { // add connection to pool
 		connection.exit()
 		return
 	}
@@ -98,6 +113,8 @@ func (connection *Connection) dispatch_test_handshake() {
 		connection.DaemonVersion = response.DaemonVersion
 	}
 	connection.Port = response.Local_Port
+	connection.compact = has_extension(connection.node.extensions, EXT_COMPACT_BLOCKS) && has_extension(response.Extension_List, EXT_COMPACT_BLOCKS)
+	connection.dandelion = has_extension(connection.node.flags, FLAG_DANDELION) && has_extension(response.Flags, FLAG_DANDELION)
 	connection.Peer_ID = response.Peer_ID
 	if len(response.Tag) < 128 {
 		connection.Tag = response.Tag
@@ -117,19 +134,17 @@ func (connection *Connection) dispatch_test_handshake() {
 			p.Address = fmt.Sprintf("[%s]:%d", Address(connection), connection.Port)
 		}
 		p.ID = connection.Peer_ID
+		p.Identity = connection.Identity
 
 		p.LastConnected = uint64(time.Now().UTC().Unix())
+		p.LastSeen = p.LastConnected
 
-		Peer_Add(&p)
+		connection.node.Peer_Add(&p)
 	}
 
 	// parse delivered peer list as grey list
 	connection.logger.V(4).Info("Peer provides peers", "count", len(response.PeerList))
-	for i := range response.PeerList {
-		if i < 13 {
-			Peer_Add(&Peer{Address: response.PeerList[i].Addr, LastConnected: uint64(time.Now().UTC().Unix())})
-		}
-	}
+	connection.add_peer_list(response.PeerList, 13)
 
 	atomic.StoreUint32(&connection.State, ACTIVE)
 }
@@ -139,7 +154,7 @@ func (c *Connection) Ping(request Dummy, response *Dummy) error {
 	defer handle_connection_panic(c)
 	fill_common_T1(&request.Common)
 	c.update(&request.Common)                             // update common information
-	fill_common(&response.Common)                         // fill common info
+	c.node.fill_common(&response.Common)                  // fill common info
 	fill_common_T0T1T2(&request.Common, &response.Common) // fill time related information
 	return nil
 }
@@ -147,7 +162,7 @@ func (c *Connection) Ping(request Dummy, response *Dummy) error {
 // serves handhake requests
 func (c *Connection) Handshake(request Handshake_Struct, response *Handshake_Struct) error {
 	defer handle_connection_panic(c)
-	if request.Peer_ID == GetPeerID() { // check if self connection exit
+	if request.Peer_ID == c.node.GetPeerID() { // check if self connection exit
 		//rlog.Tracef(1, "Same peer ID, probably self connection, disconnecting from this client")
 		c.exit()
 		return fmt.Errorf("Same peer ID")
@@ -155,22 +170,25 @@ func (c *Connection) Handshake(request Handshake_Struct, response *Handshake_Str
 
 	if !Verify_Handshake(&request) { // if not same network boot off
 		logger.V(2).Info("kill connection network id mismatch peer network id.", "Network_ID", request.Network_ID)
+		c.score(score_handshake_violation)
 		c.exit()
 		return fmt.Errorf("NID mismatch")
 	}
 
-	response.Fill()
+	if !c.verify_identity() {
+		c.exit()
+		return fmt.Errorf("identity not allowed")
+	}
+	atomic.StoreUint32(&c.handshake_accepted, 1)
+
+	response.Fill(c.node)
 
 	c.update(&request.Common) // update common information
-	if c.State == ACTIVE {
-		for i := range request.PeerList {
-			if i < 31 {
-				Peer_Add(&Peer{Address: request.PeerList[i].Addr, LastConnected: uint64(time.Now().UTC().Unix())})
-			}
-		}
+	if atomic.LoadUint32(&c.State) == ACTIVE {
+		c.add_peer_list(request.PeerList, 31)
 	}
 	if !c.Incoming {
-		Peer_SetSuccess(c.Addr.String())
+		c.node.Peer_SetSuccess(c.Addr.String())
 	}
 
 	return nil
@@ -31,6 +31,7 @@ import "github.com/deroproject/derohe/globals"
 // handles notifications of inventory
 func (c *Connection) NotifyINV(request ObjectList, response *Dummy) (err error) {
 	defer handle_connection_panic(c)
+	chain := c.node.chain
 	var need ObjectList
 	var dirty = false
 
@@ -55,6 +56,9 @@ func (c *Connection) NotifyINV(request ObjectList, response *Dummy) (err error)
 				time_to_receive := float64(globals.Time().UTC().UnixMicro()-request.Sent) / 1000000
 				metrics.Set.GetOrCreateHistogram("tx_propagation_duration_histogram_seconds").Update(time_to_receive)
 			}
+			if tx := chain.Mempool.Mempool_Get_TX(request.Tx_list[i]); tx != nil && chain.Mempool.Mempool_Is_Stem(request.Tx_list[i]) { // our stem tx has been fluffed by someone else
+				c.node.fluff_tx(tx, c.Peer_ID)
+			}
 			if !(chain.Mempool.Mempool_TX_Exist(request.Tx_list[i]) || chain.Regpool.Regpool_TX_Exist(request.Tx_list[i])) { // check if is already in mempool skip it
 				if _, err = chain.Store.Block_tx_store.ReadTX(request.Tx_list[i]); err != nil { // check whether the tx can be loaded from disk
 					need.Tx_list = append(need.Tx_list, request.Tx_list[i])
@@ -79,7 +83,7 @@ func (c *Connection) NotifyINV(request ObjectList, response *Dummy) (err error)
 			}
 
 			if !chain.Block_Exists(blid) { // check whether the block can be loaded from disk
-				if nil == is_chunk_exist(hhash, cid) { // if chunk does not exist
+				if nil == c.node.is_chunk_exist(hhash, cid) { // if chunk does not exist
 					c.logger.V(3).Info("requesting INV chunk", "blid", fmt.Sprintf("%x", blid), "cid", cid, "hhash", fmt.Sprintf("%x", hhash), "raw", fmt.Sprintf("%x", request.Chunk_list[i]))
 					need.Chunk_list = append(need.Chunk_list, request.Chunk_list[i])
 					dirty = true
@@ -90,8 +94,8 @@ func (c *Connection) NotifyINV(request ObjectList, response *Dummy) (err error)
 
 	if dirty { //  request inventory only if we want it
 		var oresponse Objects
-		fill_common(&need.Common) // fill common info
-		if err = c.Client.Call("Peer.GetObject", need, &oresponse); err != nil {
+		c.node.fill_common(&need.Common) // fill common info
+		if err = c.request_objects(need, &oresponse); err != nil {
 			c.logger.V(2).Error(err, "Call failed GetObject", "need_objects", need)
 			c.exit()
 			return
@@ -102,8 +106,8 @@ func (c *Connection) NotifyINV(request ObjectList, response *Dummy) (err error)
 		}
 	}
 
-	c.update(&request.Common)     // update common information
-	fill_common(&response.Common) // fill common info
+	c.update(&request.Common)            // update common information
+	c.node.fill_common(&response.Common) // fill common info
 
 	return nil
 
@@ -112,9 +116,11 @@ func (c *Connection) NotifyINV(request ObjectList, response *Dummy) (err error)
 // only miniblocks carry extra info, which leads to better time tracking
 func (c *Connection) NotifyMiniBlock(request Objects, response *Dummy) (err error) {
 	defer handle_connection_panic(c)
+	chain := c.node.chain
 	if len(request.MiniBlocks) >= 5 {
 		err = fmt.Errorf("Notify Block can notify max 5 miniblocks")
 		c.logger.V(3).Error(err, "Should be banned")
+		c.score(score_protocol_violation)
 		c.exit()
 		return err
 	}
@@ -126,6 +132,7 @@ func (c *Connection) NotifyMiniBlock(request Objects, response *Dummy) (err erro
 	for i := range request.MiniBlocks {
 		var mbl block.MiniBlock
 		if err = mbl.Deserialize(request.MiniBlocks[i]); err != nil {
+			c.score(score_invalid_block)
 			return err
 		}
 		mbls = append(mbls, mbl)
@@ -137,6 +144,7 @@ func (c *Connection) NotifyMiniBlock(request Objects, response *Dummy) (err erro
 		var ok bool
 
 		if mbl.Final {
+			c.score(score_protocol_violation)
 			return fmt.Errorf("final blocks are not propagted")
 		}
 
@@ -181,26 +189,29 @@ func (c *Connection) NotifyMiniBlock(request Objects, response *Dummy) (err erro
 
 		// lets get the difficulty at tips
 		if !chain.VerifyMiniblockPoW(&bl, mbl) {
+			c.score(score_invalid_block)
 			return errormsg.ErrInvalidPoW
 		}
 
 		if err, ok = chain.InsertMiniBlock(mbl); !ok {
 			return err
 		} else { // rebroadcast miniblock
+			c.score(score_useful_relay)
 			valid_found = true
 			if valid_found {
-				broadcast_MiniBlock(mbl, c.Peer_ID, request.Sent) // do not send back to the original peer
+				c.node.broadcast_MiniBlock(mbl, c.Peer_ID, request.Sent) // do not send back to the original peer
 			}
 		}
 	}
 
-	fill_common(&response.Common)                         // fill common info
+	c.node.fill_common(&response.Common)                  // fill common info
 	fill_common_T0T1T2(&request.Common, &response.Common) // fill time related information
 	return nil
 }
 
 func (c *Connection) processChunkedBlock(request Objects, data_shard_count, parity_shard_count int) error {
 	var err error
+	chain := c.node.chain
 
 	var cbl block.Complete_Block // parse incoming block and deserialize it
 	var bl block.Block
@@ -209,6 +220,7 @@ func (c *Connection) processChunkedBlock(request Objects, data_shard_count, pari
 	err = bl.Deserialize(request.CBlocks[0].Block)
 	if err != nil { // we have a block which could not be deserialized ban peer
 		c.logger.V(3).Error(err, "Block cannot be deserialized.Should be banned")
+		c.score(score_invalid_block)
 		c.exit()
 		return err
 	}
@@ -228,6 +240,7 @@ func (c *Connection) processChunkedBlock(request Objects, data_shard_count, pari
 			err = tx.Deserialize(request.CBlocks[0].Txs[j])
 			if err != nil { // we have a tx which could not be deserialized ban peer
 				c.logger.V(3).Error(err, "tx cannot be deserialized.Should be banned")
+				c.score(score_invalid_tx)
 				c.exit()
 				return err
 			}
@@ -242,11 +255,13 @@ func (c *Connection) processChunkedBlock(request Objects, data_shard_count, pari
 	atomic.StoreInt64(&c.LastObjectRequestTime, time.Now().Unix())
 	// check if we can add ourselves to chain
 	if err, ok := chain.Add_Complete_Block(&cbl); ok { // if block addition was successfil
+		c.score(score_useful_relay)
 		// notify all peers
-		Broadcast_Block(&cbl, c.Peer_ID) // do not send back to the original peer
+		c.node.Broadcast_Block(&cbl, c.Peer_ID) // do not send back to the original peer
 	} else { // ban the peer for sometime
 		if err == errormsg.ErrInvalidPoW {
 			c.logger.Error(err, "This peer should be banned and terminated")
+			c.score(score_invalid_block)
 			c.exit()
 			return err
 		}
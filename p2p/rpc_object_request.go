@@ -17,15 +17,21 @@
 package p2p
 
 import "fmt"
+import "time"
+import "encoding/binary"
+
+import "github.com/deroproject/derohe/cryptography/crypto"
 
 // peer has requested some objects, we must respond
 // if certain object is not in our list we respond with empty buffer for that slot
 // an object is either a block or a tx
 func (connection *Connection) GetObject(request ObjectList, response *Objects) error {
 	defer handle_connection_panic(connection)
+	chain := connection.node.chain
 	var err error
-	if len(request.Block_list) < 1 && len(request.Tx_list) < 1 && len(request.Chunk_list) < 1 { // we are expecting 1 block or 1 tx
+	if len(request.Block_list) < 1 && len(request.Tx_list) < 1 && len(request.Chunk_list) < 1 && len(request.Block_Tx_list) < 1 { // we are expecting 1 block or 1 tx
 		connection.logger.V(2).Info("malformed object request  received, banning peer", "request", request)
+		connection.score(score_protocol_violation)
 		connection.exit()
 		return nil
 	}
@@ -38,6 +44,9 @@ func (connection *Connection) GetObject(request ObjectList, response *Objects) e
 			return err
 		}
 		cbl.Block = bl.Serialize()
+		if int64(bl.Height)+HISTORICAL_DEPTH < chain.Get_Height() {
+			response.historical = true
+		}
 		for j := range bl.Tx_hashes {
 			var tx_bytes []byte
 			if tx_bytes, err = chain.Store.Block_tx_store.ReadTX(bl.Tx_hashes[j]); err != nil {
@@ -51,23 +60,38 @@ func (connection *Connection) GetObject(request ObjectList, response *Objects) e
 
 	for i := range request.Tx_list { // find the tx on our side
 		var tx_bytes []byte
-		if tx := chain.Mempool.Mempool_Get_TX(request.Tx_list[i]); tx != nil { // if tx can be satisfied from pool, so be it
-			tx_bytes = tx.Serialize()
-		} else if tx := chain.Regpool.Regpool_Get_TX(request.Tx_list[i]); tx != nil { // if tx can be satisfied from regpool, so be it
-			tx_bytes = tx.Serialize()
-		} else if tx_bytes, err = chain.Store.Block_tx_store.ReadTX(request.Tx_list[i]); err != nil {
+		if tx_bytes, err = connection.load_tx(request.Tx_list[i]); err != nil {
 			return err
 		}
 		response.Txs = append(response.Txs, tx_bytes) // append all the txs
 	}
 
+	for i := range request.Block_Tx_list { // txs requested by position within a block, they follow txs of Tx_list
+		var blid crypto.Hash
+		copy(blid[:], request.Block_Tx_list[i][:32])
+		index := binary.BigEndian.Uint16(request.Block_Tx_list[i][32:])
+
+		bl, err := chain.Load_BL_FROM_ID(blid)
+		if err != nil {
+			return err
+		}
+		if int(index) >= len(bl.Tx_hashes) {
+			return fmt.Errorf("block %s has no tx at %d", blid, index)
+		}
+		var tx_bytes []byte
+		if tx_bytes, err = connection.load_tx(bl.Tx_hashes[index]); err != nil {
+			return err
+		}
+		response.Txs = append(response.Txs, tx_bytes)
+	}
+
 	for i := range request.Chunk_list { // find the chunk  on our end
 		var blid, hhash [32]byte
 		copy(blid[:], request.Chunk_list[i][:])
 		cid := request.Chunk_list[i][32]
 		copy(hhash[:], request.Chunk_list[i][33:])
 
-		if chunk := is_chunk_exist(hhash, cid); chunk == nil {
+		if chunk := connection.node.is_chunk_exist(hhash, cid); chunk == nil {
 			return fmt.Errorf("no such chunk %x %x %x cid %d %2x", blid, cid, hhash, cid, cid)
 		} else { // we do have the chunk, pass it on
 			response.Chunks = append(response.Chunks, *chunk) // append the chunk
@@ -75,9 +99,36 @@ func (connection *Connection) GetObject(request ObjectList, response *Objects) e
 	}
 
 	// if everything is OK, we must respond with object response
-	fill_common(&response.Common) // fill common info
+	connection.node.fill_common(&response.Common) // fill common info
 	response.Sent = request.Sent
 
 	//rlog.Tracef(3, "OBJECT RESPONSE SENT  sent size %d %s", len(serialized), connection.logid)
 	return nil
 }
+
+// tx can be satisfied from mempool, regpool or from disk
+func (connection *Connection) load_tx(txid crypto.Hash) ([]byte, error) {
+	chain := connection.node.chain
+	if tx := chain.Mempool.Mempool_Get_TX(txid); tx != nil && !chain.Mempool.Mempool_Is_Stem(txid) { // if tx can be satisfied from pool, so be it, stem txs are never served
+		return tx.Serialize(), nil
+	} else if tx := chain.Regpool.Regpool_Get_TX(txid); tx != nil { // if tx can be satisfied from regpool, so be it
+		return tx.Serialize(), nil
+	}
+	return chain.Store.Block_tx_store.ReadTX(txid)
+}
+
+// request objects from the peer, slow responses and responses missing requested objects are penalized
+func (connection *Connection) request_objects(request ObjectList, response *Objects) error {
+	start := time.Now()
+	if err := connection.Client.Call("Peer.GetObject", request, response); err != nil {
+		connection.score(score_empty_response)
+		return err
+	}
+	if time.Now().Sub(start) > slow_response_time {
+		connection.score(score_slow_response)
+	}
+	if len(response.CBlocks) < len(request.Block_list) || len(response.Txs) < len(request.Tx_list)+len(request.Block_Tx_list) || len(response.Chunks) < len(request.Chunk_list) {
+		connection.score(score_empty_response)
+	}
+	return nil
+}
@@ -21,6 +21,7 @@ import "github.com/deroproject/graviton"
 // get parts of the specified balance tree chunk by chunk
 func (c *Connection) TreeSection(request Request_Tree_Section_Struct, response *Response_Tree_Section_Struct) (err error) {
 	defer handle_connection_panic(c)
+	chain := c.node.chain
 	if request.Topo < 2 || request.SectionLength > 256 || len(request.Section) < int(request.SectionLength/8) { // we are expecting 1 block or 1 tx
 		c.logger.V(1).Info("malformed object request  received, banning peer", "request", request)
 		c.exit()
@@ -38,18 +39,12 @@ func (c *Connection) TreeSection(request Request_Tree_Section_Struct, response *
 		var topo_balance_tree *graviton.Tree
 		if topo_ss, err = chain.Store.Balance_store.LoadSnapshot(topo_sr.State_Version); err == nil {
 			if topo_balance_tree, err = topo_ss.GetTree(string(request.TreeName)); err == nil {
-				cursor := topo_balance_tree.Cursor()
 				response.KeyCount = topo_balance_tree.KeyCountEstimate()
-				for k, v, err := cursor.SpecialFirst(request.Section, uint(request.SectionLength)); err == nil; k, v, err = cursor.Next() {
-					response.Keys = append(response.Keys, k)
-					response.Values = append(response.Values, v)
-
-					if len(response.Keys) > 10000 {
-						break
-					}
-
+				response.Keys, response.Values = tree_section_list(topo_balance_tree, request.Section, uint(request.SectionLength))
+				var root [32]byte
+				if root, err = topo_balance_tree.Hash(); err == nil {
+					response.StateHash = root // lets the requester verify the section
 				}
-				err = nil
 
 			}
 
@@ -62,7 +57,40 @@ func (c *Connection) TreeSection(request Request_Tree_Section_Struct, response *
 	}
 
 	// if everything is OK, we must respond with object response
-	fill_common(&response.Common) // fill common info
+	c.node.fill_common(&response.Common) // fill common info
 	return nil
 
 }
+
+// lists keys within the section, at most fastsync_max_section+1 keys are returned
+func tree_section_list(tree *graviton.Tree, section []byte, section_length uint) (keys, values [][]byte) {
+	cursor := tree.Cursor()
+	for k, v, err := cursor.SpecialFirst(section, section_length); err == nil; k, v, err = cursor.Next() {
+		keys = append(keys, k)
+		values = append(values, v)
+		if len(keys) > fastsync_max_section {
+			return
+		}
+	}
+
+	// graviton stores a single key as high in the tree as possible and the cursor cannot descend below it
+	// find such a leaf and return it if it belongs to the section
+	if len(keys) != 0 || section_length < 2 || section_length > 64 {
+		return
+	}
+	for l := section_length - 1; l >= 1; l-- {
+		cursor := tree.Cursor()
+		k, v, err := cursor.SpecialFirst(section, l)
+		if err != nil {
+			continue
+		}
+		keyhash := graviton.Sum(k)
+		for d := uint(0); d < section_length; d++ {
+			if is_bit_set(keyhash[:], d) != is_bit_set(section, d) {
+				return
+			}
+		}
+		return [][]byte{k}, [][]byte{v}
+	}
+	return
+}
@@ -0,0 +1,219 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package p2p
+
+/* this file implements the transports which carry p2p connections
+ * TLS and the rpc layer are placed on top of whatever connection the transport provides
+ */
+import "os"
+import "fmt"
+import "net"
+import "sync"
+import "time"
+import "strconv"
+
+import "crypto/sha1"
+
+import "github.com/deroproject/derohe/globals"
+
+import "github.com/xtaci/kcp-go/v5"
+import "golang.org/x/crypto/pbkdf2"
+
+// Transport dials and accepts raw connections to other nodes, endpoints are ip:port
+// the daemon uses KCP sessions over UDP, tests use an in-memory network
+type Transport interface {
+	Dial(endpoint string) (net.Conn, error)
+	Listen(address string) (net.Listener, error)
+}
+
+// default transport, KCP sessions encrypted with a key derived from network id
+type kcp_transport struct{}
+
+func kcp_blockcipher() kcp.BlockCrypt {
+	var masterkey = pbkdf2.Key(globals.Config.Network_ID.Bytes(), globals.Config.Network_ID.Bytes(), 1024, 32, sha1.New)
+	var blockcipher, _ = kcp.NewAESBlockCrypt(masterkey)
+	return blockcipher
+}
+
+func (kcp_transport) Dial(endpoint string) (net.Conn, error) {
+	conn, err := kcp.DialWithOptions(endpoint, kcp_blockcipher(), 10, 3)
+	if err != nil {
+		return nil, err
+	}
+	tunekcp(conn) // set tunings for low latency
+	return conn, nil
+}
+
+func (kcp_transport) Listen(address string) (net.Listener, error) {
+	l, err := kcp.ListenWithOptions(address, kcp_blockcipher(), 10, 3)
+	if err != nil {
+		return nil, err
+	}
+	return kcp_listener{l}, nil
+}
+
+type kcp_listener struct {
+	*kcp.Listener
+}
+
+func (l kcp_listener) Accept() (net.Conn, error) {
+	conn, err := l.AcceptKCP()
+	if err != nil {
+		return nil, err
+	}
+	tunekcp(conn) // tuning paramters for local stack
+	return conn, nil
+}
+
+func tunekcp(conn *kcp.UDPSession) {
+	conn.SetACKNoDelay(true)
+	if os.Getenv("TURBO") == "0" {
+		conn.SetNoDelay(1, 10, 2, 1) // tuning paramters for local stack for fast retransmission stack
+	} else {
+		conn.SetNoDelay(0, 40, 0, 0) // tuning paramters for local
+	}
+
+	size := 1 * 1024 * 1024 // set the buffer size max possible upto 1 MB, default is 1 MB
+	if os.Getenv("UDP_READ_BUF_CONN") != "" {
+		size, _ = strconv.Atoi(os.Getenv("UDP_READ_BUF_CONN"))
+		if size <= 64*1024 {
+			size = 64 * 1024
+		}
+	}
+	for size >= 64*1024 {
+		if err := conn.SetReadBuffer(size); err == nil {
+			break
+		}
+		size = size - (64 * 1024)
+	}
+}
+
+// Memory_Network connects several nodes within a single process, nothing touches the network
+// every node gets its own transport and ip, so bans, backoff and connection limits work as usual
+type Memory_Network struct {
+	sync.Mutex
+	listeners map[string]*memory_listener
+	last_port int
+}
+
+func New_Memory_Network() *Memory_Network {
+	return &Memory_Network{listeners: map[string]*memory_listener{}, last_port: 40000}
+}
+
+// transport for a node which will appear to others as coming from this ip
+func (m *Memory_Network) Transport(ip string) Transport {
+	return &memory_transport{network: m, ip: ip}
+}
+
+func (m *Memory_Network) next_port() int {
+	m.Lock()
+	defer m.Unlock()
+	m.last_port++
+	return m.last_port
+}
+
+type memory_addr string
+
+func (a memory_addr) Network() string { return "memory" }
+func (a memory_addr) String() string  { return string(a) }
+
+// pipe end which reports memory addresses instead of pipe addresses
+type memory_conn struct {
+	net.Conn
+	local, remote memory_addr
+}
+
+func (c *memory_conn) LocalAddr() net.Addr  { return c.local }
+func (c *memory_conn) RemoteAddr() net.Addr { return c.remote }
+
+type memory_transport struct {
+	network *Memory_Network
+	ip      string
+}
+
+func (t *memory_transport) Listen(address string) (net.Listener, error) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if port == "0" {
+		port = strconv.Itoa(t.network.next_port())
+	}
+
+	l := &memory_listener{network: t.network, addr: memory_addr(net.JoinHostPort(t.ip, port)), incoming: make(chan net.Conn), closed: make(chan struct{})}
+
+	t.network.Lock()
+	defer t.network.Unlock()
+	if _, ok := t.network.listeners[string(l.addr)]; ok {
+		return nil, fmt.Errorf("address %s already in use", l.addr)
+	}
+	t.network.listeners[string(l.addr)] = l
+	return l, nil
+}
+
+func (t *memory_transport) Dial(endpoint string) (net.Conn, error) {
+	t.network.Lock()
+	l, ok := t.network.listeners[endpoint]
+	t.network.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("dial %s: connection refused", endpoint)
+	}
+
+	local := memory_addr(net.JoinHostPort(t.ip, strconv.Itoa(t.network.next_port())))
+	client, server := net.Pipe()
+
+	select {
+	case l.incoming <- &memory_conn{Conn: server, local: l.addr, remote: local}:
+		return &memory_conn{Conn: client, local: local, remote: l.addr}, nil
+	case <-l.closed:
+	case <-time.After(10 * time.Second):
+	}
+	client.Close()
+	server.Close()
+	return nil, fmt.Errorf("dial %s: connection refused", endpoint)
+}
+
+type memory_listener struct {
+	network  *Memory_Network
+	addr     memory_addr
+	incoming chan net.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (l *memory_listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.incoming:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memory_listener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.network.Lock()
+		delete(l.network.listeners, string(l.addr))
+		l.network.Unlock()
+	})
+	return nil
+}
+
+func (l *memory_listener) Addr() net.Addr {
+	return l.addr
+}
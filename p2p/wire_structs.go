@@ -61,10 +61,10 @@ type Handshake_Struct struct {
 }
 
 type Peer_Info struct {
-	Addr  string `cbor:"ADDR"` // ip:port pair
-	Miner bool   `cbor:"MINER"`
-	//ID       uint64 `cbor:"I"`
-	//LastSeen uint64 `cbor:"LS"`
+	Addr     string `cbor:"ADDR"` // ip:port pair
+	Miner    bool   `cbor:"MINER"`
+	ID       uint64 `cbor:"I,omitempty"`
+	LastSeen uint64 `cbor:"LS,omitempty"` // epoch time when the sender last knew peer to be up, older peers do not send it
 }
 
 type Chain_Request_Struct struct { // our version of chain
@@ -88,6 +88,17 @@ type ObjectList struct {
 	Block_list [][32]byte          `cbor:"BLIST,omitempty"`
 	Tx_list    [][32]byte          `cbor:"TXLIST,omitempty"`
 	Chunk_list [][32 + 1 + 32]byte `cbor:"CLIST,omitempty"` // CLIST, first is block id, last byte is chunkid, max 255  chunks supported
+
+	Block_Tx_list [][32 + 2]byte `cbor:"BTXLIST,omitempty"` // block id followed by big endian index of tx within block, used by compact blocks
+}
+
+// block announced to peers which negotiated compact blocks, see compact_block.go
+type Compact_Block struct {
+	Common   Common_Struct `cbor:"COMMON"`         // add all fields of Common
+	Sent     int64         `cbor:"SENT,omitempty"` // this is timestamp in microsecs, and must be passed down
+	BLID     [32]byte      `cbor:"BLID"`
+	Block    []byte        `cbor:"BLOCK"`          // serialized block without tx hashes
+	ShortIDs [][8]byte     `cbor:"SIDS,omitempty"` // short ids of txs in block order
 }
 
 type Objects struct {
@@ -97,6 +108,8 @@ type Objects struct {
 	Txs        [][]byte         `cbor:"TXS,omitempty"`
 	MiniBlocks [][]byte         `cbor:"MBLS,omitempty"`   // miniblocks
 	Chunks     []Block_Chunk    `cbor:"CHUNKS,omitempty"` // all requested chunks are here
+
+	historical bool // not serialized, response carries historical blocks and is sent at sync priority, see bandwidth.go
 }
 
 // used to request what all changes are done by the block to the chain
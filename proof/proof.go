@@ -29,8 +29,9 @@ import "github.com/deroproject/derohe/transaction"
 //import "github.com/deroproject/derosuite/walletapi" // to decode encrypted payment ID
 
 // this function will prove detect and decode output amount for the tx
+// receiver proofs are detected and verified using ProveReceiver
 func Prove(proof string, input_tx string, ring_string [][]string, mainnet bool) (receivers []string, amounts []uint64, payload_raw [][]byte, payload_decoded []string, err error) {
-	var tx transaction.Transaction
+	var tx *transaction.Transaction
 
 	addr, err := rpc.NewAddress(strings.TrimSpace(proof))
 	if err != nil {
@@ -44,6 +45,10 @@ func Prove(proof string, input_tx string, ring_string [][]string, mainnet bool)
 
 	args := addr.Arguments
 
+	if args.Has(RECEIVER_PROOF_C, rpc.DataHash) {
+		return ProveReceiver(proof, input_tx, ring_string, mainnet)
+	}
+
 	amount := uint64(0)
 	var shared_key crypto.Hash
 
@@ -61,27 +66,10 @@ func Prove(proof string, input_tx string, ring_string [][]string, mainnet bool)
 		return
 	}
 
-	tx_hex, err := hex.DecodeString(input_tx)
-	if err != nil {
+	if tx, err = DecodeTX(input_tx, ring_string); err != nil {
 		return
 	}
 
-	err = tx.Deserialize(tx_hex)
-	if err != nil {
-		return
-	}
-
-	// now lets decode the ring
-	for i := range ring_string {
-		for j := range ring_string[i] {
-			var addr *rpc.Address
-			if addr, err = rpc.NewAddress(ring_string[i][j]); err != nil {
-				return
-			}
-			tx.Payloads[i].Statement.Publickeylist = append(tx.Payloads[i].Statement.Publickeylist, (*bn256.G1)(addr.PublicKey))
-		}
-	}
-
 	// okay all inputs have been parsed
 	var x bn256.G1
 	x.ScalarMult(crypto.G, new(big.Int).SetInt64(int64(amount)))
@@ -121,3 +109,33 @@ func Prove(proof string, input_tx string, ring_string [][]string, mainnet bool)
 	return
 
 }
+
+// DecodeTX deserializes tx and fills in the ring members
+func DecodeTX(input_tx string, ring_string [][]string) (tx *transaction.Transaction, err error) {
+	tx = &transaction.Transaction{}
+
+	tx_hex, err := hex.DecodeString(input_tx)
+	if err != nil {
+		return
+	}
+
+	if err = tx.Deserialize(tx_hex); err != nil {
+		return
+	}
+
+	if len(ring_string) > len(tx.Payloads) {
+		return nil, fmt.Errorf("tx has %d payloads, but %d rings provided", len(tx.Payloads), len(ring_string))
+	}
+
+	// now lets decode the ring
+	for i := range ring_string {
+		for j := range ring_string[i] {
+			var addr *rpc.Address
+			if addr, err = rpc.NewAddress(ring_string[i][j]); err != nil {
+				return
+			}
+			tx.Payloads[i].Statement.Publickeylist = append(tx.Payloads[i].Statement.Publickeylist, (*bn256.G1)(addr.PublicKey))
+		}
+	}
+	return
+}
@@ -0,0 +1,130 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package proof
+
+import "fmt"
+import "math/big"
+import "strings"
+
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/bn256"
+import "github.com/deroproject/derohe/transaction"
+
+// this file implements receiver side proofs
+// a transfer to ring member k is encrypted as C[k] = amount*G + r*Y[k] with D = r*G
+// receiver discloses S = x*D = r*Y[k] and proves that log_G(Y[k]) == log_D(S), so C[k] - S = amount*G proves the amount
+// S only decrypts this single transfer, so the receiver does not reveal the key
+// the sender knows r and may disclose the same S proving that log_G(D) == log_Y[k](S), both proofs are accepted
+// the proof is encoded as a "deroproof" address, whose key is S and arguments carry amount and the proof
+
+const RECEIVER_PROOF_C = "PC" // challenge of DLEQ proof
+const RECEIVER_PROOF_S = "PS" // response of DLEQ proof
+
+// NewReceiverProof encodes a receiver proof, generated by the wallet
+func NewReceiverProof(S *bn256.G1, amount uint64, c, s *big.Int, mainnet bool) string {
+	var ch, sh crypto.Hash
+	crypto.FillBytes(c, ch[:])
+	crypto.FillBytes(s, sh[:])
+
+	proof := rpc.NewAddressFromKeys((*crypto.Point)(S))
+	proof.Mainnet = mainnet
+	proof.Proof = true
+	proof.Arguments = rpc.Arguments{{Name: rpc.RPC_VALUE_TRANSFER, DataType: rpc.DataUint64, Value: amount}, {Name: RECEIVER_PROOF_C, DataType: rpc.DataHash, Value: ch}, {Name: RECEIVER_PROOF_S, DataType: rpc.DataHash, Value: sh}}
+	return proof.String()
+}
+
+// this function will verify a receiver proof and decode amount and payload received
+func ProveReceiver(proof string, input_tx string, ring_string [][]string, mainnet bool) (receivers []string, amounts []uint64, payload_raw [][]byte, payload_decoded []string, err error) {
+	addr, err := rpc.NewAddress(strings.TrimSpace(proof))
+	if err != nil {
+		return
+	}
+
+	if !addr.IsDERONetwork() || !addr.IsIntegratedAddress() || !addr.Proof {
+		err = fmt.Errorf("Invalid proof ")
+		return
+	}
+
+	args := addr.Arguments
+	if !args.Has(rpc.RPC_VALUE_TRANSFER, rpc.DataUint64) || !args.Has(RECEIVER_PROOF_C, rpc.DataHash) || !args.Has(RECEIVER_PROOF_S, rpc.DataHash) {
+		err = fmt.Errorf("Invalid receiver proof.")
+		return
+	}
+
+	tx, err := DecodeTX(input_tx, ring_string)
+	if err != nil {
+		return
+	}
+
+	return prove_receiver(addr, tx, mainnet)
+}
+
+// ring members must already be filled in tx
+func prove_receiver(addr *rpc.Address, tx *transaction.Transaction, mainnet bool) (receivers []string, amounts []uint64, payload_raw [][]byte, payload_decoded []string, err error) {
+	amount := addr.Arguments.Value(rpc.RPC_VALUE_TRANSFER, rpc.DataUint64).(uint64)
+	ch := addr.Arguments.Value(RECEIVER_PROOF_C, rpc.DataHash).(crypto.Hash)
+	sh := addr.Arguments.Value(RECEIVER_PROOF_S, rpc.DataHash).(crypto.Hash)
+	c, s := new(big.Int).SetBytes(ch[:]), new(big.Int).SetBytes(sh[:])
+
+	S := addr.PublicKey.G1()
+	var x bn256.G1
+	x.ScalarMult(crypto.G, new(big.Int).SetUint64(amount))
+	x.Add(new(bn256.G1).Set(&x), S)
+
+	for t := range tx.Payloads {
+		statement := &tx.Payloads[t].Statement
+		if statement.D == nil {
+			continue
+		}
+		for k := range statement.C {
+			if k >= len(statement.Publickeylist) || x.String() != statement.C[k].String() {
+				continue
+			}
+			if !crypto.VerifyDLEQ(statement.Publickeylist[k], statement.D, S, c, s) && !crypto.VerifyDLEQ(statement.D, statement.Publickeylist[k], S, c, s) {
+				continue
+			}
+
+			astring := rpc.NewAddressFromKeys((*crypto.Point)(statement.Publickeylist[k]))
+			astring.Mainnet = mainnet
+
+			receivers = append(receivers, astring.String())
+			amounts = append(amounts, amount)
+
+			// S is the shared secret point of the receiver, so payload can be decrypted
+			shared_key := crypto.Keccak256(S.EncodeCompressed())
+			payload := append([]byte{}, tx.Payloads[t].RPCPayload...)
+			crypto.EncryptDecryptUserData(crypto.Keccak256(shared_key[:], statement.Publickeylist[k].EncodeCompressed()), payload)
+			if len(payload) < 1 {
+				payload = []byte{0}
+			}
+
+			// skip first byte as it is sender index
+			payload_raw = append(payload_raw, payload[1:])
+			var args rpc.Arguments
+			if err := args.UnmarshalBinary(payload[1:]); err == nil {
+				payload_decoded = append(payload_decoded, fmt.Sprintf("%s", args))
+			} else {
+				payload_decoded = append(payload_decoded, err.Error())
+			}
+			return
+		}
+	}
+
+	err = fmt.Errorf("Wrong receiver proof or wrong transaction")
+	return
+}
@@ -16,7 +16,14 @@
 
 package proof
 
+import "fmt"
 import "testing"
+import "math/big"
+
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/cryptography/bn256"
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/transaction"
 
 // this function will prove detect and decode output amount for the tx
 func Test_Prove(t *testing.T) {
@@ -50,3 +57,58 @@ func Test_Prove(t *testing.T) {
 	}
 
 }
+
+// receiver proves amount and payload of a transfer without revealing key
+func Test_Prove_Receiver(t *testing.T) {
+	x := crypto.RandomScalar()
+	Y := new(bn256.G1).ScalarMult(crypto.G, x)
+	decoy := new(bn256.G1).ScalarMult(crypto.G, crypto.RandomScalar())
+
+	amount := uint64(12345)
+	r := crypto.RandomScalar()
+
+	var tx transaction.Transaction
+	tx.Payloads = make([]transaction.AssetPayload, 1)
+	statement := &tx.Payloads[0].Statement
+	statement.D = new(bn256.G1).ScalarMult(crypto.G, r)
+	statement.Publickeylist = []*bn256.G1{decoy, Y}
+	statement.C = []*bn256.G1{
+		new(bn256.G1).ScalarMult(decoy, r),
+		new(bn256.G1).Add(new(bn256.G1).ScalarMult(crypto.G, new(big.Int).SetUint64(amount)), new(bn256.G1).ScalarMult(Y, r)),
+	}
+
+	args := rpc.Arguments{{Name: rpc.RPC_COMMENT, DataType: rpc.DataString, Value: "invoice 42"}}
+	data, _ := args.MarshalBinary()
+	tx.Payloads[0].RPCPayload = append([]byte{0}, data...)
+	shared_key := crypto.GenerateSharedSecret(r, Y)
+	crypto.EncryptDecryptUserData(crypto.Keccak256(shared_key[:], Y.EncodeCompressed()), tx.Payloads[0].RPCPayload)
+
+	S := new(bn256.G1).ScalarMult(statement.D, x)
+	c, s := crypto.ProveDLEQ(x, Y, statement.D, S)
+
+	addr, err := rpc.NewAddress(NewReceiverProof(S, amount, c, s, true))
+	if err != nil {
+		t.Fatalf("cannot parse receiver proof err %s", err)
+	}
+	receivers, amounts, _, decoded, err := prove_receiver(addr, &tx, true)
+	if err != nil || len(amounts) != 1 || amounts[0] != amount || decoded[0] != fmt.Sprintf("%s", args) {
+		t.Fatalf("Proving receiver failed err %v amounts %v decoded %v", err, amounts, decoded)
+	}
+	if receivers[0] != rpc.NewAddressFromKeys((*crypto.Point)(Y)).String() {
+		t.Fatalf("wrong receiver %s", receivers[0])
+	}
+
+	// claiming a different amount must fail
+	addr, _ = rpc.NewAddress(NewReceiverProof(S, amount+1, c, s, true))
+	if _, _, _, _, err = prove_receiver(addr, &tx, true); err == nil {
+		t.Fatalf("wrong amount must not be proved")
+	}
+
+	// decoy cannot prove anything, since it does not know r*decoy without its key
+	fake := new(bn256.G1).ScalarMult(statement.D, crypto.RandomScalar())
+	c, s = crypto.ProveDLEQ(x, Y, statement.D, fake)
+	addr, _ = rpc.NewAddress(NewReceiverProof(fake, 0, c, s, true))
+	if _, _, _, _, err = prove_receiver(addr, &tx, true); err == nil {
+		t.Fatalf("forged receiver proof must fail")
+	}
+}
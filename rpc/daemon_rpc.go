@@ -282,6 +282,7 @@ type (
 		Height                     int64   `json:"height"`
 		StableHeight               int64   `json:"stableheight"`
 		TopoHeight                 int64   `json:"topoheight"`
+		PrunedTopoHeight           int64   `json:"pruned_topoheight"` // history below this topoheight has been pruned, 0 if none
 		Merkle_Balance_TreeHash    string  `json:"treehash"`
 		AverageBlockTime50         float32 `json:"averageblocktime50"`
 		Incoming_connections_count uint64  `json:"incoming_connections_count"`
@@ -322,3 +323,31 @@ type GasEstimate_Result struct {
 	GasStorage uint64 `json:"gasstorage"`
 	Status     string `json:"status"`
 }
+
+// connected peer as seen by p2p layer
+type Peer_Status struct {
+	Address       string            `json:"address"`
+	Peer_ID       uint64            `json:"peerid"`
+	Incoming      bool              `json:"incoming"`
+	Height        int64             `json:"height"`
+	TopoHeight    int64             `json:"topoheight"`
+	Latency       int64             `json:"latency"` // in milliseconds
+	DaemonVersion string            `json:"daemon_version"`
+	Tag           string            `json:"tag,omitempty"`
+	Score         int64             `json:"score"`
+	Deprioritized bool              `json:"deprioritized"`          // score is below the deprioritize threshold
+	Score_Events  map[string]uint64 `json:"score_events,omitempty"` // how many times each reason changed the score
+	BytesIn       uint64            `json:"bytes_in"`
+	BytesOut      uint64            `json:"bytes_out"`
+	Identity      string            `json:"identity,omitempty"` // public key proven by peer
+}
+
+type (
+	GetPeers_Params struct{} // no params
+	GetPeers_Result struct {
+		Peers              []Peer_Status `json:"peers,omitempty"`
+		Ban_Score          int64         `json:"ban_score"`          // peers reaching this score are banned
+		Deprioritize_Score int64         `json:"deprioritize_score"` // peers below this score are used last
+		Status             string        `json:"status"`
+	}
+)
@@ -61,6 +61,9 @@ type Entry struct {
 	Sender          string `json:"sender"`
 	DestinationPort uint64 `json:"dstport"`
 	SourcePort      uint64 `json:"srcport"`
+
+	Confirmations int64 `json:"confirmations"` // depth of block containing this entry, calculated when entry is returned
+	Final         bool  `json:"final"`         // entry is within stable height and its block was verified to be neither orphan nor side block
 }
 
 // converts entry to string
@@ -170,6 +173,8 @@ type (
 	GetBalance_Result struct {
 		Balance          uint64 `json:"balance"`
 		Unlocked_Balance uint64 `json:"unlocked_balance"`
+		ViewOnly         bool   `json:"viewonly,omitempty"`   // view only wallets never sync, balance and transfers are a snapshot disclosed by view credential
+		TopoHeight       int64  `json:"topoheight,omitempty"` // topoheight of view credential, changes after it are not visible
 	}
 )
 
@@ -234,9 +239,11 @@ type (
 		Receiver        string      `json:"receiver"`
 		DestinationPort uint64      `json:"dstport"`
 		SourcePort      uint64      `json:"srcport"`
+		Pending         bool        `json:"pending"` // also return outgoing txs which are not yet stable
 	}
 	Get_Transfers_Result struct {
-		Entries []Entry `json:"entries,omitempty"`
+		Entries []Entry     `json:"entries,omitempty"`
+		Pending []PendingTX `json:"pending,omitempty"`
 	}
 )
 
@@ -292,3 +299,295 @@ type (
 		Entry Entry `json:"entry,omitempty"`
 	}
 )
+
+// invoices are payment requests tracked by the wallet, each invoice has a unique destination port
+type InvoiceState string
+
+const (
+	InvoiceUnpaid   InvoiceState = "unpaid"
+	InvoicePartial  InvoiceState = "partial" // partially paid
+	InvoicePaid     InvoiceState = "paid"
+	InvoiceOverpaid InvoiceState = "overpaid"
+	InvoiceExpired  InvoiceState = "expired" // expired without being fully paid
+)
+
+type Invoice struct {
+	DestinationPort    uint64       `json:"dstport"` // unique id of the invoice
+	SCID               crypto.Hash  `json:"scid"`
+	Amount             uint64       `json:"amount"`
+	Comment            string       `json:"comment,omitempty"`
+	Integrated_Address string       `json:"integrated_address"` // payer must pay to this address
+	Created            time.Time    `json:"created"`
+	Expiry             time.Time    `json:"expiry"` // zero value means invoice never expires
+	Received           uint64       `json:"received"`
+	TXIDs              []string     `json:"txids,omitempty"` // txs which paid this invoice
+	State              InvoiceState `json:"state"`
+	Updated            time.Time    `json:"updated"` // last state change
+}
+
+// MakeInvoice
+type (
+	Make_Invoice_Params struct {
+		SCID    crypto.Hash `json:"scid"`
+		Amount  uint64      `json:"amount"`
+		Comment string      `json:"comment"`
+		Expiry  uint64      `json:"expiry"` // in seconds from now, 0 means invoice never expires
+	}
+	Make_Invoice_Result struct {
+		Invoice Invoice `json:"invoice"`
+	}
+)
+
+// GetInvoice
+type (
+	Get_Invoice_Params struct {
+		DestinationPort uint64 `json:"dstport"`
+	}
+	Get_Invoice_Result struct {
+		Invoice Invoice `json:"invoice"`
+	}
+)
+
+// GetInvoices
+type (
+	Get_Invoices_Params struct {
+		State InvoiceState `json:"state"` // if empty all invoices are returned
+	}
+	Get_Invoices_Result struct {
+		Invoices []Invoice `json:"invoices,omitempty"`
+	}
+)
+
+// webhook events are posted by the wallet to configured urls as json
+// body is signed using HMAC-SHA256 with the webhook secret, hex signature is carried in header WebhookSignatureHeader
+const WebhookSignatureHeader = "X-Dero-Signature"
+
+const (
+	WebhookIncoming  = "incoming"  // new incoming transfer recorded
+	WebhookConfirmed = "confirmed" // incoming transfer reached a confirmation milestone
+	WebhookRemoved   = "removed"   // incoming transfer removed from history due to chain reorganisation
+)
+
+type WebhookEvent struct {
+	ID            string      `json:"id"` // unique per event, receivers should use it to ignore duplicates
+	Event         string      `json:"event"`
+	SCID          crypto.Hash `json:"scid"`
+	Confirmations int64       `json:"confirmations"`
+	Entry         Entry       `json:"entry"`
+	Created       time.Time   `json:"created"`
+}
+
+// sub accounts derived from the wallet seed, account 0 is the master account
+type Account_Info struct {
+	Index            uint32 `json:"index"`
+	Address          string `json:"address"`
+	Balance          uint64 `json:"balance"`
+	Unlocked_Balance uint64 `json:"unlocked_balance"`
+	Selected         bool   `json:"selected"`
+}
+
+// CreateAccount
+type (
+	Create_Account_Params struct {
+		Index uint32 `json:"index"` // if 0, next unused index is used
+	}
+	Create_Account_Result struct {
+		Index   uint32 `json:"index"`
+		Address string `json:"address"`
+	}
+)
+
+// GetAccounts
+type (
+	Get_Accounts_Params struct {
+		SCID crypto.Hash `json:"scid"` // balances are reported for this scid
+	}
+	Get_Accounts_Result struct {
+		Accounts         []Account_Info `json:"accounts"`
+		Balance          uint64         `json:"balance"` // aggregate of all accounts
+		Unlocked_Balance uint64         `json:"unlocked_balance"`
+	}
+)
+
+// SelectAccount
+type (
+	Select_Account_Params struct {
+		Index uint32 `json:"index"`
+	}
+	Select_Account_Result struct {
+		Index   uint32 `json:"index"`
+		Address string `json:"address"`
+	}
+)
+
+// progress of wallet history sync for a scid
+type Sync_Progress struct {
+	SCID             crypto.Hash `json:"scid"`
+	Start_TopoHeight int64       `json:"start_topoheight"`
+	End_TopoHeight   int64       `json:"end_topoheight"`
+	TopoHeight       int64       `json:"topoheight"` // history is complete till this topoheight
+	Percent          float64     `json:"percent"`
+	ETA              int64       `json:"eta"` // estimated seconds remaining
+	Done             bool        `json:"done"`
+}
+
+// GetSyncProgress
+type (
+	Get_Sync_Progress_Params struct{} // no params
+	Get_Sync_Progress_Result struct {
+		Progress []Sync_Progress `json:"progress,omitempty"`
+	}
+)
+
+// MakeReceiverProof
+type (
+	Make_Receiver_Proof_Params struct {
+		TXID string `json:"txid"`
+	}
+	Make_Receiver_Proof_Result struct {
+		Proofs []string `json:"proofs"` // one proof for every transfer received in the tx
+	}
+)
+
+// VerifyProof, both sender and receiver proofs are supported
+type (
+	Verify_Proof_Params struct {
+		Proof string `json:"proof"`
+		TXID  string `json:"txid"`
+	}
+	Verify_Proof_Result struct {
+		Receivers []string `json:"receivers"`
+		Amounts   []uint64 `json:"amounts"`
+		Payloads  []string `json:"payloads"` // decoded payloads
+	}
+)
+
+// ExportBackup, backup is encrypted using supplied password
+type (
+	Export_Backup_Params struct {
+		Password string `json:"password"`
+	}
+	Export_Backup_Result struct {
+		Backup string `json:"backup"`
+	}
+)
+
+// RestoreBackup, backup must belong to the open wallet, restored history is verified against the chain
+type (
+	Restore_Backup_Params struct {
+		Password string `json:"password"`
+		Backup   string `json:"backup"`
+	}
+	Restore_Backup_Result struct {
+		Pending bool `json:"pending"` // history is still awaiting verification
+	}
+)
+
+// payout batches pay thousands of destinations using as few txs as possible, txs are sent one after another
+type PayoutState string
+
+const (
+	PayoutPending   PayoutState = "pending"   // waiting to be sent
+	PayoutSent      PayoutState = "sent"      // tx was broadcast, waiting to be mined
+	PayoutConfirmed PayoutState = "confirmed" // tx was mined
+	PayoutFailed    PayoutState = "failed"    // row could not be paid, see error
+)
+
+// single row of a payout batch
+type Payout struct {
+	Destination string      `json:"destination"`
+	Amount      uint64      `json:"amount"`
+	Payload_RPC Arguments   `json:"payload_rpc,omitempty"`
+	State       PayoutState `json:"state"`
+	TXID        string      `json:"txid,omitempty"`
+	Height      int64       `json:"height,omitempty"` // height at which tx was mined
+	Attempts    int         `json:"attempts,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+type PayoutBatch struct {
+	ID          uint64      `json:"id"`
+	SCID        crypto.Hash `json:"scid"`
+	Ringsize    uint64      `json:"ringsize"`
+	Rows        []Payout    `json:"rows"`
+	TXID        string      `json:"txid,omitempty"`         // tx awaiting confirmation, next tx is only sent once it is mined
+	TXHeight    int64       `json:"tx_height,omitempty"`    // reference height of tx, it cannot be mined long after this height
+	PendingFees uint64      `json:"pending_fees,omitempty"` // fees of tx awaiting confirmation
+	Fees        uint64      `json:"fees"`                   // fees of mined txs
+	Error       string      `json:"error,omitempty"`        // last error which stalled the batch
+	Created     time.Time   `json:"created"`
+	Updated     time.Time   `json:"updated"`
+	Done        bool        `json:"done"` // no pending or sent rows remain
+}
+
+// reconciliation report of a payout batch
+type PayoutReport struct {
+	ID           uint64   `json:"id"`
+	Rows         int      `json:"rows"`
+	Pending      int      `json:"pending"`
+	Sent         int      `json:"sent"`
+	Confirmed    int      `json:"confirmed"`
+	Failed       int      `json:"failed"`
+	Requested    uint64   `json:"requested"` // sum of all rows
+	Paid         uint64   `json:"paid"`      // sum of confirmed rows
+	Fees         uint64   `json:"fees"`
+	TXIDs        []string `json:"txids,omitempty"`        // mined txs
+	Unreconciled []string `json:"unreconciled,omitempty"` // mined txs which are not yet part of wallet history
+	Done         bool     `json:"done"`
+}
+
+// CreatePayoutBatch
+type (
+	Create_Payout_Batch_Params struct {
+		SCID     crypto.Hash `json:"scid"`
+		Ringsize uint64      `json:"ringsize"`
+		Payouts  []Transfer  `json:"payouts"` // only destination, amount and payload are used
+	}
+	Create_Payout_Batch_Result struct {
+		ID uint64 `json:"id"`
+	}
+)
+
+// GetPayoutBatch
+type (
+	Get_Payout_Batch_Params struct {
+		ID uint64 `json:"id"`
+	}
+	Get_Payout_Batch_Result struct {
+		Batch  PayoutBatch  `json:"batch"`
+		Report PayoutReport `json:"report"`
+	}
+)
+
+// CancelPayoutBatch, pending rows are marked failed, a tx already sent is still tracked
+type (
+	Cancel_Payout_Batch_Params struct {
+		ID uint64 `json:"id"`
+	}
+	Cancel_Payout_Batch_Result struct {
+		Report PayoutReport `json:"report"`
+	}
+)
+
+// outgoing txs are tracked till they are mined and stable or can no longer be mined
+type PendingTXState string
+
+const (
+	PendingTXBroadcast PendingTXState = "broadcast" // sent, not yet seen by daemon
+	PendingTXInPool    PendingTXState = "in_pool"
+	PendingTXMined     PendingTXState = "mined"  // mined, waiting to become stable
+	PendingTXFailed    PendingTXState = "failed" // dropped and can no longer be mined
+)
+
+type PendingTX struct {
+	TXID        string         `json:"txid"`
+	Height      uint64         `json:"height"` // reference height, tx cannot be mined long after this height
+	Payloads    int            `json:"payloads"`
+	Fees        uint64         `json:"fees"`
+	State       PendingTXState `json:"state"`
+	BlockHeight int64          `json:"block_height,omitempty"` // height at which tx was mined
+	Broadcasts  int            `json:"broadcasts"`
+	Error       string         `json:"error,omitempty"` // last broadcast error or reason of failure
+	Created     time.Time      `json:"created"`
+	Updated     time.Time      `json:"updated"`
+}
@@ -21,6 +21,8 @@ import "fmt"
 import "sort"
 import "math/big"
 import "encoding/binary"
+import "sync"
+import "sync/atomic"
 
 //import "github.com/mattn/go-isatty"
 //import "github.com/cheggaaa/pb/v3"
@@ -133,100 +135,116 @@ func Initialize_LookupTable(count int, table_size int) *LookupTable {
 }
 
 // convert point to balance
+// the lookup is tiered, first the previous balance is tried, then the precomputed table is searched directly
+// if the balance is above the table range, baby-step giant-step is used, the table provides the baby steps
+// and giant steps of the table range are walked concurrently by all cores
 func (t *LookupTable) Lookup(p *bn256.G1, previous_balance uint64) (balance uint64) {
-
-	// now this big part must be searched in the precomputation lookup table
-
-	//fmt.Printf("decoding balance now\n",)
 	var acc bn256.G1
 
 	// wait till precompute table is ready
 	// check if previous balance is still sane though it may have mutated
 
-	if len(*Balance_lookup_table) < 1 {
+	if len(*t) < 1 {
 		panic("precompute table is not ready")
 	}
 
 	acc.ScalarMult(crypto.G, new(big.Int).SetUint64(previous_balance))
 	if acc.String() == p.String() {
 		return previous_balance
-
 	}
 
-	work_per_loop := new(bn256.G1)
-
-	balance_part := uint64(0)
-
-	balance_per_loop := uint64(len((*t)[0]) * len(*t))
-
-	_ = balance_per_loop
+	if balance, found := t.baby_step(p, p, 0, &acc); found {
+		return balance
+	}
+	return t.giant_steps(p)
+}
 
-	pcopy := new(bn256.G1).Set(p)
+// total number of balances covered by the precomputed tables
+func (t *LookupTable) table_range() (r uint64) {
+	for i := range *t {
+		r += uint64(len((*t)[i]))
+	}
+	return
+}
 
-	work_per_loop.ScalarMult(crypto.G, new(big.Int).SetUint64(balance_per_loop))
-	work_per_loop = new(bn256.G1).Neg(work_per_loop)
+// checks whether pcopy is in the precomputed tables, offset is the balance already subtracted from p
+// acc is scratch space, so that the callers can avoid allocations
+func (t *LookupTable) baby_step(p, pcopy *bn256.G1, offset uint64, acc *bn256.G1) (uint64, bool) {
+	compressed := pcopy.EncodeCompressed()
 
-	loop_counter := 0
+	compressed[32] = 0
+	compressed[31] = 0
+	compressed[30] = 0
 
-	//  fmt.Printf("jumping into loop %d\n", loop_counter)
-	for { // it is an infinite loop
+	big_part := binary.BigEndian.Uint64(compressed[25:])
 
-		// fmt.Printf("loop counter %d  balance %d\n", loop_counter, balance)
+	base := offset
+	for i := range *t {
+		table := (*t)[i]
+		index := sort.Search(len(table), func(j int) bool { return (table[j] & 0xffffffffff000000) >= big_part })
 
-		if loop_counter != 0 {
-			pcopy = new(bn256.G1).Add(pcopy, work_per_loop)
+		// we may have partial collisions in the first 5 bytes, so full point is checked for every candidate
+		for ; index < len(table) && (table[index]&0xffffffffff000000) == big_part; index++ {
+			balance := base + (table[index] & 0xffffff)
+			acc.ScalarMult(crypto.G, new(big.Int).SetUint64(balance))
+			if acc.String() == p.String() {
+				return balance, true
+			}
 		}
-		loop_counter++
-
-		//if loop_counter >= 10 {
-		//    break;
-		// }
-
-		compressed := pcopy.EncodeCompressed()
-
-		compressed[32] = 0
-		compressed[31] = 0
-		compressed[30] = 0
-
-		big_part := binary.BigEndian.Uint64(compressed[25:])
-
-		for i := range *t {
-			index := sort.Search(len((*t)[i]), func(j int) bool { return ((*t)[i][j] & 0xffffffffff000000) >= big_part })
-		check_again:
-			if index < len((*t)[i]) && ((*t)[i][index]&0xffffffffff000000) == big_part {
-
-				balance_part = ((*t)[i][index]) & 0xffffff
-				acc.ScalarMult(crypto.G, new(big.Int).SetUint64(balance+balance_part))
-
-				if acc.String() == p.String() { // since we may have part collisions, make sure full point is checked
-
-					balance += balance_part
-					// fmt.Printf("balance found  %d part(%d) index %d   big part %x\n",balance,balance_part, index, big_part );
+		base += uint64(len(table))
+	}
+	return 0, false
+}
 
-					return balance
+// giant steps are distributed over workers, worker w tries giant steps w+1, w+1+workers and so on
+// since the balance is always a 64 bit value, some worker will find it
+func (t *LookupTable) giant_steps(p *bn256.G1) (balance uint64) {
+	workers := runtime.NumCPU()
+	if runtime.GOOS == "js" {
+		workers = 1
+	}
 
+	table_range := t.table_range()
+	stride := new(bn256.G1).ScalarMult(crypto.G, new(big.Int).SetUint64(table_range*uint64(workers)))
+	stride.Neg(stride)
+
+	var found int32
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var acc bn256.G1
+			offset := table_range * uint64(w+1)
+			pcopy := new(bn256.G1).ScalarMult(crypto.G, new(big.Int).SetUint64(offset))
+			pcopy.Neg(pcopy)
+			pcopy.Add(pcopy, p)
+
+			for loop_counter := 0; atomic.LoadInt32(&found) == 0; loop_counter++ {
+				if b, ok := t.baby_step(p, pcopy, offset, &acc); ok {
+					if atomic.CompareAndSwapInt32(&found, 0, 1) {
+						balance = b
+					}
+					return
 				}
+				if offset+table_range*uint64(workers) < offset { // we have wrapped around 2^64, the point is not a balance
+					return
+				}
+				offset += table_range * uint64(workers)
+				pcopy.Add(pcopy, stride)
 
-				// we have failed since it was partial collision, make sure that we can try if possible, another collision
-				// this code can be removed if no duplications exist in the first 5 bytes, but a probablity exists for the same
-				index++
-				goto check_again
-
-			} else {
-				// x is not present in data,
-				// but i is the index where it would be inserted.
-
-				balance += uint64(len((*t)[i]))
+				if loop_counter%1000 == 0 && runtime.GOOS == "js" {
+					runtime.Gosched() // gives others opportunity to run
+				}
 			}
-
-		}
-
-		// from the point we must decrease balance per loop
-
+		}(w)
 	}
+	wg.Wait()
 
-	//panic(fmt.Sprintf("balance not yet found, work done  %x", balance))
-	//return balance
+	if atomic.LoadInt32(&found) == 0 {
+		panic("balance not found, point does not encode a 64 bit balance")
+	}
+	return
 }
 
 // this should be tuned by anyone using this package
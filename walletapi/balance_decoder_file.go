@@ -0,0 +1,188 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "os"
+import "fmt"
+import "bytes"
+import "unsafe"
+import "crypto/sha256"
+import "encoding/binary"
+import "path/filepath"
+
+// this file implements persistent lookup tables
+// generating large tables takes a lot of time and RAM, so tables can be saved to a file once
+// the file is memory mapped read-only wherever possible, so many wallet processes share the same physical pages
+//
+// file layout, all integers are little endian
+// offset 0   magic "DEROLKUP"
+// offset 8   version uint32
+// offset 12  count uint32 ( number of tables)
+// offset 16  table size uint32 ( entries per table)
+// offset 20  reserved, must be zero
+// offset 32  sha256 checksum of data
+// offset 64  data, count * table size entries of uint64, each table sorted
+
+const LOOKUP_TABLE_VERSION = 1
+
+var lookup_table_magic = []byte("DEROLKUP")
+
+const lookup_table_header_size = 64
+
+// checks whether the host is little endian, so mapped data can be used as is
+func host_little_endian() bool {
+	var x uint16 = 1
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}
+
+// Save serializes the lookup table to a file, the file is first written to a temporary file and then renamed
+// so other processes will never see a partial table
+func (t *LookupTable) Save(filename string) (err error) {
+	if len(*t) < 1 {
+		return fmt.Errorf("lookup table is empty")
+	}
+	table_size := len((*t)[0])
+	for i := range *t {
+		if len((*t)[i]) != table_size {
+			return fmt.Errorf("lookup table %d has %d entries, expected %d", i, len((*t)[i]), table_size)
+		}
+	}
+
+	data := make([]byte, 8*len(*t)*table_size)
+	for i := range *t {
+		for j, v := range (*t)[i] {
+			binary.LittleEndian.PutUint64(data[8*(i*table_size+j):], v)
+		}
+	}
+	checksum := sha256.Sum256(data)
+
+	header := make([]byte, lookup_table_header_size)
+	copy(header, lookup_table_magic)
+	binary.LittleEndian.PutUint32(header[8:], LOOKUP_TABLE_VERSION)
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(*t)))
+	binary.LittleEndian.PutUint32(header[16:], uint32(table_size))
+	copy(header[32:], checksum[:])
+
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	if _, err = f.Write(header); err == nil {
+		if _, err = f.Write(data); err == nil {
+			err = f.Sync()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// parses and verifies the header, returns count and table size
+func parse_lookup_table_header(header []byte, file_size int64) (count, table_size int, checksum []byte, err error) {
+	if len(header) < lookup_table_header_size || !bytes.Equal(header[:8], lookup_table_magic) {
+		return 0, 0, nil, fmt.Errorf("not a lookup table file")
+	}
+	if version := binary.LittleEndian.Uint32(header[8:]); version != LOOKUP_TABLE_VERSION {
+		return 0, 0, nil, fmt.Errorf("unsupported lookup table version %d", version)
+	}
+	count = int(binary.LittleEndian.Uint32(header[12:]))
+	table_size = int(binary.LittleEndian.Uint32(header[16:]))
+	if count < 1 || table_size < 256 || table_size > 1<<24 || table_size&0xff != 0 {
+		return 0, 0, nil, fmt.Errorf("invalid lookup table dimensions count %d table size %d", count, table_size)
+	}
+	if !bytes.Equal(header[20:32], make([]byte, 12)) {
+		return 0, 0, nil, fmt.Errorf("lookup table header reserved bytes are not zero")
+	}
+	if expected := int64(lookup_table_header_size) + 8*int64(count)*int64(table_size); file_size != expected {
+		return 0, 0, nil, fmt.Errorf("lookup table file size %d, expected %d", file_size, expected)
+	}
+	return count, table_size, header[32:64], nil
+}
+
+// Load_LookupTable loads and verifies a lookup table file, it does not replace the global table
+// the data is memory mapped read-only where supported, the mapping lives for the remainder of the process
+func Load_LookupTable(filename string) (*LookupTable, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, lookup_table_header_size)
+	if _, err = f.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("cannot read lookup table header err %s", err)
+	}
+	count, table_size, checksum, err := parse_lookup_table_header(header, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	mapped, err := map_lookup_table_file(f, int(stat.Size()))
+	if err != nil {
+		return nil, err
+	}
+	data := mapped[lookup_table_header_size:]
+
+	if actual := sha256.Sum256(data); !bytes.Equal(actual[:], checksum) {
+		unmap_lookup_table_file(mapped)
+		return nil, fmt.Errorf("lookup table checksum mismatch, file is corrupted")
+	}
+
+	var entries []uint64
+	if host_little_endian() {
+		entries = unsafe.Slice((*uint64)(unsafe.Pointer(&data[0])), count*table_size)
+	} else {
+		entries = make([]uint64, count*table_size)
+		for i := range entries {
+			entries[i] = binary.LittleEndian.Uint64(data[8*i:])
+		}
+		unmap_lookup_table_file(mapped)
+	}
+
+	t := make(LookupTable, count)
+	for i := range t {
+		t[i] = PreComputeTable(entries[i*table_size : (i+1)*table_size : (i+1)*table_size])
+	}
+	return &t, nil
+}
+
+// Initialize_LookupTable_File loads the table from file if it exists and has the requested dimensions
+// otherwise the table is generated and saved to the file for later use
+// the table is set as global lookup table in both cases, error is only reported if the table could not be saved
+func Initialize_LookupTable_File(count int, table_size int, filename string) (*LookupTable, error) {
+	if t, err := Load_LookupTable(filename); err == nil && len(*t) == count && len((*t)[0]) == table_size {
+		Balance_lookup_table = t
+		return t, nil
+	}
+
+	t := Initialize_LookupTable(count, table_size)
+	if err := t.Save(filename); err != nil {
+		return t, fmt.Errorf("cannot save lookup table to %s err %s", filename, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "os"
+import "testing"
+import "math/big"
+import "path/filepath"
+
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/cryptography/bn256"
+
+func Test_LookupTable_File(t *testing.T) {
+	old := Balance_lookup_table
+	defer func() { Balance_lookup_table = old }()
+
+	filename := filepath.Join(t.TempDir(), "lookup.table")
+	generated, err := Initialize_LookupTable_File(2, 256, filename)
+	if err != nil {
+		t.Fatalf("cannot generate lookup table err %s", err)
+	}
+
+	loaded, err := Load_LookupTable(filename)
+	if err != nil {
+		t.Fatalf("cannot load lookup table err %s", err)
+	}
+	if len(*loaded) != 2 || len((*loaded)[1]) != 256 {
+		t.Fatalf("lookup table dimensions mismatch")
+	}
+	for i := range *generated {
+		for j := range (*generated)[i] {
+			if (*generated)[i][j] != (*loaded)[i][j] {
+				t.Fatalf("lookup table entry %d %d mismatch", i, j)
+			}
+		}
+	}
+
+	if reloaded, err := Initialize_LookupTable_File(2, 256, filename); err != nil || Balance_lookup_table != reloaded {
+		t.Fatalf("existing lookup table must be used err %v", err)
+	}
+
+	// table range is 512, so everything above is decoded using giant steps
+	for _, balance := range []uint64{0, 100, 300, 511, 512, 512*40 + 7, 1<<20 + 3} {
+		p := new(bn256.G1).ScalarMult(crypto.G, new(big.Int).SetUint64(balance))
+		if decoded := loaded.Lookup(p, 1); decoded != balance {
+			t.Fatalf("balance decoding failed expected %d actual %d", balance, decoded)
+		}
+	}
+
+	data, _ := os.ReadFile(filename)
+	data[len(data)-1] ^= 1
+	os.WriteFile(filename, data, 0600)
+	if _, err := Load_LookupTable(filename); err == nil {
+		t.Fatalf("corrupted lookup table must be rejected")
+	}
+
+	data[8] = 2
+	os.WriteFile(filename, data, 0600)
+	if _, err := Load_LookupTable(filename); err == nil {
+		t.Fatalf("unknown lookup table version must be rejected")
+	}
+}
@@ -61,6 +61,8 @@ var Connected bool = false
 
 var daemon_height int64
 var daemon_topoheight int64
+var daemon_stable_height int64
+var daemon_pruned_topoheight int64
 
 // return daemon height
 func Get_Daemon_Height() int64 {
@@ -72,6 +74,11 @@ func Get_Daemon_TopoHeight() int64 {
 	return daemon_topoheight
 }
 
+// return stable height of daemon, blocks upto this height cannot be reorganised
+func Get_Daemon_Stable_Height() int64 {
+	return daemon_stable_height
+}
+
 var simulator bool // turns on simulator, which has 0 fees
 
 // there should be no global variables, so multiple wallets can run at the same time with different assset
@@ -173,6 +180,8 @@ func test_connectivity() (err error) {
 	}
 	daemon_height = info.Height
 	daemon_topoheight = info.TopoHeight
+	daemon_stable_height = info.StableHeight
+	daemon_pruned_topoheight = info.PrunedTopoHeight
 	//	logger.Info("connection is maintained")
 	return nil
 }
@@ -183,7 +192,7 @@ func (w *Wallet_Memory) sync_loop() {
 	for {
 		select {
 		case <-w.Quit:
-			break
+			return
 		default:
 		}
 
@@ -198,20 +207,37 @@ func (w *Wallet_Memory) sync_loop() {
 			continue
 		}
 
-		var zerohash crypto.Hash
+		if w.account.ViewOnly { // view only wallets are updated by importing view credentials
+			if w.IsViewPending() {
+				if err := w.VerifyViewHistory(); err != nil {
+					logger.Error(err, "verifying view credential history")
+				}
+			}
+			time.Sleep(timeout)
+			continue
+		}
+
+		if w.account.RestorePending {
+			if err := w.VerifyRestore(); err != nil {
+				logger.Error(err, "verifying restored history")
+				time.Sleep(timeout)
+				continue
+			}
+		}
+
 		if len(w.account.EntriesNative) == 0 {
 			if err := w.Sync_Wallet_Memory_With_Daemon(); err != nil {
 				logger.Error(err, "wallet syncing err")
 			}
 		} else {
-			for k := range w.account.EntriesNative {
-				err := w.Sync_Wallet_Memory_With_Daemon_internal(k)
-				if k == zerohash && err != nil {
-					logger.Error(err, "wallet syncing err")
-				}
-			}
+			w.sync_all_scids()
 		}
 
+		w.UpdateInvoices() // invoices may change due to new payments or expiry
+		w.update_pending_txs()
+		w.process_payouts()
+		w.webhook_confirmations(daemon_height)
+
 		time.Sleep(timeout) // wait 5 seconds
 	}
 }
@@ -247,11 +273,28 @@ func IsDaemonOnline() bool {
 // sync the wallet with daemon, this is instantaneous and can be done with a single call
 // we have now the apis to avoid polling
 func (w *Wallet_Memory) Sync_Wallet_Memory_With_Daemon_internal(scid crypto.Hash) (err error) {
+	changed, err := w.sync_balance(scid)
+	if err != nil {
+		return
+	}
+	if changed {
+		w.SyncHistory(scid) // also update statement
+	}
+	w.save_if_disk() // save wallet
+	return
+}
+
+// update balance of scid, returns whether encrypted balance changed since last call
+func (w *Wallet_Memory) sync_balance(scid crypto.Hash) (changed bool, err error) {
+	if w.account.ViewOnly {
+		return false, fmt.Errorf("view only wallet cannot decrypt balances, import a newer view credential")
+	}
 
 	if !IsDaemonOnline() {
 		daemon_height = 0
 		daemon_topoheight = 0
-		return fmt.Errorf("Daemon is offline")
+		daemon_stable_height = 0
+		return false, fmt.Errorf("Daemon is offline")
 	} else {
 		//w.random_ring_members()
 		//rlog.Debugf("wallet topo height %d daemon online topo height %d\n", w.account.TopoHeight, w.Daemon_TopoHeight)
@@ -263,17 +306,16 @@ func (w *Wallet_Memory) Sync_Wallet_Memory_With_Daemon_internal(scid crypto.Hash
 			if w.getEncryptedBalanceresult(scid).Data != previous {
 				b := w.DecodeEncryptedBalanceNow(e) // try to decode balance
 
+				w.Lock()
 				if scid.IsZero() {
 					w.account.Balance_Mature = b
 				}
 				w.account.Balance[scid] = b
-				w.SyncHistory(scid) // also update statement
-			} else {
-
+				w.Unlock()
+				changed = true
 			}
-			w.save_if_disk() // save wallet
 		} else {
-			return err
+			return false, err
 		}
 	}
 
@@ -323,7 +365,15 @@ func (w *Wallet_Memory) SendTransaction(tx *transaction.Transaction) (err error)
 		return fmt.Errorf("offline or not connected. cannot send transaction.")
 	}
 
-	params := rpc.SendRawTransaction_Params{Tx_as_hex: hex.EncodeToString(tx.Serialize())}
+	if err = send_raw_transaction(tx.Serialize()); err == nil {
+		w.track_tx(tx)
+	}
+	return
+}
+
+// relay serialized tx to daemon
+func send_raw_transaction(raw []byte) (err error) {
+	params := rpc.SendRawTransaction_Params{Tx_as_hex: hex.EncodeToString(raw)}
 	var result rpc.SendRawTransaction_Result
 
 	if err := rpc_client.Call("DERO.SendRawTransaction", params, &result); err != nil {
@@ -476,6 +526,9 @@ func (w *Wallet_Memory) GetDecryptedBalanceAtTopoHeight(scid crypto.Hash, topohe
 	if err != nil {
 		return 0, 0, err
 	}
+	if w.account.ViewOnly {
+		return 0, 0, fmt.Errorf("view only wallet cannot decrypt balances")
+	}
 
 	return w.DecodeEncryptedBalance_Memory(encrypted_balance, 0), noncetopo, nil
 }
@@ -518,7 +571,9 @@ func (w *Wallet_Memory) SyncHistory(scid crypto.Hash) (balance uint64) {
 
 	//fmt.Printf("finding sync point  ( Registration point %d)\n", w.getEncryptedBalanceresult(scid).Registration)
 
+	w.Lock()
 	entries := w.account.EntriesNative[scid]
+	w.Unlock()
 
 	logger.Info("syncing loop ", "total_entries", len(entries))
 
@@ -561,13 +616,17 @@ func (w *Wallet_Memory) SyncHistory(scid crypto.Hash) (balance uint64) {
 				}
 			}
 			entries = entries[:i-skip]
-			w.account.EntriesNative[scid] = entries
+			w.Lock()
+			w.truncate_history(scid, len(entries))
+			w.Unlock()
 			logger.Info("syncing loop skipped ", "i", i, "skip", skip)
 			continue
 		}
 
 		if i <= 0 {
-			w.account.EntriesNative[scid] = entries[:0] // discard all entries
+			w.Lock()
+			w.truncate_history(scid, 0) // discard all entries
+			w.Unlock()
 			logger.Info("syncing loop discarding all entries", "i", i)
 			break
 		}
@@ -600,110 +659,13 @@ func (w *Wallet_Memory) SyncHistory(scid crypto.Hash) (balance uint64) {
 	return 0
 }
 
-// sync history
-func (w *Wallet_Memory) synchistory_internal(scid crypto.Hash, start_topo, end_topo int64) error {
-	var err error
-	var start_balance_e *crypto.ElGamal
-
-	logger.Info("syncing loop  starting internal ", "start_topo", start_topo, "end_topo", end_topo)
-
-	if w.account.TrackRecentBlocks > 0 && daemon_topoheight >= w.account.TrackRecentBlocks {
-		start_topo = daemon_topoheight - w.account.TrackRecentBlocks
-	}
-	if start_topo == w.getEncryptedBalanceresult(scid).Registration {
-		start_balance_e = crypto.ConstructElGamal(w.account.Keys.Public.G1(), crypto.ElGamal_BASE_G)
-	} else {
-		_, _, _, start_balance_e, err = w.GetEncryptedBalanceAtTopoHeight(scid, start_topo, w.GetAddress().String())
-		if err != nil {
-			logger.Error(err, "syncing info failed", "start_topo", start_topo)
-			return err
-		}
-	}
-
-	_, _, _, end_balance_e, err := w.GetEncryptedBalanceAtTopoHeight(scid, end_topo, w.GetAddress().String())
-	if err != nil {
-		logger.Error(err, "syncing info failed", "end_topo", end_topo)
-		return err
-	}
-
-	return w.synchistory_internal_binary_search(0, scid, start_topo, start_balance_e, end_topo, end_balance_e)
-
-}
-
-func (w *Wallet_Memory) synchistory_internal_binary_search(level int, scid crypto.Hash, start_topo int64, start_balance_e *crypto.ElGamal, end_topo int64, end_balance_e *crypto.ElGamal) error {
-
-	var err error
-
-	//defer fmt.Printf("end %d start %d err %s\n", end_topo, start_topo, err)
-
-	if end_topo < 0 {
-		return fmt.Errorf("done")
-	}
-
-	//if bytes.Compare(start_balance_e.Serialize(), end_balance_e.Serialize()) == 0 {
-	//	logger.Info("syncing loop  same encrypted data so skipping ","start_topo",start_topo, "end_topo",end_topo)
-	//    return nil
-	//}
-
-	defer globals.Recover(0)
-
-	//for start_topo <= end_topo{
-	{
-		median := (start_topo + end_topo) / 2
-		//	fmt.Printf("%slevel %d low %d high %d median %d\n", strings.Repeat("\t", level), level, start_topo, end_topo, median)
-		if start_topo == median {
-			if err = w.synchistory_block(scid, start_topo); err != nil {
-				logger.Error(err, "syncing block failed", "start_topo", start_topo)
-				return err
-			}
-		}
-
-		if end_topo-start_topo <= 1 {
-			if err = w.synchistory_block(scid, end_topo); err != nil {
-				logger.Error(err, "syncing block failed", "end_topo", end_topo)
-				return err
-			}
-			return nil
-		}
-
-		_, _, _, median_balance_e, err := w.GetEncryptedBalanceAtTopoHeight(scid, median, w.GetAddress().String())
-		if err != nil {
-			logger.Error(err, "syncing block getting balance failed", "median", median)
-			return err
-		}
-
-		// check if there is a change in lower section, if yes process more
-		//fmt.Printf("%slevel %d checking lower\n", strings.Repeat("\t", level), level)
-		if start_topo == w.getEncryptedBalanceresult(scid).Registration || bytes.Compare(start_balance_e.Serialize(), median_balance_e.Serialize()) != 0 {
-			err = w.synchistory_internal_binary_search(level+1, scid, start_topo, start_balance_e, median, median_balance_e)
-			if err != nil {
-				logger.Error(err, "syncing block synchistory_internal_binary_search failed", "level+1", level+1, "start_topo", start_topo, "median", median)
-				return err
-			}
-		}
-
-		// check if there is a change in higher section, if yes process more
-		//fmt.Printf("%slevel %d checking higher\n", strings.Repeat("\t", level), level)
-		if bytes.Compare(median_balance_e.Serialize(), end_balance_e.Serialize()) != 0 {
-			err = w.synchistory_internal_binary_search(level+1, scid, median, median_balance_e, end_topo, end_balance_e)
-			if err != nil {
-				logger.Error(err, "syncing block synchistory_internal_binary_search failed", "level+1", level+1, "median", median, "end_topo", end_topo)
-				return err
-			}
-		}
-	}
-
-	return nil
-}
-
 // extract history from a single block
 // first get a block, then get all the txs
 // Todo we should expose an API to get all txs which have the specific address as ring member
 // for a particular block
 // for the entire chain
-func (w *Wallet_Memory) synchistory_block(scid crypto.Hash, topo int64) (err error) {
-
-	var local_entries []rpc.Entry
+// entries are returned, so that blocks can be fetched concurrently and inserted in order
+func (w *Wallet_Memory) synchistory_block(scid crypto.Hash, topo int64) (local_entries []rpc.Entry, err error) {
 
 	compressed_address := w.account.Keys.Public.EncodeCompressed()
 
@@ -715,24 +677,24 @@ func (w *Wallet_Memory) synchistory_block(scid crypto.Hash, topo int64) (err err
 	} else {
 		_, _, _, previous_balance_e, err = w.GetEncryptedBalanceAtTopoHeight(scid, topo-1, w.GetAddress().String())
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	//logger.Info("syncing block", "topo", topo)
 	_, _, _, current_balance_e, err = w.GetEncryptedBalanceAtTopoHeight(scid, topo, w.GetAddress().String())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var bl block.Block
 	var bresult rpc.GetBlock_Result
 	if err = rpc_client.Call("DERO.GetBlock", rpc.GetBlock_Params{Height: uint64(topo)}, &bresult); err != nil {
-		return fmt.Errorf("getblock rpc failed")
+		return nil, fmt.Errorf("getblock rpc failed")
 	}
 
 	if bresult.Block_Header.SideBlock && w.getEncryptedBalanceresult(scid).Registration != topo {
-		return nil
+		return nil, nil
 	}
 
 	EWData := fmt.Sprintf("%x", current_balance_e.Serialize())
@@ -746,10 +708,10 @@ func (w *Wallet_Memory) synchistory_block(scid crypto.Hash, topo int64) (err err
 
 	block_bin, err := hex.DecodeString(bresult.Blob)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err = bl.Deserialize(block_bin); err != nil {
-		return err
+		return nil, err
 	}
 
 	if !bresult.Block_Header.SideBlock && len(bl.Tx_hashes) >= 1 {
@@ -765,12 +727,12 @@ func (w *Wallet_Memory) synchistory_block(scid crypto.Hash, topo int64) (err err
 			//fmt.Printf("Requesting tx data %s\n", bl.Tx_hashes[i].String())
 
 			if err = rpc_client.Call("DERO.GetTransaction", tx_params, &tx_result); err != nil {
-				return fmt.Errorf("gettransa rpc failed %s", err)
+				return nil, fmt.Errorf("gettransa rpc failed %s", err)
 			}
 
 			tx_bin, err := hex.DecodeString(tx_result.Txs_as_hex[0])
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if err = tx.Deserialize(tx_bin); err != nil {
 				logger.V(1).Error(err, "Error deserialing tx", "txid", bl.Tx_hashes[i].String(), "incoming bytes", tx_result.Txs_as_hex[0])
@@ -783,7 +745,7 @@ func (w *Wallet_Memory) synchistory_block(scid crypto.Hash, topo int64) (err err
 
 			// if daemon was syncing/or disk corrupption, it may not give data, so skip
 			if len(tx_result.Txs) == 0 {
-				return fmt.Errorf("Daemon did not expandd tx %s", bl.Tx_hashes[i].String())
+				return nil, fmt.Errorf("Daemon did not expandd tx %s", bl.Tx_hashes[i].String())
 			}
 
 			// since balance might change with tx, we track within tx using this
@@ -873,12 +835,7 @@ func (w *Wallet_Memory) synchistory_block(scid crypto.Hash, topo int64) (err err
 							entry.Status = 1                        // mark it as spend
 							total_sent += entry.Amount + entry.Fees // burn is in amount
 
-							rinputs := append([]byte{}, tx.Payloads[t].Statement.Roothash[:]...)
-							for l := range tx.Payloads[t].Statement.Publickeylist_compressed {
-								rinputs = append(rinputs, tx.Payloads[t].Statement.Publickeylist_compressed[l][:]...)
-							}
-							rencrypted := new(bn256.G1).ScalarMult(crypto.HashToPoint(crypto.HashtoNumber(append([]byte(crypto.PROTOCOL_CONSTANT), rinputs...))), w.account.Keys.Secret.BigInt())
-							r := crypto.ReducedHash(rencrypted.EncodeCompressed())
+							r := payload_secret(w.account.Keys.Secret.BigInt(), &tx.Payloads[t].Statement)
 
 							//fmt.Printf("t %d r  calculated %s value amount %d burn %d\n", t, r.Text(16), entry.Amount,entry.Burn)
 
@@ -1056,15 +1013,5 @@ func (w *Wallet_Memory) synchistory_block(scid crypto.Hash, topo int64) (err err
 		//fmt.Printf("Coinbase Reward %s for block %d\n", globals.FormatMoney(current_balance-(previous_balance-total_sent+total_received)), topo)
 	}
 
-	for _, e := range local_entries {
-		w.InsertReplace(scid, e)
-	}
-
-	if len(local_entries) >= 1 {
-		w.save_if_disk() // save wallet()
-		//	w.db.Sync()
-	}
-
-	return nil
-
+	return local_entries, nil
 }
@@ -0,0 +1,412 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "sort"
+import "sync"
+import "time"
+import "bytes"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+// this file implements parallel and resumable history sync
+// a block involves the account, if encrypted balance at its topoheight differs from the previous topoheight
+// the range to sync is split into chunks, encrypted balances at chunk boundaries are fetched concurrently
+// chunks whose boundaries differ are bisected concurrently, to find all blocks where balance changed, these blocks are then fetched concurrently
+// all daemon requests made while syncing share a bounded worker pool
+// entries are inserted in topological order, after every chunk a checkpoint is recorded in the wallet, so an interrupted sync resumes from there
+
+const sync_chunk_size = 4096   // topoheights per chunk, a checkpoint is recorded after every chunk
+const sync_workers_default = 8 // concurrent daemon requests while syncing
+const sync_scid_workers = 4    // scids whose history is synced concurrently
+const sync_save_every = 30 * time.Second
+
+type SyncCheckpoint struct {
+	TopoHeight int64       `json:"topoheight"` // history is complete till this topoheight
+	BlockHash  crypto.Hash `json:"blockhash"`  // block at topoheight, checkpoint is discarded if chain reorganised
+}
+
+// sets number of concurrent daemon requests used while syncing, a negative value returns current value
+func (w *Wallet_Memory) SetSyncWorkers(workers int) (old int) {
+	w.Lock()
+	defer w.Unlock()
+	old = w.sync_workers
+	if old == 0 {
+		old = sync_workers_default
+	}
+	if workers > 0 {
+		w.sync_workers = workers
+		w.sync_limiter = nil // will be recreated with new size
+	}
+	return
+}
+
+// callback is triggered whenever sync progresses, it is called from syncing goroutines and must not block for long
+func (w *Wallet_Memory) SetSyncProgressCallback(callback func(rpc.Sync_Progress)) {
+	w.Lock()
+	defer w.Unlock()
+	w.sync_progress_callback = callback
+}
+
+// progress of all syncs, since wallet was opened
+func (w *Wallet_Memory) GetSyncProgress() (progress []rpc.Sync_Progress) {
+	w.Lock()
+	defer w.Unlock()
+	for _, p := range w.sync_progress {
+		progress = append(progress, p)
+	}
+	sort.Slice(progress, func(i, j int) bool { return bytes.Compare(progress[i].SCID[:], progress[j].SCID[:]) < 0 })
+	return
+}
+
+func (w *Wallet_Memory) set_sync_progress(p rpc.Sync_Progress) {
+	w.Lock()
+	if w.sync_progress == nil {
+		w.sync_progress = map[crypto.Hash]rpc.Sync_Progress{}
+	}
+	w.sync_progress[p.SCID] = p
+	callback := w.sync_progress_callback
+	w.Unlock()
+
+	if callback != nil {
+		callback(p)
+	}
+}
+
+// calculate progress and estimated time remaining
+func sync_progress_estimate(p *rpc.Sync_Progress, elapsed time.Duration) {
+	total := p.End_TopoHeight - p.Start_TopoHeight + 1
+	done := p.TopoHeight - p.Start_TopoHeight + 1
+	if total <= 0 || done >= total {
+		p.Percent, p.ETA = 100, 0
+		return
+	}
+	if done <= 0 {
+		p.Percent, p.ETA = 0, -1 // unknown
+		return
+	}
+	p.Percent = float64(done) * 100 / float64(total)
+	p.ETA = int64(elapsed.Seconds() * float64(total-done) / float64(done))
+}
+
+// worker pool shared by all syncing goroutines
+func (w *Wallet_Memory) get_sync_limiter() chan struct{} {
+	w.Lock()
+	defer w.Unlock()
+	if w.sync_limiter == nil {
+		workers := w.sync_workers
+		if workers <= 0 {
+			workers = sync_workers_default
+		}
+		w.sync_limiter = make(chan struct{}, workers)
+	}
+	return w.sync_limiter
+}
+
+// get checkpoint of scid, checkpoint is only returned if its block is still at same topoheight
+func (w *Wallet_Memory) get_sync_checkpoint(scid crypto.Hash) (cp SyncCheckpoint, ok bool) {
+	w.Lock()
+	cp, ok = w.account.SyncCheckpoints[scid]
+	w.Unlock()
+	if !ok {
+		return
+	}
+
+	var result rpc.GetBlockHeaderByHeight_Result
+	if err := rpc_client.Call("DERO.GetBlockHeaderByTopoHeight", rpc.GetBlockHeaderByTopoHeight_Params{TopoHeight: uint64(cp.TopoHeight)}, &result); err != nil || result.Status != "OK" {
+		return cp, false
+	}
+	if result.Block_Header.Hash != cp.BlockHash.String() {
+		logger.Info("sync checkpoint discarded due to reorganisation", "scid", scid, "topoheight", cp.TopoHeight)
+		return cp, false
+	}
+	return cp, true
+}
+
+func (w *Wallet_Memory) set_sync_checkpoint(scid crypto.Hash, cp SyncCheckpoint) {
+	w.Lock()
+	defer w.Unlock()
+	if w.account.SyncCheckpoints == nil {
+		w.account.SyncCheckpoints = map[crypto.Hash]SyncCheckpoint{}
+	}
+	w.account.SyncCheckpoints[scid] = cp
+}
+
+// history of scid is no longer complete from topo onwards, lock must be held by caller
+// checkpoint covering topo is dropped, so that the next sync fetches the range again
+func (w *Wallet_Memory) invalidate_sync_checkpoint(scid crypto.Hash, topo int64) {
+	if cp, ok := w.account.SyncCheckpoints[scid]; ok && cp.TopoHeight >= topo {
+		delete(w.account.SyncCheckpoints, scid)
+	}
+}
+
+// keep first n entries of scid, lock must be held by caller
+func (w *Wallet_Memory) truncate_history(scid crypto.Hash, n int) {
+	entries := w.account.EntriesNative[scid]
+	if n >= len(entries) {
+		return
+	}
+	w.invalidate_sync_checkpoint(scid, entries[n].TopoHeight)
+	w.webhook_entries_removed(scid, entries[n:])
+	w.account.EntriesNative[scid] = entries[:n]
+}
+
+// sync balance of all scids, history of scids whose balance changed is then synced concurrently
+func (w *Wallet_Memory) sync_all_scids() {
+	var scids []crypto.Hash
+	w.Lock()
+	for scid := range w.account.EntriesNative {
+		scids = append(scids, scid)
+	}
+	w.Unlock()
+
+	var changed []crypto.Hash
+	for _, scid := range scids {
+		c, err := w.sync_balance(scid)
+		if err != nil {
+			if scid.IsZero() {
+				logger.Error(err, "wallet syncing err")
+			}
+			continue
+		}
+		if c {
+			changed = append(changed, scid)
+		}
+	}
+
+	var wg sync.WaitGroup
+	limiter := make(chan struct{}, sync_scid_workers)
+	for _, scid := range changed {
+		wg.Add(1)
+		limiter <- struct{}{}
+		go func(scid crypto.Hash) {
+			defer func() { <-limiter; wg.Done() }()
+			w.SyncHistory(scid)
+		}(scid)
+	}
+	wg.Wait()
+	w.save_if_disk()
+
+	for _, scid := range scids {
+		w.VerifyHistory(scid) // detect entries which are no longer part of main chain
+	}
+}
+
+// state of a single history sync
+type history_sync struct {
+	w            *Wallet_Memory
+	scid         crypto.Hash
+	registration int64
+	limiter      chan struct{}
+	get_balance  func(topo int64) (*crypto.ElGamal, crypto.Hash, error) // fetch encrypted balance from daemon
+}
+
+func (w *Wallet_Memory) new_history_sync(scid crypto.Hash) *history_sync {
+	s := &history_sync{w: w, scid: scid, registration: w.getEncryptedBalanceresult(scid).Registration, limiter: w.get_sync_limiter()}
+	s.get_balance = func(topo int64) (*crypto.ElGamal, crypto.Hash, error) {
+		_, _, blid, e, err := w.GetEncryptedBalanceAtTopoHeight(scid, topo, w.GetAddress().String())
+		return e, blid, err
+	}
+	return s
+}
+
+// encrypted balance at topoheight, balance before registration is zero
+func (s *history_sync) balance(topo int64) (e *crypto.ElGamal, blid crypto.Hash, err error) {
+	if topo < s.registration {
+		return crypto.ConstructElGamal(s.w.account.Keys.Public.G1(), crypto.ElGamal_BASE_G), blid, nil
+	}
+	s.limiter <- struct{}{}
+	defer func() { <-s.limiter }()
+	return s.get_balance(topo)
+}
+
+// fetch balances at multiple topoheights concurrently
+func (s *history_sync) balances(topos []int64) (balances []*crypto.ElGamal, blids []crypto.Hash, err error) {
+	balances = make([]*crypto.ElGamal, len(topos))
+	blids = make([]crypto.Hash, len(topos))
+	errs := make([]error, len(topos))
+
+	var wg sync.WaitGroup
+	for i := range topos {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			balances[i], blids[i], errs[i] = s.balance(topos[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range errs {
+		if errs[i] != nil {
+			return nil, nil, fmt.Errorf("balance at topoheight %d err %s", topos[i], errs[i])
+		}
+	}
+	return
+}
+
+// find all topoheights within (lo, hi] where encrypted balance changed, by concurrent bisection
+func (s *history_sync) find_changes(lo int64, lo_e *crypto.ElGamal, hi int64, hi_e *crypto.ElGamal) (changes []int64, err error) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+
+	var find func(lo int64, lo_e *crypto.ElGamal, hi int64, hi_e *crypto.ElGamal)
+	find = func(lo int64, lo_e *crypto.ElGamal, hi int64, hi_e *crypto.ElGamal) {
+		defer wg.Done()
+		if bytes.Equal(lo_e.Serialize(), hi_e.Serialize()) {
+			return
+		}
+		if hi-lo <= 1 {
+			mutex.Lock()
+			changes = append(changes, hi)
+			mutex.Unlock()
+			return
+		}
+
+		median := lo + (hi-lo)/2
+		median_e, _, merr := s.balance(median)
+		if merr != nil {
+			mutex.Lock()
+			if err == nil {
+				err = fmt.Errorf("balance at topoheight %d err %s", median, merr)
+			}
+			mutex.Unlock()
+			return
+		}
+		wg.Add(2)
+		go find(lo, lo_e, median, median_e)
+		go find(median, median_e, hi, hi_e)
+	}
+
+	wg.Add(1)
+	go find(lo, lo_e, hi, hi_e)
+	wg.Wait()
+
+	// registration block is always processed
+	if lo < s.registration && s.registration <= hi {
+		found := false
+		for _, topo := range changes {
+			found = found || topo == s.registration
+		}
+		if !found {
+			changes = append(changes, s.registration)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i] < changes[j] })
+	return
+}
+
+// fetch entries of multiple blocks concurrently, entries are returned in topological order
+func (s *history_sync) fetch_blocks(topos []int64) (entries []rpc.Entry, err error) {
+	results := make([][]rpc.Entry, len(topos))
+	errs := make([]error, len(topos))
+
+	var wg sync.WaitGroup
+	for i := range topos {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.limiter <- struct{}{}
+			defer func() { <-s.limiter }()
+			results[i], errs[i] = s.w.synchistory_block(s.scid, topos[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range topos {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("syncing block at topoheight %d err %s", topos[i], errs[i])
+		}
+		entries = append(entries, results[i]...)
+	}
+	return
+}
+
+// sync history of blocks from start_topo to end_topo, both inclusive
+func (w *Wallet_Memory) synchistory_internal(scid crypto.Hash, start_topo, end_topo int64) (err error) {
+	s := w.new_history_sync(scid)
+
+	if w.account.TrackRecentBlocks > 0 && daemon_topoheight >= w.account.TrackRecentBlocks {
+		start_topo = daemon_topoheight - w.account.TrackRecentBlocks
+	}
+	if start_topo < s.registration {
+		start_topo = s.registration
+	}
+	if cp, ok := w.get_sync_checkpoint(scid); ok && cp.TopoHeight >= start_topo && cp.TopoHeight < end_topo {
+		logger.Info("syncing resumes from checkpoint", "scid", scid, "checkpoint", cp.TopoHeight)
+		start_topo = cp.TopoHeight + 1
+	}
+
+	logger.Info("syncing loop  starting internal ", "start_topo", start_topo, "end_topo", end_topo)
+
+	progress := rpc.Sync_Progress{SCID: scid, Start_TopoHeight: start_topo, End_TopoHeight: end_topo, TopoHeight: start_topo - 1}
+	started, lastsaved := time.Now(), time.Now()
+	defer func() {
+		progress.Done = err == nil
+		sync_progress_estimate(&progress, time.Since(started))
+		w.set_sync_progress(progress)
+	}()
+
+	if start_topo > end_topo {
+		return nil
+	}
+
+	// history is complete till start_topo - 1, so chunks start from there
+	var boundaries []int64
+	for topo := start_topo - 1; topo < end_topo; topo += sync_chunk_size {
+		boundaries = append(boundaries, topo)
+	}
+	boundaries = append(boundaries, end_topo)
+
+	balances, blids, err := s.balances(boundaries)
+	if err != nil {
+		logger.Error(err, "syncing info failed", "start_topo", start_topo, "end_topo", end_topo)
+		return err
+	}
+
+	for i := 1; i < len(boundaries); i++ {
+		changes, err := s.find_changes(boundaries[i-1], balances[i-1], boundaries[i], balances[i])
+		if err != nil {
+			logger.Error(err, "syncing failed", "start_topo", boundaries[i-1], "end_topo", boundaries[i])
+			return err
+		}
+
+		entries, err := s.fetch_blocks(changes)
+		if err != nil {
+			logger.Error(err, "syncing block failed", "start_topo", boundaries[i-1], "end_topo", boundaries[i])
+			return err
+		}
+		for _, e := range entries {
+			w.InsertReplace(scid, e)
+		}
+
+		w.set_sync_checkpoint(scid, SyncCheckpoint{TopoHeight: boundaries[i], BlockHash: blids[i]})
+		if len(entries) >= 1 || time.Since(lastsaved) > sync_save_every {
+			w.save_if_disk() // save wallet()
+			lastsaved = time.Now()
+		}
+
+		progress.TopoHeight = boundaries[i]
+		sync_progress_estimate(&progress, time.Since(started))
+		if i < len(boundaries)-1 {
+			w.set_sync_progress(progress)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "time"
+import "testing"
+import "math/big"
+import "sync/atomic"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/blockchain"
+import "github.com/deroproject/derohe/transaction"
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/cryptography/bn256"
+
+func Test_Sync_Find_Changes(t *testing.T) {
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	changed := []int64{7, 8, 1000, 5000, 9999} // topoheights where balance changes
+	balance_at := func(topo int64) *crypto.ElGamal {
+		count := int64(0)
+		for _, c := range changed {
+			if c <= topo {
+				count++
+			}
+		}
+		return crypto.ConstructElGamal(new(bn256.G1).ScalarMult(crypto.G, big.NewInt(count+1)), crypto.ElGamal_BASE_G)
+	}
+
+	var calls int64
+	s := &history_sync{w: w, registration: 5, limiter: make(chan struct{}, 4)}
+	s.get_balance = func(topo int64) (*crypto.ElGamal, crypto.Hash, error) {
+		atomic.AddInt64(&calls, 1)
+		return balance_at(topo), crypto.Hash{}, nil
+	}
+
+	lo_e, _, _ := s.balance(4)
+	hi_e, _, _ := s.balance(10000)
+	changes, err := s.find_changes(4, lo_e, 10000, hi_e)
+	if err != nil || fmt.Sprint(changes) != "[5 7 8 1000 5000 9999]" {
+		t.Fatalf("incorrect changes %v err %v", changes, err)
+	}
+	if calls > 100 {
+		t.Fatalf("bisection made too many requests %d", calls)
+	}
+
+	if changes, err = s.find_changes(1000, balance_at(1000), 4999, balance_at(4999)); err != nil || len(changes) != 0 {
+		t.Fatalf("unchanged range must not report changes %v err %v", changes, err)
+	}
+
+	s.get_balance = func(topo int64) (*crypto.ElGamal, crypto.Hash, error) {
+		return nil, crypto.Hash{}, fmt.Errorf("offline")
+	}
+	if _, err = s.find_changes(10, balance_at(10), 2000, balance_at(2000)); err == nil {
+		t.Fatalf("errors must be reported")
+	}
+}
+
+func Test_Sync_Progress_Estimate(t *testing.T) {
+	p := rpc.Sync_Progress{Start_TopoHeight: 1, End_TopoHeight: 100, TopoHeight: 25}
+	sync_progress_estimate(&p, 10*time.Second)
+	if p.Percent != 25 || p.ETA != 30 {
+		t.Fatalf("incorrect estimate %+v", p)
+	}
+
+	p.TopoHeight = 0
+	sync_progress_estimate(&p, time.Second)
+	if p.Percent != 0 || p.ETA != -1 {
+		t.Fatalf("incorrect estimate %+v", p)
+	}
+
+	p.TopoHeight = 100
+	sync_progress_estimate(&p, time.Second)
+	if p.Percent != 100 || p.ETA != 0 {
+		t.Fatalf("incorrect estimate %+v", p)
+	}
+}
+
+// history discarded by reorganisation or verification must be fetched again, even though sync checkpoint is ahead
+func Test_Sync_Truncated_History(t *testing.T) {
+	Initialize_LookupTable(1, 1<<17)
+
+	wgenesis, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "perfil lujo faja puma favor pedir detalle doble carbón neón paella cuarto ánimo cuento conga correr dental moneda león donar entero logro realidad acceso doble")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	genesis_tx := transaction.Transaction{Transaction_Prefix: transaction.Transaction_Prefix{Version: 1, Value: 2012345}}
+	copy(genesis_tx.MinerAddress[:], wgenesis.account.Keys.Public.EncodeCompressed())
+	config.Testnet.Genesis_Tx = fmt.Sprintf("%x", genesis_tx.Serialize())
+	config.Mainnet.Genesis_Tx = fmt.Sprintf("%x", genesis_tx.Serialize())
+	genesis_block := blockchain.Generate_Genesis_Block()
+	config.Testnet.Genesis_Block_Hash = genesis_block.GetHash()
+	config.Mainnet.Genesis_Block_Hash = genesis_block.GetHash()
+
+	chain, rpcserver, _ := simulator_chain_start()
+	defer simulator_chain_stop(chain, rpcserver)
+	globals.Arguments["--daemon-address"] = rpcport
+	go Keep_Connectivity()
+
+	for i := 0; i < 6; i++ {
+		simulator_chain_mineblock(chain, wgenesis.GetAddress(), t)
+	}
+	wgenesis.SetDaemonAddress(rpcport)
+	wgenesis.SetOnlineMode()
+	time.Sleep(time.Second)
+	if err = wgenesis.Sync_Wallet_Memory_With_Daemon(); err != nil {
+		t.Fatalf("wallet sync error err %s", err)
+	}
+
+	var zerohash crypto.Hash
+	synced := len(wgenesis.account.EntriesNative[zerohash])
+	if _, ok := wgenesis.account.SyncCheckpoints[zerohash]; !ok || synced < 4 {
+		t.Fatalf("history must be synced with checkpoint, entries %d", synced)
+	}
+
+	for _, keep := range []int{synced / 2, 0} {
+		wgenesis.Lock()
+		wgenesis.truncate_history(zerohash, keep)
+		wgenesis.Unlock()
+		if _, ok := wgenesis.account.SyncCheckpoints[zerohash]; ok {
+			t.Fatalf("checkpoint must not survive truncation")
+		}
+
+		wgenesis.SyncHistory(zerohash)
+		if len(wgenesis.account.EntriesNative[zerohash]) != synced {
+			t.Fatalf("truncated history must be fetched again, expected %d actual %d", synced, len(wgenesis.account.EntriesNative[zerohash]))
+		}
+	}
+}
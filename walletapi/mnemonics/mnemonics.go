@@ -72,7 +72,6 @@ func Language_List() (list []string) {
 // this function converts a list of words to a key
 func Words_To_Key(words_line string) (language_name string, keybig *big.Int, err error) {
 
-	var key [32]byte
 	checksum_present := false
 	words := strings.Fields(words_line)
 	//rlog.Tracef(1, "len of words %d", words)
@@ -102,51 +101,52 @@ func Words_To_Key(words_line string) (language_name string, keybig *big.Int, err
 		}
 	}
 
-	// key = make([]byte,(SEED_LENGTH/3)*4,(SEED_LENGTH/3)*4) // our keys are  32 bytes
+	key := indices_to_key(indices, wordlist_length)
+	keybig = new(big.Int).SetBytes(key[:])
 
-	// map 3 words to 4 bytes each
-	// so 24 words = 32 bytes
-	for i := 0; i < (SEED_LENGTH / 3); i++ {
-		w1 := indices[i*3]
-		w2 := indices[i*3+1]
-		w3 := indices[i*3+2]
+	return
+}
 
-		val := w1 + wordlist_length*(((wordlist_length-w1)+w2)%wordlist_length) +
-			wordlist_length*wordlist_length*(((wordlist_length-w2)+w3)%wordlist_length)
+// this will map the key to recovery words from the spcific language
+// language must exist,if not we return english
+func Key_To_Words(keybig *big.Int, language string) (words_line string) {
+	var words []string // all words are appended here
 
-			// sanity check, this can never occur
-		if (val % wordlist_length) != w1 {
-			panic("Word list error")
-		}
+	l_index := language_index(language)
 
-		value_32bit := uint32(val)
+	words = key_to_words(key_to_bytes(keybig), l_index)
 
-		binary.LittleEndian.PutUint32(key[i*4:], value_32bit) // place key into output container
+	checksum_index, err := Calculate_Checksum_Index(words, Languages[l_index].Unique_Prefix_Length)
+	if err != nil {
+		//fmt.Printf("Checksum index failed")
+		return
+
+	} else {
+		// append checksum word
+		words = append(words, words[checksum_index])
 
-		//memcpy(dst.data + i * 4, &val, 4);  // copy 4 bytes to position
 	}
-	//fmt.Printf("words %+v\n", indices)
-	//fmt.Printf("key %x\n", key)
 
-	keybig = new(big.Int).SetBytes(key[:])
+	words_line = strings.Join(words, " ")
+
+	//fmt.Printf("words %s \n", words_line)
 
 	return
-}
 
-// this will map the key to recovery words from the spcific language
-// language must exist,if not we return english
-func Key_To_Words(keybig *big.Int, language string) (words_line string) {
-	var key [32]byte
-	var words []string // all words are appended here
+}
 
-	l_index := 0
+// index of language, if language does not exist, english is used
+func language_index(language string) int {
 	for i := range Languages {
 		if Languages[i].Name == language {
-			l_index = i
-			break
+			return i
 		}
 	}
+	return 0
+}
 
+// convert key to 32 bytes
+func key_to_bytes(keybig *big.Int) (key [32]byte) {
 	// FillBytes not available pre 1.15
 	bb := keybig.Bytes()
 	j := 32
@@ -155,7 +155,11 @@ func Key_To_Words(keybig *big.Int, language string) (words_line string) {
 		key[j] = bb[i]
 
 	}
+	return
+}
 
+// map 32 byte key to 24 words of the language
+func key_to_words(key [32]byte, l_index int) (words []string) {
 	// total numbers of words in specified language dictionary
 	word_list_length := uint32(len(Languages[l_index].Words))
 
@@ -173,24 +177,35 @@ func Key_To_Words(keybig *big.Int, language string) (words_line string) {
 		words = append(words, Languages[l_index].Words[w2])
 		words = append(words, Languages[l_index].Words[w3])
 	}
+	return
+}
 
-	checksum_index, err := Calculate_Checksum_Index(words, Languages[l_index].Unique_Prefix_Length)
-	if err != nil {
-		//fmt.Printf("Checksum index failed")
-		return
+// map indices of 24 words to 32 byte key
+func indices_to_key(indices []uint64, wordlist_length uint64) (key [32]byte) {
+	// key = make([]byte,(SEED_LENGTH/3)*4,(SEED_LENGTH/3)*4) // our keys are  32 bytes
 
-	} else {
-		// append checksum word
-		words = append(words, words[checksum_index])
+	// map 3 words to 4 bytes each
+	// so 24 words = 32 bytes
+	for i := 0; i < (SEED_LENGTH / 3); i++ {
+		w1 := indices[i*3]
+		w2 := indices[i*3+1]
+		w3 := indices[i*3+2]
 
-	}
+		val := w1 + wordlist_length*(((wordlist_length-w1)+w2)%wordlist_length) +
+			wordlist_length*wordlist_length*(((wordlist_length-w2)+w3)%wordlist_length)
 
-	words_line = strings.Join(words, " ")
+			// sanity check, this can never occur
+		if (val % wordlist_length) != w1 {
+			panic("Word list error")
+		}
 
-	//fmt.Printf("words %s \n", words_line)
+		value_32bit := uint32(val)
 
-	return
+		binary.LittleEndian.PutUint32(key[i*4:], value_32bit) // place key into output container
 
+		//memcpy(dst.data + i * 4, &val, 4);  // copy 4 bytes to position
+	}
+	return
 }
 
 // find language and indices
@@ -238,12 +253,15 @@ func Find_indices(words []string) (indices []uint64, language_index int, word_li
 // take mod of SEED_LENGTH 24, to get the checksum word
 
 func Calculate_Checksum_Index(words []string, prefix_len int) (uint32, error) {
-	var trimmed_runes []rune
-
 	if len(words) != SEED_LENGTH {
 		return 0, fmt.Errorf("Words not equal to seed length")
 	}
+	return checksum_index(words, prefix_len), nil
+}
 
+// checksum of any number of words, result is index of checksum word
+func checksum_index(words []string, prefix_len int) uint32 {
+	var trimmed_runes []rune
 	for i := range words {
 		if utf8.RuneCountInString(words[i]) > prefix_len { // take first prefix_len utf8 chars
 			trimmed_runes = append(trimmed_runes, ([]rune(words[i]))[0:prefix_len]...)
@@ -257,7 +275,7 @@ func Calculate_Checksum_Index(words []string, prefix_len int) (uint32, error) {
 
 	//fmt.Printf("trimmed words %s  %d \n", string(trimmed_runes), checksum)
 
-	return checksum % SEED_LENGTH, nil
+	return checksum % uint32(len(words))
 
 }
 
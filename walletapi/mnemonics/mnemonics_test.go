@@ -17,6 +17,7 @@
 package mnemonics
 
 import "testing"
+import "strings"
 
 // we are covering atleast one test case each for all supported languages
 
@@ -117,3 +118,76 @@ func Test_Mnemonics(t *testing.T) {
 	}
 
 }
+
+// shares must survive mnemonic encode/decode round trip in every language and recover the key
+func Test_Shares(t *testing.T) {
+	_, key, err := Words_To_Key("sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly")
+	if err != nil {
+		t.Fatalf("Mnemonics testing failed err %s", err)
+	}
+
+	if _, err = Split_Key(key, 4, 3); err == nil {
+		t.Fatalf("threshold larger than shares must fail")
+	}
+
+	shares, err := Split_Key(key, 3, 5)
+	if err != nil || len(shares) != 5 {
+		t.Fatalf("Split key failed err %s", err)
+	}
+
+	for _, language := range Language_List() {
+		var decoded []Share
+		for _, share := range shares {
+			words := Share_To_Words(share, language)
+			if len(strings.Fields(words)) != SHARE_LENGTH {
+				t.Fatalf("%s share must be %d words", language, SHARE_LENGTH)
+			}
+			l, d, err := Words_To_Share(words)
+			if err != nil || l != language || d.ID != share.ID || d.Threshold != 3 || d.Index != share.Index || d.Key.Cmp(share.Key) != 0 || d.Checksum != share.Checksum {
+				t.Fatalf("%s share round trip failed err %s", language, err)
+			}
+			decoded = append(decoded, d)
+		}
+
+		if recovered, err := Combine_Shares(decoded[2:]); err != nil || recovered.Cmp(key) != 0 {
+			t.Fatalf("%s key recovery failed err %s", language, err)
+		}
+	}
+
+	// any threshold shares recover the key, less shares do not
+	if recovered, err := Combine_Shares([]Share{shares[4], shares[0], shares[2]}); err != nil || recovered.Cmp(key) != 0 {
+		t.Fatalf("key recovery failed err %s", err)
+	}
+	if _, err = Combine_Shares([]Share{shares[1], shares[3], shares[1]}); err == nil {
+		t.Fatalf("duplicate shares must not recover key")
+	}
+
+	// shares of another split cannot be mixed
+	other, _ := Split_Key(key, 3, 5)
+	other[1].ID = shares[0].ID + 1
+	if _, err = Combine_Shares([]Share{shares[0], shares[1], other[1]}); err == nil {
+		t.Fatalf("shares of different sets must not be combined")
+	}
+	other[2].ID = shares[0].ID // set ids collide, key checksum still detects mixing
+	if _, err = Combine_Shares([]Share{shares[0], shares[1], other[2]}); err == nil {
+		t.Fatalf("shares of different splits with same set id must not be combined")
+	}
+
+	// corrupted share is detected by checksum
+	line := Share_To_Words(shares[0], "English")
+	words := strings.Fields(line)
+	for _, w := range Mnemonics_English.Words {
+		if !strings.Contains(line, w) {
+			words[SHARE_LENGTH-1] = w
+			break
+		}
+	}
+	if _, _, err = Words_To_Share(strings.Join(words, " ")); err == nil {
+		t.Fatalf("corrupted share must fail checksum")
+	}
+
+	// seeds are not shares
+	if _, _, err = Words_To_Share(Key_To_Words(key, "English")); err == nil {
+		t.Fatalf("seed must not decode as share")
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2017-2018 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package mnemonics
+
+import "fmt"
+import "bytes"
+import "strings"
+import "math/big"
+import "crypto/rand"
+import "crypto/sha256"
+import "encoding/binary"
+
+// this file implements k of n secret sharing of keys, so custody of a seed can be split across several people
+// every byte of the key is split independently using shamir secret sharing over GF(256)
+// so any 32 byte key is supported, shares are exactly as large as the key
+// a share is encoded as SHARE_LENGTH words, set id, threshold, index, 24 key words, 3 key checksum words and a checksum word
+// set id is random and detects mixing shares of most different splits, it is too short to detect all of them
+// so a checksum of the key is split along with the key and verified after combining, shares do not reveal it
+
+const SHARE_LENGTH = SEED_LENGTH + 7 // checksum shares are 3 + 24 + 3 + 1 = 31 words long
+const MAX_SHARES = 255
+const KEY_CHECKSUM_LENGTH = 3 // bytes of sha256 of key, mixed shares go undetected with probability 2^-24
+
+// a single share of a key
+type Share struct {
+	ID        uint16                    // identifies shares created together
+	Threshold uint8                     // shares required to recover key
+	Index     uint8                     // x coordinate of share, 1 to 255
+	Key       *big.Int                  // y coordinates of share
+	Checksum  [KEY_CHECKSUM_LENGTH]byte // y coordinates of key checksum
+}
+
+var gf_exp [510]byte
+var gf_log [256]byte
+
+// build GF(256) tables using AES polynomial x^8 + x^4 + x^3 + x + 1 and generator 3
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gf_exp[i] = x
+		gf_exp[i+255] = x
+		gf_log[x] = byte(i)
+		x ^= x<<1 ^ byte(0x1b&-(x>>7)) // x = x*3
+	}
+}
+
+func gf_mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gf_exp[int(gf_log[a])+int(gf_log[b])]
+}
+
+func gf_div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gf_exp[int(gf_log[a])+255-int(gf_log[b])]
+}
+
+// split key in count shares, any threshold shares recover the key
+func Split_Key(keybig *big.Int, threshold, count int) (shares []Share, err error) {
+	if threshold < 2 || threshold > count || count > MAX_SHARES {
+		return nil, fmt.Errorf("invalid threshold %d of %d shares, 2 <= threshold <= shares <= %d", threshold, count, MAX_SHARES)
+	}
+	if keybig.Sign() < 0 || keybig.BitLen() > 256 {
+		return nil, fmt.Errorf("key must be 32 bytes")
+	}
+	key32 := key_to_bytes(keybig)
+	checksum := sha256.Sum256(key32[:])
+	key := append(key32[:], checksum[:KEY_CHECKSUM_LENGTH]...) // checksum is split along with the key
+
+	var id [2]byte
+	if _, err = rand.Read(id[:]); err != nil {
+		return
+	}
+	set_id := binary.LittleEndian.Uint16(id[:]) % uint16(len(Languages[0].Words))
+
+	// random polynomial for every byte, constant term is the key byte
+	coefficients := make([]byte, len(key)*(threshold-1))
+	if _, err = rand.Read(coefficients); err != nil {
+		return
+	}
+
+	for x := 1; x <= count; x++ {
+		y := make([]byte, len(key))
+		for i := range key {
+			c := coefficients[i*(threshold-1) : (i+1)*(threshold-1)]
+			acc := byte(0)
+			for j := len(c) - 1; j >= 0; j-- { // horner's method
+				acc = gf_mul(acc, byte(x)) ^ c[j]
+			}
+			y[i] = gf_mul(acc, byte(x)) ^ key[i]
+		}
+		share := Share{ID: set_id, Threshold: uint8(threshold), Index: uint8(x), Key: new(big.Int).SetBytes(y[:32])}
+		copy(share.Checksum[:], y[32:])
+		shares = append(shares, share)
+	}
+	return
+}
+
+// recover key from shares, atleast threshold distinct shares of the same set are required
+func Combine_Shares(shares []Share) (keybig *big.Int, err error) {
+	if len(shares) < 1 {
+		return nil, fmt.Errorf("no shares")
+	}
+	threshold := int(shares[0].Threshold)
+	seen := map[uint8]bool{}
+	var selected []Share
+	for _, s := range shares {
+		if s.ID != shares[0].ID || int(s.Threshold) != threshold {
+			return nil, fmt.Errorf("shares belong to different sets")
+		}
+		if s.Index == 0 || s.Key == nil || s.Key.BitLen() > 256 {
+			return nil, fmt.Errorf("invalid share")
+		}
+		if !seen[s.Index] && len(selected) < threshold {
+			seen[s.Index] = true
+			selected = append(selected, s)
+		}
+	}
+	if threshold < 2 || len(selected) < threshold {
+		return nil, fmt.Errorf("%d shares required, %d distinct shares supplied", threshold, len(selected))
+	}
+
+	// lagrange interpolation at x = 0, subtraction is xor in GF(256)
+	var key [32 + KEY_CHECKSUM_LENGTH]byte
+	for i, s := range selected {
+		basis := byte(1)
+		for j, o := range selected {
+			if i != j {
+				basis = gf_mul(basis, gf_div(o.Index, o.Index^s.Index))
+			}
+		}
+		y32 := key_to_bytes(s.Key)
+		y := append(y32[:], s.Checksum[:]...)
+		for k := range key {
+			key[k] ^= gf_mul(y[k], basis)
+		}
+	}
+	checksum := sha256.Sum256(key[:32])
+	if !bytes.Equal(checksum[:KEY_CHECKSUM_LENGTH], key[32:]) {
+		return nil, fmt.Errorf("shares do not recover a valid key, they probably belong to different sets")
+	}
+	return new(big.Int).SetBytes(key[:32]), nil
+}
+
+// encode share as words of the language, if language does not exist, english is used
+func Share_To_Words(share Share, language string) (words_line string) {
+	l_index := language_index(language)
+	list := Languages[l_index].Words
+
+	words := []string{list[int(share.ID)%len(list)], list[share.Threshold], list[share.Index]}
+	words = append(words, key_to_words(key_to_bytes(share.Key), l_index)...)
+	for _, b := range share.Checksum {
+		words = append(words, list[b])
+	}
+	words = append(words, words[checksum_index(words, Languages[l_index].Unique_Prefix_Length)])
+	return strings.Join(words, " ")
+}
+
+// decode share from words, checksum is verified
+func Words_To_Share(words_line string) (language_name string, share Share, err error) {
+	words := strings.Fields(words_line)
+	if len(words) != SHARE_LENGTH {
+		err = fmt.Errorf("Invalid Share, share must be %d words", SHARE_LENGTH)
+		return
+	}
+
+	indices, language_index, wordlist_length, found := Find_indices(words)
+	if !found {
+		err = fmt.Errorf("Share not found in any Language")
+		return
+	}
+	language_name = Languages[language_index].Name
+
+	if words[checksum_index(words[:SHARE_LENGTH-1], Languages[language_index].Unique_Prefix_Length)] != words[SHARE_LENGTH-1] {
+		err = fmt.Errorf("Share Checksum failed")
+		return
+	}
+	if indices[1] < 2 || indices[1] > MAX_SHARES || indices[2] < 1 || indices[2] > MAX_SHARES {
+		err = fmt.Errorf("Invalid Share")
+		return
+	}
+
+	key := indices_to_key(indices[3:3+SEED_LENGTH], wordlist_length)
+	share = Share{ID: uint16(indices[0]), Threshold: uint8(indices[1]), Index: uint8(indices[2]), Key: new(big.Int).SetBytes(key[:])}
+	for i := range share.Checksum {
+		if indices[3+SEED_LENGTH+i] > 255 {
+			err = fmt.Errorf("Invalid Share")
+			return
+		}
+		share.Checksum[i] = byte(indices[3+SEED_LENGTH+i])
+	}
+	return
+}
@@ -0,0 +1,82 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rpcserver
+
+import "fmt"
+import "context"
+import "runtime/debug"
+
+import "github.com/deroproject/derohe/rpc"
+
+func CreateAccount(ctx context.Context, p rpc.Create_Account_Params) (result rpc.Create_Account_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	account, err := w.master.CreateAccount(p.Index)
+	if err != nil {
+		return
+	}
+	result.Index = account.GetAccountIndex()
+	result.Address = account.GetAddress().String()
+	return
+}
+
+func GetAccounts(ctx context.Context, p rpc.Get_Accounts_Params) (result rpc.Get_Accounts_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	for _, account := range w.master.GetAccounts() {
+		mature, locked := account.Get_Balance_scid(p.SCID)
+		result.Accounts = append(result.Accounts, rpc.Account_Info{
+			Index:            account.GetAccountIndex(),
+			Address:          account.GetAddress().String(),
+			Balance:          mature + locked,
+			Unlocked_Balance: mature,
+			Selected:         account == w.wallet,
+		})
+	}
+
+	mature, locked := w.master.Get_Balance_All(p.SCID)
+	result.Balance = mature + locked
+	result.Unlocked_Balance = mature
+	return
+}
+
+func SelectAccount(ctx context.Context, p rpc.Select_Account_Params) (result rpc.Select_Account_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	if err = w.master.SelectAccount(p.Index); err != nil {
+		return
+	}
+	account := w.master.GetSelectedAccount()
+	result.Index = account.GetAccountIndex()
+	result.Address = account.GetAddress().String()
+	return
+}
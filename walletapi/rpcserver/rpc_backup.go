@@ -0,0 +1,55 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rpcserver
+
+import "fmt"
+import "context"
+import "runtime/debug"
+
+import "github.com/deroproject/derohe/rpc"
+
+func ExportBackup(ctx context.Context, p rpc.Export_Backup_Params) (result rpc.Export_Backup_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	backup, err := w.wallet.ExportBackup(p.Password)
+	if err != nil {
+		return
+	}
+	result.Backup = string(backup)
+	return
+}
+
+func RestoreBackup(ctx context.Context, p rpc.Restore_Backup_Params) (result rpc.Restore_Backup_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	if err = w.wallet.ImportBackup(p.Password, []byte(p.Backup)); err != nil {
+		return
+	}
+	// sync loop verifies restored history once wallet is online
+	result.Pending = w.wallet.IsRestorePending()
+	return
+}
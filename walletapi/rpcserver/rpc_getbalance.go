@@ -30,13 +30,20 @@ func GetBalance(ctx context.Context, p rpc.GetBalance_Params) (result rpc.GetBal
 
 	w := fromContext(ctx)
 
-	if err := w.wallet.Sync_Wallet_Memory_With_Daemon_internal(p.SCID); err != nil {
-		return result, err
+	view_only := w.wallet.IsViewOnly() // view only wallets cannot sync, they report balance disclosed by view credential
+	if !view_only {
+		if err := w.wallet.Sync_Wallet_Memory_With_Daemon_internal(p.SCID); err != nil {
+			return result, err
+		}
 	}
 
 	mature, locked := w.wallet.Get_Balance_scid(p.SCID)
-	return rpc.GetBalance_Result{
+	result = rpc.GetBalance_Result{
 		Balance:          mature + locked,
 		Unlocked_Balance: mature,
-	}, nil
+	}
+	if view_only {
+		result.ViewOnly, result.TopoHeight = true, w.wallet.Get_TopoHeight()
+	}
+	return result, nil
 }
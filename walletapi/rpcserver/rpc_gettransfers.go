@@ -31,6 +31,9 @@ func GetTransfers(ctx context.Context, p rpc.Get_Transfers_Params) (result rpc.G
 	w := fromContext(ctx)
 
 	result.Entries = w.wallet.Show_Transfers(p.SCID, p.Coinbase, p.In, p.Out, p.Min_Height, p.Max_Height, p.Sender, p.Receiver, p.DestinationPort, p.SourcePort)
+	if p.Pending {
+		result.Pending = w.wallet.GetPendingTransactions()
+	}
 
 	return result, nil
 }
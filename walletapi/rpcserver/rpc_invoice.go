@@ -0,0 +1,62 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rpcserver
+
+import "fmt"
+import "time"
+import "context"
+import "runtime/debug"
+
+import "github.com/deroproject/derohe/rpc"
+
+func MakeInvoice(ctx context.Context, p rpc.Make_Invoice_Params) (result rpc.Make_Invoice_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	result.Invoice, err = w.wallet.CreateInvoice(p.SCID, p.Amount, p.Comment, time.Duration(p.Expiry)*time.Second)
+	return
+}
+
+func GetInvoice(ctx context.Context, p rpc.Get_Invoice_Params) (result rpc.Get_Invoice_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	w.wallet.UpdateInvoices() // expiry may have passed since last sync
+	result.Invoice, err = w.wallet.GetInvoice(p.DestinationPort)
+	return
+}
+
+func GetInvoices(ctx context.Context, p rpc.Get_Invoices_Params) (result rpc.Get_Invoices_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	w.wallet.UpdateInvoices() // expiry may have passed since last sync
+	result.Invoices = w.wallet.GetInvoices(p.State)
+	return
+}
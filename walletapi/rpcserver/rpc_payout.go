@@ -0,0 +1,69 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rpcserver
+
+import "fmt"
+import "context"
+import "runtime/debug"
+
+import "github.com/deroproject/derohe/rpc"
+
+func CreatePayoutBatch(ctx context.Context, p rpc.Create_Payout_Batch_Params) (result rpc.Create_Payout_Batch_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	batch, err := w.wallet.CreatePayoutBatch(p.SCID, p.Ringsize, p.Payouts)
+	if err != nil {
+		return
+	}
+	result.ID = batch.ID
+	return
+}
+
+func GetPayoutBatch(ctx context.Context, p rpc.Get_Payout_Batch_Params) (result rpc.Get_Payout_Batch_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	if result.Batch, err = w.wallet.GetPayoutBatch(p.ID); err != nil {
+		return
+	}
+	result.Report, err = w.wallet.GetPayoutReport(p.ID)
+	return
+}
+
+func CancelPayoutBatch(ctx context.Context, p rpc.Cancel_Payout_Batch_Params) (result rpc.Cancel_Payout_Batch_Result, err error) {
+	defer func() { // safety so if anything wrong happens, we return error
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace %s", debug.Stack())
+		}
+	}()
+
+	w := fromContext(ctx)
+	if _, err = w.wallet.CancelPayoutBatch(p.ID); err != nil {
+		return
+	}
+	result.Report, err = w.wallet.GetPayoutReport(p.ID)
+	return
+}
@@ -40,6 +40,10 @@ func Transfer(ctx context.Context, p rpc.Transfer_Params) (result rpc.Transfer_R
 
 	w := fromContext(ctx)
 
+	if _, err = w.wallet.ResolveContacts(p.Transfers, false); err != nil { // owner changes must be accepted before paying over rpc
+		return
+	}
+
 	for _, t := range p.Transfers {
 		_, err = t.Payload_RPC.CheckPack(transaction.PAYLOAD0_LIMIT)
 		if err != nil {
@@ -78,6 +78,14 @@ func RPCServer_Start(wallet *walletapi.Wallet_Disk, title string) (*RPCServer, e
 		r.password = parts[1]
 	}
 
+	// push invoice changes to all websocket clients
+	wallet.SetInvoiceCallback(func(invoice rpc.Invoice) {
+		client_connections.Range(func(key, value interface{}) bool {
+			key.(*jrpc2.Server).Notify(context.Background(), "Invoice", invoice)
+			return true
+		})
+	})
+
 	go r.Run(wallet)
 	atomic.AddUint32(&globals.Subsystem_Active, 1) // increment subsystem
 
@@ -134,7 +142,7 @@ func (rpcserver *RPCServer) Run(wallet *walletapi.Wallet_Disk) {
 	var wallet_apis WALLET_CONTEXT
 
 	wallet_apis.logger = rpcserver.logger
-	wallet_apis.wallet = wallet
+	wallet_apis.master = wallet
 
 	var options = &jrpc2.ServerOptions{AllowPush: true, NewContext: func() context.Context { return context.WithValue(context.Background(), "wallet_context", &wallet_apis) }}
 	// create a new mux
@@ -281,7 +289,8 @@ var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { retu
 type WALLET_CONTEXT struct {
 	r      *RPCServer
 	logger logr.Logger
-	wallet *walletapi.Wallet_Disk
+	master *walletapi.Wallet_Disk   // wallet as opened
+	wallet *walletapi.Wallet_Memory // currently selected account, all apis operate on it
 } // exports daemon status and other RPC apis
 
 func WalletEcho(ctx context.Context, args []string) string {
@@ -319,6 +328,34 @@ var wallet_handler = handler.Map{
 	"Transfer":                 handler.New(Transfer),
 	"transfer_split":           handler.New(Transfer),
 	"scinvoke":                 handler.New(ScInvoke),
+	"make_invoice":             handler.New(MakeInvoice),
+	"MakeInvoice":              handler.New(MakeInvoice),
+	"get_invoice":              handler.New(GetInvoice),
+	"GetInvoice":               handler.New(GetInvoice),
+	"get_invoices":             handler.New(GetInvoices),
+	"GetInvoices":              handler.New(GetInvoices),
+	"create_account":           handler.New(CreateAccount),
+	"CreateAccount":            handler.New(CreateAccount),
+	"get_accounts":             handler.New(GetAccounts),
+	"GetAccounts":              handler.New(GetAccounts),
+	"select_account":           handler.New(SelectAccount),
+	"SelectAccount":            handler.New(SelectAccount),
+	"get_sync_progress":        handler.New(GetSyncProgress),
+	"GetSyncProgress":          handler.New(GetSyncProgress),
+	"make_receiver_proof":      handler.New(MakeReceiverProof),
+	"MakeReceiverProof":        handler.New(MakeReceiverProof),
+	"verify_proof":             handler.New(VerifyProof),
+	"VerifyProof":              handler.New(VerifyProof),
+	"export_backup":            handler.New(ExportBackup),
+	"ExportBackup":             handler.New(ExportBackup),
+	"restore_backup":           handler.New(RestoreBackup),
+	"RestoreBackup":            handler.New(RestoreBackup),
+	"create_payout_batch":      handler.New(CreatePayoutBatch),
+	"CreatePayoutBatch":        handler.New(CreatePayoutBatch),
+	"get_payout_batch":         handler.New(GetPayoutBatch),
+	"GetPayoutBatch":           handler.New(GetPayoutBatch),
+	"cancel_payout_batch":      handler.New(CancelPayoutBatch),
+	"CancelPayoutBatch":        handler.New(CancelPayoutBatch),
 }
 
 var servicemux = handler.ServiceMap{
@@ -334,5 +371,7 @@ func fromContext(ctx context.Context) *WALLET_CONTEXT {
 	if !ok {
 		panic("cannot find wallet context")
 	}
-	return u
+	c := *u
+	c.wallet = u.master.GetSelectedAccount()
+	return &c
 }
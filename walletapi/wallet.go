@@ -71,6 +71,32 @@ type Account struct {
 
 	RingMembers map[string]int64 `json:"ring_members"` // ring members
 
+	AddressBook map[string]Contact `json:"addressbook,omitempty"` // contacts indexed by label
+
+	Invoices map[uint64]rpc.Invoice `json:"invoices,omitempty"` // invoices indexed by destination port
+
+	Payouts map[uint64]rpc.PayoutBatch `json:"payouts,omitempty"` // payout batches indexed by id, see wallet_payout.go
+
+	PendingTXs map[string]Tracked_TX `json:"pending_txs,omitempty"` // outgoing txs indexed by txid, see wallet_pending.go
+
+	Webhooks      []Webhook         `json:"webhooks,omitempty"`
+	WebhookQueue  []WebhookDelivery `json:"webhook_queue,omitempty"`  // events awaiting delivery
+	WebhookHeight int64             `json:"webhook_height,omitempty"` // height till which confirmations have been notified
+
+	WebhookRemovals map[string]int `json:"webhook_removals,omitempty"` // removals and re-additions of incoming entries, see wallet_webhook.go
+
+	Index       uint32     `json:"index,omitempty"`       // index of sub account, 0 is master account
+	SubAccounts []*Account `json:"subaccounts,omitempty"` // only used by master account
+	Selected    uint32     `json:"selected,omitempty"`    // currently selected account, only used by master account
+
+	SyncCheckpoints map[crypto.Hash]SyncCheckpoint `json:"sync_checkpoints,omitempty"` // resume point of interrupted syncs
+
+	ViewOnly     bool                        `json:"viewonly,omitempty"`      // only public key is available, see wallet_viewonly.go
+	ViewBalances []ViewBalance               `json:"view_balances,omitempty"` // balances disclosed by last imported view credential
+	ViewPending  map[crypto.Hash][]rpc.Entry `json:"view_pending,omitempty"`  // disclosed history not yet verified against the chain
+
+	RestorePending bool `json:"restore_pending,omitempty"` // history restored from backup is not yet verified, see wallet_backup.go
+
 	SaveChangesEvery time.Duration `json:"-"` // default is zero
 	lastsaved        time.Time
 
@@ -100,8 +126,11 @@ func (w *Wallet_Memory) setEncryptedBalanceresult(scid crypto.Hash, entry rpc.Ge
 }
 
 // add a entry in the suitable place
-// this is always single threaded
+// entries of different scids may be inserted concurrently, but entries of a scid must be inserted in order
 func (w *Wallet_Memory) InsertReplace(scid crypto.Hash, e rpc.Entry) {
+	w.Lock()
+	defer w.Unlock()
+
 	var entries []rpc.Entry
 	if _, ok := w.account.EntriesNative[scid]; ok {
 		entries = w.account.EntriesNative[scid]
@@ -115,11 +144,16 @@ func (w *Wallet_Memory) InsertReplace(scid crypto.Hash, e rpc.Entry) {
 
 	// entry already exists, we are probably rescanning/overwiting, delete anything afterwards
 	if i < len(entries) && entries[i].TopoHeight == e.TopoHeight && entries[i].TransactionPos == e.TransactionPos && entries[i].Pos == e.Pos {
+		if i+1 < len(entries) {
+			w.invalidate_sync_checkpoint(scid, entries[i+1].TopoHeight)
+			w.webhook_entries_removed(scid, entries[i+1:])
+		}
 		entries = entries[:i]
 		// x is present at data[i]
 	} else {
 		// x is not present in data,
 		// but i is the index where it would be inserted.
+		w.webhook_entry_added(scid, e)
 	}
 	entries = append(entries, e)
 
@@ -174,14 +208,35 @@ func Generate_Account_From_Seed(Seed *crypto.BNRed) (user *Account, err error) {
 
 // convert key to seed using language
 func (w *Wallet_Memory) GetSeed() (str string) {
+	if w.account.ViewOnly {
+		return ""
+	}
 	return mnemonics.Key_To_Words(w.account.Keys.Secret.BigInt(), w.account.SeedLanguage)
 }
 
 // convert key to seed using language
 func (w *Wallet_Memory) GetSeedinLanguage(lang string) (str string) {
+	if w.account.ViewOnly {
+		return ""
+	}
 	return mnemonics.Key_To_Words(w.account.Keys.Secret.BigInt(), lang)
 }
 
+// split seed in count shares encoded as words, any threshold shares can restore the wallet
+func (w *Wallet_Memory) GetSeedShares(threshold, count int) (shares []string, err error) {
+	if w.account.ViewOnly {
+		return nil, fmt.Errorf("view only wallet does not have seed")
+	}
+	split, err := mnemonics.Split_Key(w.account.Keys.Secret.BigInt(), threshold, count)
+	if err != nil {
+		return
+	}
+	for _, share := range split {
+		shares = append(shares, mnemonics.Share_To_Words(share, w.account.SeedLanguage))
+	}
+	return
+}
+
 func (account *Account) GetAddress() (addr rpc.Address) {
 	addr.PublicKey = new(crypto.Point).Set(account.Keys.Public)
 	return
@@ -246,6 +301,7 @@ func (w *Wallet_Memory) Show_Transfers(scid crypto.Hash, coinbase bool, in bool,
 	}
 	for _, e := range all_entries {
 		if e.Height >= min_height && e.Height <= max_height {
+			e.Confirmations = entry_confirmations(e, daemon_height)
 			if coinbase && e.Coinbase {
 				entries = append(entries, e)
 				continue
@@ -286,6 +342,7 @@ func (w *Wallet_Memory) Get_Payments_DestinationPort(scid crypto.Hash, port uint
 
 	for _, e := range all_entries {
 		if e.Height >= min_height && e.DestinationPort == port {
+			e.Confirmations = entry_confirmations(e, daemon_height)
 			entries = append(entries, e)
 		}
 	}
@@ -308,6 +365,7 @@ func (w *Wallet_Memory) Get_Payments_TXID(txid string) (entry rpc.Entry) {
 
 	for _, e := range all_entries {
 		if txid == e.TXID {
+			e.Confirmations = entry_confirmations(e, daemon_height)
 			return e
 		}
 	}
@@ -322,6 +380,8 @@ func (w *Wallet_Memory) Clean() {
 	defer w.Unlock()
 	//w.account.Entries = w.account.Entries[:0]
 
+	w.account.SyncCheckpoints = nil // rescan from scratch
+
 	for k := range w.account.EntriesNative {
 		delete(w.account.EntriesNative, k)
 	}
@@ -369,11 +429,17 @@ func (w *Wallet_Memory) Get_Keys() _Keys {
 func (w *Wallet_Memory) SetOfflineMode() bool {
 	current_mode := w.wallet_online_mode
 	w.wallet_online_mode = false
+	for _, sub := range w.subaccounts {
+		sub.SetOfflineMode()
+	}
 	return current_mode
 }
 
 func (w *Wallet_Memory) SetNetwork(mainnet bool) bool {
 	w.account.mainnet = mainnet
+	for _, sub := range w.subaccounts {
+		sub.SetNetwork(mainnet)
+	}
 	return w.account.mainnet
 }
 
@@ -403,7 +469,11 @@ func (w *Wallet_Memory) SetOnlineMode() bool {
 	w.wallet_online_mode = true
 
 	if current_mode != true { // trigger subroutine if previous mode was offline
-		go w.sync_loop() // start sync subroutine
+		go w.sync_loop()    // start sync subroutine
+		go w.webhook_loop() // start webhook delivery subroutine
+	}
+	for _, sub := range w.subaccounts {
+		sub.SetOnlineMode()
 	}
 	return current_mode
 }
@@ -511,6 +581,9 @@ func (w *Wallet_Memory) SetTrackRecentBlocks(recency int64) (old int64) {
 
 // retrieve  secret key for any tx we may have created
 func (w *Wallet_Memory) GetRegistrationTX() *transaction.Transaction {
+	if w.account.ViewOnly {
+		return nil
+	}
 	var tx transaction.Transaction
 	tx.Version = 1
 	tx.TransactionType = transaction.REGISTRATION
@@ -573,6 +646,9 @@ func FormatMoneyPrecision(amount uint64, precision int) string {
 
 // this basically does a  Schnorr Signature on random information for registration
 func (w *Wallet_Memory) SignData(input []byte) []byte {
+	if w.account.ViewOnly {
+		return nil
+	}
 	var tmppoint bn256.G1
 
 	tmpsecret := crypto.RandomScalar()
@@ -642,6 +718,9 @@ func (w *Wallet_Memory) CheckSignature(input []byte) (signer *rpc.Address, messa
 // NOTE: this function brings entire file to RAM 2 times, this could be removed by refactoring this function
 // NOTE: a similar function which wraps data already exists in wallet.go just above this function
 func (w *Wallet_Memory) SignFile(filename string) error {
+	if w.account.ViewOnly {
+		return fmt.Errorf("view only wallet cannot sign")
+	}
 	var tmppoint bn256.G1
 
 	tmpsecret := crypto.RandomScalar()
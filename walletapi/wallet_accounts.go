@@ -0,0 +1,200 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "sort"
+import "encoding/binary"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+// this file implements deterministic sub accounts, so that many accounts can be backed up using a single seed
+//
+// derivation scheme
+//   account 0 is the master account, its secret key is the seed itself, this keeps all existing wallets unchanged
+//   account i ( i >= 1 ) has secret key
+//     secret_i = Keccak256( "DERO_SUBACCOUNT" || master_secret || index ) mod bn256.Order
+//   where master_secret is 32 byte big endian encoding of the master secret and index is 4 byte big endian encoding of i
+//   public key is derived from secret_i as for any other account
+//
+// sub accounts are stored within the master account in the wallet file, each sub account has its own history, balance and settings
+// every sub account is represented by its own Wallet_Memory, which syncs independently but saves using the master wallet
+
+const subaccount_derivation_constant = "DERO_SUBACCOUNT"
+
+// derive secret key of sub account from master secret key, index 0 is the master account itself
+func Derive_SubAccount_Secret(master *crypto.BNRed, index uint32) *crypto.BNRed {
+	if index == 0 {
+		return master
+	}
+
+	var index_bytes [4]byte
+	binary.BigEndian.PutUint32(index_bytes[:], index)
+
+	var input []byte
+	input = append(input, []byte(subaccount_derivation_constant)...)
+	input = append(input, crypto.ConvertBigIntToByte(master.BigInt())...)
+	input = append(input, index_bytes[:]...)
+
+	return crypto.GetBNRed(crypto.ReducedHash(input))
+}
+
+// returns master wallet of any sub account
+func (w *Wallet_Memory) master() *Wallet_Memory {
+	if w.parent != nil {
+		return w.parent
+	}
+	return w
+}
+
+// index of this account, master account has index 0
+func (w *Wallet_Memory) GetAccountIndex() uint32 {
+	return w.account.Index
+}
+
+// setup wallet for sub account, master lock must be held by caller
+func (w *Wallet_Memory) load_subaccount(account *Account) *Wallet_Memory {
+	sub := &Wallet_Memory{Version: w.Version, account: account, parent: w, Quit: make(chan bool), invoice_callback: w.invoice_callback}
+	sub.account.mainnet = w.account.mainnet
+	sub.id = string((sub.account.GetAddress().String())[:8]) // set unique id for logs
+
+	if sub.account.Balance == nil {
+		sub.account.Balance = map[crypto.Hash]uint64{}
+	}
+	var scid crypto.Hash
+	sub.setEncryptedBalanceresult(scid, rpc.GetEncryptedBalance_Result{SCID: scid, Registration: -1})
+
+	if w.subaccounts == nil {
+		w.subaccounts = map[uint32]*Wallet_Memory{}
+	}
+	w.subaccounts[account.Index] = sub
+
+	if w.wallet_online_mode {
+		sub.SetOnlineMode()
+	}
+	return sub
+}
+
+// stop goroutines of all sub accounts and forget them, master lock must be held by caller
+func (w *Wallet_Memory) unload_subaccounts() {
+	for _, sub := range w.subaccounts {
+		sub.wallet_online_mode = false
+		close(sub.Quit)
+	}
+	w.subaccounts = nil
+}
+
+// create a sub account with specific index, if index is 0, next unused index is used
+// an account can be created again after restoring from seed, since derivation is deterministic
+func (w *Wallet_Memory) CreateAccount(index uint32) (sub *Wallet_Memory, err error) {
+	m := w.master()
+	if m.account.ViewOnly {
+		return nil, fmt.Errorf("view only wallet cannot derive accounts")
+	}
+
+	defer m.save_if_disk() // save wallet, runs after unlock
+	m.Lock()
+	defer m.Unlock()
+
+	if index == 0 {
+		for index = 1; m.subaccounts[index] != nil; index++ {
+		}
+	}
+	if _, ok := m.subaccounts[index]; ok {
+		err = fmt.Errorf("account %d already exists", index)
+		return
+	}
+
+	account, err := Generate_Account_From_Seed(Derive_SubAccount_Secret(m.account.Keys.Secret, index))
+	if err != nil {
+		return
+	}
+	account.Index = index
+	account.SeedLanguage = m.account.SeedLanguage
+	account.Ringsize = m.account.Ringsize
+	account.FeesMultiplier = m.account.FeesMultiplier
+	account.SaveChangesEvery = m.account.SaveChangesEvery
+	account.TrackRecentBlocks = m.account.TrackRecentBlocks
+
+	m.account.SubAccounts = append(m.account.SubAccounts, account)
+	sub = m.load_subaccount(account)
+	return
+}
+
+// get account by index, index 0 is the master account
+func (w *Wallet_Memory) GetAccountByIndex(index uint32) (*Wallet_Memory, error) {
+	m := w.master()
+	if index == 0 {
+		return m, nil
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if sub, ok := m.subaccounts[index]; ok {
+		return sub, nil
+	}
+	return nil, fmt.Errorf("account %d does not exist", index)
+}
+
+// all accounts including master account sorted by index
+func (w *Wallet_Memory) GetAccounts() (accounts []*Wallet_Memory) {
+	m := w.master()
+	accounts = append(accounts, m)
+
+	m.Lock()
+	for _, sub := range m.subaccounts {
+		accounts = append(accounts, sub)
+	}
+	m.Unlock()
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].account.Index < accounts[j].account.Index })
+	return
+}
+
+// select account which will be used by default, eg by rpc server
+func (w *Wallet_Memory) SelectAccount(index uint32) (err error) {
+	if _, err = w.GetAccountByIndex(index); err != nil {
+		return
+	}
+
+	m := w.master()
+	defer m.save_if_disk() // save wallet, runs after unlock
+	m.Lock()
+	defer m.Unlock()
+	m.account.Selected = index
+	return
+}
+
+// get currently selected account
+func (w *Wallet_Memory) GetSelectedAccount() *Wallet_Memory {
+	m := w.master()
+	if sub, err := m.GetAccountByIndex(m.account.Selected); err == nil {
+		return sub
+	}
+	return m
+}
+
+// aggregate balance of all accounts
+func (w *Wallet_Memory) Get_Balance_All(scid crypto.Hash) (mature_balance uint64, locked_balance uint64) {
+	for _, account := range w.GetAccounts() {
+		mature, locked := account.Get_Balance_scid(scid)
+		mature_balance += mature
+		locked_balance += locked
+	}
+	return
+}
@@ -0,0 +1,89 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "testing"
+
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+func Test_SubAccounts(t *testing.T) {
+	seed := "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly"
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("QWER", seed)
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	sub1, err := w.CreateAccount(0)
+	if err != nil || sub1.GetAccountIndex() != 1 {
+		t.Fatalf("Cannot create sub account, err %s", err)
+	}
+	sub5, err := w.CreateAccount(5)
+	if err != nil || sub5.GetAccountIndex() != 5 {
+		t.Fatalf("Cannot create sub account, err %s", err)
+	}
+	if _, err = sub1.CreateAccount(5); err == nil {
+		t.Fatalf("duplicate account must not be created")
+	}
+	if sub1.GetAddress().String() == w.GetAddress().String() || sub1.GetAddress().String() == sub5.GetAddress().String() {
+		t.Fatalf("sub accounts must have distinct addresses")
+	}
+
+	// derivation must be deterministic, so accounts can be restored from seed
+	restored, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("QWER", seed)
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+	if sub, err := restored.CreateAccount(5); err != nil || sub.GetAddress().String() != sub5.GetAddress().String() {
+		t.Fatalf("sub account derivation is not deterministic, err %v", err)
+	}
+	if Derive_SubAccount_Secret(w.account.Keys.Secret, 0) != w.account.Keys.Secret {
+		t.Fatalf("account 0 must be master account")
+	}
+
+	if err = w.SelectAccount(3); err == nil {
+		t.Fatalf("non existing account must not be selected")
+	}
+	if err = w.SelectAccount(5); err != nil || w.GetSelectedAccount() != sub5 {
+		t.Fatalf("Cannot select account, err %v", err)
+	}
+
+	var zerohash crypto.Hash
+	w.account.Balance[zerohash] = 100
+	sub1.account.Balance[zerohash] = 20
+	sub5.account.Balance[zerohash] = 3
+	if mature, _ := sub1.Get_Balance_All(zerohash); mature != 123 {
+		t.Fatalf("aggregate balance incorrect %d", mature)
+	}
+
+	if err = sub1.Save_Wallet(); err != nil {
+		t.Fatalf("Cannot save wallet, err %s", err)
+	}
+	reopened, err := Open_Encrypted_Wallet_Memory("QWER", w.db_memory)
+	if err != nil {
+		t.Fatalf("Cannot open wallet, err %s", err)
+	}
+	accounts := reopened.GetAccounts()
+	if len(accounts) != 3 || accounts[1].GetAddress().String() != sub1.GetAddress().String() || accounts[2].GetAccountIndex() != 5 {
+		t.Fatalf("sub accounts not restored %d", len(accounts))
+	}
+	if reopened.GetSelectedAccount().GetAddress().String() != sub5.GetAddress().String() {
+		t.Fatalf("selected account not restored")
+	}
+	if mature, _ := reopened.Get_Balance_All(zerohash); mature != 123 {
+		t.Fatalf("aggregate balance not restored %d", mature)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "sort"
+import "time"
+import "strings"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/transaction"
+
+// address book is stored within the account, so it is encrypted along with keys
+// a contact can point to a plain address, an integrated address or a name registered with the name service
+// names are resolved every time the contact is used, since name ownership can change
+type Contact struct {
+	Label           string        `json:"label"`                 // user chosen label, used instead of address
+	Address         string        `json:"address"`               // base address, for names this is owner at the time contact was saved
+	Name            string        `json:"name,omitempty"`        // name service name, if contact was created using a name
+	DestinationPort uint64        `json:"dstport,omitempty"`     // default payment port used while paying this contact
+	Payload_RPC     rpc.Arguments `json:"payload_rpc,omitempty"` // payload from integrated address, used while paying this contact
+	Added           time.Time     `json:"added"`
+}
+
+// arguments which will be embedded in payment to this contact
+func (c Contact) Arguments() (args rpc.Arguments) {
+	if c.DestinationPort != 0 {
+		args = append(args, rpc.Argument{Name: rpc.RPC_DESTINATION_PORT, DataType: rpc.DataUint64, Value: c.DestinationPort})
+	}
+	return append(args, c.Payload_RPC...)
+}
+
+// add or replace a contact in the address book
+// target can be an address, an integrated address or a registered name
+func (w *Wallet_Memory) AddContact(label string, target string, dstport uint64, payload rpc.Arguments) (c Contact, err error) {
+	label = strings.TrimSpace(label)
+	target = strings.TrimSpace(target)
+
+	if label == "" || strings.ContainsAny(label, " \t\r\n") {
+		err = fmt.Errorf("contact label cannot be empty or contain spaces")
+		return
+	}
+	if _, err1 := rpc.NewAddress(label); err1 == nil {
+		err = fmt.Errorf("contact label cannot be an address")
+		return
+	}
+	if target == "" {
+		err = fmt.Errorf("contact address or name cannot be empty")
+		return
+	}
+
+	c = Contact{Label: label, DestinationPort: dstport, Added: time.Now().UTC()}
+	c.Payload_RPC = append(c.Payload_RPC, payload...)
+
+	if addr, err1 := rpc.NewAddress(target); err1 == nil {
+		if addr.IsIntegratedAddress() && len(c.Payload_RPC) == 0 {
+			c.Payload_RPC = append(c.Payload_RPC, addr.Arguments...)
+		}
+		c.Address = addr.BaseAddress().String()
+	} else { // it must be a name, resolve it now and remember the owner
+		if c.Address, err = w.NameToAddress(target); err != nil {
+			err = fmt.Errorf("could not decode name or address err '%s' name '%s'", err, target)
+			return
+		}
+		c.Name = target
+	}
+
+	if err = c.Payload_RPC.Validate_Arguments(); err != nil {
+		return
+	}
+
+	// destination port is kept separately, so the user supplied port wins over embedded one
+	if i := c.Payload_RPC.Index(rpc.RPC_DESTINATION_PORT, rpc.DataUint64); i >= 0 {
+		if c.DestinationPort == 0 {
+			c.DestinationPort = c.Payload_RPC[i].Value.(uint64)
+		}
+		c.Payload_RPC = append(c.Payload_RPC[:i], c.Payload_RPC[i+1:]...)
+	}
+
+	if _, err = c.Arguments().CheckPack(transaction.PAYLOAD0_LIMIT); err != nil {
+		return
+	}
+
+	defer w.save_if_disk() // save wallet, runs after unlock
+	w.Lock()
+	defer w.Unlock()
+	if w.account.AddressBook == nil {
+		w.account.AddressBook = map[string]Contact{}
+	}
+	w.account.AddressBook[label] = c
+	return
+}
+
+// delete a contact from address book
+func (w *Wallet_Memory) DeleteContact(label string) (err error) {
+	defer w.save_if_disk() // save wallet, runs after unlock
+	w.Lock()
+	defer w.Unlock()
+	if _, ok := w.account.AddressBook[label]; !ok {
+		return fmt.Errorf("contact '%s' not found", label)
+	}
+	delete(w.account.AddressBook, label)
+	return
+}
+
+// get a contact by its label
+func (w *Wallet_Memory) GetContact(label string) (c Contact, ok bool) {
+	w.Lock()
+	defer w.Unlock()
+	c, ok = w.account.AddressBook[strings.TrimSpace(label)]
+	return
+}
+
+// list all contacts sorted by label
+func (w *Wallet_Memory) ListContacts() (contacts []Contact) {
+	w.Lock()
+	defer w.Unlock()
+	for _, c := range w.account.AddressBook {
+		contacts = append(contacts, c)
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].Label < contacts[j].Label })
+	return
+}
+
+// resolve a contact to an address, which will carry the contact payload as integrated arguments
+// names are resolved again, owner_changed is set if the name now points to a different address than when saved
+// in such case the returned address is the current owner, caller must decide whether to proceed
+func (w *Wallet_Memory) ContactToAddress(label string) (addr *rpc.Address, owner_changed bool, err error) {
+	c, ok := w.GetContact(label)
+	if !ok {
+		err = fmt.Errorf("contact '%s' not found", label)
+		return
+	}
+
+	address := c.Address
+	if c.Name != "" {
+		if address, err = w.NameToAddress(c.Name); err != nil {
+			err = fmt.Errorf("could not resolve name '%s' of contact '%s' err %s", c.Name, label, err)
+			return
+		}
+		if address != c.Address {
+			owner_changed = true
+			logger.Info("WARNING: name owner has changed since contact was saved", "contact", label, "name", c.Name, "saved", c.Address, "current", address)
+		}
+	}
+
+	if addr, err = rpc.NewAddress(address); err != nil {
+		return
+	}
+	addr.Arguments = c.Arguments()
+	return
+}
+
+// accept current name owner as the owner of the contact, used after user has acknowledged owner change
+func (w *Wallet_Memory) UpdateContactOwner(label string) (c Contact, err error) {
+	c, ok := w.GetContact(label)
+	if !ok {
+		err = fmt.Errorf("contact '%s' not found", label)
+		return
+	}
+	if c.Name == "" {
+		return
+	}
+	if c.Address, err = w.NameToAddress(c.Name); err != nil {
+		return
+	}
+
+	defer w.save_if_disk() // save wallet, runs after unlock
+	w.Lock()
+	defer w.Unlock()
+	w.account.AddressBook[label] = c
+	return
+}
+
+// replace destinations which are contact labels with contact address and payload
+// valid addresses are never treated as labels and unknown labels are left as is, so they can be resolved as names
+// user supplied payload is never overwritten
+// if a name has a new owner, an error is returned unless confirm is set, in which case caller must confirm the warnings with user before sending
+// callers which cannot confirm, such as rpc, must not set confirm, the new owner must be accepted using UpdateContactOwner
+func (w *Wallet_Memory) ResolveContacts(transfers []rpc.Transfer, confirm bool) (warnings []string, err error) {
+	for i := range transfers {
+		if _, err1 := rpc.NewAddress(transfers[i].Destination); err1 == nil {
+			continue
+		}
+		if _, ok := w.GetContact(transfers[i].Destination); !ok {
+			continue
+		}
+
+		var addr *rpc.Address
+		var owner_changed bool
+		if addr, owner_changed, err = w.ContactToAddress(transfers[i].Destination); err != nil {
+			return
+		}
+		if owner_changed && !confirm {
+			err = fmt.Errorf("contact '%s' name owner has changed to %s, accept the new owner before paying", transfers[i].Destination, addr.BaseAddress().String())
+			return
+		}
+		if owner_changed {
+			warnings = append(warnings, fmt.Sprintf("contact '%s' name owner has changed, now paying %s", transfers[i].Destination, addr.BaseAddress().String()))
+		}
+
+		transfers[i].Destination = addr.BaseAddress().String()
+		if len(transfers[i].Payload_RPC) == 0 {
+			transfers[i].Payload_RPC = addr.Arguments
+		}
+	}
+	return
+}
@@ -0,0 +1,88 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "testing"
+
+import "github.com/deroproject/derohe/rpc"
+
+// address book functionality which does not require daemon
+func Test_AddressBook(t *testing.T) {
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+	w2, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "perfil lujo faja puma favor pedir detalle doble carbón neón paella cuarto ánimo cuento conga correr dental moneda león donar entero logro realidad acceso doble")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	if _, err = w.AddContact(w2.GetAddress().String(), w2.GetAddress().String(), 0, nil); err == nil {
+		t.Fatalf("address must not be accepted as label")
+	}
+	if _, err = w.AddContact("bob", "unregistered-name", 0, nil); err == nil {
+		t.Fatalf("names cannot be resolved while offline")
+	}
+
+	iaddr := w2.GetRandomIAddress8()
+	iaddr.Arguments = append(iaddr.Arguments, rpc.Argument{Name: rpc.RPC_COMMENT, DataType: rpc.DataString, Value: "invoice"})
+	c, err := w.AddContact("bob", iaddr.String(), 0, nil)
+	if err != nil {
+		t.Fatalf("Cannot add contact, err %s", err)
+	}
+	if c.Address != w2.GetAddress().String() || c.DestinationPort != iaddr.Arguments.Value(rpc.RPC_DESTINATION_PORT, rpc.DataUint64).(uint64) || len(c.Payload_RPC) != 1 {
+		t.Fatalf("integrated address not split correctly %+v", c)
+	}
+
+	if _, err = w.AddContact("alice", w2.GetAddress().String(), 1234, nil); err != nil {
+		t.Fatalf("Cannot add contact, err %s", err)
+	}
+	if contacts := w.ListContacts(); len(contacts) != 2 || contacts[0].Label != "alice" || contacts[1].Label != "bob" {
+		t.Fatalf("contact listing failed %+v", contacts)
+	}
+
+	transfers := []rpc.Transfer{{Destination: "alice", Amount: 1}, {Destination: "bob", Amount: 1, Payload_RPC: rpc.Arguments{{Name: rpc.RPC_DESTINATION_PORT, DataType: rpc.DataUint64, Value: uint64(7)}}}, {Destination: "somename", Amount: 1}}
+	if _, err = w.ResolveContacts(transfers, false); err != nil {
+		t.Fatalf("Cannot resolve contacts, err %s", err)
+	}
+	if transfers[0].Destination != w2.GetAddress().String() || transfers[0].Payload_RPC.Value(rpc.RPC_DESTINATION_PORT, rpc.DataUint64).(uint64) != 1234 {
+		t.Fatalf("contact not resolved %+v", transfers[0])
+	}
+	if transfers[1].Destination != w2.GetAddress().String() || transfers[1].Payload_RPC.Value(rpc.RPC_DESTINATION_PORT, rpc.DataUint64).(uint64) != 7 {
+		t.Fatalf("user payload must not be overwritten %+v", transfers[1])
+	}
+	if transfers[2].Destination != "somename" {
+		t.Fatalf("unknown labels must be left for name service %+v", transfers[2])
+	}
+
+	// address book must survive reopening
+	w.Save_Wallet()
+	w3, err := Open_Encrypted_Wallet_Memory("", w.db_memory)
+	if err != nil {
+		t.Fatalf("Cannot open encrypted wallet, err %s", err)
+	}
+	if c, ok := w3.GetContact("bob"); !ok || len(c.Payload_RPC) != 1 {
+		t.Fatalf("address book not persisted")
+	}
+
+	if err = w3.DeleteContact("bob"); err != nil {
+		t.Fatalf("Cannot delete contact, err %s", err)
+	}
+	if err = w3.DeleteContact("bob"); err == nil {
+		t.Fatalf("deleting missing contact must fail")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "time"
+import "encoding/json"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+// this file implements encrypted backups of a wallet
+// seed only restores the keys, a backup also carries history, payloads, ring members, contacts, invoices and settings
+// a backup is protected by its own password, so it can be stored elsewhere than the wallet
+// restored history is not trusted, every entry is verified against the chain before the backup is considered verified
+
+const BACKUP_FORMAT = 1
+const backup_magic = "DERO WALLET BACKUP"
+
+// an encrypted wallet backup, address is readable so users can identify backups without the password
+type Wallet_Backup struct {
+	Magic   string    `json:"magic"`
+	Format  int       `json:"format"`
+	Address string    `json:"address"`
+	Created time.Time `json:"created"`
+	KDF     KDF       `json:"kdf"`
+	Data    []byte    `json:"data"` // serialized account, address is authenticated along with data
+}
+
+// export an encrypted backup of the wallet including all sub accounts
+func (w *Wallet_Memory) ExportBackup(backup_password string) (data []byte, err error) {
+	if backup_password == "" {
+		return nil, fmt.Errorf("backup password cannot be empty")
+	}
+	m := w.master()
+
+	m.Lock()
+	for _, sub := range m.subaccounts {
+		sub.RLock()
+	}
+	account, err := json.Marshal(m.account)
+	for _, sub := range m.subaccounts {
+		sub.RUnlock()
+	}
+	address := m.GetAddress().String()
+	m.Unlock()
+	if err != nil {
+		return
+	}
+
+	backup := Wallet_Backup{Magic: backup_magic, Format: BACKUP_FORMAT, Address: address, Created: time.Now().UTC()}
+	if backup.KDF, err = new_kdf(); err != nil {
+		return
+	}
+	if backup.Data, err = seal_section(Generate_Key(backup.KDF, backup_password), "backup "+address, account); err != nil {
+		return
+	}
+	return json.Marshal(backup)
+}
+
+// decrypt a backup and return the account stored within
+func decrypt_backup(backup_password string, data []byte) (account *Account, err error) {
+	var backup Wallet_Backup
+	if err = json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("invalid backup: %s", err)
+	}
+	if backup.Magic != backup_magic {
+		return nil, fmt.Errorf("not a wallet backup")
+	}
+	if backup.Format > BACKUP_FORMAT {
+		return nil, fmt.Errorf("backup format %d is not supported, upgrade wallet", backup.Format)
+	}
+
+	plain, err := open_section(Generate_Key(backup.KDF, backup_password), "backup "+backup.Address, backup.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup password or backup is damaged")
+	}
+
+	account = &Account{}
+	if err = json.Unmarshal(plain, account); err != nil {
+		return nil, err
+	}
+	if account.Keys.Public == nil || (account.Keys.Secret == nil && !account.ViewOnly) {
+		return nil, fmt.Errorf("backup does not contain keys")
+	}
+	if account.Keys.Secret != nil && Generate_Keys_From_Seed(account.Keys.Secret).Public.String() != account.Keys.Public.String() {
+		return nil, fmt.Errorf("backup keys do not match")
+	}
+	if addr, err := rpc.NewAddress(backup.Address); err != nil || addr.PublicKey.String() != account.Keys.Public.String() {
+		return nil, fmt.Errorf("backup address mismatch")
+	}
+	return
+}
+
+// restored history is only a hint, it must be verified against the chain before being trusted
+func prepare_restored_account(account *Account) {
+	for scid, entries := range account.EntriesNative {
+		for i := range entries {
+			entries[i].Final = false
+		}
+		account.EntriesNative[scid] = entries
+	}
+	account.Balance_Result = nil // balances are fetched again from daemon
+	account.SyncCheckpoints = nil
+	account.RestorePending = true
+
+	for _, sub := range account.SubAccounts {
+		prepare_restored_account(sub)
+	}
+}
+
+// restore a wallet from an encrypted backup, wallet is encrypted using password
+func Restore_Encrypted_Wallet_Memory(password string, backup_password string, data []byte) (w *Wallet_Memory, err error) {
+	account, err := decrypt_backup(backup_password, data)
+	if err != nil {
+		return
+	}
+	prepare_restored_account(account)
+
+	if w, err = new_wallet_memory(password, account); err != nil {
+		return nil, err
+	}
+	for _, sub := range account.SubAccounts {
+		w.load_subaccount(sub)
+	}
+	return
+}
+
+// import an encrypted backup into an already open wallet, backup must belong to the same address
+// keys of the open wallet are retained, everything else is replaced by the backup
+func (w *Wallet_Memory) ImportBackup(backup_password string, data []byte) (err error) {
+	account, err := decrypt_backup(backup_password, data)
+	if err != nil {
+		return
+	}
+
+	m := w.master()
+	if account.Keys.Public.String() != m.account.Keys.Public.String() {
+		return fmt.Errorf("backup belongs to a different wallet")
+	}
+	prepare_restored_account(account)
+
+	defer m.save_if_disk() // save wallet, runs after unlock
+	m.Lock()
+	defer m.Unlock()
+
+	account.Keys = m.account.Keys
+	account.ViewOnly = m.account.ViewOnly
+	account.mainnet = m.account.mainnet
+	account.SaveChangesEvery = m.account.SaveChangesEvery
+	account.TrackRecentBlocks = m.account.TrackRecentBlocks
+	if account.Balance == nil {
+		account.Balance = map[crypto.Hash]uint64{}
+	}
+	m.account = account
+
+	var scid crypto.Hash
+	m.setEncryptedBalanceresult(scid, rpc.GetEncryptedBalance_Result{SCID: scid, Registration: -1})
+
+	m.unload_subaccounts() // old sub accounts must not keep syncing
+	for _, sub := range account.SubAccounts {
+		m.load_subaccount(sub)
+	}
+	return
+}
+
+// whether history restored from a backup is still awaiting verification against the chain
+func (w *Wallet_Memory) IsRestorePending() bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.account.RestorePending
+}
+
+// verify history restored from a backup, balances are fetched again and every entry is checked against the chain
+// invalid entries are discarded and resynced, rest of the history is reused without a rescan
+func (w *Wallet_Memory) VerifyRestore() (err error) {
+	if !w.IsRestorePending() {
+		return
+	}
+	if !IsDaemonOnline() {
+		return fmt.Errorf("wallet is offline")
+	}
+
+	var scids []crypto.Hash
+	w.Lock()
+	for scid := range w.account.EntriesNative {
+		scids = append(scids, scid)
+	}
+	w.Unlock()
+
+	for _, scid := range scids {
+		if _, err = w.sync_balance(scid); err != nil && scid.IsZero() {
+			return
+		}
+		err = nil
+		w.SyncHistory(scid)
+		if err = w.VerifyHistory(scid); err != nil {
+			return
+		}
+	}
+
+	w.Lock()
+	w.account.RestorePending = false
+	w.Unlock()
+	logger.Info("restored history verified", "address", w.GetAddress().String(), "scids", len(scids))
+	w.save_if_disk()
+	return
+}
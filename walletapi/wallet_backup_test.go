@@ -0,0 +1,136 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "time"
+import "bytes"
+import "testing"
+import "runtime"
+import "encoding/json"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+func Test_Wallet_Backup(t *testing.T) {
+	seed := "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly"
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("QWER", seed)
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	// simulate a synced wallet with history and settings
+	var scid crypto.Hash
+	w.account.EntriesNative = map[crypto.Hash][]rpc.Entry{scid: {{Height: 9, TopoHeight: 9, Incoming: true, Amount: 123456, Final: true, Payload_RPC: rpc.Arguments{{Name: rpc.RPC_COMMENT, DataType: rpc.DataString, Value: "invoice 7"}}}}}
+	w.account.RingMembers = map[string]int64{"member": 5}
+	w.account.Ringsize = 32
+	if _, err = w.CreateAccount(3); err != nil {
+		t.Fatalf("Cannot create account, err %s", err)
+	}
+
+	if _, err = w.ExportBackup(""); err == nil {
+		t.Fatalf("empty backup password must be rejected")
+	}
+	backup, err := w.ExportBackup("backup")
+	if err != nil {
+		t.Fatalf("Cannot export backup, err %s", err)
+	}
+
+	if _, err = Restore_Encrypted_Wallet_Memory("QWER", "wrong", backup); err == nil {
+		t.Fatalf("wrong backup password must be rejected")
+	}
+
+	r, err := Restore_Encrypted_Wallet_Memory("ASDF", "backup", backup)
+	if err != nil {
+		t.Fatalf("Cannot restore backup, err %s", err)
+	}
+	if r.GetSeed() != w.GetSeed() || r.account.Ringsize != 32 || r.account.RingMembers["member"] != 5 || !r.Check_Password("ASDF") {
+		t.Fatalf("restored wallet must contain keys and settings")
+	}
+	entries := r.account.EntriesNative[scid]
+	if len(entries) != 1 || entries[0].Amount != 123456 || entries[0].Payload_RPC[0].Value != "invoice 7" {
+		t.Fatalf("restored wallet must contain history and payloads")
+	}
+	if entries[0].Final || !r.IsRestorePending() {
+		t.Fatalf("restored history must be verified before being trusted")
+	}
+	if sub, err := r.GetAccountByIndex(3); err != nil || !sub.IsRestorePending() {
+		t.Fatalf("restored wallet must contain sub accounts")
+	}
+
+	// backup can be imported into an open wallet of same address only
+	fresh, _ := Create_Encrypted_Wallet_From_Recovery_Words_Memory("QWER", seed)
+	if err = fresh.ImportBackup("backup", backup); err != nil || len(fresh.account.EntriesNative[scid]) != 1 {
+		t.Fatalf("Cannot import backup, err %s", err)
+	}
+	other, _ := Create_Encrypted_Wallet_Random_Memory("QWER")
+	if err = other.ImportBackup("backup", backup); err == nil {
+		t.Fatalf("backup of different wallet must be rejected")
+	}
+
+	// tampered address is detected
+	var b Wallet_Backup
+	json.Unmarshal(backup, &b)
+	b.Address = other.GetAddress().String()
+	tampered, _ := json.Marshal(b)
+	if _, err = Restore_Encrypted_Wallet_Memory("QWER", "backup", tampered); err == nil {
+		t.Fatalf("tampered backup must be rejected")
+	}
+}
+
+// counts running goroutines of wallets which start in function
+func count_goroutines(function string) int {
+	buf := make([]byte, 1<<22)
+	buf = buf[:runtime.Stack(buf, true)]
+	return bytes.Count(buf, []byte("walletapi.(*Wallet_Memory)."+function+"("))
+}
+
+// importing a backup replaces sub accounts, goroutines of replaced sub accounts must exit
+func Test_Wallet_Backup_Import_Stops_Accounts(t *testing.T) {
+	w, err := Create_Encrypted_Wallet_Random_Memory("QWER")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+	if _, err = w.CreateAccount(1); err != nil {
+		t.Fatalf("Cannot create account, err %s", err)
+	}
+	backup, err := w.ExportBackup("backup")
+	if err != nil {
+		t.Fatalf("Cannot export backup, err %s", err)
+	}
+
+	sync_loops, webhook_loops := count_goroutines("sync_loop"), count_goroutines("webhook_loop")
+	w.SetOnlineMode()
+	defer w.SetOfflineMode()
+	old, _ := w.GetAccountByIndex(1)
+
+	if err = w.ImportBackup("backup", backup); err != nil {
+		t.Fatalf("Cannot import backup, err %s", err)
+	}
+	if sub, _ := w.GetAccountByIndex(1); sub == old || !sub.GetMode() || old.GetMode() {
+		t.Fatalf("sub account must be replaced by restored one")
+	}
+
+	// master and restored sub account remain
+	for deadline := time.Now().Add(3 * timeout); ; time.Sleep(100 * time.Millisecond) {
+		if count_goroutines("sync_loop") == sync_loops+2 && count_goroutines("webhook_loop") == webhook_loops+2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines of replaced sub account must exit, sync loops %d webhook loops %d", count_goroutines("sync_loop")-sync_loops, count_goroutines("webhook_loop")-webhook_loops)
+		}
+	}
+}
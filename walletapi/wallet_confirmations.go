@@ -0,0 +1,132 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+// this file verifies wallet history against the daemon
+// entries whose block became an orphan or side block are discarded and resynced
+// once an entry is below stable height and verified, it is marked final and never checked again
+
+// calculate confirmations of an entry at specific height
+func entry_confirmations(e rpc.Entry, height int64) int64 {
+	if height < int64(e.Height) {
+		return 0
+	}
+	return height - int64(e.Height) + 1
+}
+
+// find first entry which is no longer valid, entries are verified using supplied function
+// entries below pruned_topo are not verified, since daemon no longer has their blocks
+// returns -1 if all entries are valid, also returns entries which can be marked final
+func find_invalid_entry(entries []rpc.Entry, pruned_topo, stable_height int64, verify func(e rpc.Entry) (valid bool, err error)) (invalid int, final []int, err error) {
+	checked := map[string]bool{} // entries within a block share the result
+	for i, e := range entries {
+		if e.Final {
+			continue
+		}
+		if e.TopoHeight < pruned_topo {
+			final = append(final, i)
+			continue
+		}
+		valid, ok := checked[e.BlockHash]
+		if !ok {
+			if valid, err = verify(e); err != nil {
+				return -1, final, err
+			}
+			checked[e.BlockHash] = valid
+		}
+		if !valid {
+			return i, final, nil
+		}
+		if int64(e.Height) <= stable_height {
+			final = append(final, i)
+		}
+	}
+	return -1, final, nil
+}
+
+// whether entry i of current history is still the one which was verified
+// history may be truncated and resynced while verification runs without lock
+func same_entry(current, verified []rpc.Entry, i int) bool {
+	if i >= len(current) || i >= len(verified) {
+		return false
+	}
+	a, b := current[i], verified[i]
+	return a.TopoHeight == b.TopoHeight && a.TXID == b.TXID && a.Pos == b.Pos && a.BlockHash == b.BlockHash
+}
+
+// verify block of entry using daemon, block must be on main chain and at the expected topoheight
+func verify_entry_block(e rpc.Entry) (bool, error) {
+	var result rpc.GetBlockHeaderByHash_Result
+	if err := rpc_client.Call("DERO.GetBlockHeaderByHash", rpc.GetBlockHeaderByHash_Params{Hash: e.BlockHash}, &result); err != nil {
+		return false, err
+	}
+	if result.Status != "OK" {
+		return false, nil
+	}
+	h := result.Block_Header
+	return !h.Orphan_Status && !h.SideBlock && h.TopoHeight == e.TopoHeight, nil
+}
+
+// verify all non-final entries, if any entry is invalid, history is discarded from that point and resynced
+func (w *Wallet_Memory) VerifyHistory(scid crypto.Hash) (err error) {
+	if !IsDaemonOnline() {
+		return
+	}
+
+	w.Lock()
+	entries := append([]rpc.Entry{}, w.account.EntriesNative[scid]...)
+	w.Unlock()
+
+	invalid, final, err := find_invalid_entry(entries, daemon_pruned_topoheight, daemon_stable_height, verify_entry_block)
+	if err != nil {
+		logger.V(1).Error(err, "DERO.GetBlockHeaderByHash Call failed:")
+		return
+	}
+
+	w.Lock()
+	current := w.account.EntriesNative[scid]
+	if invalid >= 0 && !same_entry(current, entries, invalid) { // history changed while verifying, try again next time
+		w.Unlock()
+		return
+	}
+	for _, i := range final {
+		if same_entry(current, entries, i) {
+			current[i].Final = true
+		}
+	}
+	var removed []rpc.Entry
+	if invalid >= 0 {
+		removed = append(removed, current[invalid:]...)
+		w.truncate_history(scid, invalid)
+	}
+	w.Unlock()
+
+	if len(final) == 0 && invalid < 0 {
+		return
+	}
+
+	if invalid >= 0 {
+		logger.Info("wallet history reorganised, resyncing", "scid", scid, "topoheight", entries[invalid].TopoHeight, "blid", entries[invalid].BlockHash, "discarded", len(removed))
+		w.SyncHistory(scid)
+		w.UpdateInvoices()
+	}
+	w.save_if_disk()
+	return
+}
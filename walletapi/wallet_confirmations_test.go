@@ -0,0 +1,92 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "testing"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+func Test_Find_Invalid_Entry(t *testing.T) {
+	entries := []rpc.Entry{
+		{Height: 5, TopoHeight: 5, BlockHash: "a"},
+		{Height: 10, TopoHeight: 10, BlockHash: "b", Final: true},
+		{Height: 20, TopoHeight: 20, BlockHash: "c"},
+		{Height: 30, TopoHeight: 30, BlockHash: "d"},
+		{Height: 30, TopoHeight: 30, BlockHash: "d", Pos: 1},
+		{Height: 40, TopoHeight: 40, BlockHash: "e"},
+	}
+
+	calls := map[string]int{}
+	orphans := map[string]bool{}
+	verify := func(e rpc.Entry) (bool, error) {
+		calls[e.BlockHash]++
+		return !orphans[e.BlockHash], nil
+	}
+
+	invalid, final, err := find_invalid_entry(entries, 6, 30, verify)
+	if err != nil || invalid != -1 || fmt.Sprint(final) != "[0 2 3 4]" {
+		t.Fatalf("all entries must be valid, invalid %d final %v err %v", invalid, final, err)
+	}
+	if calls["a"] != 0 || calls["b"] != 0 || calls["d"] != 1 {
+		t.Fatalf("final entries or pruned entries must not be verified, each block verified once %+v", calls)
+	}
+
+	calls = map[string]int{}
+	if _, _, err = find_invalid_entry(entries, 5, 30, verify); err != nil || calls["a"] != 1 {
+		t.Fatalf("entries at prune height must be verified %+v", calls)
+	}
+
+	orphans["d"] = true
+	if invalid, final, _ = find_invalid_entry(entries, 6, 30, verify); invalid != 3 || fmt.Sprint(final) != "[0 2]" {
+		t.Fatalf("first entry in orphan block must be detected, invalid %d final %v", invalid, final)
+	}
+
+	if _, _, err = find_invalid_entry(entries, 5, 30, func(e rpc.Entry) (bool, error) { return false, fmt.Errorf("offline") }); err == nil {
+		t.Fatalf("verification errors must be reported")
+	}
+}
+
+func Test_Entry_Confirmations(t *testing.T) {
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	var zerohash crypto.Hash
+	w.InsertReplace(zerohash, rpc.Entry{Height: 100, TopoHeight: 100, Incoming: true, Amount: 400, TXID: "tx1"})
+
+	defer func(h int64) { daemon_height = h }(daemon_height)
+	daemon_height = 109
+	if entries := w.Show_Transfers(zerohash, true, true, true, 0, 0, "", "", 0, 0); len(entries) != 1 || entries[0].Confirmations != 10 {
+		t.Fatalf("confirmations incorrect %+v", entries)
+	}
+	daemon_height = 90
+	if e := w.Get_Payments_TXID("tx1"); e.Confirmations != 0 {
+		t.Fatalf("entries above daemon height must have zero confirmations %+v", e)
+	}
+}
+
+// results of verification are only applied to entries which did not change meanwhile
+func Test_Same_Entry(t *testing.T) {
+	verified := []rpc.Entry{{TopoHeight: 10, TXID: "tx1", BlockHash: "b10"}, {TopoHeight: 12, TXID: "tx2", BlockHash: "b12"}}
+	resynced := []rpc.Entry{{TopoHeight: 10, TXID: "tx1", BlockHash: "b10"}, {TopoHeight: 13, TXID: "tx3", BlockHash: "b13"}, {TopoHeight: 14, TXID: "tx4", BlockHash: "b14"}}
+	if !same_entry(resynced, verified, 0) || same_entry(resynced, verified, 1) || same_entry(resynced, verified, 2) || same_entry(verified[:1], verified, 1) {
+		t.Fatalf("entries must be compared by position within history")
+	}
+}
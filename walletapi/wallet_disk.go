@@ -75,6 +75,17 @@ func Create_Encrypted_Wallet_From_Recovery_Words(filename string, password strin
 	return
 }
 
+// create an encrypted wallet using seed shares
+func Create_Encrypted_Wallet_From_Shares(filename string, password string, shares []string) (wd *Wallet_Disk, err error) {
+	wd = &Wallet_Disk{filename: filename}
+
+	if wd.Wallet_Memory, err = Create_Encrypted_Wallet_From_Shares_Memory(password, shares); err != nil {
+		return nil, err
+	}
+	wd.Wallet_Memory.wallet_disk = wd
+	return
+}
+
 // create an encrypted wallet using using random data
 func Create_Encrypted_Wallet_Random(filename string, password string) (wd *Wallet_Disk, err error) {
 	wd = &Wallet_Disk{filename: filename}
@@ -86,6 +97,36 @@ func Create_Encrypted_Wallet_Random(filename string, password string) (wd *Walle
 	return nil, err
 }
 
+// create a view only wallet using view credential exported by the owner
+func Create_Encrypted_Wallet_ViewOnly(filename string, password string, credential string) (wd *Wallet_Disk, err error) {
+	if _, err = os.Stat(filename); err == nil {
+		err = fmt.Errorf("File '%s' already exists", filename)
+		return
+	}
+
+	wd = &Wallet_Disk{filename: filename}
+	if wd.Wallet_Memory, err = Create_Encrypted_Wallet_ViewOnly_Memory(password, credential); err != nil {
+		return nil, err
+	}
+	wd.Wallet_Memory.wallet_disk = wd
+	return
+}
+
+// restore a wallet from an encrypted backup, history is verified against the chain once wallet is online
+func Restore_Encrypted_Wallet(filename string, password string, backup_password string, backup []byte) (wd *Wallet_Disk, err error) {
+	if _, err = os.Stat(filename); err == nil {
+		err = fmt.Errorf("File '%s' already exists", filename)
+		return
+	}
+
+	wd = &Wallet_Disk{filename: filename}
+	if wd.Wallet_Memory, err = Restore_Encrypted_Wallet_Memory(password, backup_password, backup); err != nil {
+		return nil, err
+	}
+	wd.Wallet_Memory.wallet_disk = wd
+	return
+}
+
 // wallet must already be open
 func (w *Wallet_Disk) Set_Encrypted_Wallet_Password(password string) (err error) {
 	if w != nil {
@@ -113,6 +154,15 @@ func Open_Encrypted_Wallet(filename string, password string) (wd *Wallet_Disk, e
 	}
 	wd.Wallet_Memory.wallet_disk = wd
 
+	if wd.Wallet_Memory.migrated { // older copy is kept as backup, which normal saves never touch
+		if err = ioutil.WriteFile(filename+".v1.bak", filedata, 0600); err != nil {
+			return nil, fmt.Errorf("cannot backup wallet before migration err %s", err)
+		}
+		if err = wd.Save_Wallet(); err != nil {
+			return nil, err
+		}
+	}
+
 	return
 
 }
@@ -0,0 +1,321 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "crypto/rand"
+import "crypto/hmac"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+
+import "golang.org/x/crypto/chacha20poly1305"
+
+import "github.com/deroproject/derohe/config"
+
+// this file implements wallet format v2
+// format v1 stored the whole account as a single encrypted json blob ( Account_Encrypted)
+// format v2 splits the account in sections ( keys, settings, history), each sealed separately with the master key
+// the section name is authenticated, so sections cannot be swapped, unchanged sections are not re-encrypted on save
+// so history can grow without re-encrypting keys
+// the header contains checksums of all sections, so corruption can be detected without password
+// and is authenticated with the master key. if the history section is damaged, wallet opens and history is rebuilt from chain
+// sub accounts are split the same way, their settings live in settings section and only their history in history section
+// wallets in older format are migrated on open, original file is kept as <file>.v1.bak
+
+const WALLET_FORMAT_V2 = 2
+
+const wallet_magic = "DERO WALLET"
+
+const (
+	SECTION_KEYS     = "keys"
+	SECTION_SETTINGS = "settings"
+	SECTION_HISTORY  = "history"
+)
+
+var wallet_sections = []string{SECTION_KEYS, SECTION_SETTINGS, SECTION_HISTORY}
+
+const field_subaccounts = "subaccounts"                 // sub accounts without history, in settings section
+const field_subaccounts_history = "subaccounts_history" // history of sub accounts in same order, in history section
+
+// json fields of Account and their sections, everything not listed here is a setting
+var section_fields = map[string]string{
+	"keys":             SECTION_KEYS,
+	"seedlanguage":     SECTION_KEYS,
+	"viewonly":         SECTION_KEYS,
+	"height":           SECTION_HISTORY,
+	"topoheight":       SECTION_HISTORY,
+	"balance_mature":   SECTION_HISTORY,
+	"balance":          SECTION_HISTORY,
+	"balance_locked":   SECTION_HISTORY,
+	"Balance_Result":   SECTION_HISTORY,
+	"EntriesNative":    SECTION_HISTORY,
+	"ring_members":     SECTION_HISTORY,
+	"webhook_height":   SECTION_HISTORY,
+	"sync_checkpoints": SECTION_HISTORY,
+	"view_balances":    SECTION_HISTORY,
+}
+
+// stored in plain, so that recovery tools can identify and check wallets without password
+type Wallet_Header struct {
+	Magic    string            `json:"magic"`
+	Format   int               `json:"format"`
+	Address  string            `json:"address"`
+	Sections map[string]string `json:"sections"` // sha256 of every sealed section
+	MAC      string            `json:"mac"`      // hmac-sha256 of header using master key
+}
+
+// mac over all fields except mac itself
+func (h Wallet_Header) mac(key []byte) string {
+	h.MAC = ""
+	data, _ := json.Marshal(h) // map keys are sorted, so this is deterministic
+	m := hmac.New(sha256.New, key)
+	m.Write(data)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// checks all section checksums, this does not need password
+func (h *Wallet_Header) verify_checksums(sections map[string][]byte) (damaged []string) {
+	for _, name := range wallet_sections {
+		sum := sha256.Sum256(sections[name])
+		if h.Sections[name] != hex.EncodeToString(sum[:]) {
+			damaged = append(damaged, name)
+		}
+	}
+	return
+}
+
+func seal_section(key []byte, name string, data []byte) (result []byte, err error) {
+	cipher, err := chacha20poly1305.New(key)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+
+	result = cipher.Seal(nil, nonce, data, []byte(wallet_magic+" "+name))
+	return append(result, nonce...), nil
+}
+
+func open_section(key []byte, name string, data []byte) (result []byte, err error) {
+	if len(data) < 28 {
+		return nil, fmt.Errorf("Invalid data")
+	}
+	cipher, err := chacha20poly1305.New(key)
+	if err != nil {
+		return
+	}
+	nonce := data[len(data)-chacha20poly1305.NonceSize:]
+	return cipher.Open(nil, nonce, data[:len(data)-chacha20poly1305.NonceSize], []byte(wallet_magic+" "+name))
+}
+
+// split serialized account in sections
+func split_sections(account []byte) (sections map[string][]byte, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(account, &fields); err != nil {
+		return
+	}
+
+	grouped := map[string]map[string]json.RawMessage{}
+	for _, name := range wallet_sections {
+		grouped[name] = map[string]json.RawMessage{}
+	}
+	for field, value := range fields {
+		if field == field_subaccounts {
+			if err = split_subaccounts(value, grouped); err != nil {
+				return
+			}
+			continue
+		}
+		name, ok := section_fields[field]
+		if !ok {
+			name = SECTION_SETTINGS
+		}
+		grouped[name][field] = value
+	}
+
+	sections = map[string][]byte{}
+	for name, group := range grouped {
+		if sections[name], err = json.Marshal(group); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// history of every sub account is separated from rest of it
+func split_subaccounts(value json.RawMessage, grouped map[string]map[string]json.RawMessage) (err error) {
+	var subs []map[string]json.RawMessage
+	if err = json.Unmarshal(value, &subs); err != nil {
+		return
+	}
+	history := make([]map[string]json.RawMessage, len(subs))
+	for i := range subs {
+		history[i] = map[string]json.RawMessage{}
+		for field, v := range subs[i] {
+			if section_fields[field] == SECTION_HISTORY {
+				history[i][field] = v
+				delete(subs[i], field)
+			}
+		}
+	}
+	if grouped[SECTION_SETTINGS][field_subaccounts], err = json.Marshal(subs); err != nil {
+		return
+	}
+	grouped[SECTION_HISTORY][field_subaccounts_history], err = json.Marshal(history)
+	return
+}
+
+// merges history of sub accounts back, if history section was dropped sub accounts remain without history
+func join_subaccounts(fields map[string]json.RawMessage) (err error) {
+	value, ok := fields[field_subaccounts_history]
+	if !ok {
+		return
+	}
+	delete(fields, field_subaccounts_history)
+	if _, ok = fields[field_subaccounts]; !ok {
+		return
+	}
+
+	var subs, history []map[string]json.RawMessage
+	if err = json.Unmarshal(fields[field_subaccounts], &subs); err != nil {
+		return
+	}
+	if err = json.Unmarshal(value, &history); err != nil {
+		return
+	}
+	for i := range subs {
+		if i >= len(history) {
+			break
+		}
+		for field, v := range history[i] {
+			subs[i][field] = v
+		}
+	}
+	fields[field_subaccounts], err = json.Marshal(subs)
+	return
+}
+
+// seal account in sections and update header, caller must hold the lock
+func (w *Wallet_Memory) seal_sections() (err error) {
+	account, err := json.Marshal(w.account)
+	if err != nil {
+		return
+	}
+	plain, err := split_sections(account)
+	if err != nil {
+		return
+	}
+
+	if w.Sections == nil || w.section_hashes == nil {
+		w.Sections = map[string][]byte{}
+		w.section_hashes = map[string][32]byte{}
+	}
+
+	header := Wallet_Header{Magic: wallet_magic, Format: WALLET_FORMAT_V2, Address: w.account.GetAddress().String(), Sections: map[string]string{}}
+	for _, name := range wallet_sections {
+		hash := sha256.Sum256(plain[name])
+		if _, ok := w.Sections[name]; !ok || w.section_hashes[name] != hash {
+			if w.Sections[name], err = seal_section(w.master_password, name, plain[name]); err != nil {
+				return
+			}
+			w.section_hashes[name] = hash
+		}
+		sum := sha256.Sum256(w.Sections[name])
+		header.Sections[name] = hex.EncodeToString(sum[:])
+	}
+	header.MAC = header.mac(w.master_password)
+
+	w.Header = &header
+	w.Format = WALLET_FORMAT_V2
+	w.Account_Encrypted = nil
+	return
+}
+
+// opens all sections and loads the account, master password must already be available
+// a damaged history section is dropped, so the history will be rebuilt from chain
+func (w *Wallet_Memory) open_sections() (err error) {
+	if w.Header == nil || w.Header.Magic != wallet_magic || w.Header.Format != WALLET_FORMAT_V2 {
+		return fmt.Errorf("invalid wallet header")
+	}
+	if !hmac.Equal([]byte(w.Header.mac(w.master_password)), []byte(w.Header.MAC)) {
+		return fmt.Errorf("wallet header authentication failed, wallet is tampered or corrupted")
+	}
+
+	damaged := w.Header.verify_checksums(w.Sections)
+
+	w.section_hashes = map[string][32]byte{}
+	fields := map[string]json.RawMessage{}
+	for _, name := range wallet_sections {
+		var plain []byte
+		if !contains_string(damaged, name) {
+			plain, err = open_section(w.master_password, name, w.Sections[name])
+		}
+		if contains_string(damaged, name) || err != nil {
+			if name != SECTION_HISTORY {
+				return fmt.Errorf("wallet section '%s' is damaged", name)
+			}
+			logger.Error(err, "wallet history is damaged, it will be rebuilt from chain")
+			delete(w.Sections, name)
+			err = nil
+			continue
+		}
+
+		var group map[string]json.RawMessage
+		if err = json.Unmarshal(plain, &group); err != nil {
+			return fmt.Errorf("wallet section '%s' is damaged err %s", name, err)
+		}
+		for field, value := range group {
+			fields[field] = value
+		}
+		w.section_hashes[name] = sha256.Sum256(plain)
+	}
+	if err = join_subaccounts(fields); err != nil {
+		return fmt.Errorf("wallet sub accounts are damaged err %s", err)
+	}
+
+	account, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+	w.account = &Account{} // allocate a new instance
+	return json.Unmarshal(account, w.account)
+}
+
+// wallets older than format v2 are migrated to v2 with a new KDF, master key remains the same
+func (w *Wallet_Memory) migrate(password string) (err error) {
+	logger.Info("migrating wallet to new format", "version", w.Version.String(), "format", WALLET_FORMAT_V2)
+
+	if w.KDF, err = new_kdf(); err != nil {
+		return
+	}
+	w.pbkdf2_password = Generate_Key(w.KDF, password)
+	w.Version = config.Version
+	w.migrated = true
+	return w.Save_Wallet()
+}
+
+func contains_string(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
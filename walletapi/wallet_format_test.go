@@ -0,0 +1,153 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "bytes"
+import "testing"
+import "io/ioutil"
+import "crypto/rand"
+import "path/filepath"
+import "encoding/json"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+func Test_Wallet_Format_V2(t *testing.T) {
+	var scid crypto.Hash
+	w, err := Create_Encrypted_Wallet_Random_Memory("QWER")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+	w.account.EntriesNative = map[crypto.Hash][]rpc.Entry{scid: {{Height: 9, TopoHeight: 9, Incoming: true, Amount: 5}}}
+	w.SetRingSize(32)
+	sub, err := w.CreateAccount(3)
+	if err != nil {
+		t.Fatalf("Cannot create sub account, err %s", err)
+	}
+	sub.account.EntriesNative = map[crypto.Hash][]rpc.Entry{scid: {{Height: 8, TopoHeight: 8, Incoming: true, Amount: 7}}}
+	sub.account.Ringsize = 64
+
+	data := w.Get_Encrypted_Wallet()
+	if w.KDF.Hashfunction != "ARGON2ID" || w.Format != WALLET_FORMAT_V2 || w.Account_Encrypted != nil || len(w.Sections) != 3 {
+		t.Fatalf("wallet must be saved in format v2")
+	}
+	if bytes.Contains(data, []byte("EntriesNative")) || bytes.Contains(data, []byte("ringsize")) {
+		t.Fatalf("wallet sections must be encrypted")
+	}
+
+	opened, err := Open_Encrypted_Wallet_Memory("QWER", data)
+	if err != nil {
+		t.Fatalf("Cannot open wallet, err %s", err)
+	}
+	if opened.GetAddress().String() != w.GetAddress().String() || len(opened.account.EntriesNative[scid]) != 1 || opened.GetRingSize() != 32 {
+		t.Fatalf("wallet contents mismatch after reopening")
+	}
+	if subs := opened.account.SubAccounts; len(subs) != 1 || subs[0].Index != 3 || subs[0].Ringsize != 64 || len(subs[0].EntriesNative[scid]) != 1 {
+		t.Fatalf("sub account mismatch after reopening %+v", subs)
+	}
+	if _, err = Open_Encrypted_Wallet_Memory("WRONG", data); err == nil {
+		t.Fatalf("wallet must not open with wrong password")
+	}
+
+	// growing history must not re-encrypt keys
+	keys := append([]byte{}, w.Sections[SECTION_KEYS]...)
+	history := append([]byte{}, w.Sections[SECTION_HISTORY]...)
+	w.InsertReplace(scid, rpc.Entry{Height: 10, TopoHeight: 10, Incoming: true, Amount: 6})
+	w.Get_Encrypted_Wallet()
+	if !bytes.Equal(keys, w.Sections[SECTION_KEYS]) || bytes.Equal(history, w.Sections[SECTION_HISTORY]) {
+		t.Fatalf("only changed sections must be re-encrypted")
+	}
+
+	// damaged history is dropped and rebuilt later, damaged keys are fatal
+	reopen := func(modify func(*Wallet_Memory)) (*Wallet_Memory, error) {
+		var raw Wallet_Memory
+		json.Unmarshal(data, &raw)
+		modify(&raw)
+		modified, _ := json.Marshal(&raw)
+		return Open_Encrypted_Wallet_Memory("QWER", modified)
+	}
+	damaged, err := reopen(func(raw *Wallet_Memory) { raw.Sections[SECTION_HISTORY][0] ^= 1 })
+	if err != nil || len(damaged.account.EntriesNative) != 0 || damaged.GetAddress().String() != w.GetAddress().String() {
+		t.Fatalf("wallet with damaged history must open without history, err %v", err)
+	}
+	if subs := damaged.account.SubAccounts; len(subs) != 1 || subs[0].Index != 3 || subs[0].Ringsize != 64 || len(subs[0].EntriesNative) != 0 {
+		t.Fatalf("damaged history must only drop history of sub accounts %+v", subs)
+	}
+	if _, err = reopen(func(raw *Wallet_Memory) { raw.Sections[SECTION_KEYS][0] ^= 1 }); err == nil {
+		t.Fatalf("wallet with damaged keys must not open")
+	}
+	if _, err = reopen(func(raw *Wallet_Memory) { raw.Header.Address = "tampered" }); err == nil {
+		t.Fatalf("tampered header must be detected")
+	}
+	if _, err = reopen(func(raw *Wallet_Memory) {
+		raw.Sections[SECTION_KEYS], raw.Sections[SECTION_SETTINGS] = raw.Sections[SECTION_SETTINGS], raw.Sections[SECTION_KEYS]
+		raw.Header.Sections[SECTION_KEYS], raw.Header.Sections[SECTION_SETTINGS] = raw.Header.Sections[SECTION_SETTINGS], raw.Header.Sections[SECTION_KEYS]
+	}); err == nil {
+		t.Fatalf("swapped sections must be detected")
+	}
+}
+
+// wallets in format v1 must be migrated on open
+func Test_Wallet_Format_Migration(t *testing.T) {
+	w, err := Create_Encrypted_Wallet_Random_Memory("QWER")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	// recreate format v1 as written by older versions
+	v1 := &Wallet_Memory{Version: w.Version, master_password: w.master_password}
+	v1.KDF = KDF{Hashfunction: "SHA1", Keylen: 32, Iterations: 10, Salt: make([]byte, 32)}
+	rand.Read(v1.KDF.Salt)
+	v1.Secret, _ = EncryptWithKey(Generate_Key(v1.KDF, "QWER"), w.master_password)
+	account, _ := json.Marshal(w.account)
+	v1.Account_Encrypted, _ = v1.Encrypt(account)
+	data, _ := json.Marshal(v1)
+
+	migrated, err := Open_Encrypted_Wallet_Memory("QWER", data)
+	if err != nil {
+		t.Fatalf("Cannot open wallet in format v1, err %s", err)
+	}
+	if !migrated.migrated || migrated.Format != WALLET_FORMAT_V2 || migrated.KDF.Hashfunction != "ARGON2ID" || migrated.GetAddress().String() != w.GetAddress().String() {
+		t.Fatalf("wallet must be migrated to format v2")
+	}
+	if !migrated.Check_Password("QWER") {
+		t.Fatalf("password must remain same after migration")
+	}
+
+	reopened, err := Open_Encrypted_Wallet_Memory("QWER", migrated.Get_Encrypted_Wallet())
+	if err != nil || reopened.migrated || reopened.GetAddress().String() != w.GetAddress().String() {
+		t.Fatalf("migrated wallet must open without migration, err %v", err)
+	}
+
+	// older copy must survive any number of saves
+	filename := filepath.Join(t.TempDir(), "wallet.db")
+	if err = ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatalf("Cannot write wallet err %s", err)
+	}
+	wd, err := Open_Encrypted_Wallet(filename, "QWER")
+	if err != nil {
+		t.Fatalf("Cannot open wallet in format v1, err %s", err)
+	}
+	wd.Save_Wallet()
+	wd.Save_Wallet()
+	if backup, err := ioutil.ReadFile(filename + ".v1.bak"); err != nil || !bytes.Equal(backup, data) {
+		t.Fatalf("wallet in format v1 must be kept as backup err %v", err)
+	}
+	if _, err = Open_Encrypted_Wallet_Memory("QWER", data); err != nil {
+		t.Fatalf("backup must remain usable by older versions err %s", err)
+	}
+}
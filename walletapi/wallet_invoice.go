@@ -0,0 +1,191 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "sort"
+import "time"
+import "crypto/rand"
+import "encoding/binary"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/transaction"
+
+// this file implements invoices (payment requests) on top of integrated addresses
+// every invoice gets a unique random destination port, incoming payments with that port are credited to the invoice
+// invoices are stored within the account and are matched against history every time wallet syncs
+
+// create a new invoice, expiry of zero means invoice never expires
+func (w *Wallet_Memory) CreateInvoice(scid crypto.Hash, amount uint64, comment string, expiry time.Duration) (invoice rpc.Invoice, err error) {
+	if amount == 0 {
+		err = fmt.Errorf("invoice amount cannot be zero")
+		return
+	}
+
+	invoice = rpc.Invoice{SCID: scid, Amount: amount, Comment: comment, Created: time.Now().UTC(), State: rpc.InvoiceUnpaid}
+	invoice.Updated = invoice.Created
+	if expiry > 0 {
+		invoice.Expiry = invoice.Created.Add(expiry).Truncate(time.Second)
+	}
+
+	defer w.save_if_disk() // save wallet, runs after unlock
+	w.Lock()
+	defer w.Unlock()
+
+	for { // setup random 8 bytes of destination port, it must be unique within this wallet
+		var dstport [8]byte
+		if _, err = rand.Read(dstport[:]); err != nil {
+			return
+		}
+		invoice.DestinationPort = binary.BigEndian.Uint64(dstport[:])
+		if _, ok := w.account.Invoices[invoice.DestinationPort]; !ok && invoice.DestinationPort != 0 {
+			break
+		}
+	}
+
+	addr := w.account.GetAddress()
+	addr.Mainnet = w.account.mainnet
+	addr.Arguments = rpc.Arguments{{Name: rpc.RPC_DESTINATION_PORT, DataType: rpc.DataUint64, Value: invoice.DestinationPort}}
+	if scid.IsZero() { // value transfer is only understood for DERO
+		addr.Arguments = append(addr.Arguments, rpc.Argument{Name: rpc.RPC_VALUE_TRANSFER, DataType: rpc.DataUint64, Value: amount})
+	}
+	if !invoice.Expiry.IsZero() {
+		addr.Arguments = append(addr.Arguments, rpc.Argument{Name: rpc.RPC_EXPIRY, DataType: rpc.DataTime, Value: invoice.Expiry})
+	}
+	if comment != "" {
+		addr.Arguments = append(addr.Arguments, rpc.Argument{Name: rpc.RPC_COMMENT, DataType: rpc.DataString, Value: comment})
+	}
+	if _, err = addr.Arguments.CheckPack(transaction.PAYLOAD0_LIMIT); err != nil {
+		return
+	}
+	invoice.Integrated_Address = addr.String()
+
+	if w.account.Invoices == nil {
+		w.account.Invoices = map[uint64]rpc.Invoice{}
+	}
+	w.account.Invoices[invoice.DestinationPort] = invoice
+	return
+}
+
+// get an invoice using its destination port
+func (w *Wallet_Memory) GetInvoice(dstport uint64) (invoice rpc.Invoice, err error) {
+	w.Lock()
+	defer w.Unlock()
+	invoice, ok := w.account.Invoices[dstport]
+	if !ok {
+		err = fmt.Errorf("invoice %d not found", dstport)
+	}
+	return
+}
+
+// list invoices sorted by creation time, if state is empty all invoices are returned
+func (w *Wallet_Memory) GetInvoices(state rpc.InvoiceState) (invoices []rpc.Invoice) {
+	w.Lock()
+	defer w.Unlock()
+	for _, invoice := range w.account.Invoices {
+		if state == "" || state == invoice.State {
+			invoices = append(invoices, invoice)
+		}
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].Created.Before(invoices[j].Created) })
+	return
+}
+
+// callback is triggered whenever an invoice changes state or receives funds
+// callback is called from the syncing goroutine and must not block for long
+func (w *Wallet_Memory) SetInvoiceCallback(callback func(rpc.Invoice)) {
+	w.Lock()
+	defer w.Unlock()
+	w.invoice_callback = callback
+	for _, sub := range w.subaccounts {
+		sub.SetInvoiceCallback(callback)
+	}
+}
+
+// calculate state of invoice at specific time
+func invoice_state(invoice rpc.Invoice, received uint64, now time.Time) rpc.InvoiceState {
+	switch {
+	case received > invoice.Amount:
+		return rpc.InvoiceOverpaid
+	case received == invoice.Amount:
+		return rpc.InvoicePaid
+	case !invoice.Expiry.IsZero() && now.After(invoice.Expiry):
+		return rpc.InvoiceExpired
+	case received > 0:
+		return rpc.InvoicePartial
+	default:
+		return rpc.InvoiceUnpaid
+	}
+}
+
+// match all invoices against incoming history and trigger callbacks for any changes
+// this is called after every sync, but can be called any time
+func (w *Wallet_Memory) UpdateInvoices() {
+	w.invoice_mutex.Lock()
+	defer w.invoice_mutex.Unlock()
+
+	w.Lock()
+	invoices := make([]rpc.Invoice, 0, len(w.account.Invoices))
+	for _, invoice := range w.account.Invoices {
+		invoices = append(invoices, invoice)
+	}
+	callback := w.invoice_callback
+	w.Unlock()
+
+	now := time.Now().UTC()
+	var changed []rpc.Invoice
+	for _, invoice := range invoices {
+		var received uint64
+		var txids []string
+		for _, e := range w.Get_Payments_DestinationPort(invoice.SCID, invoice.DestinationPort, 0) {
+			if e.Incoming {
+				received += e.Amount
+				txids = append(txids, e.TXID)
+			}
+		}
+
+		state := invoice_state(invoice, received, now)
+		if state != invoice.State || received != invoice.Received {
+			invoice.State = state
+			invoice.Received = received
+			invoice.TXIDs = txids
+			invoice.Updated = now
+			changed = append(changed, invoice)
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	w.Lock()
+	for _, invoice := range changed {
+		if _, ok := w.account.Invoices[invoice.DestinationPort]; ok {
+			w.account.Invoices[invoice.DestinationPort] = invoice
+		}
+	}
+	w.Unlock()
+	w.save_if_disk()
+
+	for _, invoice := range changed {
+		logger.V(1).Info("invoice updated", "dstport", invoice.DestinationPort, "state", invoice.State, "received", invoice.Received)
+		if callback != nil {
+			callback(invoice)
+		}
+	}
+}
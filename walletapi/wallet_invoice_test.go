@@ -0,0 +1,102 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "time"
+import "testing"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+func Test_Invoices(t *testing.T) {
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	var zerohash crypto.Hash
+	if _, err = w.CreateInvoice(zerohash, 0, "", 0); err == nil {
+		t.Fatalf("zero amount invoice must not be created")
+	}
+
+	invoice, err := w.CreateInvoice(zerohash, 1000, "order 42", time.Hour)
+	if err != nil {
+		t.Fatalf("Cannot create invoice, err %s", err)
+	}
+	addr, err := rpc.NewAddress(invoice.Integrated_Address)
+	if err != nil {
+		t.Fatalf("Cannot parse invoice address, err %s", err)
+	}
+	if addr.Arguments.Value(rpc.RPC_DESTINATION_PORT, rpc.DataUint64).(uint64) != invoice.DestinationPort || addr.Arguments.Value(rpc.RPC_VALUE_TRANSFER, rpc.DataUint64).(uint64) != 1000 || !addr.Arguments.Has(rpc.RPC_EXPIRY, rpc.DataTime) {
+		t.Fatalf("invoice address has incorrect arguments %+v", addr.Arguments)
+	}
+
+	var updates []rpc.Invoice
+	w.SetInvoiceCallback(func(i rpc.Invoice) { updates = append(updates, i) })
+
+	w.UpdateInvoices()
+	if len(updates) != 0 {
+		t.Fatalf("unchanged invoice must not trigger callback")
+	}
+
+	w.InsertReplace(zerohash, rpc.Entry{Height: 10, TopoHeight: 10, Incoming: true, Amount: 400, DestinationPort: invoice.DestinationPort, TXID: "tx1"})
+	w.InsertReplace(zerohash, rpc.Entry{Height: 11, TopoHeight: 11, Incoming: true, Amount: 400, DestinationPort: invoice.DestinationPort + 1, TXID: "tx2"})
+	w.UpdateInvoices()
+	if len(updates) != 1 || updates[0].State != rpc.InvoicePartial || updates[0].Received != 400 {
+		t.Fatalf("invoice must be partially paid %+v", updates)
+	}
+
+	w.InsertReplace(zerohash, rpc.Entry{Height: 12, TopoHeight: 12, Incoming: true, Amount: 600, DestinationPort: invoice.DestinationPort, TXID: "tx3"})
+	w.UpdateInvoices()
+	if invoice, err = w.GetInvoice(invoice.DestinationPort); err != nil || invoice.State != rpc.InvoicePaid || len(invoice.TXIDs) != 2 || len(updates) != 2 {
+		t.Fatalf("invoice must be paid %+v err %s", invoice, err)
+	}
+
+	if len(w.GetInvoices(rpc.InvoicePaid)) != 1 || len(w.GetInvoices(rpc.InvoiceUnpaid)) != 0 || len(w.GetInvoices("")) != 1 {
+		t.Fatalf("invoice listing failed")
+	}
+	if _, err = w.GetInvoice(invoice.DestinationPort + 1); err == nil {
+		t.Fatalf("unknown invoice must not be found")
+	}
+}
+
+func Test_Invoice_State(t *testing.T) {
+	now := time.Now()
+	invoice := rpc.Invoice{Amount: 100, Expiry: now}
+
+	tests := []struct {
+		received uint64
+		at       time.Time
+		state    rpc.InvoiceState
+	}{
+		{0, now.Add(-time.Second), rpc.InvoiceUnpaid},
+		{50, now.Add(-time.Second), rpc.InvoicePartial},
+		{50, now.Add(time.Second), rpc.InvoiceExpired},
+		{100, now.Add(time.Second), rpc.InvoicePaid},
+		{150, now.Add(-time.Second), rpc.InvoiceOverpaid},
+	}
+	for _, test := range tests {
+		if state := invoice_state(invoice, test.received, test.at); state != test.state {
+			t.Fatalf("received %d expected state %s actual %s", test.received, test.state, state)
+		}
+	}
+
+	invoice.Expiry = time.Time{} // never expires
+	if state := invoice_state(invoice, 0, now.Add(24*365*time.Hour)); state != rpc.InvoiceUnpaid {
+		t.Fatalf("invoice without expiry must never expire")
+	}
+}
@@ -30,6 +30,7 @@ import "encoding/json"
 
 import "github.com/blang/semver/v4"
 import "golang.org/x/crypto/pbkdf2" // // used to encrypt master password ( so user can change his password anytime)
+import "golang.org/x/crypto/argon2"
 
 import "github.com/deroproject/derohe/rpc"
 import "github.com/deroproject/derohe/config"
@@ -41,10 +42,12 @@ import "github.com/deroproject/derohe/walletapi/mnemonics"
 
 // see this https://godoc.org/golang.org/x/crypto/pbkdf2
 type KDF struct {
-	Hashfunction string `json:"hash"` //"SHA1" currently only sha1 is supported
+	Hashfunction string `json:"hash"` //"SHA1" or "ARGON2ID", new wallets use argon2id
 	Keylen       int    `json:"keylen"`
 	Iterations   int    `json:"iterations"`
 	Salt         []byte `json:"salt"`
+	Memory       uint32 `json:"memory,omitempty"`  // argon2id memory in KiB
+	Threads      uint8  `json:"threads,omitempty"` // argon2id parallelism
 }
 
 // this is stored in disk in encrypted form
@@ -57,7 +60,13 @@ type Wallet_Memory struct {
 	KDF KDF `json:"kdf"`
 
 	account           *Account //`json:"-"` // not serialized, we store an encrypted version  // keys, seed language etc settings
-	Account_Encrypted []byte   `json:"account_encrypted"`
+	Account_Encrypted []byte   `json:"account_encrypted,omitempty"` // only used by format v1
+
+	Format         int                 `json:"format,omitempty"` // missing in wallets older than format v2, see wallet_format.go
+	Header         *Wallet_Header      `json:"header,omitempty"`
+	Sections       map[string][]byte   `json:"sections,omitempty"` // encrypted account sections
+	section_hashes map[string][32]byte // plain text hashes of sealed sections, unchanged sections are not re-encrypted
+	migrated       bool                // wallet was migrated from older format while opening
 
 	pbkdf2_password []byte // used to encrypt metadata on updates
 	master_password []byte // single password which never changes
@@ -85,24 +94,40 @@ type Wallet_Memory struct {
 	sync.RWMutex
 
 	sync_in_progress sync.Mutex // whether sync is in progress
+
+	invoice_mutex    sync.Mutex        // only one invoice update at a time
+	invoice_callback func(rpc.Invoice) // triggered on invoice changes
+
+	payout_mutex sync.Mutex // only one payout step at a time
+
+	parent      *Wallet_Memory            // master wallet, only set for sub accounts
+	subaccounts map[uint32]*Wallet_Memory // sub accounts, only set for master wallet
+
+	sync_workers           int                               // concurrent daemon requests while syncing
+	sync_limiter           chan struct{}                     // worker pool shared by all syncing goroutines
+	sync_progress          map[crypto.Hash]rpc.Sync_Progress // progress of syncs
+	sync_progress_callback func(rpc.Sync_Progress)           // triggered whenever sync progresses
+
+	persist_callback func([]byte) error // stores encrypted wallet, used where there is no Wallet_Disk
 }
 
 // when smart contracts are implemented, each will have it's own universe to track and maintain transactions
 
 // this file implements the encrypted data store at rest
 func Create_Encrypted_Wallet_Memory(password string, seed *crypto.BNRed) (w *Wallet_Memory, err error) {
-	w = &Wallet_Memory{}
-	w.Version = config.Version
-
-	if err != nil {
-		return
-	}
-
 	// generate account keys
-	w.account, err = Generate_Account_From_Seed(seed)
+	account, err := Generate_Account_From_Seed(seed)
 	if err != nil {
 		return
 	}
+	return new_wallet_memory(password, account)
+}
+
+// setup a new wallet around the account
+func new_wallet_memory(password string, account *Account) (w *Wallet_Memory, err error) {
+	w = &Wallet_Memory{}
+	w.Version = config.Version
+	w.account = account
 
 	// generate a 64 byte key to be used as master Key
 	w.master_password = make([]byte, 32, 32)
@@ -143,6 +168,37 @@ func Create_Encrypted_Wallet_From_Recovery_Words_Memory(password string, electru
 	return
 }
 
+// recover seed from seed shares, atleast threshold shares are required
+func Combine_Seed_Shares(shares []string) (language string, seed *crypto.BNRed, err error) {
+	var decoded []mnemonics.Share
+	for i := range shares {
+		var share mnemonics.Share
+		if language, share, err = mnemonics.Words_To_Share(shares[i]); err != nil {
+			return "", nil, fmt.Errorf("share %d: %s", i+1, err)
+		}
+		decoded = append(decoded, share)
+	}
+	key, err := mnemonics.Combine_Shares(decoded)
+	if err != nil {
+		return
+	}
+	return language, crypto.GetBNRed(key), nil
+}
+
+// create an encrypted wallet using seed shares
+func Create_Encrypted_Wallet_From_Shares_Memory(password string, shares []string) (w *Wallet_Memory, err error) {
+	language, seed, err := Combine_Seed_Shares(shares)
+	if err != nil {
+		return
+	}
+	if w, err = Create_Encrypted_Wallet_Memory(password, seed); err != nil {
+		return
+	}
+
+	w.account.SeedLanguage = language
+	return
+}
+
 // create an encrypted wallet using using random data
 func Create_Encrypted_Wallet_Random_Memory(password string) (w *Wallet_Memory, err error) {
 	w, err = Create_Encrypted_Wallet_Memory(password, crypto.RandomScalarBNRed())
@@ -163,23 +219,10 @@ func (w *Wallet_Memory) Set_Encrypted_Wallet_Password(password string) (err erro
 	w.Lock()
 
 	// set up KDF structure
-	w.KDF.Salt = make([]byte, 32, 32)
-	_, err = rand.Read(w.KDF.Salt)
-	if err != nil {
+	if w.KDF, err = new_kdf(); err != nil {
 		w.Unlock()
 		return
 	}
-	w.KDF.Keylen = 32
-	w.KDF.Iterations = 262144
-	w.KDF.Hashfunction = "SHA1"
-
-	if runtime.GOOS == "js" {
-		w.KDF.Iterations = 32768
-	}
-
-	if globals.IsSimulator() {
-		w.KDF.Iterations = 10
-	}
 
 	// lets generate the encrypted password
 
@@ -218,18 +261,23 @@ func Open_Encrypted_Wallet_Memory(password string, filedata []byte) (w *Wallet_M
 
 	// password has been  found, open the account
 
-	account_bytes, err := w.Decrypt(w.Account_Encrypted)
-	if err != nil {
-		//rlog.Errorf("err opening account err: %s ", err)
-		err = fmt.Errorf("probably Invalid Password")
-		w = nil
-		return
-	}
-
-	w.account = &Account{} // allocate a new instance
-	err = json.Unmarshal(account_bytes, w.account)
-	if err != nil {
-		return
+	if w.Format >= WALLET_FORMAT_V2 {
+		if err = w.open_sections(); err != nil {
+			w = nil
+			return
+		}
+	} else {
+		account_bytes, err := w.Decrypt(w.Account_Encrypted)
+		if err != nil {
+			//rlog.Errorf("err opening account err: %s ", err)
+			err = fmt.Errorf("probably Invalid Password")
+			return nil, err
+		}
+
+		w.account = &Account{} // allocate a new instance
+		if err = json.Unmarshal(account_bytes, w.account); err != nil {
+			return nil, err
+		}
 	}
 	var scid crypto.Hash
 	d := rpc.GetEncryptedBalance_Result{SCID: scid, Registration: -1}
@@ -238,6 +286,15 @@ func Open_Encrypted_Wallet_Memory(password string, filedata []byte) (w *Wallet_M
 		w.account.Balance = map[crypto.Hash]uint64{}
 	}
 
+	for _, account := range w.account.SubAccounts {
+		w.load_subaccount(account)
+	}
+
+	if w.Format < WALLET_FORMAT_V2 {
+		if err = w.migrate(password); err != nil {
+			return nil, err
+		}
+	}
 	return
 
 }
@@ -266,30 +323,28 @@ func (w *Wallet_Memory) Check_Password(password string) bool {
 
 // save updated copy of wallet
 func (w *Wallet_Memory) Save_Wallet() (err error) {
+	if w != nil && w.parent != nil { // sub accounts are stored within master account
+		return w.parent.Save_Wallet()
+	}
 	w.Lock()
 	defer w.Unlock()
 	if w == nil {
 		return
 	}
 
-	// encrypted the master password with the pbkdf2
-	w.Secret, err = EncryptWithKey(w.pbkdf2_password[:], w.master_password) // encrypt the master key
-	if err != nil {
-		return
+	for _, sub := range w.subaccounts { // sub accounts are serialized along with master account
+		sub.RLock()
+		defer sub.RUnlock()
 	}
 
-	// encrypt the account
-
-	account_serialized, err := json.Marshal(w.account)
+	// encrypted the master password with the pbkdf2
+	w.Secret, err = EncryptWithKey(w.pbkdf2_password[:], w.master_password) // encrypt the master key
 	if err != nil {
 		return
 	}
 
-	//  fmt.Printf("account serialized %s\n", string(account_serialized))
-	//  fmt.Printf("account serialized full %+v  %s\n", w.account , w.account.Keys.Secret.Text(16))
-
-	w.Account_Encrypted, err = w.Encrypt(account_serialized)
-	if err != nil {
+	// encrypt the account in sections
+	if err = w.seal_sections(); err != nil {
 		return
 	}
 
@@ -319,9 +374,35 @@ func (w *Wallet_Memory) Close_Encrypted_Wallet() {
 	w.Save_Wallet()
 }
 
+// KDF used for new wallets and password changes
+func new_kdf() (k KDF, err error) {
+	k.Salt = make([]byte, 32, 32)
+	if _, err = rand.Read(k.Salt); err != nil {
+		return
+	}
+	k.Keylen = 32
+	k.Hashfunction = "ARGON2ID"
+	k.Iterations = 3
+	k.Memory = 64 * 1024
+	k.Threads = 4
+
+	if runtime.GOOS == "js" {
+		k.Memory = 16 * 1024
+		k.Threads = 1
+	}
+
+	if globals.IsSimulator() {
+		k.Iterations = 1
+		k.Memory = 64
+	}
+	return
+}
+
 // generate key from password
 func Generate_Key(k KDF, password string) (key []byte) {
 	switch k.Hashfunction {
+	case "ARGON2ID":
+		return argon2.IDKey([]byte(password), k.Salt, uint32(k.Iterations), k.Memory, k.Threads, uint32(k.Keylen))
 	case "SHA1":
 		return pbkdf2.Key([]byte(password), k.Salt, k.Iterations, k.Keylen, sha1.New)
 
@@ -338,10 +419,43 @@ func (w *Wallet_Memory) GetAccount() *Account {
 }
 
 func (w *Wallet_Memory) save_if_disk() {
-	if w == nil || w.wallet_disk == nil {
+	if w != nil && w.parent != nil {
+		w.parent.save_if_disk()
+		return
+	}
+	if w == nil {
+		return
+	}
+
+	w.RLock()
+	persist := w.persist_callback
+	w.RUnlock()
+	if persist != nil {
+		if err := w.Save_Wallet(); err != nil {
+			logger.Error(err, "Error saving wallet")
+			return
+		}
+		w.RLock()
+		data := append([]byte{}, w.db_memory...)
+		w.RUnlock()
+		if err := persist(data); err != nil {
+			logger.Error(err, "Error persisting wallet")
+		}
+		return
+	}
+
+	if w.wallet_disk == nil {
 		return
 	}
 	if runtime.GOARCH != "wasm" {
 		w.wallet_disk.Save_Wallet()
 	}
 }
+
+// callback receives the encrypted wallet whenever it changes, so environments without a filesystem ( such as wasm ) can store it
+// it is called from the goroutine which modified the wallet and must not block for long
+func (w *Wallet_Memory) SetPersistCallback(callback func([]byte) error) {
+	w.Lock()
+	defer w.Unlock()
+	w.persist_callback = callback
+}
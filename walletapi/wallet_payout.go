@@ -0,0 +1,371 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "sort"
+import "time"
+import "errors"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/errormsg"
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/transaction"
+
+// this file implements batch payouts for pools and exchanges
+// rows are packed in txs which fit the maximum tx size, txs are sent one after another
+// next tx is only built once previous tx is mined and stable, since every tx spends the balance left by the previous one
+// state of every row is persisted before a tx is broadcast, so payouts can be safely resumed after a crash
+// a tx cannot be mined once chain is tx_validity_height blocks past its reference height, only then its rows are retried
+// so a row is never part of two txs which can both be mined
+
+const payout_max_transfers = 64 // transfers tried in a tx, reduced till tx fits
+const payout_max_attempts = 3   // row is marked failed after so many failed attempts
+
+// daemon interaction of payouts, replaceable for tests
+type payout_runner struct {
+	height  int64 // daemon height
+	stable  int64 // daemon stable height
+	build   func(scid crypto.Hash, ringsize uint64, transfers []rpc.Transfer) (txid string, raw []byte, height int64, fees uint64, err error)
+	send    func(raw []byte) error
+	status  func(txid string) (state tx_state, height int64, err error)
+	persist func(b rpc.PayoutBatch) // batch is persisted before tx is broadcast
+}
+
+// create a payout batch, rows are paid in the background while wallet is online
+func (w *Wallet_Memory) CreatePayoutBatch(scid crypto.Hash, ringsize uint64, transfers []rpc.Transfer) (batch rpc.PayoutBatch, err error) {
+	if w.account.ViewOnly {
+		return batch, fmt.Errorf("view only wallet cannot create transactions")
+	}
+	if len(transfers) == 0 {
+		return batch, fmt.Errorf("payout batch is empty")
+	}
+	if ringsize != 0 && (!crypto.IsPowerOf2(int(ringsize)) || ringsize < config.MIN_RINGSIZE || ringsize > config.MAX_RINGSIZE) {
+		return batch, fmt.Errorf("invalid ringsize %d", ringsize)
+	}
+
+	batch = rpc.PayoutBatch{SCID: scid, Ringsize: ringsize, Created: time.Now().UTC()}
+	batch.Updated = batch.Created
+	for i, t := range transfers {
+		addr, err := rpc.NewAddress(t.Destination)
+		if err != nil {
+			return batch, fmt.Errorf("row %d: invalid destination '%s' err %s", i, t.Destination, err)
+		}
+		if addr.IsMainnet() != w.GetNetwork() {
+			return batch, fmt.Errorf("row %d: destination '%s' belongs to another network", i, t.Destination)
+		}
+		if t.Amount == 0 {
+			return batch, fmt.Errorf("row %d: amount cannot be zero", i)
+		}
+		if _, err = t.Payload_RPC.CheckPack(transaction.PAYLOAD0_LIMIT); err != nil {
+			return batch, fmt.Errorf("row %d: %s", i, err)
+		}
+		batch.Rows = append(batch.Rows, rpc.Payout{Destination: t.Destination, Amount: t.Amount, Payload_RPC: t.Payload_RPC, State: rpc.PayoutPending})
+	}
+
+	defer w.save_if_disk() // save wallet, runs after unlock
+	w.Lock()
+	defer w.Unlock()
+
+	for id := range w.account.Payouts {
+		if id >= batch.ID {
+			batch.ID = id + 1
+		}
+	}
+	if batch.ID == 0 {
+		batch.ID = 1
+	}
+	if w.account.Payouts == nil {
+		w.account.Payouts = map[uint64]rpc.PayoutBatch{}
+	}
+	w.account.Payouts[batch.ID] = batch
+	return
+}
+
+// get a payout batch using its id
+func (w *Wallet_Memory) GetPayoutBatch(id uint64) (batch rpc.PayoutBatch, err error) {
+	w.Lock()
+	defer w.Unlock()
+	batch, ok := w.account.Payouts[id]
+	if !ok {
+		err = fmt.Errorf("payout batch %d not found", id)
+	}
+	return
+}
+
+// list payout batches sorted by id
+func (w *Wallet_Memory) GetPayoutBatches() (batches []rpc.PayoutBatch) {
+	w.Lock()
+	defer w.Unlock()
+	for _, batch := range w.account.Payouts {
+		batches = append(batches, batch)
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].ID < batches[j].ID })
+	return
+}
+
+// cancel pending rows of a payout batch, a tx which was already sent is still tracked till it is mined or expires
+func (w *Wallet_Memory) CancelPayoutBatch(id uint64) (batch rpc.PayoutBatch, err error) {
+	w.payout_mutex.Lock()
+	defer w.payout_mutex.Unlock()
+
+	defer w.save_if_disk() // save wallet, runs after unlock
+	w.Lock()
+	defer w.Unlock()
+	batch, ok := w.account.Payouts[id]
+	if !ok {
+		return batch, fmt.Errorf("payout batch %d not found", id)
+	}
+	for i := range batch.Rows {
+		if batch.Rows[i].State == rpc.PayoutPending {
+			batch.Rows[i].State = rpc.PayoutFailed
+			batch.Rows[i].Error = "cancelled"
+		}
+	}
+	batch.Done = batch.TXID == ""
+	batch.Updated = time.Now().UTC()
+	w.account.Payouts[id] = batch
+	return
+}
+
+// reconciliation report of a payout batch, mined txs are checked against wallet history
+func (w *Wallet_Memory) GetPayoutReport(id uint64) (report rpc.PayoutReport, err error) {
+	batch, err := w.GetPayoutBatch(id)
+	if err != nil {
+		return
+	}
+
+	report = rpc.PayoutReport{ID: batch.ID, Rows: len(batch.Rows), Fees: batch.Fees, Done: batch.Done}
+	txids := map[string]bool{}
+	for _, row := range batch.Rows {
+		report.Requested += row.Amount
+		switch row.State {
+		case rpc.PayoutPending:
+			report.Pending++
+		case rpc.PayoutSent:
+			report.Sent++
+		case rpc.PayoutConfirmed:
+			report.Confirmed++
+			report.Paid += row.Amount
+			if !txids[row.TXID] {
+				txids[row.TXID] = true
+				report.TXIDs = append(report.TXIDs, row.TXID)
+			}
+		case rpc.PayoutFailed:
+			report.Failed++
+		}
+	}
+
+	w.Lock()
+	history := map[string]bool{}
+	for _, e := range w.account.EntriesNative[batch.SCID] {
+		if !e.Incoming {
+			history[e.TXID] = true
+		}
+	}
+	w.Unlock()
+	for _, txid := range report.TXIDs {
+		if !history[txid] {
+			report.Unreconciled = append(report.Unreconciled, txid)
+		}
+	}
+	return
+}
+
+// process all unfinished payout batches, called from the sync loop
+func (w *Wallet_Memory) process_payouts() {
+	if !IsDaemonOnline() {
+		return
+	}
+	w.payout_mutex.Lock()
+	defer w.payout_mutex.Unlock()
+
+	w.Lock()
+	var batches []rpc.PayoutBatch
+	for _, batch := range w.account.Payouts {
+		if !batch.Done {
+			batches = append(batches, batch)
+		}
+	}
+	w.Unlock()
+	if len(batches) == 0 {
+		return
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].ID < batches[j].ID })
+
+	r := w.new_payout_runner()
+	for _, batch := range batches { // batches are paid in order, since every tx waits for the previous one
+		if !r.process(&batch) || !batch.Done {
+			break
+		}
+	}
+}
+
+func (w *Wallet_Memory) new_payout_runner() *payout_runner {
+	r := &payout_runner{height: daemon_height, stable: daemon_stable_height}
+	r.build = func(scid crypto.Hash, ringsize uint64, transfers []rpc.Transfer) (txid string, raw []byte, height int64, fees uint64, err error) {
+		tx, err := w.TransferPayload0(transfers, ringsize, false, rpc.Arguments{}, 0, false)
+		if err != nil {
+			return
+		}
+		return tx.GetHash().String(), tx.Serialize(), int64(tx.Height), tx.Fees(), nil
+	}
+	r.send = w.broadcast_raw // txs are also tracked and rebroadcast, see wallet_pending.go
+	r.status = get_tx_state
+	r.persist = func(b rpc.PayoutBatch) {
+		w.Lock()
+		w.account.Payouts[b.ID] = b
+		w.Unlock()
+		w.save_if_disk()
+	}
+	return r
+}
+
+// advance batch by one step, returns false if batch is stalled
+func (r *payout_runner) process(b *rpc.PayoutBatch) bool {
+	if b.TXID != "" { // previous tx must be stable before next tx is built
+		state, height, err := r.status(b.TXID)
+		if err != nil {
+			return false
+		}
+		switch {
+		case state == tx_mined && height <= r.stable:
+			for i := range b.Rows {
+				if b.Rows[i].State == rpc.PayoutSent && b.Rows[i].TXID == b.TXID {
+					b.Rows[i].State = rpc.PayoutConfirmed
+					b.Rows[i].Height = height
+					b.Rows[i].Error = ""
+				}
+			}
+			b.Fees += b.PendingFees
+			b.TXID, b.TXHeight, b.PendingFees, b.Error = "", 0, 0, ""
+		case state != tx_mined && r.height > b.TXHeight+tx_validity_height: // tx can no longer be mined
+			payout_retry(b, fmt.Sprintf("tx %s expired without being mined", b.TXID))
+		default:
+			return false
+		}
+		b.Updated = time.Now().UTC()
+		b.Done = payout_done(b)
+		r.persist(*b)
+		return true
+	}
+
+	var pending []int
+	for i := range b.Rows {
+		if b.Rows[i].State == rpc.PayoutPending {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		b.Done = true
+		r.persist(*b)
+		return true
+	}
+
+	count := payout_max_transfers
+	for {
+		if count > len(pending) {
+			count = len(pending)
+		}
+		var transfers []rpc.Transfer
+		for _, i := range pending[:count] {
+			row := b.Rows[i]
+			transfers = append(transfers, rpc.Transfer{SCID: b.SCID, Destination: row.Destination, Amount: row.Amount, Payload_RPC: row.Payload_RPC})
+		}
+
+		txid, raw, height, fees, err := r.build(b.SCID, b.Ringsize, transfers)
+		if err != nil && errors.Is(err, errormsg.ErrInsufficientFunds) && count == 1 {
+			b.Error = err.Error() // wait for funds, rows are not failed
+			b.Updated = time.Now().UTC()
+			r.persist(*b)
+			return false
+		}
+		if err != nil && count > 1 { // a single row may be responsible, so try smaller txs
+			count /= 2
+			continue
+		}
+		if err != nil {
+			row := &b.Rows[pending[0]]
+			row.Attempts++
+			row.Error = err.Error()
+			if row.Attempts >= payout_max_attempts {
+				row.State = rpc.PayoutFailed
+			}
+			b.Updated = time.Now().UTC()
+			b.Done = payout_done(b)
+			r.persist(*b)
+			return true
+		}
+		if len(raw) > int(config.STARGATE_HE_MAX_TX_SIZE) {
+			if count == 1 {
+				row := &b.Rows[pending[0]]
+				row.State, row.Error = rpc.PayoutFailed, "tx too large"
+				b.Done = payout_done(b)
+				r.persist(*b)
+				return true
+			}
+			count = count * int(config.STARGATE_HE_MAX_TX_SIZE) / len(raw) // size grows linearly with transfers
+			if count < 1 {
+				count = 1 // a single transfer may be larger than the rest together
+			}
+			continue
+		}
+
+		for _, i := range pending[:count] {
+			b.Rows[i].State = rpc.PayoutSent
+			b.Rows[i].TXID = txid
+			b.Rows[i].Attempts++
+		}
+		b.TXID, b.TXHeight, b.PendingFees, b.Error = txid, height, fees, ""
+		b.Updated = time.Now().UTC()
+		r.persist(*b) // rows must be recorded as sent before tx is broadcast
+
+		if err = r.send(raw); err != nil { // tx expires anyway, rows are retried once it can no longer be mined
+			b.Error = err.Error()
+			r.persist(*b)
+			return false
+		}
+		logger.Info("payout tx sent", "batch", b.ID, "txid", txid, "transfers", count, "fees", fees)
+		return false // wait for tx to be mined
+	}
+}
+
+// rows of a tx which can no longer be mined are retried, or failed if attempts are exhausted
+func payout_retry(b *rpc.PayoutBatch, reason string) {
+	for i := range b.Rows {
+		if b.Rows[i].State == rpc.PayoutSent && b.Rows[i].TXID == b.TXID {
+			b.Rows[i].TXID = ""
+			b.Rows[i].Error = reason
+			b.Rows[i].State = rpc.PayoutPending
+			if b.Rows[i].Attempts >= payout_max_attempts {
+				b.Rows[i].State = rpc.PayoutFailed
+			}
+		}
+	}
+	b.TXID, b.TXHeight, b.PendingFees, b.Error = "", 0, 0, reason
+}
+
+// batch is done when no row is pending or sent
+func payout_done(b *rpc.PayoutBatch) bool {
+	for i := range b.Rows {
+		if b.Rows[i].State == rpc.PayoutPending || b.Rows[i].State == rpc.PayoutSent {
+			return false
+		}
+	}
+	return true
+}
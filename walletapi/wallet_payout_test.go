@@ -0,0 +1,193 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "testing"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/errormsg"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+func Test_Payout_Batch(t *testing.T) {
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	var zerohash crypto.Hash
+	var transfers []rpc.Transfer
+	for i := 0; i < 100; i++ {
+		addr := rpc.NewAddressFromKeys(Generate_Keys_From_Seed(crypto.RandomScalarBNRed()).Public)
+		addr.Mainnet = w.GetNetwork()
+		transfers = append(transfers, rpc.Transfer{Destination: addr.String(), Amount: uint64(i + 1)})
+	}
+
+	if _, err = w.CreatePayoutBatch(zerohash, 0, []rpc.Transfer{{Destination: "invalid", Amount: 1}}); err == nil {
+		t.Fatalf("invalid destination must be rejected")
+	}
+	batch, err := w.CreatePayoutBatch(zerohash, 0, transfers)
+	if err != nil || batch.ID != 1 || len(batch.Rows) != 100 {
+		t.Fatalf("Cannot create payout batch, err %s", err)
+	}
+
+	// simulated daemon, every transfer takes 10 KB, last row can never be paid
+	type sim_tx struct {
+		transfers int
+		height    int64 // mined at height, 0 if not mined
+	}
+	txs := map[string]*sim_tx{}
+	var sent []string
+	r := w.new_payout_runner()
+	r.height, r.stable = 100, 90
+	r.build = func(scid crypto.Hash, ringsize uint64, transfers []rpc.Transfer) (string, []byte, int64, uint64, error) {
+		for _, t := range transfers {
+			if t.Amount == 100 {
+				return "", nil, 0, 0, fmt.Errorf("unregistered destination")
+			}
+		}
+		txid := fmt.Sprintf("tx%d", len(txs))
+		txs[txid] = &sim_tx{transfers: len(transfers)}
+		return txid, make([]byte, 10*1024*len(transfers)), r.height, 100, nil
+	}
+	r.send = func(raw []byte) error { sent = append(sent, fmt.Sprintf("tx%d", len(txs)-1)); return nil }
+	r.status = func(txid string) (tx_state, int64, error) {
+		if tx := txs[txid]; tx != nil && tx.height != 0 {
+			return tx_mined, tx.height, nil
+		}
+		return tx_pool, 0, nil
+	}
+
+	step := func() bool {
+		b, _ := w.GetPayoutBatch(1)
+		return r.process(&b)
+	}
+
+	// first tx is shrunk to fit maximum tx size
+	step()
+	b, _ := w.GetPayoutBatch(1)
+	if len(sent) != 1 || txs[sent[0]].transfers != 30 || b.TXID != sent[0] || b.Rows[0].State != rpc.PayoutSent || b.Rows[30].State != rpc.PayoutPending {
+		t.Fatalf("first tx must contain 30 transfers, sent %v", sent)
+	}
+
+	// state is persisted before broadcast, so payouts resume after a crash
+	reopened, err := Open_Encrypted_Wallet_Memory("", w.Get_Encrypted_Wallet())
+	if err != nil {
+		t.Fatalf("Cannot reopen wallet, err %s", err)
+	}
+	if rb, err := reopened.GetPayoutBatch(1); err != nil || rb.TXID != sent[0] || rb.Rows[29].State != rpc.PayoutSent {
+		t.Fatalf("payout state must be persisted, err %s", err)
+	}
+
+	// next tx waits till previous tx is mined and stable
+	if step() || len(sent) != 1 {
+		t.Fatalf("next tx must wait for previous tx")
+	}
+	txs[sent[0]].height = 95
+	step()
+	if b, _ = w.GetPayoutBatch(1); b.TXID == "" || b.Rows[0].State != rpc.PayoutSent {
+		t.Fatalf("unstable tx must not be confirmed")
+	}
+	r.stable = 95
+	step()
+	if b, _ = w.GetPayoutBatch(1); b.TXID != "" || b.Rows[0].State != rpc.PayoutConfirmed || b.Rows[0].Height != 95 || b.Fees != 100 {
+		t.Fatalf("mined tx must confirm its rows %+v", b.Rows[0])
+	}
+
+	// tx which is never mined expires and its rows are retried
+	step()
+	lost := sent[1]
+	r.height = 100 + tx_validity_height
+	if step() {
+		t.Fatalf("tx must not expire before it is unminable")
+	}
+	r.height++
+	step()
+	if b, _ = w.GetPayoutBatch(1); b.TXID != "" || b.Rows[30].State != rpc.PayoutPending || b.Rows[30].Error == "" {
+		t.Fatalf("expired tx must be retried")
+	}
+
+	// run till done, each mined tx becomes stable immediately
+	for i := 0; i < 100; i++ {
+		if b, _ = w.GetPayoutBatch(1); b.Done {
+			break
+		}
+		if b.TXID != "" {
+			txs[b.TXID].height = r.stable
+		}
+		step()
+	}
+	if !b.Done || b.Rows[99].State != rpc.PayoutFailed || b.Rows[99].Attempts != payout_max_attempts {
+		t.Fatalf("batch must complete, failing only the unpayable row %+v", b.Rows[99])
+	}
+	for i, row := range b.Rows {
+		if i != 99 && (row.State != rpc.PayoutConfirmed || row.TXID == lost) {
+			t.Fatalf("row %d must be paid by a mined tx %+v", i, row)
+		}
+	}
+
+	w.InsertReplace(zerohash, rpc.Entry{Height: 95, TopoHeight: 95, TXID: sent[0]})
+	report, err := w.GetPayoutReport(1)
+	if err != nil || report.Confirmed != 99 || report.Failed != 1 || report.Paid != report.Requested-100 || len(report.TXIDs) != len(report.Unreconciled)+1 || !report.Done {
+		t.Fatalf("invalid report %+v err %s", report, err)
+	}
+}
+
+func Test_Payout_Batch_Errors(t *testing.T) {
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	var zerohash crypto.Hash
+	var transfers []rpc.Transfer
+	for i := 0; i < 2; i++ {
+		addr := rpc.NewAddressFromKeys(Generate_Keys_From_Seed(crypto.RandomScalarBNRed()).Public)
+		addr.Mainnet = w.GetNetwork()
+		transfers = append(transfers, rpc.Transfer{Destination: addr.String(), Amount: uint64(i + 1)})
+	}
+	if _, err = w.CreatePayoutBatch(zerohash, 0, transfers); err != nil {
+		t.Fatalf("Cannot create payout batch, err %s", err)
+	}
+
+	r := w.new_payout_runner()
+	r.height, r.stable = 100, 90
+	r.send = func(raw []byte) error { return nil }
+
+	// missing funds are waited for, rows are not failed
+	r.build = func(scid crypto.Hash, ringsize uint64, transfers []rpc.Transfer) (string, []byte, int64, uint64, error) {
+		return "", nil, 0, 0, fmt.Errorf("%w for scid %s", errormsg.ErrInsufficientFunds, scid)
+	}
+	b, _ := w.GetPayoutBatch(1)
+	if r.process(&b) || b.Error == "" || b.Rows[0].Attempts != 0 || b.Rows[0].State != rpc.PayoutPending {
+		t.Fatalf("insufficient funds must not fail rows %+v", b)
+	}
+
+	// oversized first transfer never shrinks the tx to zero transfers
+	r.build = func(scid crypto.Hash, ringsize uint64, transfers []rpc.Transfer) (string, []byte, int64, uint64, error) {
+		if len(transfers) == 0 {
+			t.Fatalf("tx must contain at least one transfer")
+		}
+		return "tx", make([]byte, 3*int(config.STARGATE_HE_MAX_TX_SIZE)), r.height, 100, nil
+	}
+	b, _ = w.GetPayoutBatch(1)
+	r.process(&b)
+	if b.Rows[0].State != rpc.PayoutFailed || b.Rows[0].Error != "tx too large" || b.Rows[1].State != rpc.PayoutPending {
+		t.Fatalf("oversized transfer must fail alone %+v", b.Rows)
+	}
+}
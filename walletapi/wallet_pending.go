@@ -0,0 +1,194 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "sort"
+import "time"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/transaction"
+
+// this file tracks outgoing txs after they are broadcast
+// daemon may drop a tx from its pool (eg. house keeping or restart), such a tx is rebroadcast while it can still be mined
+// a tx cannot be mined once chain is tx_validity_height blocks past its reference height, it is then marked failed
+// mined txs are tracked till they become stable, after that wallet history is authoritative
+// daemon hides txs in dandelion stem phase, so a just broadcast tx is not rebroadcast till it had time to fluff
+
+const tx_validity_height = 11              // same as blockchain.TX_VALIDITY_HEIGHT
+const tx_failed_retention = 24 * time.Hour // failed txs are reported for so long
+const tx_stem_grace = 2 * time.Minute      // dandelion stem embargo is at most a minute
+
+type tx_state int
+
+const (
+	tx_unknown tx_state = iota // not in pool or chain
+	tx_pool
+	tx_mined
+)
+
+// an outgoing tx along with its raw form, so it can be rebroadcast even after wallet restarts
+type Tracked_TX struct {
+	Info rpc.PendingTX `json:"info"`
+	Raw  []byte        `json:"raw"`
+}
+
+// find state of tx using daemon
+func get_tx_state(txid string) (state tx_state, height int64, err error) {
+	var tx_params rpc.GetTransaction_Params
+	var tx_result rpc.GetTransaction_Result
+
+	tx_params.Tx_Hashes = append(tx_params.Tx_Hashes, txid)
+	if err = rpc_client.Call("DERO.GetTransaction", tx_params, &tx_result); err != nil {
+		return
+	}
+	if len(tx_result.Txs_as_hex) == 0 || len(tx_result.Txs) == 0 || tx_result.Txs_as_hex[0] == "" {
+		return tx_unknown, 0, nil
+	}
+	switch info := tx_result.Txs[0]; {
+	case info.In_pool:
+		return tx_pool, 0, nil
+	case info.ValidBlock != "":
+		return tx_mined, info.Block_Height, nil
+	}
+	return tx_unknown, 0, nil // only invalid blocks contain the tx
+}
+
+// broadcast serialized tx and track it
+func (w *Wallet_Memory) broadcast_raw(raw []byte) (err error) {
+	var tx transaction.Transaction
+	if err = tx.Deserialize(raw); err != nil {
+		return
+	}
+	return w.SendTransaction(&tx)
+}
+
+// start tracking a tx which was successfully broadcast
+func (w *Wallet_Memory) track_tx(tx *transaction.Transaction) {
+	now := time.Now().UTC()
+	t := Tracked_TX{Raw: tx.Serialize()}
+	t.Info = rpc.PendingTX{TXID: tx.GetHash().String(), Height: tx.Height, Payloads: len(tx.Payloads), Fees: tx.Fees(), State: rpc.PendingTXBroadcast, Broadcasts: 1, Created: now, Updated: now}
+
+	defer w.save_if_disk() // save wallet, runs after unlock
+	w.Lock()
+	defer w.Unlock()
+	if w.account.PendingTXs == nil {
+		w.account.PendingTXs = map[string]Tracked_TX{}
+	}
+	if old, ok := w.account.PendingTXs[t.Info.TXID]; ok { // tx was rebroadcast by caller
+		t.Info.Broadcasts += old.Info.Broadcasts
+		t.Info.Created = old.Info.Created
+	}
+	w.account.PendingTXs[t.Info.TXID] = t
+}
+
+// outgoing txs which are not yet stable or have failed recently, sorted by creation time
+func (w *Wallet_Memory) GetPendingTransactions() (pending []rpc.PendingTX) {
+	w.Lock()
+	defer w.Unlock()
+	for _, t := range w.account.PendingTXs {
+		pending = append(pending, t.Info)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Created.Before(pending[j].Created) })
+	return
+}
+
+// advance state of a tracked tx, returns true if tx no longer needs to be tracked
+func update_tracked_tx(t *Tracked_TX, height, stable int64, now time.Time, status func(string) (tx_state, int64, error), send func([]byte) error) (remove bool, err error) {
+	if t.Info.State == rpc.PendingTXFailed {
+		return now.Sub(t.Info.Updated) > tx_failed_retention, nil
+	}
+
+	state, block_height, err := status(t.Info.TXID)
+	if err != nil {
+		return
+	}
+
+	old := t.Info
+	switch {
+	case state == tx_mined && block_height <= stable:
+		return true, nil
+	case state == tx_mined:
+		t.Info.State, t.Info.BlockHeight, t.Info.Error = rpc.PendingTXMined, block_height, ""
+	case state == tx_pool:
+		t.Info.State, t.Info.BlockHeight = rpc.PendingTXInPool, 0
+	case height > int64(t.Info.Height)+tx_validity_height: // dropped and can never be mined
+		t.Info.State, t.Info.BlockHeight, t.Info.Error = rpc.PendingTXFailed, 0, "tx was dropped and can no longer be mined"
+	case t.Info.State == rpc.PendingTXBroadcast && now.Sub(t.Info.Updated) < tx_stem_grace: // possibly still in stem phase, Updated is time of last broadcast
+	default: // dropped by daemon or orphaned, rebroadcast
+		t.Info.State, t.Info.BlockHeight = rpc.PendingTXBroadcast, 0
+		t.Info.Broadcasts++
+		t.Info.Error = ""
+		if err = send(t.Raw); err != nil {
+			t.Info.Error = err.Error()
+			err = nil
+		}
+	}
+	if t.Info != old {
+		t.Info.Updated = now
+	}
+	return
+}
+
+// update all tracked txs, called from the sync loop
+func (w *Wallet_Memory) update_pending_txs() {
+	if !IsDaemonOnline() {
+		return
+	}
+
+	w.Lock()
+	var tracked []Tracked_TX
+	for _, t := range w.account.PendingTXs {
+		tracked = append(tracked, t)
+	}
+	w.Unlock()
+	if len(tracked) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	changed := false
+	for i := range tracked {
+		t := &tracked[i]
+		old := t.Info
+		remove, err := update_tracked_tx(t, daemon_height, daemon_stable_height, now, get_tx_state, send_raw_transaction)
+		if err != nil {
+			logger.V(1).Error(err, "DERO.GetTransaction Call failed:")
+			return
+		}
+		if t.Info.State != old.State && t.Info.State == rpc.PendingTXFailed {
+			logger.Error(fmt.Errorf("%s", t.Info.Error), "outgoing tx failed", "txid", t.Info.TXID)
+		}
+		if t.Info.Broadcasts != old.Broadcasts {
+			logger.Info("rebroadcast outgoing tx", "txid", t.Info.TXID, "broadcasts", t.Info.Broadcasts, "err", t.Info.Error)
+		}
+
+		w.Lock()
+		if _, ok := w.account.PendingTXs[t.Info.TXID]; ok {
+			if remove {
+				delete(w.account.PendingTXs, t.Info.TXID)
+			} else {
+				w.account.PendingTXs[t.Info.TXID] = *t
+			}
+		}
+		w.Unlock()
+		changed = changed || remove || t.Info != old
+	}
+	if changed {
+		w.save_if_disk()
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "time"
+import "testing"
+
+import "github.com/deroproject/derohe/rpc"
+
+func Test_Pending_TX_Tracking(t *testing.T) {
+	now := time.Now().UTC()
+	tx := Tracked_TX{Info: rpc.PendingTX{TXID: "tx1", Height: 100, State: rpc.PendingTXBroadcast, Broadcasts: 1, Created: now, Updated: now}, Raw: []byte{1}}
+
+	state, mined := tx_pool, int64(0)
+	status := func(txid string) (tx_state, int64, error) { return state, mined, nil }
+	var sent int
+	var send_err error
+	send := func(raw []byte) error { sent++; return send_err }
+
+	if remove, _ := update_tracked_tx(&tx, 101, 95, now, status, send); remove || tx.Info.State != rpc.PendingTXInPool {
+		t.Fatalf("tx in pool must be tracked %+v", tx.Info)
+	}
+
+	// dropped from pool, rebroadcast while it can still be mined
+	state = tx_unknown
+	send_err = fmt.Errorf("rejected")
+	update_tracked_tx(&tx, 105, 97, now, status, send)
+	if sent != 1 || tx.Info.Broadcasts != 2 || tx.Info.State != rpc.PendingTXBroadcast || tx.Info.Error != "rejected" {
+		t.Fatalf("dropped tx must be rebroadcast %+v", tx.Info)
+	}
+
+	// mined, but tracked till stable
+	state, mined = tx_mined, 106
+	if remove, _ := update_tracked_tx(&tx, 107, 99, now, status, send); remove || tx.Info.State != rpc.PendingTXMined || tx.Info.BlockHeight != 106 || tx.Info.Error != "" {
+		t.Fatalf("mined tx must be tracked till stable %+v", tx.Info)
+	}
+
+	// orphaned and can no longer be mined
+	state = tx_unknown
+	if update_tracked_tx(&tx, 100+tx_validity_height+1, 105, now, status, send); sent != 1 || tx.Info.State != rpc.PendingTXFailed {
+		t.Fatalf("expired tx must fail %+v", tx.Info)
+	}
+	if remove, _ := update_tracked_tx(&tx, 200, 190, now, status, send); remove {
+		t.Fatalf("failed tx must be reported for a while")
+	}
+	if remove, _ := update_tracked_tx(&tx, 200, 190, now.Add(tx_failed_retention+time.Second), status, send); !remove {
+		t.Fatalf("failed tx must be removed after retention")
+	}
+
+	// stable tx is no longer tracked
+	tx.Info.State = rpc.PendingTXInPool
+	state, mined = tx_mined, 106
+	if remove, _ := update_tracked_tx(&tx, 120, 110, now, status, send); !remove {
+		t.Fatalf("stable tx must not be tracked")
+	}
+
+	w, err := Create_Encrypted_Wallet_Random_Memory("")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+	w.account.PendingTXs = map[string]Tracked_TX{"tx2": {Info: rpc.PendingTX{TXID: "tx2", Created: now.Add(time.Second)}}, "tx1": tx}
+	if pending := w.GetPendingTransactions(); len(pending) != 2 || pending[0].TXID != "tx1" {
+		t.Fatalf("pending txs must be sorted by creation %+v", pending)
+	}
+}
+
+// daemon does not report txs in stem phase, they are not rebroadcast till they had time to fluff
+func Test_Pending_TX_Stem(t *testing.T) {
+	now := time.Now().UTC()
+	tx := Tracked_TX{Info: rpc.PendingTX{TXID: "tx1", Height: 100, State: rpc.PendingTXBroadcast, Broadcasts: 1, Created: now, Updated: now}, Raw: []byte{1}}
+
+	status := func(txid string) (tx_state, int64, error) { return tx_unknown, 0, nil }
+	var sent int
+	send := func(raw []byte) error { sent++; return nil }
+
+	if update_tracked_tx(&tx, 101, 95, now.Add(time.Minute), status, send); sent != 0 || tx.Info.Broadcasts != 1 || tx.Info.State != rpc.PendingTXBroadcast {
+		t.Fatalf("just broadcast tx must not be rebroadcast %+v", tx.Info)
+	}
+	if update_tracked_tx(&tx, 101, 95, now.Add(tx_stem_grace), status, send); sent != 1 || tx.Info.Broadcasts != 2 || !tx.Info.Updated.Equal(now.Add(tx_stem_grace)) {
+		t.Fatalf("tx missing after grace must be rebroadcast %+v", tx.Info)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "math/big"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/proof"
+import "github.com/deroproject/derohe/transaction"
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/cryptography/bn256"
+
+// this file implements receiver side payment proofs, see proof/proof_receiver.go for the construction
+
+// retrieve tx from daemon, tx_hex is empty if tx is not found
+func get_tx(txid string) (tx_hex string, info rpc.Tx_Related_Info, err error) {
+	var tx_params rpc.GetTransaction_Params
+	var tx_result rpc.GetTransaction_Result
+
+	if !IsDaemonOnline() {
+		err = fmt.Errorf("offline or not connected")
+		return
+	}
+
+	tx_params.Tx_Hashes = append(tx_params.Tx_Hashes, txid)
+	if err = rpc_client.Call("DERO.GetTransaction", tx_params, &tx_result); err != nil {
+		err = fmt.Errorf("gettransa rpc failed %s", err)
+		return
+	}
+	if len(tx_result.Txs_as_hex) == 0 || len(tx_result.Txs) == 0 || tx_result.Txs_as_hex[0] == "" {
+		return // tx not found
+	}
+	return tx_result.Txs_as_hex[0], tx_result.Txs[0], nil
+}
+
+// retrieve tx along with its ring members from daemon
+func get_tx_with_ring(txid string) (tx_hex string, ring [][]string, err error) {
+	tx_hex, info, err := get_tx(txid)
+	if err == nil && tx_hex == "" {
+		err = fmt.Errorf("tx %s not found", txid)
+	}
+	return tx_hex, info.Ring, err
+}
+
+// secret r of a payload, it is derived from the key of the sender, see transaction_build.go
+func payload_secret(secret *big.Int, statement *crypto.Statement) *big.Int {
+	rinputs := append([]byte{}, statement.Roothash[:]...)
+	for l := range statement.Publickeylist_compressed {
+		rinputs = append(rinputs, statement.Publickeylist_compressed[l][:]...)
+	}
+	rencrypted := new(bn256.G1).ScalarMult(crypto.HashToPoint(crypto.HashtoNumber(append([]byte(crypto.PROTOCOL_CONSTANT), rinputs...))), secret)
+	return crypto.ReducedHash(rencrypted.EncodeCompressed())
+}
+
+// generates proof that payload t of tx transferred amount to the owner of secret, ring members must be filled in tx
+func generate_receiver_proof(secret *big.Int, tx *transaction.Transaction, t int, amount uint64, mainnet bool) (string, error) {
+	if t >= len(tx.Payloads) {
+		return "", fmt.Errorf("tx does not have payload %d", t)
+	}
+	statement := &tx.Payloads[t].Statement
+	public := new(bn256.G1).ScalarMult(crypto.G, secret)
+
+	for k := range statement.Publickeylist {
+		if k >= len(statement.C) || statement.Publickeylist[k].String() != public.String() {
+			continue
+		}
+
+		S := new(bn256.G1).ScalarMult(statement.D, secret) // shared secret point r*Y
+		var x bn256.G1
+		x.ScalarMult(crypto.G, new(big.Int).SetUint64(amount))
+		x.Add(new(bn256.G1).Set(&x), S)
+		if x.String() != statement.C[k].String() {
+			return "", fmt.Errorf("payload %d did not transfer %d", t, amount)
+		}
+
+		c, s := crypto.ProveDLEQ(secret, public, statement.D, S)
+		return proof.NewReceiverProof(S, amount, c, s, mainnet), nil
+	}
+	return "", fmt.Errorf("wallet is not a ring member of payload %d", t)
+}
+
+// generates proof that payload t of tx transferred amount to receiver, r is the secret of the payload known to the sender
+// the proof has the same format as receiver proof, but proves that log_G(D) == log_Y(S)
+func generate_sender_proof(r *big.Int, tx *transaction.Transaction, t int, receiver *bn256.G1, amount uint64, mainnet bool) (string, error) {
+	if t >= len(tx.Payloads) {
+		return "", fmt.Errorf("tx does not have payload %d", t)
+	}
+	statement := &tx.Payloads[t].Statement
+
+	for k := range statement.Publickeylist {
+		if k >= len(statement.C) || statement.Publickeylist[k].String() != receiver.String() {
+			continue
+		}
+
+		S := new(bn256.G1).ScalarMult(receiver, r) // shared secret point r*Y
+		var x bn256.G1
+		x.ScalarMult(crypto.G, new(big.Int).SetUint64(amount))
+		x.Add(new(bn256.G1).Set(&x), S)
+		if x.String() != statement.C[k].String() {
+			return "", fmt.Errorf("payload %d did not transfer %d", t, amount)
+		}
+
+		c, s := crypto.ProveDLEQ(r, statement.D, receiver, S)
+		return proof.NewReceiverProof(S, amount, c, s, mainnet), nil
+	}
+	return "", fmt.Errorf("receiver is not a ring member of payload %d", t)
+}
+
+// GenerateReceiverProof generates a proof for every transfer received in the tx
+// proofs disclose amount and payload of these transfers only, the key is not revealed
+func (w *Wallet_Memory) GenerateReceiverProof(txid string) (proofs []string, err error) {
+	if w.account.ViewOnly {
+		return nil, fmt.Errorf("view only wallet cannot generate proofs")
+	}
+
+	var received []rpc.Entry
+	w.Lock()
+	for _, entries := range w.account.EntriesNative {
+		for _, e := range entries {
+			if e.TXID == txid && e.Incoming && !e.Coinbase {
+				received = append(received, e)
+			}
+		}
+	}
+	w.Unlock()
+
+	if len(received) == 0 {
+		return nil, fmt.Errorf("no transfer received in tx %s", txid)
+	}
+
+	tx_hex, ring, err := get_tx_with_ring(txid)
+	if err != nil {
+		return
+	}
+	tx, err := proof.DecodeTX(tx_hex, ring)
+	if err != nil {
+		return
+	}
+
+	for _, e := range received {
+		p, err := generate_receiver_proof(w.account.Keys.Secret.BigInt(), tx, e.Pos, e.Amount, w.GetNetwork())
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, p)
+	}
+	return
+}
+
+// VerifyProof verifies sender or receiver proof of a tx, tx is retrieved from daemon
+func (w *Wallet_Memory) VerifyProof(p string, txid string) (receivers []string, amounts []uint64, payloads []string, err error) {
+	tx_hex, ring, err := get_tx_with_ring(txid)
+	if err != nil {
+		return
+	}
+	receivers, amounts, _, payloads, err = proof.Prove(p, tx_hex, ring, w.GetNetwork())
+	return
+}
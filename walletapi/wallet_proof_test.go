@@ -0,0 +1,96 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "testing"
+import "math/big"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/proof"
+import "github.com/deroproject/derohe/transaction"
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/cryptography/bn256"
+
+func Test_Receiver_Proof(t *testing.T) {
+	x := crypto.RandomScalar()
+	Y := new(bn256.G1).ScalarMult(crypto.G, x)
+	decoy := new(bn256.G1).ScalarMult(crypto.G, crypto.RandomScalar())
+
+	amount := uint64(777)
+	r := crypto.RandomScalar()
+
+	var tx transaction.Transaction
+	tx.Payloads = make([]transaction.AssetPayload, 1)
+	statement := &tx.Payloads[0].Statement
+	statement.D = new(bn256.G1).ScalarMult(crypto.G, r)
+	statement.Publickeylist = []*bn256.G1{Y, decoy}
+	statement.C = []*bn256.G1{
+		new(bn256.G1).Add(new(bn256.G1).ScalarMult(crypto.G, new(big.Int).SetUint64(amount)), new(bn256.G1).ScalarMult(Y, r)),
+		new(bn256.G1).ScalarMult(decoy, r),
+	}
+
+	p, err := generate_receiver_proof(x, &tx, 0, amount, true)
+	if err != nil {
+		t.Fatalf("cannot generate receiver proof err %s", err)
+	}
+
+	addr, err := rpc.NewAddress(p)
+	if err != nil || !addr.Proof {
+		t.Fatalf("invalid receiver proof err %v", err)
+	}
+	if addr.PublicKey.G1().String() != new(bn256.G1).ScalarMult(Y, r).String() {
+		t.Fatalf("receiver proof must disclose shared secret point of this transfer only")
+	}
+	if addr.Arguments.Value(rpc.RPC_VALUE_TRANSFER, rpc.DataUint64).(uint64) != amount {
+		t.Fatalf("receiver proof must contain amount")
+	}
+	ch := addr.Arguments.Value(proof.RECEIVER_PROOF_C, rpc.DataHash).(crypto.Hash)
+	sh := addr.Arguments.Value(proof.RECEIVER_PROOF_S, rpc.DataHash).(crypto.Hash)
+	if !crypto.VerifyDLEQ(Y, statement.D, addr.PublicKey.G1(), new(big.Int).SetBytes(ch[:]), new(big.Int).SetBytes(sh[:])) {
+		t.Fatalf("receiver proof does not verify")
+	}
+
+	// sender discloses the same share using r
+	sp, err := generate_sender_proof(r, &tx, 0, Y, amount, true)
+	if err != nil {
+		t.Fatalf("cannot generate sender proof err %s", err)
+	}
+	if addr, err = rpc.NewAddress(sp); err != nil || addr.PublicKey.G1().String() != new(bn256.G1).ScalarMult(Y, r).String() {
+		t.Fatalf("sender proof must disclose shared secret point of this transfer only err %v", err)
+	}
+	ch = addr.Arguments.Value(proof.RECEIVER_PROOF_C, rpc.DataHash).(crypto.Hash)
+	sh = addr.Arguments.Value(proof.RECEIVER_PROOF_S, rpc.DataHash).(crypto.Hash)
+	if !crypto.VerifyDLEQ(statement.D, Y, addr.PublicKey.G1(), new(big.Int).SetBytes(ch[:]), new(big.Int).SetBytes(sh[:])) {
+		t.Fatalf("sender proof does not verify")
+	}
+	if _, err = generate_sender_proof(r, &tx, 0, Y, amount+1, true); err == nil {
+		t.Fatalf("sender proof for wrong amount must not be generated")
+	}
+	if _, err = generate_sender_proof(crypto.RandomScalar(), &tx, 0, Y, amount, true); err == nil {
+		t.Fatalf("sender proof must not be generated without r")
+	}
+
+	if _, err = generate_receiver_proof(x, &tx, 0, amount+1, true); err == nil {
+		t.Fatalf("proof for wrong amount must not be generated")
+	}
+	if _, err = generate_receiver_proof(crypto.RandomScalar(), &tx, 0, amount, true); err == nil {
+		t.Fatalf("proof must not be generated by non ring members")
+	}
+	if _, err = generate_receiver_proof(x, &tx, 1, amount, true); err == nil {
+		t.Fatalf("proof for missing payload must fail")
+	}
+}
@@ -30,6 +30,7 @@ import "encoding/hex"
 //import "github.com/vmihailenco/msgpack"
 
 import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/errormsg"
 import "github.com/deroproject/derohe/cryptography/crypto"
 
 //import "github.com/deroproject/derohe/crypto/ringct"
@@ -63,6 +64,11 @@ func (w *Wallet_Memory) TransferPayload0(transfers []rpc.Transfer, ringsize uint
 	w.transfer_mutex.Lock()
 	defer w.transfer_mutex.Unlock()
 
+	if w.account.ViewOnly {
+		err = fmt.Errorf("view only wallet cannot create transactions")
+		return
+	}
+
 	//if len(transfers) == 0 {
 	//	return nil,  fmt.Error("transfers is nil, cannot send.")
 	//}
@@ -161,7 +167,7 @@ func (w *Wallet_Memory) TransferPayload0(transfers []rpc.Transfer, ringsize uint
 			return
 		}
 		if total_amount_required[transfers[i].SCID] > current_balance {
-			err = fmt.Errorf("Insufficent funds for scid %s Need %s Actual %s", transfers[i].SCID, FormatMoney(total_amount_required[transfers[i].SCID]), FormatMoney(current_balance))
+			err = fmt.Errorf("%w for scid %s Need %s Actual %s", errormsg.ErrInsufficientFunds, transfers[i].SCID, FormatMoney(total_amount_required[transfers[i].SCID]), FormatMoney(current_balance))
 			return
 		}
 	}
@@ -0,0 +1,464 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "math/big"
+import "encoding/hex"
+import "encoding/binary"
+import "encoding/json"
+import "bytes"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/block"
+import "github.com/deroproject/derohe/proof"
+import "github.com/deroproject/derohe/transaction"
+import "github.com/deroproject/derohe/cryptography/crypto"
+import "github.com/deroproject/derohe/cryptography/bn256"
+
+// this file implements view only wallets for auditors
+// in DERO HE the balance decryption key is the spend key, decryption of (C, D) requires x since C - x*D = b*G
+// so any key which can decrypt future balances can also spend them and view access cannot be delegated as a key
+// instead the owner exports a view credential, a signed snapshot of balances and history at some topoheight
+// every disclosed balance carries the decryption share x*D with a proof that the share was created using the key of the address
+// so the auditor can verify disclosed balances against the chain without ever learning x
+// every disclosed transfer carries the decryption share of that transfer only, so its amount and payload are proven against the chain
+// history is only shown after it has been verified using the daemon, for coinbase entries only the miner is verified, not the amount
+// a view only wallet only holds the public key, so it can never build a transaction
+// since it cannot decrypt anything, it never syncs, it shows the state at the credential topoheight till a newer credential is imported
+
+// a balance disclosed in a view credential
+type ViewBalance struct {
+	SCID       crypto.Hash   `json:"scid"`
+	TopoHeight int64         `json:"topoheight"` // topoheight of encrypted balance
+	Balance    uint64        `json:"balance"`
+	Encrypted  string        `json:"encrypted"` // encrypted balance as stored on chain in hex
+	Share      *crypto.Point `json:"share"`     // decryption share x*D
+	C          *crypto.BNRed `json:"c"`         // proof that log_G(public key) == log_D(share)
+	S          *crypto.BNRed `json:"s"`
+}
+
+// ViewCredential is signed by the owner and exported as a "DERO SIGNED MESSAGE"
+type ViewCredential struct {
+	Address    string                      `json:"address"`
+	Height     uint64                      `json:"height"`
+	TopoHeight int64                       `json:"topoheight"`
+	Balances   []ViewBalance               `json:"balances"`
+	Entries    map[crypto.Hash][]rpc.Entry `json:"entries"`
+}
+
+// whether the wallet only has the public key
+func (w *Wallet_Memory) IsViewOnly() bool {
+	return w.account.ViewOnly
+}
+
+// decodes an encrypted balance without panicking on malformed data
+func decode_encrypted_balance(data string) (e *crypto.ElGamal, err error) {
+	raw, err := hex.DecodeString(data)
+	if err != nil {
+		return
+	}
+	if len(raw) != 66 {
+		return nil, fmt.Errorf("encrypted balance must be 66 bytes, actual %d", len(raw))
+	}
+	left, right := new(bn256.G1), new(bn256.G1)
+	if err = left.DecodeCompressed(raw[:33]); err != nil {
+		return
+	}
+	if err = right.DecodeCompressed(raw[33:]); err != nil {
+		return
+	}
+	return crypto.ConstructElGamal(left, right), nil
+}
+
+// verifies that disclosed balance is decryption of disclosed encrypted balance using key of public key
+func (b *ViewBalance) verify(public *bn256.G1) error {
+	e, err := decode_encrypted_balance(b.Encrypted)
+	if err != nil {
+		return fmt.Errorf("scid %s invalid encrypted balance err %s", b.SCID, err)
+	}
+	if b.Share == nil || b.C == nil || b.S == nil {
+		return fmt.Errorf("scid %s incomplete balance proof", b.SCID)
+	}
+	if !crypto.VerifyDLEQ(public, e.Right, b.Share.G1(), b.C.BigInt(), b.S.BigInt()) {
+		return fmt.Errorf("scid %s invalid decryption share proof", b.SCID)
+	}
+
+	decrypted := new(bn256.G1).Add(e.Left, new(bn256.G1).Neg(b.Share.G1()))
+	if decrypted.String() != new(bn256.G1).ScalarMult(crypto.G, new(big.Int).SetUint64(b.Balance)).String() {
+		return fmt.Errorf("scid %s disclosed balance does not match encrypted balance", b.SCID)
+	}
+	return nil
+}
+
+// ExportViewCredential exports a signed snapshot of synced balances and history, which can be imported in a view only wallet
+func (w *Wallet_Memory) ExportViewCredential() (credential string, err error) {
+	if w.account.ViewOnly {
+		return "", fmt.Errorf("view only wallet cannot export view credential")
+	}
+
+	w.Lock()
+	cred := ViewCredential{Address: w.GetAddress().String(), Height: w.account.Height, TopoHeight: w.account.TopoHeight, Entries: map[crypto.Hash][]rpc.Entry{}}
+	for scid, entries := range w.account.EntriesNative {
+		cred.Entries[scid] = append([]rpc.Entry{}, entries...)
+	}
+
+	x := w.account.Keys.Secret.BigInt()
+	public := w.account.Keys.Public.G1()
+	for _, r := range w.account.Balance_Result {
+		if r.Data == "" { // never synced
+			continue
+		}
+		raw, err := hex.DecodeString(r.Data)
+		_, size := binary.Uvarint(raw) // skip nonce height
+		if err != nil || size <= 0 {
+			w.Unlock()
+			return "", fmt.Errorf("scid %s cached encrypted balance is invalid", r.SCID)
+		}
+		e, err := decode_encrypted_balance(hex.EncodeToString(raw[size:]))
+		if err != nil {
+			w.Unlock()
+			return "", fmt.Errorf("scid %s cached encrypted balance is invalid err %s", r.SCID, err)
+		}
+		b := ViewBalance{SCID: r.SCID, TopoHeight: r.Topoheight, Balance: w.account.Balance[r.SCID], Encrypted: hex.EncodeToString(e.Serialize())}
+		share := new(bn256.G1).ScalarMult(e.Right, x)
+		c, s := crypto.ProveDLEQ(x, public, e.Right, share)
+		b.Share, b.C, b.S = (*crypto.Point)(share), crypto.GetBNRed(c), crypto.GetBNRed(s)
+
+		if err = b.verify(public); err != nil { // balance has changed but is not yet decoded
+			w.Unlock()
+			return "", fmt.Errorf("wallet is not synced, please retry err %s", err)
+		}
+		cred.Balances = append(cred.Balances, b)
+	}
+	w.Unlock()
+
+	if err = w.prove_view_entries(cred.Entries); err != nil {
+		return
+	}
+
+	data, err := json.Marshal(cred)
+	if err != nil {
+		return
+	}
+	return string(w.SignData(data)), nil
+}
+
+// replaces proofs of disclosed entries with share proofs which an auditor can verify against the chain
+// proofs stored during sync only carry a blinder, which can be computed for any amount, so they cannot be used
+func (w *Wallet_Memory) prove_view_entries(entries map[crypto.Hash][]rpc.Entry) error {
+	x := w.account.Keys.Secret.BigInt()
+	txs := map[string]*transaction.Transaction{}
+	for scid := range entries {
+		for i := range entries[scid] {
+			e := &entries[scid][i]
+			e.Proof = ""
+			if e.Coinbase || (!e.Incoming && e.Destination == "") { // nothing was transferred to anyone, only block and tx are verified
+				continue
+			}
+
+			tx, ok := txs[e.TXID]
+			if !ok {
+				tx_hex, ring, err := get_tx_with_ring(e.TXID)
+				if err != nil {
+					return fmt.Errorf("cannot prove tx %s, daemon must be online err %s", e.TXID, err)
+				}
+				if tx, err = proof.DecodeTX(tx_hex, ring); err != nil {
+					return err
+				}
+				for t := range tx.Payloads { // r is derived from compressed ring members
+					statement := &tx.Payloads[t].Statement
+					for _, p := range statement.Publickeylist {
+						var buf [33]byte
+						copy(buf[:], p.EncodeCompressed())
+						statement.Publickeylist_compressed = append(statement.Publickeylist_compressed, buf)
+					}
+				}
+				txs[e.TXID] = tx
+			}
+
+			var err error
+			if e.Incoming {
+				e.Proof, err = generate_receiver_proof(x, tx, e.Pos, e.Amount, w.GetNetwork())
+			} else {
+				var receiver *rpc.Address
+				if receiver, err = rpc.NewAddress(e.Destination); err == nil && e.Pos < len(tx.Payloads) {
+					r := payload_secret(x, &tx.Payloads[e.Pos].Statement)
+					e.Proof, err = generate_sender_proof(r, tx, e.Pos, receiver.PublicKey.G1(), e.Amount-e.Burn, w.GetNetwork())
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("cannot prove tx %s err %s", e.TXID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// verify a disclosed entry using daemon, block must be on main chain and tx must be valid in it
+// disclosed amount, receiver and payload of transfers must be proven by the share proof
+func verify_view_entry(addr *rpc.Address, e rpc.Entry) (bool, error) {
+	if e.TopoHeight < daemon_pruned_topoheight {
+		return false, fmt.Errorf("daemon has pruned history below topoheight %d, connect to a full node", daemon_pruned_topoheight)
+	}
+
+	var result rpc.GetBlock_Result
+	if err := rpc_client.Call("DERO.GetBlock", rpc.GetBlock_Params{Hash: e.BlockHash}, &result); err != nil {
+		return false, err
+	}
+	h := result.Block_Header
+	if result.Status != "OK" || h.Orphan_Status || h.SideBlock || h.TopoHeight != e.TopoHeight {
+		return false, nil
+	}
+
+	if e.Coinbase { // address must have mined the block or registered in it
+		var balance rpc.GetEncryptedBalance_Result
+		if err := rpc_client.Call("DERO.GetEncryptedBalance", rpc.GetEncryptedBalance_Params{Address: addr.String(), TopoHeight: -1}, &balance); err != nil {
+			return false, err
+		}
+		if balance.Registration == e.TopoHeight {
+			return true, nil
+		}
+
+		var bl block.Block
+		if block_bin, err := hex.DecodeString(result.Blob); err != nil || bl.Deserialize(block_bin) != nil {
+			return false, nil
+		}
+		if bytes.Equal(bl.Miner_TX.MinerAddress[:], addr.PublicKey.EncodeCompressed()) {
+			return true, nil
+		}
+		for _, m := range h.Miners {
+			if miner, err := rpc.NewAddress(m); err == nil && miner.PublicKey.String() == addr.PublicKey.String() {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	tx_hex, info, err := get_tx(e.TXID)
+	if err != nil {
+		return false, err
+	}
+	if tx_hex == "" || info.In_pool || info.ValidBlock != e.BlockHash {
+		return false, nil
+	}
+	if !e.Incoming && e.Destination == "" {
+		return true, nil
+	}
+
+	if p, err := rpc.NewAddress(e.Proof); err != nil || !p.Arguments.Has(proof.RECEIVER_PROOF_C, rpc.DataHash) {
+		return false, nil
+	}
+	receivers, amounts, payloads, _, err := proof.Prove(e.Proof, tx_hex, info.Ring, addr.Mainnet)
+	if err != nil || len(receivers) != 1 {
+		return false, nil
+	}
+	receiver, err := rpc.NewAddress(receivers[0])
+	if err != nil {
+		return false, nil
+	}
+
+	if e.Incoming {
+		if receiver.PublicKey.String() != addr.PublicKey.String() || amounts[0] != e.Amount {
+			return false, nil
+		}
+	} else {
+		destination, err := rpc.NewAddress(e.Destination)
+		if err != nil || receiver.PublicKey.String() != destination.PublicKey.String() || receiver.PublicKey.String() == addr.PublicKey.String() || amounts[0] != e.Amount-e.Burn {
+			return false, nil
+		}
+	}
+	if e.PayloadType == transaction.ENCRYPTED_DEFAULT_PAYLOAD_CBOR && !bytes.Equal(payloads[0], e.Payload) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// ParseViewCredential verifies signature and all disclosed balances of a view credential
+func ParseViewCredential(credential string) (cred *ViewCredential, addr *rpc.Address, err error) {
+	signer, message, err := (&Wallet_Memory{}).CheckSignature([]byte(credential))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid view credential err %s", err)
+	}
+
+	cred = &ViewCredential{}
+	if err = json.Unmarshal(message, cred); err != nil {
+		return nil, nil, fmt.Errorf("invalid view credential err %s", err)
+	}
+	if addr, err = rpc.NewAddress(cred.Address); err != nil {
+		return nil, nil, err
+	}
+	if signer.PublicKey.String() != addr.PublicKey.String() {
+		return nil, nil, fmt.Errorf("view credential is not signed by %s", cred.Address)
+	}
+	for i := range cred.Balances {
+		if err = cred.Balances[i].verify(addr.PublicKey.G1()); err != nil {
+			return nil, nil, err
+		}
+	}
+	return
+}
+
+// create a view only wallet from a view credential
+func Create_Encrypted_Wallet_ViewOnly_Memory(password string, credential string) (w *Wallet_Memory, err error) {
+	_, addr, err := ParseViewCredential(credential)
+	if err != nil {
+		return
+	}
+
+	account := &Account{Ringsize: 16, FeesMultiplier: 2.0, ViewOnly: true}
+	account.Keys.Public = new(crypto.Point).Set(addr.PublicKey)
+	account.mainnet = addr.Mainnet
+	if w, err = new_wallet_memory(password, account); err != nil {
+		return
+	}
+	err = w.ImportViewCredential(credential)
+	return
+}
+
+// ImportViewCredential replaces balances and history of a view only wallet with a newer view credential
+// balances are verified by their proofs, history is verified against the chain once wallet is online
+func (w *Wallet_Memory) ImportViewCredential(credential string) (err error) {
+	if !w.account.ViewOnly {
+		return fmt.Errorf("view credential can only be imported in view only wallet")
+	}
+
+	cred, addr, err := ParseViewCredential(credential)
+	if err != nil {
+		return
+	}
+
+	defer w.save_if_disk()
+	w.Lock()
+	defer w.Unlock()
+
+	if addr.PublicKey.String() != w.account.Keys.Public.String() {
+		return fmt.Errorf("view credential belongs to %s", cred.Address)
+	}
+	if cred.TopoHeight < w.account.TopoHeight {
+		return fmt.Errorf("view credential topoheight %d is older than current %d", cred.TopoHeight, w.account.TopoHeight)
+	}
+
+	w.account.Height = cred.Height
+	w.account.TopoHeight = cred.TopoHeight
+	w.account.ViewBalances = cred.Balances
+	w.account.ViewPending = cred.Entries // history is shown once verified, see VerifyViewHistory
+	w.account.EntriesNative = map[crypto.Hash][]rpc.Entry{}
+
+	w.account.Balance = map[crypto.Hash]uint64{}
+	w.account.Balance_Mature = 0
+	for _, b := range cred.Balances {
+		w.account.Balance[b.SCID] = b.Balance
+		if b.SCID.IsZero() {
+			w.account.Balance_Mature = b.Balance
+			w.account.Registered = true
+		}
+	}
+	return nil
+}
+
+// whether history disclosed by last view credential is still awaiting verification against the chain
+func (w *Wallet_Memory) IsViewPending() bool {
+	w.RLock()
+	defer w.RUnlock()
+	return len(w.account.ViewPending) > 0
+}
+
+// VerifyViewHistory verifies history disclosed by last view credential against the chain
+// if any entry cannot be proven, the credential is forged and whole disclosed history is discarded
+func (w *Wallet_Memory) VerifyViewHistory() error {
+	if !IsDaemonOnline() {
+		return fmt.Errorf("offline or not connected")
+	}
+	return w.verify_view_history(verify_view_entry)
+}
+
+func (w *Wallet_Memory) verify_view_history(verify func(addr *rpc.Address, e rpc.Entry) (bool, error)) error {
+	w.RLock()
+	pending, topoheight := w.account.ViewPending, w.account.TopoHeight
+	w.RUnlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	addr := w.GetAddress()
+	var invalid error
+	for scid, entries := range pending {
+		for _, e := range entries {
+			valid, err := verify(&addr, e)
+			if err != nil {
+				return err
+			}
+			if !valid {
+				invalid = fmt.Errorf("scid %s disclosed entry at topoheight %d txid %s cannot be proven, history discarded", scid, e.TopoHeight, e.TXID)
+				break
+			}
+		}
+		if invalid != nil {
+			break
+		}
+	}
+
+	defer w.save_if_disk()
+	w.Lock()
+	defer w.Unlock()
+	if w.account.TopoHeight != topoheight { // a newer credential was imported meanwhile
+		return nil
+	}
+	if invalid == nil {
+		w.account.EntriesNative = pending
+	}
+	w.account.ViewPending = nil
+	return invalid
+}
+
+// VerifyViewCredential checks disclosed encrypted balances against the chain
+// error is returned if a disclosed balance was never on chain, changed lists scids whose balance changed since the credential
+func (w *Wallet_Memory) VerifyViewCredential() (changed []crypto.Hash, err error) {
+	if !w.account.ViewOnly {
+		return nil, fmt.Errorf("wallet is not view only")
+	}
+
+	if err = w.VerifyViewHistory(); err != nil {
+		return nil, err
+	}
+
+	w.RLock()
+	balances := append([]ViewBalance{}, w.account.ViewBalances...)
+	w.RUnlock()
+
+	addr := w.GetAddress().String()
+	topoheight := Get_Daemon_TopoHeight()
+	for _, b := range balances {
+		_, _, _, e, err := w.GetEncryptedBalanceAtTopoHeight(b.SCID, b.TopoHeight, addr)
+		if err != nil {
+			return nil, err
+		}
+		if hex.EncodeToString(e.Serialize()) != b.Encrypted {
+			return nil, fmt.Errorf("scid %s disclosed balance does not exist on chain at topoheight %d", b.SCID, b.TopoHeight)
+		}
+
+		_, _, _, e, err = w.GetEncryptedBalanceAtTopoHeight(b.SCID, topoheight, addr)
+		if err != nil {
+			return nil, err
+		}
+		if hex.EncodeToString(e.Serialize()) != b.Encrypted {
+			changed = append(changed, b.SCID)
+		}
+	}
+	return
+}
@@ -0,0 +1,211 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "bytes"
+import "time"
+import "testing"
+import "math/big"
+import "encoding/hex"
+import "encoding/pem"
+import "encoding/json"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/blockchain"
+import "github.com/deroproject/derohe/transaction"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+func Test_ViewOnly_Wallet(t *testing.T) {
+	seed := "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly"
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("QWER", seed)
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	// simulate a synced wallet
+	var scid crypto.Hash
+	nb := crypto.NonceBalance{NonceHeight: 7, Balance: crypto.CommitElGamal(w.account.Keys.Public.G1(), new(big.Int).SetUint64(123456))}
+	w.setEncryptedBalanceresult(scid, rpc.GetEncryptedBalance_Result{SCID: scid, Data: hex.EncodeToString(nb.Serialize()), Topoheight: 10})
+	w.account.Balance[scid] = 123456
+	w.account.TopoHeight = 10
+	w.account.EntriesNative = map[crypto.Hash][]rpc.Entry{scid: {{Height: 9, TopoHeight: 9, Incoming: true, Coinbase: true, Amount: 123456}}}
+
+	credential, err := w.ExportViewCredential()
+	if err != nil {
+		t.Fatalf("Cannot export view credential, err %s", err)
+	}
+
+	v, err := Create_Encrypted_Wallet_ViewOnly_Memory("QWER", credential)
+	if err != nil {
+		t.Fatalf("Cannot create view only wallet, err %s", err)
+	}
+	if !v.IsViewOnly() || v.account.Keys.Secret != nil || v.GetAddress().String() != w.GetAddress().String() {
+		t.Fatalf("view only wallet must only contain public key")
+	}
+	if balance, _ := v.Get_Balance(); balance != 123456 || len(v.account.EntriesNative[scid]) != 0 || !v.IsViewPending() {
+		t.Fatalf("view only wallet must show disclosed balance and hold history till verified, balance %d", balance)
+	}
+	if err = v.verify_view_history(func(addr *rpc.Address, e rpc.Entry) (bool, error) { return true, nil }); err != nil || len(v.account.EntriesNative[scid]) != 1 || v.IsViewPending() {
+		t.Fatalf("verified history must be shown err %v", err)
+	}
+
+	if _, err = v.TransferPayload0([]rpc.Transfer{{Destination: w.GetAddress().String(), Amount: 1}}, 0, false, rpc.Arguments{}, 0, false); err == nil {
+		t.Fatalf("view only wallet must not create transactions")
+	}
+	if v.BuildTransaction(nil, nil, nil, crypto.Hash{}, 0, nil, nil, 0, 0) != nil || v.GetRegistrationTX() != nil || v.SignData([]byte("data")) != nil || v.GetSeed() != "" {
+		t.Fatalf("view only wallet must not use spend key")
+	}
+	if _, err = v.ExportViewCredential(); err == nil {
+		t.Fatalf("view only wallet must not export view credential")
+	}
+
+	// disclosed balance must match encrypted balance
+	w.account.Balance[scid] = 1
+	if _, err = w.ExportViewCredential(); err == nil {
+		t.Fatalf("unsynced balance must not be exported")
+	}
+	w.account.Balance[scid] = 123456
+
+	// tampering breaks the signature
+	block, _ := pem.Decode([]byte(credential))
+	block.Bytes = bytes.Replace(block.Bytes, []byte("123456"), []byte("123457"), -1)
+	if err = v.ImportViewCredential(string(pem.EncodeToMemory(block))); err == nil {
+		t.Fatalf("tampered view credential must be rejected")
+	}
+
+	// credential signed by someone else
+	other, _ := Create_Encrypted_Wallet_Random_Memory("QWER")
+	cred, _, _ := ParseViewCredential(credential)
+	cred.Balances[0].Balance = 1
+	if _, _, err = ParseViewCredential(string(other.SignData([]byte(`{"address":"` + cred.Address + `"}`)))); err == nil {
+		t.Fatalf("view credential signed by another key must be rejected")
+	}
+	if err = cred.Balances[0].verify(w.account.Keys.Public.G1()); err == nil {
+		t.Fatalf("forged balance must be rejected")
+	}
+
+	// history which cannot be proven is discarded
+	if err = v.ImportViewCredential(credential); err != nil {
+		t.Fatalf("cannot import view credential again err %s", err)
+	}
+	if err = v.verify_view_history(func(addr *rpc.Address, e rpc.Entry) (bool, error) { return false, nil }); err == nil || len(v.account.EntriesNative[scid]) != 0 || v.IsViewPending() {
+		t.Fatalf("unproven history must be discarded")
+	}
+
+	w.account.TopoHeight = 5
+	older, _ := w.ExportViewCredential()
+	if err = v.ImportViewCredential(older); err == nil {
+		t.Fatalf("older view credential must be rejected")
+	}
+}
+
+// disclosed history must be proven against the chain, forged amounts are rejected even if signed by the owner
+func Test_ViewOnly_Verify_History(t *testing.T) {
+	Initialize_LookupTable(1, 1<<17)
+
+	wgenesis, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "perfil lujo faja puma favor pedir detalle doble carbón neón paella cuarto ánimo cuento conga correr dental moneda león donar entero logro realidad acceso doble")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+	wdst, err := Create_Encrypted_Wallet_Random_Memory("")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	genesis_tx := transaction.Transaction{Transaction_Prefix: transaction.Transaction_Prefix{Version: 1, Value: 2012345}}
+	copy(genesis_tx.MinerAddress[:], wgenesis.account.Keys.Public.EncodeCompressed())
+	config.Testnet.Genesis_Tx = fmt.Sprintf("%x", genesis_tx.Serialize())
+	config.Mainnet.Genesis_Tx = fmt.Sprintf("%x", genesis_tx.Serialize())
+	genesis_block := blockchain.Generate_Genesis_Block()
+	config.Testnet.Genesis_Block_Hash = genesis_block.GetHash()
+	config.Mainnet.Genesis_Block_Hash = genesis_block.GetHash()
+
+	chain, rpcserver, _ := simulator_chain_start()
+	defer simulator_chain_stop(chain, rpcserver)
+	globals.Arguments["--daemon-address"] = rpcport
+	go Keep_Connectivity()
+
+	if err := chain.Add_TX_To_Pool(wdst.GetRegistrationTX()); err != nil {
+		t.Fatalf("Cannot add regtx to pool err %s", err)
+	}
+	for i := 0; i < 6; i++ { // transfers use balances 3 topoheights below tip
+		simulator_chain_mineblock(chain, wgenesis.GetAddress(), t)
+	}
+	for _, w := range []*Wallet_Memory{wgenesis, wdst} {
+		w.SetDaemonAddress(rpcport)
+		w.SetOnlineMode()
+		w.account.Ringsize = 2
+	}
+	time.Sleep(time.Second)
+	if err = wgenesis.Sync_Wallet_Memory_With_Daemon(); err != nil {
+		t.Fatalf("wallet sync error err %s", err)
+	}
+
+	tx, err := wgenesis.TransferPayload0([]rpc.Transfer{{Destination: wdst.GetAddress().String(), Amount: 7777, Payload_RPC: rpc.Arguments{{Name: rpc.RPC_COMMENT, DataType: rpc.DataString, Value: "audited"}}}}, 0, false, rpc.Arguments{}, 0, false)
+	if err != nil {
+		t.Fatalf("Cannot create transaction, err %s", err)
+	}
+	var dtx transaction.Transaction
+	dtx.Deserialize(tx.Serialize())
+	if err := chain.Add_TX_To_Pool(&dtx); err != nil {
+		t.Fatalf("Cannot add transfer tx to pool err %s", err)
+	}
+	simulator_chain_mineblock(chain, wgenesis.GetAddress(), t)
+	wgenesis.Sync_Wallet_Memory_With_Daemon()
+	wdst.Sync_Wallet_Memory_With_Daemon()
+
+	var zerohash crypto.Hash
+	for _, w := range []*Wallet_Memory{wgenesis, wdst} {
+		credential, err := w.ExportViewCredential()
+		if err != nil {
+			t.Fatalf("Cannot export view credential, err %s", err)
+		}
+		v, err := Create_Encrypted_Wallet_ViewOnly_Memory("", credential)
+		if err != nil {
+			t.Fatalf("Cannot create view only wallet, err %s", err)
+		}
+		if err = v.VerifyViewHistory(); err != nil {
+			t.Fatalf("disclosed history must verify err %s", err)
+		}
+		if len(v.account.EntriesNative[zerohash]) == 0 || len(v.account.EntriesNative[zerohash]) != len(w.account.EntriesNative[zerohash]) {
+			t.Fatalf("disclosed history not shown, expected %d actual %d", len(w.account.EntriesNative[zerohash]), len(v.account.EntriesNative[zerohash]))
+		}
+
+		// owner signs a credential with forged transfer amount
+		cred, _, _ := ParseViewCredential(credential)
+		forged := false
+		for i, e := range cred.Entries[zerohash] {
+			if !e.Coinbase && e.Amount > 0 {
+				cred.Entries[zerohash][i].Amount++
+				forged = true
+			}
+		}
+		if !forged {
+			t.Fatalf("history must contain transfer")
+		}
+		data, _ := json.Marshal(cred)
+		if err = v.ImportViewCredential(string(w.SignData(data))); err != nil {
+			t.Fatalf("Cannot import view credential, err %s", err)
+		}
+		if err = v.VerifyViewHistory(); err == nil || len(v.account.EntriesNative[zerohash]) != 0 {
+			t.Fatalf("forged history must be discarded")
+		}
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "fmt"
+import "time"
+import "bytes"
+import "net/url"
+import "net/http"
+import "crypto/hmac"
+import "crypto/sha256"
+import "encoding/hex"
+import "encoding/json"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+// this file implements http webhooks for incoming transfers
+// events are queued within the account so they are persisted along with the wallet and survive restarts
+// queued events are delivered by a separate goroutine, failed deliveries are retried with exponential backoff
+// event ids are derived from the entry, so rescans never produce new events and receivers can ignore duplicates
+// an incoming entry removed from history is counted, so if it is re-added it is notified again under a new id
+
+const webhook_timeout = 10 * time.Second
+const webhook_backoff_min = 5 * time.Second
+const webhook_backoff_max = time.Hour
+const webhook_max_attempts = 100 // with max backoff, an event is retried for roughly 4 days before being dropped
+
+type Webhook struct {
+	URL           string  `json:"url"`
+	Secret        string  `json:"secret"`                  // used to sign every request using HMAC-SHA256
+	Confirmations []int64 `json:"confirmations,omitempty"` // confirmation milestones to notify, eg 10, 100
+}
+
+// a pending event for a specific webhook
+type WebhookDelivery struct {
+	URL         string           `json:"url"`
+	Event       rpc.WebhookEvent `json:"event"`
+	Attempts    int              `json:"attempts"`
+	NextAttempt time.Time        `json:"next_attempt"`
+}
+
+// add or replace a webhook, webhooks are identified by their url
+func (w *Wallet_Memory) AddWebhook(hook Webhook) error {
+	if u, err := url.Parse(hook.URL); err != nil {
+		return err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook url must be http or https")
+	}
+	if hook.Secret == "" { // unsigned events could be forged by anyone who can reach the url
+		return fmt.Errorf("webhook secret must not be empty")
+	}
+	for _, c := range hook.Confirmations {
+		if c < 1 {
+			return fmt.Errorf("invalid confirmation milestone %d", c)
+		}
+	}
+
+	defer w.save_if_disk() // save wallet, runs after unlock
+	w.Lock()
+	defer w.Unlock()
+
+	for i := range w.account.Webhooks {
+		if w.account.Webhooks[i].URL == hook.URL {
+			w.account.Webhooks[i] = hook
+			return nil
+		}
+	}
+	w.account.Webhooks = append(w.account.Webhooks, hook)
+	return nil
+}
+
+// delete a webhook, any pending deliveries to it are discarded
+func (w *Wallet_Memory) DeleteWebhook(hook_url string) {
+	defer w.save_if_disk() // save wallet, runs after unlock
+	w.Lock()
+	defer w.Unlock()
+
+	hooks := w.account.Webhooks[:0]
+	for _, hook := range w.account.Webhooks {
+		if hook.URL != hook_url {
+			hooks = append(hooks, hook)
+		}
+	}
+	w.account.Webhooks = hooks
+
+	queue := w.account.WebhookQueue[:0]
+	for _, d := range w.account.WebhookQueue {
+		if d.URL != hook_url {
+			queue = append(queue, d)
+		}
+	}
+	w.account.WebhookQueue = queue
+}
+
+func (w *Wallet_Memory) GetWebhooks() []Webhook {
+	w.Lock()
+	defer w.Unlock()
+	return append([]Webhook{}, w.account.Webhooks...)
+}
+
+// number of events awaiting delivery
+func (w *Wallet_Memory) GetWebhookQueueLength() int {
+	w.Lock()
+	defer w.Unlock()
+	return len(w.account.WebhookQueue)
+}
+
+// identifies an entry across rescans and reorganisations, coinbase entries have no txid
+func webhook_entry_key(scid crypto.Hash, e rpc.Entry) string {
+	if e.Coinbase {
+		return fmt.Sprintf("%s/%s", scid, e.BlockHash)
+	}
+	return fmt.Sprintf("%s/%s/%d", scid, e.TXID, e.Pos)
+}
+
+// deterministic event id, same event for same entry always carries same id
+func webhook_event_id(event string, scid crypto.Hash, e rpc.Entry, milestone int64, removals int) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%d/%d/%d", event, webhook_entry_key(scid, e), e.TopoHeight, milestone, removals)))
+	return hex.EncodeToString(hash[:16])
+}
+
+// queue an event for all webhooks, lock must be held by caller
+// milestone is only valid for confirmed events and only webhooks interested in it receive the event
+// events which are already queued are not queued again
+func (w *Wallet_Memory) webhook_enqueue(event string, scid crypto.Hash, e rpc.Entry, confirmations int64, milestone int64) {
+	now := time.Now().UTC()
+	id := webhook_event_id(event, scid, e, milestone, w.account.WebhookRemovals[webhook_entry_key(scid, e)])
+	for _, hook := range w.account.Webhooks {
+		if event == rpc.WebhookConfirmed && !webhook_has_milestone(hook, milestone) {
+			continue
+		}
+		if w.webhook_queued(hook.URL, id) {
+			continue
+		}
+		ev := rpc.WebhookEvent{ID: id, Event: event, SCID: scid, Confirmations: confirmations, Entry: e, Created: now}
+		w.account.WebhookQueue = append(w.account.WebhookQueue, WebhookDelivery{URL: hook.URL, Event: ev, NextAttempt: now})
+	}
+}
+
+// whether event is awaiting delivery to url, lock must be held by caller
+func (w *Wallet_Memory) webhook_queued(url string, id string) bool {
+	for _, d := range w.account.WebhookQueue {
+		if d.URL == url && d.Event.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// every event is delivered to each webhook, so deliveries are identified by both
+func (d WebhookDelivery) key() string {
+	return d.URL + " " + d.Event.ID
+}
+
+func webhook_has_milestone(hook Webhook, milestone int64) bool {
+	for _, c := range hook.Confirmations {
+		if c == milestone {
+			return true
+		}
+	}
+	return false
+}
+
+// called when a new entry is recorded, lock must be held by caller
+// only entries above notified height are new, anything below was seen earlier and is being rescanned
+// entries which were removed earlier are always notified
+func (w *Wallet_Memory) webhook_entry_added(scid crypto.Hash, e rpc.Entry) {
+	if !e.Incoming || len(w.account.Webhooks) == 0 {
+		return
+	}
+	key := webhook_entry_key(scid, e)
+	removals := w.account.WebhookRemovals[key]
+	if removals%2 == 1 { // re-added after removal
+		w.account.WebhookRemovals[key] = removals + 1
+	} else if w.account.WebhookHeight == 0 || int64(e.Height) <= w.account.WebhookHeight {
+		return
+	}
+	w.webhook_enqueue(rpc.WebhookIncoming, scid, e, entry_confirmations(e, daemon_height), 0)
+}
+
+// called when entries are removed from history, lock must be held by caller
+func (w *Wallet_Memory) webhook_entries_removed(scid crypto.Hash, entries []rpc.Entry) {
+	if len(w.account.Webhooks) == 0 {
+		return
+	}
+	for _, e := range entries {
+		if !e.Incoming {
+			continue
+		}
+		key := webhook_entry_key(scid, e)
+		removals := w.account.WebhookRemovals[key]
+		if removals%2 == 1 { // already removed
+			continue
+		}
+		if w.account.WebhookRemovals == nil {
+			w.account.WebhookRemovals = map[string]int{}
+		}
+		w.account.WebhookRemovals[key] = removals + 1
+		w.webhook_enqueue(rpc.WebhookRemoved, scid, e, 0, 0)
+	}
+}
+
+// queue events for all incoming entries which crossed a confirmation milestone since last call
+// on first call, the height is only recorded, so as not to flood webhooks with the entire history
+func (w *Wallet_Memory) webhook_confirmations(height int64) {
+	w.Lock()
+	defer w.Unlock()
+
+	previous := w.account.WebhookHeight
+	if height <= previous {
+		return
+	}
+	w.account.WebhookHeight = height
+	if previous == 0 || len(w.account.Webhooks) == 0 {
+		return
+	}
+
+	for scid, entries := range w.account.EntriesNative {
+		for _, e := range entries {
+			if !e.Incoming {
+				continue
+			}
+			before, after := entry_confirmations(e, previous), entry_confirmations(e, height)
+			for _, hook := range w.account.Webhooks {
+				for _, milestone := range hook.Confirmations {
+					if before < milestone && milestone <= after {
+						w.webhook_enqueue(rpc.WebhookConfirmed, scid, e, after, milestone)
+					}
+				}
+			}
+		}
+	}
+}
+
+// sign the body using the webhook secret
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhook_post(hook Webhook, ev rpc.WebhookEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(rpc.WebhookSignatureHeader, WebhookSignature(hook.Secret, body))
+
+	client := http.Client{Timeout: webhook_timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// backoff doubles with every failed attempt
+func webhook_backoff(attempts int) time.Duration {
+	backoff := webhook_backoff_min
+	for i := 1; i < attempts && backoff < webhook_backoff_max; i++ {
+		backoff *= 2
+	}
+	if backoff > webhook_backoff_max {
+		backoff = webhook_backoff_max
+	}
+	return backoff
+}
+
+// attempt delivery of all due events, events to same url are delivered in order
+func (w *Wallet_Memory) deliver_webhooks() {
+	now := time.Now().UTC()
+
+	w.Lock()
+	var due []WebhookDelivery
+	hooks := map[string]Webhook{}
+	for _, hook := range w.account.Webhooks {
+		hooks[hook.URL] = hook
+	}
+	for _, d := range w.account.WebhookQueue {
+		if !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	w.Unlock()
+
+	if len(due) == 0 {
+		return
+	}
+
+	done := map[string]bool{}   // delivered or dropped events
+	failed := map[string]bool{} // urls which failed in this round
+	retry := map[string]WebhookDelivery{}
+	for _, d := range due {
+		hook, ok := hooks[d.URL]
+		if !ok { // webhook was removed
+			done[d.key()] = true
+			continue
+		}
+		if failed[d.URL] { // keep order, do not send later events to a failing url
+			continue
+		}
+		if err := webhook_post(hook, d.Event); err != nil {
+			failed[d.URL] = true
+			d.Attempts++
+			if d.Attempts >= webhook_max_attempts {
+				logger.Error(err, "webhook delivery failed, dropping event", "url", d.URL, "event", d.Event.Event, "id", d.Event.ID, "txid", d.Event.Entry.TXID)
+				done[d.key()] = true
+				continue
+			}
+			logger.V(1).Error(err, "webhook delivery failed", "url", d.URL, "attempts", d.Attempts)
+			d.NextAttempt = time.Now().UTC().Add(webhook_backoff(d.Attempts))
+			retry[d.key()] = d
+			continue
+		}
+		done[d.key()] = true
+	}
+
+	w.Lock()
+	queue := w.account.WebhookQueue[:0]
+	for _, d := range w.account.WebhookQueue {
+		if done[d.key()] {
+			continue
+		}
+		if r, ok := retry[d.key()]; ok {
+			d = r
+		} else if failed[d.URL] && !d.NextAttempt.After(now) { // undelivered events behind a failed one wait for it
+			d.NextAttempt = time.Now().UTC().Add(webhook_backoff(1))
+		}
+		queue = append(queue, d)
+	}
+	w.account.WebhookQueue = queue
+	w.Unlock()
+
+	w.save_if_disk()
+}
+
+// delivers queued webhook events every second
+func (w *Wallet_Memory) webhook_loop() {
+	for {
+		select {
+		case <-w.Quit:
+			return
+		case <-time.After(time.Second):
+		}
+		w.deliver_webhooks()
+	}
+}
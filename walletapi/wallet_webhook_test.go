@@ -0,0 +1,127 @@
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package walletapi
+
+import "io"
+import "time"
+import "testing"
+import "net/http"
+import "net/http/httptest"
+import "encoding/json"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+func Test_Webhooks(t *testing.T) {
+	w, err := Create_Encrypted_Wallet_From_Recovery_Words_Memory("", "sequence atlas unveil summon pebbles tuesday beer rudely snake rockets different fuselage woven tagged bested dented vegan hover rapid fawns obvious muppet randomly seasons randomly")
+	if err != nil {
+		t.Fatalf("Cannot create encrypted wallet, err %s", err)
+	}
+
+	var events []rpc.WebhookEvent
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(rpc.WebhookSignatureHeader) != WebhookSignature("secret", body) {
+			t.Errorf("invalid webhook signature")
+		}
+		if fail {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var ev rpc.WebhookEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Errorf("invalid webhook body, err %s", err)
+		}
+		events = append(events, ev)
+	}))
+	defer server.Close()
+
+	if err = w.AddWebhook(Webhook{URL: "ftp://localhost/"}); err == nil {
+		t.Fatalf("non http webhooks must not be accepted")
+	}
+	if err = w.AddWebhook(Webhook{URL: server.URL}); err == nil {
+		t.Fatalf("webhooks without secret must not be accepted")
+	}
+	if err = w.AddWebhook(Webhook{URL: server.URL, Secret: "secret", Confirmations: []int64{10}}); err != nil {
+		t.Fatalf("Cannot add webhook, err %s", err)
+	}
+
+	var zerohash crypto.Hash
+	w.InsertReplace(zerohash, rpc.Entry{Height: 90, TopoHeight: 90, Incoming: true, Amount: 400, TXID: "tx0"}) // history before first sync is not notified
+	w.webhook_confirmations(99)                                                                                // first call only records height
+	w.InsertReplace(zerohash, rpc.Entry{Height: 100, TopoHeight: 100, Incoming: true, Amount: 400, TXID: "tx1"})
+	w.InsertReplace(zerohash, rpc.Entry{Height: 101, TopoHeight: 101, Incoming: false, Amount: 100, TXID: "tx2"})
+	w.InsertReplace(zerohash, rpc.Entry{Height: 100, TopoHeight: 100, Incoming: true, Amount: 400, TXID: "tx1"}) // rescan must not trigger again
+	if w.GetWebhookQueueLength() != 1 {
+		t.Fatalf("only new incoming entries must be queued, queued %d", w.GetWebhookQueueLength())
+	}
+
+	w.deliver_webhooks()
+	if w.GetWebhookQueueLength() != 1 || w.account.WebhookQueue[0].Attempts != 1 || !w.account.WebhookQueue[0].NextAttempt.After(time.Now()) {
+		t.Fatalf("failed delivery must be retried later %+v", w.account.WebhookQueue)
+	}
+
+	w.webhook_confirmations(101)
+	w.webhook_confirmations(105)
+	w.webhook_confirmations(110) // entry at height 100 reaches 10 confirmations at 109
+	w.webhook_confirmations(120)
+	w.Lock()
+	w.truncate_history(zerohash, 1) // tx1 and tx2 are reorganised away
+	w.Unlock()
+	if w.GetWebhookQueueLength() != 3 {
+		t.Fatalf("expected 3 queued events, queued %d", w.GetWebhookQueueLength())
+	}
+
+	fail = false
+	for i := range w.account.WebhookQueue {
+		w.account.WebhookQueue[i].NextAttempt = time.Time{}
+	}
+	w.deliver_webhooks()
+	if w.GetWebhookQueueLength() != 0 || len(events) != 3 {
+		t.Fatalf("all events must be delivered, pending %d delivered %d", w.GetWebhookQueueLength(), len(events))
+	}
+	if events[0].Event != rpc.WebhookIncoming || events[1].Event != rpc.WebhookConfirmed || events[1].Confirmations != 11 || events[2].Event != rpc.WebhookRemoved || events[2].Entry.TXID != "tx1" {
+		t.Fatalf("events delivered incorrectly %+v", events)
+	}
+
+	// rescan from scratch finds nothing new, entry removed earlier is notified again under a new id
+	incoming_id := events[0].ID
+	w.Clean()
+	w.InsertReplace(zerohash, rpc.Entry{Height: 90, TopoHeight: 90, Incoming: true, Amount: 400, TXID: "tx0"})
+	w.InsertReplace(zerohash, rpc.Entry{Height: 102, TopoHeight: 102, Incoming: true, Amount: 400, TXID: "tx1"})
+	w.InsertReplace(zerohash, rpc.Entry{Height: 102, TopoHeight: 102, Incoming: true, Amount: 400, TXID: "tx1"})
+	if w.GetWebhookQueueLength() != 1 || w.account.WebhookQueue[0].Event.ID == incoming_id || w.account.WebhookQueue[0].Event.Event != rpc.WebhookIncoming {
+		t.Fatalf("re-added entry must be notified once %+v", w.account.WebhookQueue)
+	}
+	if id := webhook_event_id(rpc.WebhookIncoming, zerohash, w.account.WebhookQueue[0].Event.Entry, 0, 2); id != w.account.WebhookQueue[0].Event.ID {
+		t.Fatalf("event id must be deterministic")
+	}
+	w.deliver_webhooks()
+
+	w.DeleteWebhook(server.URL)
+	w.InsertReplace(zerohash, rpc.Entry{Height: 130, TopoHeight: 130, Incoming: true, Amount: 400, TXID: "tx3"})
+	if len(w.GetWebhooks()) != 0 || w.GetWebhookQueueLength() != 0 {
+		t.Fatalf("deleted webhook must not receive events")
+	}
+}
+
+func Test_Webhook_Backoff(t *testing.T) {
+	if webhook_backoff(1) != webhook_backoff_min || webhook_backoff(2) != 2*webhook_backoff_min || webhook_backoff(50) != webhook_backoff_max {
+		t.Fatalf("webhook backoff incorrect")
+	}
+}
@@ -0,0 +1,159 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wasmsdk
+
+// helpers to move values between go and javascript
+
+import "fmt"
+import "syscall/js"
+import "encoding/json"
+import "runtime/debug"
+
+// runs fn in a goroutine and settles the returned promise with its result
+// fn must never be run directly from a js callback, since it may wait on javascript promises
+func promise(fn func() (interface{}, error)) js.Value {
+	var executor js.Func
+	executor = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resolve, reject := args[0], args[1]
+		go func() {
+			defer executor.Release()
+			defer func() { // safety so if anything wrong happens, we reject
+				if r := recover(); r != nil {
+					reject.Invoke(js_error(fmt.Errorf("panic occured. stack trace %s", debug.Stack())))
+				}
+			}()
+
+			result, err := fn()
+			if err != nil {
+				reject.Invoke(js_error(err))
+				return
+			}
+			resolve.Invoke(to_js(result))
+		}()
+		return nil
+	})
+	return js.Global().Get("Promise").New(executor)
+}
+
+func js_error(err error) js.Value {
+	return js.Global().Get("Error").New(err.Error())
+}
+
+// waits for v to settle if it is a promise ( or any thenable ), other values are returned as is
+func await(v js.Value) (js.Value, error) {
+	if v.Type() != js.TypeObject || v.Get("then").Type() != js.TypeFunction {
+		return v, nil
+	}
+
+	type settled struct {
+		value js.Value
+		err   error
+	}
+	ch := make(chan settled, 1)
+
+	on_resolve := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		ch <- settled{value: arg(args, 0)}
+		return nil
+	})
+	on_reject := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		reason := arg(args, 0)
+		if reason.Type() == js.TypeObject && reason.Get("message").Type() == js.TypeString {
+			ch <- settled{err: fmt.Errorf("%s", reason.Get("message").String())}
+		} else {
+			ch <- settled{err: fmt.Errorf("%s", js_string(reason))}
+		}
+		return nil
+	})
+	defer on_resolve.Release()
+	defer on_reject.Release()
+
+	v.Call("then", on_resolve, on_reject)
+	r := <-ch
+	return r.value, r.err
+}
+
+// returns undefined for missing arguments
+func arg(args []js.Value, i int) js.Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return js.Undefined()
+}
+
+func js_string(v js.Value) string {
+	if v.Type() == js.TypeString {
+		return v.String()
+	}
+	return js.Global().Get("String").Invoke(v).String()
+}
+
+// converts go values to javascript, []byte becomes Uint8Array, structures go through json
+// note that json numbers above 2^53 lose precision in javascript
+func to_js(v interface{}) js.Value {
+	switch t := v.(type) {
+	case nil:
+		return js.Undefined()
+	case js.Value:
+		return t
+	case js.Func:
+		return t.Value
+	case string:
+		return js.ValueOf(t)
+	case bool:
+		return js.ValueOf(t)
+	case []byte:
+		buf := js.Global().Get("Uint8Array").New(len(t))
+		js.CopyBytesToJS(buf, t)
+		return buf
+	}
+
+	serialized, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return js.Global().Get("JSON").Call("parse", string(serialized))
+}
+
+// decodes a javascript object ( or json string ) into a go structure, undefined and null leave it untouched
+func from_js(v js.Value, out interface{}) error {
+	switch v.Type() {
+	case js.TypeUndefined, js.TypeNull:
+		return nil
+	case js.TypeString:
+		return json.Unmarshal([]byte(v.String()), out)
+	}
+	return json.Unmarshal([]byte(js.Global().Get("JSON").Call("stringify", v).String()), out)
+}
+
+// accepts Uint8Array, ArrayBuffer or string
+func js_bytes(v js.Value) ([]byte, error) {
+	switch {
+	case v.Type() == js.TypeString:
+		return []byte(v.String()), nil
+	case v.InstanceOf(js.Global().Get("Uint8Array")):
+	case v.InstanceOf(js.Global().Get("ArrayBuffer")):
+		v = js.Global().Get("Uint8Array").New(v)
+	default:
+		return nil, fmt.Errorf("expected Uint8Array, ArrayBuffer or string")
+	}
+	buf := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(buf, v)
+	return buf, nil
+}
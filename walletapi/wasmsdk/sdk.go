@@ -0,0 +1,220 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wasmsdk
+
+// this file installs the global namespace object, which creates and opens wallets
+
+import "fmt"
+import "sync"
+import "syscall/js"
+
+import "github.com/deroproject/derohe/config"
+import "github.com/deroproject/derohe/globals"
+import "github.com/deroproject/derohe/walletapi"
+
+// options accepted by init
+type Init_Options struct {
+	Network   string `json:"network"`   // "mainnet" ( default ) or "testnet"
+	Simulator bool   `json:"simulator"` // wallets use simulator settings such as weak KDF, only for testing
+	Daemon    string `json:"daemon"`    // daemon address, connectivity is maintained once this is set
+}
+
+// options accepted by createWallet and openWallet
+type Wallet_Options struct {
+	Name     string `json:"name"`     // name used with the storage hooks, wallets without name are not persisted
+	Password string `json:"password"` // password of the wallet
+	Seed     string `json:"seed"`     // createWallet only, 25 recovery words, random wallet is created if empty
+	Online   bool   `json:"online"`   // start syncing as soon as the wallet is available
+}
+
+type sdk struct {
+	sync.Mutex
+	storage      js.Value  // javascript object with load(name) and save(name, data) functions
+	connectivity sync.Once // connectivity goroutine is started only once
+}
+
+// Register installs the sdk in the javascript global object under name ( DERO if empty ) and returns the object
+// it must be called once, before the javascript side uses the api
+func Register(name string) js.Value {
+	if name == "" {
+		name = "DERO"
+	}
+	if globals.Arguments == nil {
+		globals.Arguments = map[string]interface{}{}
+	}
+
+	s := &sdk{storage: js.Undefined()}
+
+	namespace := js.Global().Get("Object").New()
+	namespace.Set("version", SDK_VERSION)
+	namespace.Set("init", method(func(args []js.Value) (interface{}, error) { return nil, s.init(arg(args, 0)) }))
+	namespace.Set("setStorage", method(func(args []js.Value) (interface{}, error) { return nil, s.set_storage(arg(args, 0)) }))
+	namespace.Set("createWallet", method(func(args []js.Value) (interface{}, error) { return s.create_wallet(arg(args, 0)) }))
+	namespace.Set("openWallet", method(func(args []js.Value) (interface{}, error) { return s.open_wallet(arg(args, 0), arg(args, 1)) }))
+
+	js.Global().Set(name, namespace)
+	return namespace
+}
+
+// wraps fn as a javascript function returning a promise
+func method(fn func(args []js.Value) (interface{}, error)) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return promise(func() (interface{}, error) { return fn(args) })
+	})
+}
+
+func (s *sdk) init(v js.Value) error {
+	var opts Init_Options
+	if err := from_js(v, &opts); err != nil {
+		return err
+	}
+
+	switch opts.Network {
+	case "", "mainnet":
+		globals.Config = config.Mainnet
+		globals.Arguments["--testnet"] = false
+	case "testnet":
+		globals.Config = config.Testnet
+		globals.Arguments["--testnet"] = true
+	default:
+		return fmt.Errorf("unknown network %q", opts.Network)
+	}
+	globals.Arguments["--simulator"] = opts.Simulator
+
+	if opts.Daemon != "" {
+		walletapi.SetDaemonAddress(opts.Daemon)
+		s.connectivity.Do(func() { go walletapi.Keep_Connectivity() })
+	}
+	return nil
+}
+
+func (s *sdk) set_storage(v js.Value) error {
+	if v.Type() != js.TypeObject || v.Get("load").Type() != js.TypeFunction || v.Get("save").Type() != js.TypeFunction {
+		return fmt.Errorf("storage must be an object with load(name) and save(name, data) functions")
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.storage = v
+	return nil
+}
+
+func (s *sdk) get_storage() js.Value {
+	s.Lock()
+	defer s.Unlock()
+	return s.storage
+}
+
+// returns nil if storage has nothing under name
+func (s *sdk) load(name string) ([]byte, error) {
+	storage := s.get_storage()
+	if storage.IsUndefined() {
+		return nil, fmt.Errorf("storage is not set")
+	}
+	v, err := await(storage.Call("load", name))
+	if err != nil {
+		return nil, fmt.Errorf("loading wallet %s failed err %s", name, err)
+	}
+	if v.IsUndefined() || v.IsNull() {
+		return nil, nil
+	}
+	return js_bytes(v)
+}
+
+func (s *sdk) save(name string, data []byte) error {
+	storage := s.get_storage()
+	if storage.IsUndefined() || name == "" {
+		return nil
+	}
+	if _, err := await(storage.Call("save", name, to_js(data))); err != nil {
+		return fmt.Errorf("saving wallet %s failed err %s", name, err)
+	}
+	return nil
+}
+
+func (s *sdk) create_wallet(v js.Value) (interface{}, error) {
+	var opts Wallet_Options
+	if err := from_js(v, &opts); err != nil {
+		return nil, err
+	}
+
+	if opts.Name != "" && !s.get_storage().IsUndefined() { // never overwrite an existing wallet
+		if data, err := s.load(opts.Name); err != nil {
+			return nil, err
+		} else if data != nil {
+			return nil, fmt.Errorf("wallet %s already exists", opts.Name)
+		}
+	}
+
+	var w *walletapi.Wallet_Memory
+	var err error
+	if opts.Seed != "" {
+		w, err = walletapi.Create_Encrypted_Wallet_From_Recovery_Words_Memory(opts.Password, opts.Seed)
+	} else {
+		w, err = walletapi.Create_Encrypted_Wallet_Random_Memory(opts.Password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	w.SetNetwork(globals.IsMainnet())
+
+	o := s.new_wallet(opts.Name, w)
+	if err := o.persist(); err != nil {
+		return nil, err
+	}
+	if opts.Online {
+		w.SetOnlineMode()
+	}
+	return o.object, nil
+}
+
+// wallet data is taken from the second argument if provided, otherwise it is loaded from storage
+func (s *sdk) open_wallet(v js.Value, data_value js.Value) (interface{}, error) {
+	var opts Wallet_Options
+	if err := from_js(v, &opts); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var err error
+	if data_value.IsUndefined() || data_value.IsNull() {
+		if opts.Name == "" {
+			return nil, fmt.Errorf("wallet name or data is required")
+		}
+		if data, err = s.load(opts.Name); err != nil {
+			return nil, err
+		} else if data == nil {
+			return nil, fmt.Errorf("wallet %s does not exist", opts.Name)
+		}
+	} else if data, err = js_bytes(data_value); err != nil {
+		return nil, err
+	}
+
+	w, err := walletapi.Open_Encrypted_Wallet_Memory(opts.Password, data)
+	if err != nil {
+		return nil, err
+	}
+	w.SetNetwork(globals.IsMainnet())
+
+	o := s.new_wallet(opts.Name, w)
+	if opts.Online {
+		w.SetOnlineMode()
+	}
+	return o.object, nil
+}
@@ -0,0 +1,139 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wasmsdk
+
+import "strings"
+import "testing"
+import "encoding/pem"
+import "syscall/js"
+
+// these tests drive the sdk through javascript exactly as a page would, they run headless under node, see README.md
+
+// in memory storage written in javascript, using async hooks
+const test_storage = `(function() {
+	const store = new Map();
+	return { store: store, load: async (name) => store.has(name) ? store.get(name) : null, save: async (name, data) => { store.set(name, data.slice()) } };
+})()`
+
+func call(t *testing.T, object js.Value, name string, args ...interface{}) (js.Value, error) {
+	t.Helper()
+	p := object.Call(name, args...)
+	if p.Get("then").Type() != js.TypeFunction {
+		t.Fatalf("%s did not return a promise", name)
+	}
+	return await(p)
+}
+
+func must(t *testing.T, object js.Value, name string, args ...interface{}) js.Value {
+	t.Helper()
+	v, err := call(t, object, name, args...)
+	if err != nil {
+		t.Fatalf("%s failed err %s", name, err)
+	}
+	return v
+}
+
+func Test_SDK(t *testing.T) {
+	dero := Register("DERO_TEST")
+	if js.Global().Get("DERO_TEST").Get("version").String() != SDK_VERSION {
+		t.Fatalf("version not exported")
+	}
+
+	if _, err := call(t, dero, "init", map[string]interface{}{"network": "unknown"}); err == nil {
+		t.Fatalf("unknown network must be rejected")
+	}
+	must(t, dero, "init", map[string]interface{}{"network": "testnet", "simulator": true})
+
+	if _, err := call(t, dero, "setStorage", map[string]interface{}{}); err == nil {
+		t.Fatalf("storage without hooks must be rejected")
+	}
+	storage := js.Global().Call("eval", test_storage)
+	must(t, dero, "setStorage", storage)
+
+	w := must(t, dero, "createWallet", map[string]interface{}{"name": "first", "password": "QWER"})
+	address := w.Get("address").String()
+	if !strings.HasPrefix(address, "deto") {
+		t.Fatalf("testnet address expected, got %s", address)
+	}
+	if !storage.Get("store").Call("has", "first").Bool() {
+		t.Fatalf("new wallet was not persisted")
+	}
+	if _, err := call(t, dero, "createWallet", map[string]interface{}{"name": "first", "password": "QWER"}); err == nil {
+		t.Fatalf("existing wallet must not be overwritten")
+	}
+
+	balance := must(t, w, "getBalance")
+	if balance.Get("balance").Int() != 0 || balance.Get("unlocked_balance").Int() != 0 {
+		t.Fatalf("new wallet must have zero balance")
+	}
+	progress := must(t, w, "getSyncProgress")
+	if progress.Get("online").Bool() {
+		t.Fatalf("wallet must start offline")
+	}
+	if transfers := must(t, w, "getTransfers", map[string]interface{}{"in": true, "out": true}); transfers.Get("entries").Truthy() {
+		t.Fatalf("new wallet must not have transfers")
+	}
+	if _, err := call(t, w, "transfer", map[string]interface{}{"transfers": []interface{}{map[string]interface{}{"destination": address, "amount": 1}}}); err == nil || !strings.Contains(err.Error(), "offline") {
+		t.Fatalf("offline wallet must not transfer, err %v", err)
+	}
+	if _, err := call(t, w, "invokeSC", map[string]interface{}{}); err == nil {
+		t.Fatalf("scinvoke without scid must fail")
+	}
+
+	signed := must(t, w, "signData", "hello")
+	verified := must(t, w, "verifyData", signed)
+	if verified.Get("signer").String() != address || js.Global().Get("Buffer").Call("from", verified.Get("message")).Call("toString").String() != "hello" {
+		t.Fatalf("signature verification mismatch")
+	}
+	block, _ := pem.Decode([]byte(signed.String()))
+	block.Bytes = []byte("hellp")
+	if _, err := call(t, w, "verifyData", string(pem.EncodeToMemory(block))); err == nil {
+		t.Fatalf("tampered message must not verify")
+	}
+
+	seed := must(t, w, "getSeed").String()
+	must(t, w, "close")
+	if _, err := call(t, w, "getBalance"); err == nil {
+		t.Fatalf("closed wallet must reject calls")
+	}
+
+	if _, err := call(t, dero, "openWallet", map[string]interface{}{"name": "first", "password": "wrong"}); err == nil {
+		t.Fatalf("wrong password must be rejected")
+	}
+	if _, err := call(t, dero, "openWallet", map[string]interface{}{"name": "missing", "password": "QWER"}); err == nil {
+		t.Fatalf("missing wallet must be rejected")
+	}
+	reopened := must(t, dero, "openWallet", map[string]interface{}{"name": "first", "password": "QWER"})
+	if reopened.Get("address").String() != address {
+		t.Fatalf("reopened wallet address mismatch")
+	}
+
+	// explicit data takes precedence over storage, exported data is not bound to any name
+	exported := must(t, reopened, "export")
+	copied := must(t, dero, "openWallet", map[string]interface{}{"password": "QWER"}, exported)
+	if copied.Get("address").String() != address {
+		t.Fatalf("exported wallet address mismatch")
+	}
+
+	recovered := must(t, dero, "createWallet", map[string]interface{}{"name": "second", "password": "ASDF", "seed": seed})
+	if recovered.Get("address").String() != address {
+		t.Fatalf("wallet recovered from seed address mismatch")
+	}
+}
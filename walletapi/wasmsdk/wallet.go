@@ -0,0 +1,279 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Copyright 2017-2021 DERO Project. All rights reserved.
+// Use of this source code in any form is governed by RESEARCH license.
+// license can be found in the LICENSE file.
+// GPG: 0F39 E425 8C65 3947 702A  8234 08B2 0360 A03A 9DE8
+//
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY
+// EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL
+// THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+// SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO,
+// PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+// STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF
+// THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package wasmsdk
+
+// this file exports an opened wallet as a javascript object, see README.md for the api
+
+import "fmt"
+import "sync"
+import "syscall/js"
+import "encoding/base64"
+
+import "github.com/deroproject/derohe/rpc"
+import "github.com/deroproject/derohe/walletapi"
+import "github.com/deroproject/derohe/transaction"
+import "github.com/deroproject/derohe/cryptography/crypto"
+
+// result of getSyncProgress
+type Sync_Status struct {
+	Online        bool                `json:"online"`    // wallet is in online mode
+	Connected     bool                `json:"connected"` // connection to daemon is alive
+	Height        uint64              `json:"height"`
+	Daemon_Height uint64              `json:"daemon_height"`
+	Progress      []rpc.Sync_Progress `json:"progress,omitempty"`
+}
+
+type wallet struct {
+	sync.Mutex
+	sdk    *sdk
+	name   string
+	w      *walletapi.Wallet_Memory
+	object js.Value
+	closed bool
+
+	transfer_mutex sync.Mutex // one transfer at a time, so the same inputs are not used twice
+}
+
+func (s *sdk) new_wallet(name string, w *walletapi.Wallet_Memory) *wallet {
+	o := &wallet{sdk: s, name: name, w: w, object: js.Global().Get("Object").New()}
+
+	if name != "" {
+		w.SetPersistCallback(func(data []byte) error { return s.save(name, data) })
+	}
+
+	o.object.Set("name", name)
+	o.object.Set("address", w.GetAddress().String())
+
+	o.set("getAddress", func(args []js.Value) (interface{}, error) { return o.w.GetAddress().String(), nil })
+	o.set("getSeed", o.get_seed)
+	o.set("getSyncProgress", o.get_sync_progress)
+	o.set("getBalance", o.get_balance)
+	o.set("getTransfers", o.get_transfers)
+	o.set("transfer", o.transfer)
+	o.set("invokeSC", o.invoke_sc)
+	o.set("signData", o.sign_data)
+	o.set("verifyData", o.verify_data)
+	o.set("setOnline", func(args []js.Value) (interface{}, error) { return o.w.SetOnlineMode(), nil })
+	o.set("setOffline", func(args []js.Value) (interface{}, error) { return o.w.SetOfflineMode(), nil })
+	o.set("save", func(args []js.Value) (interface{}, error) { return nil, o.persist() })
+	o.set("export", func(args []js.Value) (interface{}, error) { return o.w.Get_Encrypted_Wallet(), nil })
+	o.set("close", o.close)
+
+	// events are plain callbacks, they are not promises since they fire repeatedly
+	o.object.Set("onSyncProgress", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		callback := arg(args, 0)
+		if callback.Type() != js.TypeFunction {
+			w.SetSyncProgressCallback(nil)
+			return nil
+		}
+		w.SetSyncProgressCallback(func(p rpc.Sync_Progress) { callback.Invoke(to_js(p)) })
+		return nil
+	}))
+	return o
+}
+
+// every method rejects once the wallet is closed
+func (o *wallet) set(name string, fn func(args []js.Value) (interface{}, error)) {
+	o.object.Set(name, method(func(args []js.Value) (interface{}, error) {
+		o.Lock()
+		closed := o.closed
+		o.Unlock()
+		if closed {
+			return nil, fmt.Errorf("wallet is closed")
+		}
+		return fn(args)
+	}))
+}
+
+// stores encrypted wallet using the storage hook
+func (o *wallet) persist() error {
+	data := o.w.Get_Encrypted_Wallet()
+	if len(data) == 0 {
+		return fmt.Errorf("wallet could not be encrypted")
+	}
+	return o.sdk.save(o.name, data)
+}
+
+func (o *wallet) get_seed(args []js.Value) (interface{}, error) {
+	if language := arg(args, 0); language.Type() == js.TypeString {
+		return o.w.GetSeedinLanguage(language.String()), nil
+	}
+	return o.w.GetSeed(), nil
+}
+
+func (o *wallet) get_sync_progress(args []js.Value) (interface{}, error) {
+	return Sync_Status{
+		Online:        o.w.GetMode(),
+		Connected:     walletapi.Connected,
+		Height:        o.w.Get_Height(),
+		Daemon_Height: o.w.Get_Daemon_Height(),
+		Progress:      o.w.GetSyncProgress(),
+	}, nil
+}
+
+func (o *wallet) get_balance(args []js.Value) (interface{}, error) {
+	var p rpc.GetBalance_Params
+	if err := from_js(arg(args, 0), &p); err != nil {
+		return nil, err
+	}
+	if o.w.GetMode() {
+		if err := o.w.Sync_Wallet_Memory_With_Daemon_internal(p.SCID); err != nil {
+			return nil, err
+		}
+	}
+	mature, locked := o.w.Get_Balance_scid(p.SCID)
+	return rpc.GetBalance_Result{Balance: mature + locked, Unlocked_Balance: mature}, nil
+}
+
+func (o *wallet) get_transfers(args []js.Value) (interface{}, error) {
+	var p rpc.Get_Transfers_Params
+	if err := from_js(arg(args, 0), &p); err != nil {
+		return nil, err
+	}
+	var result rpc.Get_Transfers_Result
+	result.Entries = o.w.Show_Transfers(p.SCID, p.Coinbase, p.In, p.Out, p.Min_Height, p.Max_Height, p.Sender, p.Receiver, p.DestinationPort, p.SourcePort)
+	if p.Pending {
+		result.Pending = o.w.GetPendingTransactions()
+	}
+	return result, nil
+}
+
+func (o *wallet) transfer(args []js.Value) (interface{}, error) {
+	var p rpc.Transfer_Params
+	if err := from_js(arg(args, 0), &p); err != nil {
+		return nil, err
+	}
+	return o.send(p)
+}
+
+// builds the same transfer as the wallet rpc scinvoke call
+func (o *wallet) invoke_sc(args []js.Value) (interface{}, error) {
+	var p rpc.SC_Invoke_Params
+	if err := from_js(arg(args, 0), &p); err != nil {
+		return nil, err
+	}
+	if p.SC_ID == "" {
+		return nil, fmt.Errorf("SCID cannot be empty")
+	}
+	if !o.w.GetMode() {
+		return nil, fmt.Errorf("Wallet is in offline mode")
+	}
+
+	var tp rpc.Transfer_Params
+	if p.SC_DERO_Deposit >= 1 { // we must burn this much native currency
+		var mainscid crypto.Hash
+		random := o.w.Random_ring_members(mainscid)
+		if len(random) < 3 {
+			return nil, fmt.Errorf("SCID could not obtain ring members")
+		}
+		tp.Transfers = append(tp.Transfers, rpc.Transfer{Destination: random[0], Amount: 0, Burn: p.SC_DERO_Deposit})
+	}
+	if p.SC_TOKEN_Deposit >= 1 {
+		tp.Transfers = append(tp.Transfers, rpc.Transfer{SCID: crypto.HashHexToHash(p.SC_ID), Amount: 0, Burn: p.SC_TOKEN_Deposit})
+	}
+	tp.SC_RPC = p.SC_RPC
+	tp.SC_ID = p.SC_ID
+	tp.Ringsize = p.Ringsize
+	return o.send(tp)
+}
+
+// translates the parameters exactly like the wallet rpc transfer call, builds and sends the tx
+func (o *wallet) send(p rpc.Transfer_Params) (result rpc.Transfer_Result, err error) {
+	o.transfer_mutex.Lock()
+	defer o.transfer_mutex.Unlock()
+
+	if _, err = o.w.ResolveContacts(p.Transfers, false); err != nil { // owner changes must be accepted before paying over rpc
+		return
+	}
+	for _, t := range p.Transfers {
+		if _, err = t.Payload_RPC.CheckPack(transaction.PAYLOAD0_LIMIT); err != nil {
+			return
+		}
+	}
+	if !o.w.GetMode() {
+		return result, fmt.Errorf("Wallet is in offline mode")
+	}
+
+	if len(p.SC_Code) >= 1 { // decode SC from base64 if possible, since json has limitations
+		if sc, err := base64.StdEncoding.DecodeString(p.SC_Code); err == nil {
+			p.SC_Code = string(sc)
+		}
+	}
+	if p.SC_Code != "" && p.SC_ID == "" {
+		p.SC_RPC = append(p.SC_RPC, rpc.Argument{Name: rpc.SCACTION, DataType: rpc.DataUint64, Value: uint64(rpc.SC_INSTALL)})
+		p.SC_RPC = append(p.SC_RPC, rpc.Argument{Name: rpc.SCCODE, DataType: rpc.DataString, Value: p.SC_Code})
+	}
+	if p.SC_ID != "" {
+		p.SC_RPC = append(p.SC_RPC, rpc.Argument{Name: rpc.SCACTION, DataType: rpc.DataUint64, Value: uint64(rpc.SC_CALL)})
+		p.SC_RPC = append(p.SC_RPC, rpc.Argument{Name: rpc.SCID, DataType: rpc.DataHash, Value: crypto.HashHexToHash(p.SC_ID)})
+		if p.SC_Code != "" {
+			p.SC_RPC = append(p.SC_RPC, rpc.Argument{Name: rpc.SCCODE, DataType: rpc.DataString, Value: p.SC_Code})
+		}
+	}
+
+	tx, err := o.w.TransferPayload0(p.Transfers, p.Ringsize, false, p.SC_RPC, p.Fees, false)
+	if err != nil {
+		return
+	}
+	if err = o.w.SendTransaction(tx); err != nil {
+		return
+	}
+	result.TXID = tx.GetHash().String()
+	return
+}
+
+func (o *wallet) sign_data(args []js.Value) (interface{}, error) {
+	data, err := js_bytes(arg(args, 0))
+	if err != nil {
+		return nil, err
+	}
+	signed := o.w.SignData(data)
+	if signed == nil {
+		return nil, fmt.Errorf("view only wallet cannot sign")
+	}
+	return string(signed), nil
+}
+
+// resolves with the signer address and the signed message as Uint8Array
+func (o *wallet) verify_data(args []js.Value) (interface{}, error) {
+	data, err := js_bytes(arg(args, 0))
+	if err != nil {
+		return nil, err
+	}
+	signer, message, err := o.w.CheckSignature(data)
+	if err != nil {
+		return nil, err
+	}
+	return js.ValueOf(map[string]interface{}{"signer": signer.String(), "message": to_js(message)}), nil
+}
+
+// stops syncing and stores the wallet a final time, the object cannot be used afterwards
+func (o *wallet) close(args []js.Value) (interface{}, error) {
+	o.Lock()
+	o.closed = true
+	o.Unlock()
+
+	o.w.SetSyncProgressCallback(nil)
+	o.w.SetOfflineMode()
+	o.w.Close_Encrypted_Wallet()
+	err := o.persist()
+	o.w.SetPersistCallback(nil)
+	return nil, err
+}